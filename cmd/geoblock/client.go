@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by the commands querying a running instance when their
+// check fails.
+var (
+	errDenied    = errors.New("request denied")
+	errNotFound  = errors.New("country not found")
+	errDatabases = errors.New("databases not up to date")
+)
+
+// clientOptions are the flags of the commands querying the admin API of a
+// running instance.
+type clientOptions struct {
+	target  string
+	token   string
+	timeout time.Duration
+	output  *outputFormat
+}
+
+// newClientOptions registers the flags of the commands querying a running
+// instance on the given flag set. The token defaults to the
+// GEOBLOCK_ADMIN_TOKEN environment variable, so that it doesn't appear in the
+// process list.
+func newClientOptions(flags *flag.FlagSet) *clientOptions {
+	options := &clientOptions{
+		target:  "http://localhost:8081",
+		token:   os.Getenv("GEOBLOCK_ADMIN_TOKEN"),
+		timeout: 5 * time.Second,
+	}
+	flags.StringVar(
+		&options.target,
+		"target",
+		options.target,
+		"URL of the admin API of the instance",
+	)
+	flags.StringVar(
+		&options.token,
+		"token",
+		options.token,
+		"bearer token of the admin API",
+	)
+	flags.DurationVar(
+		&options.timeout,
+		"timeout",
+		options.timeout,
+		"timeout of each request",
+	)
+	options.output = outputFlag(flags)
+	return options
+}
+
+// call sends a request with the given method, path and JSON body, if any, to
+// the instance, and decodes its JSON response into the given value.
+func (o *clientOptions) call(
+	method string,
+	path string,
+	body any,
+	value any,
+) error {
+	target, err := url.Parse(o.target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return fmt.Errorf("%w: invalid target URL: %q", errUsage, o.target)
+	}
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequest(
+		method, strings.TrimSuffix(o.target, "/")+path, &payload,
+	)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if o.token != "" {
+		request.Header.Set("Authorization", "Bearer "+o.token)
+	}
+
+	client := &http.Client{Timeout: o.timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status: %s", method, path,
+			response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
+
+// lookupResult is the resolution of an IP by a running instance.
+type lookupResult struct {
+	IP           string   `json:"ip"`
+	Country      string   `json:"country"`
+	Region       string   `json:"region,omitempty"`
+	City         string   `json:"city,omitempty"`
+	ASN          uint32   `json:"asn"`
+	Organization string   `json:"organization"`
+	CDN          string   `json:"cdn,omitempty"`
+	Monitor      string   `json:"monitor,omitempty"`
+	Registry     string   `json:"registry,omitempty"`
+	Anonymizers  []string `json:"anonymizers,omitempty"`
+}
+
+// lookup resolves the IPs given as arguments with the databases of a running
+// instance. It fails if the country of an IP is unknown.
+func lookup(args []string) error {
+	var (
+		flags   = flag.NewFlagSet("lookup", flag.ContinueOnError)
+		options = newClientOptions(flags)
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("%w: no IP to look up", errUsage)
+	}
+
+	var (
+		results = make([]lookupResult, 0, flags.NArg())
+		rows    = make([][]string, 0, flags.NArg())
+		missing = 0
+	)
+	for _, arg := range flags.Args() {
+		ip, err := netip.ParseAddr(arg)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errUsage, err)
+		}
+
+		var result lookupResult
+		path := "/v1/debug/resolve?ip=" + url.QueryEscape(ip.String())
+		err = options.call(http.MethodGet, path, nil, &result)
+		if err != nil {
+			return err
+		}
+		if result.Country == "" {
+			missing++
+		}
+
+		var asn string
+		if result.ASN != 0 {
+			asn = strconv.FormatUint(uint64(result.ASN), 10)
+		}
+		results = append(results, result)
+		rows = append(rows, []string{
+			result.IP, result.Country, result.Region, result.City, asn,
+			result.Organization,
+		})
+	}
+
+	var err error
+	if *options.output == outputJSON {
+		err = printJSON(results)
+	} else {
+		err = printTable([]string{
+			"IP", "COUNTRY", "REGION", "CITY", "ASN", "ORGANIZATION",
+		}, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %d of %d IPs", errNotFound, missing,
+			len(results))
+	}
+	return nil
+}
+
+// checkQuery is a request evaluated by a running instance.
+type checkQuery struct {
+	IP     string `json:"ip"`
+	Domain string `json:"domain"`
+	Method string `json:"method"`
+	Path   string `json:"path,omitempty"`
+}
+
+// checkDecision is the decision of a running instance for a request.
+type checkDecision struct {
+	checkQuery
+	Allowed  bool   `json:"allowed"`
+	Rule     *int   `json:"rule,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	Banned   bool   `json:"banned,omitempty"`
+	Country  string `json:"country,omitempty"`
+	ASN      uint32 `json:"asn,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// check evaluates a request against the rules of a running instance, without
+// affecting its rate limits. It fails if the request is denied.
+func check(args []string) error {
+	var (
+		flags   = flag.NewFlagSet("check", flag.ContinueOnError)
+		options = newClientOptions(flags)
+		query   = checkQuery{Method: http.MethodGet}
+	)
+	flags.StringVar(&query.IP, "ip", "", "source IP of the request")
+	flags.StringVar(&query.Domain, "domain", "", "requested domain")
+	flags.StringVar(&query.Method, "method", query.Method, "requested method")
+	flags.StringVar(&query.Path, "path", "", "requested path")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if _, err := netip.ParseAddr(query.IP); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if query.Domain == "" {
+		return fmt.Errorf("%w: no domain to check", errUsage)
+	}
+
+	var response struct {
+		Decisions []checkDecision `json:"decisions"`
+	}
+	err := options.call(
+		http.MethodPost, "/v1/authorize", []checkQuery{query}, &response,
+	)
+	if err != nil {
+		return err
+	}
+	if len(response.Decisions) != 1 {
+		return fmt.Errorf("got %d decisions, want 1", len(response.Decisions))
+	}
+	decision := response.Decisions[0]
+	if decision.Error != "" {
+		return errors.New(decision.Error)
+	}
+
+	if *options.output == outputJSON {
+		err = printJSON(decision)
+	} else {
+		outcome := "deny"
+		if decision.Allowed {
+			outcome = "allow"
+		}
+		var rule, asn string
+		if decision.Rule != nil {
+			rule = strconv.Itoa(*decision.Rule)
+		}
+		if decision.RuleName != "" {
+			rule = decision.RuleName
+		}
+		if decision.ASN != 0 {
+			asn = strconv.FormatUint(uint64(decision.ASN), 10)
+		}
+		err = printTable(
+			[]string{"DECISION", "RULE", "COUNTRY", "ASN", "BANNED"},
+			[][]string{{
+				outcome, rule, decision.Country, asn,
+				strconv.FormatBool(decision.Banned),
+			}},
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return errDenied
+	}
+	return nil
+}
+
+// databaseSource is the state of a database source of a running instance.
+type databaseSource struct {
+	Name       string     `json:"name"`
+	URL        string     `json:"url,omitempty"`
+	Records    int        `json:"records"`
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	Cached     bool       `json:"cached"`
+	Stale      bool       `json:"stale"`
+}
+
+// databases prints the state of the database sources of a running instance.
+// It fails if a source is stale or has never been loaded.
+func databases(args []string) error {
+	var (
+		flags   = flag.NewFlagSet("db", flag.ContinueOnError)
+		options = newClientOptions(flags)
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	var response struct {
+		Sources []databaseSource `json:"sources"`
+	}
+	err := options.call(http.MethodGet, "/v1/db/status", nil, &response)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	rows := make([][]string, 0, len(response.Sources))
+	for _, source := range response.Sources {
+		var lastUpdate string
+		if source.LastUpdate != nil {
+			lastUpdate = source.LastUpdate.Format(time.RFC3339)
+		}
+		if source.Stale || source.LastUpdate == nil {
+			failed++
+		}
+		rows = append(rows, []string{
+			source.Name, strconv.Itoa(source.Records), lastUpdate,
+			strconv.FormatBool(source.Cached),
+			strconv.FormatBool(source.Stale),
+		})
+	}
+
+	if *options.output == outputJSON {
+		err = printJSON(response.Sources)
+	} else {
+		err = printTable([]string{
+			"SOURCE", "RECORDS", "LAST UPDATE", "CACHED", "STALE",
+		}, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d sources stale or not loaded",
+			errDatabases, failed, len(response.Sources))
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/conformance"
+	"github.com/danroc/geoblock/internal/fixtures"
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// errInvalidConfig is returned by the validate command once the errors of
+// the configuration have been printed.
+var errInvalidConfig = errors.New("configuration is invalid")
+
+// errConformance is returned by the conformance command when a check fails.
+var errConformance = errors.New("conformance checks failed")
+
+// commands are the subcommands of the geoblock binary. Without a subcommand,
+// the authorization server is started.
+var commands = map[string]func(args []string) error{
+	"check":            check,
+	"conformance":      conformanceChecks,
+	"db":               databases,
+	"fixtures":         generateFixtures,
+	"lookup":           lookup,
+	"prometheus-rules": prometheusRules,
+	"validate":         validate,
+}
+
+// prometheusRules prints a Prometheus rule file with the alerts and recording
+// rules based on the metrics exported by geoblock.
+func prometheusRules(args []string) error {
+	var (
+		options = metrics.DefaultRulesOptions()
+		flags   = flag.NewFlagSet("prometheus-rules", flag.ContinueOnError)
+	)
+	flags.DurationVar(
+		&options.StaleAfter,
+		"stale-after",
+		options.StaleAfter,
+		"age after which the databases are considered stale",
+	)
+	flags.Float64Var(
+		&options.DenySpikeFactor,
+		"deny-spike-factor",
+		options.DenySpikeFactor,
+		"ratio between the 5m and 1h rates of denied requests",
+	)
+	flags.Float64Var(
+		&options.InvalidRatio,
+		"invalid-ratio",
+		options.InvalidRatio,
+		"ratio of invalid requests above which an alert is raised",
+	)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	rules, err := metrics.Rules(options)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(rules)
+	return err
+}
+
+// conformanceResult is the result of a conformance check in the JSON output
+// of the conformance command.
+type conformanceResult struct {
+	Proxy  string `json:"proxy"`
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// conformanceChecks runs the conformance checks against the forward-auth
+// endpoint of a running instance and prints their results, one per line.
+func conformanceChecks(args []string) error {
+	var (
+		target  = "http://localhost:8080"
+		timeout = 5 * time.Second
+		flags   = flag.NewFlagSet("conformance", flag.ContinueOnError)
+		output  = outputFlag(flags)
+	)
+	flags.StringVar(
+		&target,
+		"target",
+		target,
+		"URL of the instance or of its forward-auth endpoint",
+	)
+	flags.DurationVar(&timeout, "timeout", timeout, "timeout of each request")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	endpoint, err := conformance.Endpoint(target)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	// Redirects are deny responses, they must not be followed.
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var (
+		failed  = 0
+		results = conformance.Run(client, endpoint)
+		outputs = make([]conformanceResult, 0, len(results))
+	)
+	for _, result := range results {
+		output := conformanceResult{
+			Proxy:  result.Check.Proxy,
+			Name:   result.Check.Name,
+			Passed: result.Passed(),
+			Status: result.Status,
+		}
+		if result.Err != nil {
+			output.Error = result.Err.Error()
+		}
+		if !output.Passed {
+			failed++
+		}
+		outputs = append(outputs, output)
+	}
+
+	if *output == outputJSON {
+		if err := printJSON(outputs); err != nil {
+			return err
+		}
+	} else {
+		for _, output := range outputs {
+			status := "PASS"
+			if !output.Passed {
+				status = "FAIL"
+			}
+			line := fmt.Sprintf("%s  %-8s %s", status, output.Proxy,
+				output.Name)
+			if output.Error != "" {
+				line += ": " + output.Error
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", errConformance, failed,
+			len(results))
+	}
+	if *output != outputJSON {
+		fmt.Printf("%s: all %d checks passed\n", endpoint, len(results))
+	}
+	return nil
+}
+
+// splitList splits the given comma-separated list, ignoring the empty
+// entries.
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// generateFixtures writes synthetic databases and a configuration using them
+// to a directory, and prints the sample clients with their expected
+// decisions.
+func generateFixtures(args []string) error {
+	var (
+		dir       = "fixtures"
+		countries = "FR,US"
+		denied    = ""
+		asns      = ""
+		flags     = flag.NewFlagSet("fixtures", flag.ContinueOnError)
+		output    = outputFlag(flags)
+	)
+	flags.StringVar(&dir, "dir", dir, "directory where the files are written")
+	flags.StringVar(
+		&countries,
+		"countries",
+		countries,
+		"comma-separated allowed countries",
+	)
+	flags.StringVar(
+		&denied,
+		"deny",
+		denied,
+		"comma-separated denied countries",
+	)
+	flags.StringVar(&asns, "asns", asns, "comma-separated allowed ASNs")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	options := fixtures.Options{
+		Countries:       splitList(countries),
+		DeniedCountries: splitList(denied),
+	}
+	for _, entry := range splitList(asns) {
+		asn, err := strconv.ParseUint(entry, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%w: invalid ASN %q", errUsage, entry)
+		}
+		options.ASNs = append(options.ASNs, uint32(asn))
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	options.Directory = dir
+
+	generated, err := fixtures.Generate(options)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(generated.Files)) {
+		path := filepath.Join(dir, name)
+		// The fixtures aren't secret, and may be read by a container.
+		err := os.WriteFile(path, generated.Files[name], 0o644) // #nosec G306
+		if err != nil {
+			return err
+		}
+	}
+
+	if *output == outputJSON {
+		return printJSON(generated.Clients)
+	}
+	rows := make([][]string, 0, len(generated.Clients))
+	for _, client := range generated.Clients {
+		var asn string
+		if client.ASN != 0 {
+			asn = strconv.FormatUint(uint64(client.ASN), 10)
+		}
+		rows = append(rows, []string{
+			client.IP.String(), client.Country, asn, client.Decision,
+		})
+	}
+	return printTable([]string{"IP", "COUNTRY", "ASN", "DECISION"}, rows)
+}
+
+// validateResult is the JSON output of the validate command.
+type validateResult struct {
+	Path   string          `json:"path"`
+	Valid  bool            `json:"valid"`
+	Errors []validateError `json:"errors,omitempty"`
+}
+
+// validateError is an error of the configuration in the JSON output of the
+// validate command.
+type validateError struct {
+	Line    int    `json:"line,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// validate loads and validates a configuration file. Each error is printed on
+// its own line, prefixed by the file name and, if known, its line number.
+func validate(args []string) error {
+	var (
+		path   = getOptions().configPath
+		flags  = flag.NewFlagSet("validate", flag.ContinueOnError)
+		output = outputFlag(flags)
+	)
+	flags.StringVar(&path, "config", path, "path to the configuration file")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	_, err := loadConfig(path)
+	var errs config.Errors
+	if err != nil && !errors.As(err, &errs) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if *output == outputJSON {
+		result := validateResult{Path: path, Valid: len(errs) == 0}
+		for _, err := range errs {
+			result.Errors = append(result.Errors, validateError{
+				Line:    err.Line,
+				Field:   err.Field,
+				Message: err.Message,
+			})
+		}
+		if err := printJSON(result); err != nil {
+			return err
+		}
+		if !result.Valid {
+			return errInvalidConfig
+		}
+		return nil
+	}
+
+	if len(errs) == 0 {
+		fmt.Printf("%s: configuration is valid\n", path)
+		return nil
+	}
+	for _, err := range errs {
+		location := path
+		if err.Line > 0 {
+			location += fmt.Sprintf(":%d", err.Line)
+		}
+		if err.Field != "" {
+			location += ": " + err.Field
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", location, err.Message)
+	}
+	return errInvalidConfig
+}
+
+// runCommand runs the given subcommand and exits with the exit code of its
+// result, see exitCode.
+func runCommand(name string, args []string) {
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+		os.Exit(exitUsage)
+	}
+	err := command(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitCode(err))
+}
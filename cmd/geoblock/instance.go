@@ -0,0 +1,63 @@
+package main
+
+import (
+	"maps"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// instanceLabels returns the labels identifying the instance: the configured
+// labels and the instance ID, if any. The given ID takes precedence over the
+// configured one.
+func instanceLabels(id string, cfg *config.Instance) map[string]string {
+	labels := maps.Clone(cfg.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	if id == "" {
+		id = cfg.ID
+	}
+	if id != "" {
+		labels[metrics.InstanceLabel] = id
+	}
+	return labels
+}
+
+// fieldsHook is a logger hook that adds fields to all the log entries. The
+// fields of the entries take precedence.
+type fieldsHook struct {
+	fields log.Fields
+}
+
+// Levels returns the levels of the entries modified by the hook.
+func (h *fieldsHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the hook's fields to the given entry.
+func (h *fieldsHook) Fire(entry *log.Entry) error {
+	for key, value := range h.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+// configureInstance attaches the given labels to all the logs and metrics.
+func configureInstance(labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	fields := make(log.Fields, len(labels))
+	for key, value := range labels {
+		fields[key] = value
+	}
+	log.AddHook(&fieldsHook{fields: fields})
+
+	return metrics.SetConstLabels(labels)
+}
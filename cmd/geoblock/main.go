@@ -3,22 +3,68 @@ package main
 
 import (
 	"bytes"
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/danroc/geoblock/internal/audit"
+	"github.com/danroc/geoblock/internal/autotls"
 	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/firstseen"
+	"github.com/danroc/geoblock/internal/health"
+	"github.com/danroc/geoblock/internal/history"
 	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/privacy"
 	"github.com/danroc/geoblock/internal/rules"
 	"github.com/danroc/geoblock/internal/server"
+	"github.com/danroc/geoblock/internal/webhook"
 )
 
 const (
 	autoUpdateInterval = 24 * time.Hour
-	autoReloadInterval = 5 * time.Second
+	autoUpdateRetry    = time.Minute
+	autoStageInterval  = 5 * time.Second
+	reloadDelay        = 100 * time.Millisecond
 )
 
+// maxRetryShift bounds the exponential backoff of the failed updates, so that
+// the retry delay can't overflow before being capped by the update interval.
+const maxRetryShift = 16
+
+// lowMemoryGCPercent is the garbage collection target percentage used in low
+// memory mode, unless the GOGC environment variable is set.
+const lowMemoryGCPercent = 50
+
+// lowMemoryResolutionCacheSize is the number of cached resolutions in low
+// memory mode, unless the size of the resolution cache is set.
+const lowMemoryResolutionCacheSize = 1000
+
+// snapshotFileName is the name of the snapshot of the parsed databases in the
+// cache directory.
+const snapshotFileName = "snapshot.gob"
+
+// defaultACMEHTTPAddress is the address of the listener answering the ACME
+// HTTP-01 challenges, unless configured otherwise.
+const defaultACMEHTTPAddress = ":80"
+
 func getEnv(key, fallback string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -27,28 +73,588 @@ func getEnv(key, fallback string) string {
 }
 
 type appOptions struct {
-	configPath string
-	serverPort string
-	logLevel   string
+	configPath     string
+	nextConfigPath string
+	serverPort     string
+	logLevel       string
+	instanceID     string
+	readOnly       string
+	updateInterval string
 }
 
 // getOptions returns the application options from the environment variables.
 func getOptions() *appOptions {
 	return &appOptions{
-		configPath: getEnv("GEOBLOCK_CONFIG", "/etc/geoblock/config.yaml"),
-		serverPort: getEnv("GEOBLOCK_PORT", "8080"),
-		logLevel:   getEnv("GEOBLOCK_LOG_LEVEL", "info"),
+		configPath:     getEnv("GEOBLOCK_CONFIG", "/etc/geoblock/config.yaml"),
+		nextConfigPath: getEnv("GEOBLOCK_NEXT_CONFIG", ""),
+		serverPort:     getEnv("GEOBLOCK_PORT", "8080"),
+		logLevel:       getEnv("GEOBLOCK_LOG_LEVEL", "info"),
+		instanceID:     getEnv("GEOBLOCK_INSTANCE_ID", ""),
+		readOnly:       getEnv("GEOBLOCK_READ_ONLY", "false"),
+		updateInterval: getEnv("GEOBLOCK_UPDATE_INTERVAL", ""),
+	}
+}
+
+// newFetcher returns the fetcher used to retrieve the databases. If a cache
+// directory is configured, fetched databases are also cached on disk.
+func newFetcher(cfg *config.Databases) ipres.Fetcher {
+	options := ipres.HTTPOptions{
+		MaxSize:   int64(cfg.MaxDownloadSize),
+		UserAgent: cfg.Fetcher.UserAgent,
+	}
+	if cfg.Fetcher.Proxy != "" {
+		proxy, err := url.Parse(cfg.Fetcher.Proxy)
+		if err != nil {
+			log.Fatalf("Invalid database proxy: %v", err)
+		}
+		options.Proxy = proxy
+	}
+	if cfg.Fetcher.CABundle != "" {
+		data, err := os.ReadFile(cfg.Fetcher.CABundle)
+		if err != nil {
+			log.Fatalf("Cannot read database CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			log.Fatal("Cannot read database CA bundle: no certificate found")
+		}
+		options.RootCAs = pool
+	}
+
+	fetcher := ipres.NewHTTPFetcher(options)
+	if cfg.Cache.Directory == "" {
+		return fetcher
+	}
+	return ipres.NewCachedFetcher(fetcher, ipres.CacheOptions{
+		Directory: cfg.Cache.Directory,
+		MaxAge:    cfg.Cache.MaxAge,
+		MaxSize:   int64(cfg.Cache.MaxSize),
+	})
+}
+
+// snapshotFile returns the file in which the parsed databases are saved, in
+// the cache directory, or an empty string if the snapshots are disabled.
+func snapshotFile(cfg *config.Databases) string {
+	if cfg.Cache.Directory == "" || !cfg.Cache.Snapshot {
+		return ""
+	}
+	return filepath.Join(cfg.Cache.Directory, snapshotFileName)
+}
+
+// databaseURLs returns the URLs of the MMDB country, city and ASN databases.
+// The URLs that aren't configured are the MaxMind download URLs of the
+// configured editions if a license key is set.
+func databaseURLs(
+	cfg *config.Databases,
+) (countryURL, cityURL, asnURL string) {
+	countryURL, cityURL, asnURL = cfg.CountryURL, cfg.CityURL, cfg.ASNURL
+	licenseKey := os.Getenv(config.LicenseKeyEnv)
+	if cfg.Format != ipres.FormatMMDB || licenseKey == "" {
+		return countryURL, cityURL, asnURL
+	}
+	if countryURL == "" {
+		edition := cfg.CountryEdition
+		if edition == "" {
+			edition = ipres.EditionGeoLite2Country
+		}
+		countryURL = ipres.MaxMindURL(edition, licenseKey)
+	}
+	if cityURL == "" {
+		edition := cfg.CityEdition
+		if edition == "" {
+			edition = ipres.EditionGeoLite2City
+		}
+		cityURL = ipres.MaxMindURL(edition, licenseKey)
+	}
+	if asnURL == "" {
+		edition := cfg.ASNEdition
+		if edition == "" {
+			edition = ipres.EditionGeoLite2ASN
+		}
+		asnURL = ipres.MaxMindURL(edition, licenseKey)
+	}
+	return countryURL, cityURL, asnURL
+}
+
+// prefixes converts the given configured networks to prefixes.
+func prefixes(networks []config.CIDR) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		result = append(result, network.Prefix)
+	}
+	return result
+}
+
+// newCheckServer returns the TCP check server, or nil if it's disabled.
+func newCheckServer(
+	cfg *config.Configuration,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options server.Options,
+) *server.CheckServer {
+	if cfg.TCPCheck.Address == "" {
+		return nil
+	}
+	return server.NewCheckServer(
+		cfg.TCPCheck.Address, engine, resolver, options,
+	)
+}
+
+// newMilterServer returns the milter server, or nil if it's disabled.
+func newMilterServer(
+	cfg *config.Configuration,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options server.Options,
+) *server.MilterServer {
+	if cfg.Milter.Address == "" {
+		return nil
+	}
+	return server.NewMilterServer(
+		cfg.Milter.Address, engine, resolver, options,
+	)
+}
+
+// newDNSBLServer returns the DNSBL server, or nil if it's disabled.
+func newDNSBLServer(
+	cfg *config.Configuration,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+) *server.DNSBLServer {
+	if cfg.DNSBL.Address == "" {
+		return nil
+	}
+	return server.NewDNSBLServer(
+		cfg.DNSBL.Address, cfg.DNSBL.Zone, engine, resolver,
+	)
+}
+
+// newExtAuthzServer returns the ext_authz server, or nil if it's disabled.
+func newExtAuthzServer(
+	cfg *config.Configuration,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options server.Options,
+) *server.ExtAuthzServer {
+	if cfg.ExtAuthz.Address == "" {
+		return nil
+	}
+	return server.NewExtAuthzServer(
+		cfg.ExtAuthz.Address, engine, resolver, options,
+	)
+}
+
+// acmeHTTPAddress returns the address of the listener answering the ACME
+// HTTP-01 challenges, or an empty string if there's none.
+func acmeHTTPAddress(cfg *config.Admin) string {
+	if cfg.Address == "" || cfg.ACME == nil ||
+		cfg.ACME.Challenge == autotls.ChallengeDNS01 {
+		return ""
+	}
+	return cmp.Or(cfg.ACME.HTTPAddress, defaultACMEHTTPAddress)
+}
+
+// newACMEManager returns the manager of the certificate of the admin server,
+// and starts obtaining and renewing it. The HTTP-01 challenges are answered
+// by a listener started on the configured HTTP address.
+func newACMEManager(cfg *config.Admin) *autotls.Manager {
+	var solver autotls.Solver = &autotls.HookSolver{Command: cfg.ACME.DNSHook}
+	if address := acmeHTTPAddress(cfg); address != "" {
+		httpSolver := autotls.NewHTTP01Solver()
+		challenges := &http.Server{
+			Addr:         address,
+			Handler:      httpSolver,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			log.Infof("Starting ACME challenge server at %s", address)
+			log.Fatal(challenges.ListenAndServe())
+		}()
+		solver = httpSolver
+	}
+
+	manager := autotls.NewManager(autotls.Options{
+		Domains:      cfg.ACME.Domains,
+		Email:        cfg.ACME.Email,
+		DirectoryURL: cfg.ACME.DirectoryURL,
+		Directory:    cfg.ACME.Directory,
+		Solver:       solver,
+		RenewBefore:  cfg.ACME.RenewBefore,
+	})
+	go manager.Run()
+	return manager
+}
+
+// newAdminServer returns the admin server, or nil if it's disabled. Clients
+// must present a certificate signed by the configured client CA, if any. The
+// staged configuration can be promoted if promote is true.
+func newAdminServer(
+	cfg *config.Configuration,
+	engine *rules.Engine,
+	updates *updater,
+	store *history.Store,
+	readOnly bool,
+	promote bool,
+) *http.Server {
+	if cfg.Admin.Address == "" {
+		return nil
+	}
+
+	var tlsConfig *tls.Config
+	switch {
+	case cfg.Admin.ACME != nil:
+		tlsConfig = &tls.Config{
+			GetCertificate: newACMEManager(&cfg.Admin).GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	case cfg.Admin.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.Admin.CertFile, cfg.Admin.KeyFile)
+		if err != nil {
+			log.Fatalf("Cannot load admin certificate: %v", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+	if cfg.Admin.ClientCA != "" {
+		data, err := os.ReadFile(cfg.Admin.ClientCA)
+		if err != nil {
+			log.Fatalf("Cannot read admin client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			log.Fatal("Cannot read admin client CA: no certificate found")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	options := server.AdminOptions{
+		Token:      cfg.Admin.Token,
+		TLSConfig:  tlsConfig,
+		Config:     cfg,
+		Refresh:    updates.update,
+		Resolver:   updates.resolver,
+		History:    store,
+		PromoteAPI: promote,
+		ReadOnly:   readOnly,
+	}
+	return server.NewAdminServer(cfg.Admin.Address, engine, options)
+}
+
+// newOverrides converts the configured overrides to resolver overrides.
+func newOverrides(overrides []config.Override) []ipres.Override {
+	result := make([]ipres.Override, 0, len(overrides))
+	for _, override := range overrides {
+		result = append(result, ipres.Override{
+			Prefix: override.Network.Prefix,
+			Resolution: ipres.Resolution{
+				CountryCode:  override.Country,
+				Region:       override.Region,
+				City:         override.City,
+				ASN:          override.ASN,
+				Organization: override.Organization,
+			},
+		})
+	}
+	return result
+}
+
+// newVerifications returns the verifications of the database sources, by
+// source name.
+func newVerifications(
+	verify map[string]config.Verification,
+) map[string]ipres.Verification {
+	result := make(map[string]ipres.Verification, len(verify))
+	for name, verification := range verify {
+		result[name] = ipres.Verification{
+			SHA256:       verification.SHA256,
+			SHA256URL:    verification.SHA256URL,
+			SignatureURL: verification.SignatureURL,
+		}
+	}
+	return result
+}
+
+// logDiff logs the changes of each database source since the previous update.
+// Nothing is logged for the initial load since there's nothing to compare to.
+// Sources that shrank significantly and country codes or ASNs whose number of
+// records changed significantly are logged as warnings.
+func logDiff(diffs []ipres.SourceDiff) {
+	for _, diff := range diffs {
+		fields := log.Fields{
+			"source":  diff.Source,
+			"records": diff.After,
+			"added":   diff.Added,
+			"removed": diff.Removed,
+		}
+		if diff.Initial {
+			continue
+		}
+
+		if diff.Shrunk() {
+			log.WithFields(fields).Warn("Database shrank significantly")
+		} else {
+			log.WithFields(fields).Info("Database changes")
+		}
+
+		for _, change := range diff.Changed {
+			log.WithFields(log.Fields{
+				"source": diff.Source,
+				"key":    change.Key,
+				"before": change.Before,
+				"after":  change.After,
+			}).Warn("Number of records changed significantly")
+		}
+	}
+}
+
+// logMemory logs, at the debug level, the estimated memory used by each
+// database source and the statistics of the interned strings.
+func logMemory(resolver *ipres.Resolver) {
+	memory, intern := resolver.Memory()
+	for _, source := range slices.Sorted(maps.Keys(memory)) {
+		log.WithFields(log.Fields{
+			"source":       source,
+			"memory_bytes": memory[source],
+		}).Debug("Database memory")
+	}
+	log.WithFields(log.Fields{
+		"strings":      intern.Strings,
+		"string_bytes": intern.Bytes,
+		"deduplicated": intern.Deduplicated,
+	}).Debug("Database strings")
+}
+
+// loadASN returns whether the ASN databases must be loaded. Unless explicitly
+// enabled, they aren't loaded in low memory mode.
+func loadASN(cfg *config.Configuration) bool {
+	if cfg.Databases.ASN != nil {
+		return *cfg.Databases.ASN
+	}
+	return !cfg.LowMemory
+}
+
+// databaseIndex returns the index of the database ranges. Unless explicitly
+// set, the flat index is used in low memory mode.
+func databaseIndex(cfg *config.Configuration) string {
+	if cfg.Databases.Index != "" {
+		return cfg.Databases.Index
 	}
+	if cfg.LowMemory {
+		return ipres.IndexFlat
+	}
+	return ipres.IndexTree
+}
+
+// databaseConcurrency returns the number of database sources fetched and
+// decoded concurrently. Unless explicitly set, the sources are loaded as
+// they're decoded in low memory mode.
+func databaseConcurrency(cfg *config.Configuration) int {
+	if cfg.Databases.Concurrency != 0 {
+		return cfg.Databases.Concurrency
+	}
+	if cfg.LowMemory {
+		return 1
+	}
+	return ipres.DefaultConcurrency
+}
+
+// resolutionCacheSize returns the maximum number of cached resolutions. Unless
+// explicitly set, a small cache is used in low memory mode, and the default
+// size otherwise.
+func resolutionCacheSize(cfg *config.Configuration) int {
+	if cfg.Databases.ResolutionCache.Size != 0 {
+		return cfg.Databases.ResolutionCache.Size
+	}
+	if cfg.LowMemory {
+		return lowMemoryResolutionCacheSize
+	}
+	return ipres.DefaultResolutionCacheSize
+}
+
+// configureMemory tunes the garbage collector for small devices when the low
+// memory mode is enabled.
+func configureMemory(cfg *config.Configuration) {
+	if cfg.LowMemory && os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
+}
+
+// releaseMemory returns the memory of the previous databases to the operating
+// system in low memory mode. Otherwise, the runtime keeps it for later use.
+func releaseMemory(lowMemory bool) {
+	if lowMemory {
+		debug.FreeOSMemory()
+	}
+}
+
+// logEmpty logs when the resolver starts or stops serving requests without
+// country data. The error is only logged once, not after every update, and
+// wasEmpty is the state after the previous update. It returns the new state.
+func logEmpty(resolver *ipres.Resolver, wasEmpty bool) bool {
+	empty := resolver.Empty()
+	switch {
+	case empty && !wasEmpty:
+		log.Error(
+			"Serving requests without country data, countries " +
+				"conditions won't match",
+		)
+	case !empty && wasEmpty:
+		log.Info("Country data available again")
+	}
+	return empty
+}
+
+// fallbackPolicy returns the policy of the requests without source country
+// while the databases are unavailable, or an empty string if the rules are
+// evaluated as usual.
+func fallbackPolicy(cfg *config.Databases) string {
+	if cfg.FailurePolicy == config.FailurePolicyStale {
+		return ""
+	}
+	return cfg.FailurePolicy
+}
+
+// resolvePeeringDB resolves the ASNs of the PeeringDB organizations used by
+// the rules of the engine. Organizations that can't be resolved keep their
+// previous ASNs.
+func resolvePeeringDB(engine *rules.Engine, fetcher ipres.Fetcher) {
+	names := engine.PeeringDBOrganizations()
+	if len(names) == 0 {
+		return
+	}
+
+	asns := make(map[string][]uint32, len(names))
+	for _, name := range names {
+		list, err := ipres.OrganizationASNs(fetcher, ipres.PeeringDBURL, name)
+		if err != nil {
+			log.WithField("organization", name).Errorf(
+				"Cannot resolve PeeringDB organization: %v", err,
+			)
+			continue
+		}
+		asns[name] = list
+	}
+	engine.SetOrganizationASNs(asns)
+	log.WithField("organizations", len(asns)).Info(
+		"PeeringDB organizations resolved",
+	)
+}
+
+// updater updates the databases and the PeeringDB organizations, either at
+// regular intervals or on demand. Updates are serialized.
+type updater struct {
+	mu        sync.Mutex
+	resolver  *ipres.Resolver
+	engine    *rules.Engine
+	fetcher   ipres.Fetcher
+	lowMemory bool
+	empty     bool // Whether the databases had no country data
 }
 
-// autoUpdate updates the databases at regular intervals.
-func autoUpdate(resolver *ipres.Resolver) {
-	for range time.Tick(autoUpdateInterval) {
-		if err := resolver.Update(); err != nil {
+// update updates the databases and the PeeringDB organizations. The fallback
+// policy of the engine is removed once the databases are loaded. If the
+// update fails, the previous databases keep being used.
+func (u *updater) update() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	resolvePeeringDB(u.engine, u.fetcher)
+
+	degraded := u.resolver.Degraded()
+	if err := u.resolver.Update(); err != nil {
+		return err
+	}
+	if degraded {
+		u.engine.SetFallbackPolicy("")
+		log.Info("Databases loaded, failure policy no longer applied")
+	}
+	log.Info("Databases updated")
+	logDiff(u.resolver.Diff())
+	logMemory(u.resolver)
+	u.empty = logEmpty(u.resolver, u.empty)
+	releaseMemory(u.lowMemory)
+	return nil
+}
+
+// updateSchedule returns the interval between the database updates and its
+// jitter. The interval of the GEOBLOCK_UPDATE_INTERVAL environment variable
+// takes precedence over the one of the configuration. Without jitter, a tenth
+// of the interval is used.
+func updateSchedule(
+	options *appOptions,
+	cfg *config.Databases,
+) (time.Duration, time.Duration, error) {
+	interval := cfg.UpdateInterval
+	if options.updateInterval != "" {
+		var err error
+		interval, err = config.ParseDuration(options.updateInterval)
+		if err != nil {
+			return 0, 0, err
+		}
+		if interval <= 0 {
+			return 0, 0, config.ErrInvalidDuration
+		}
+	}
+	if interval == 0 {
+		interval = autoUpdateInterval
+	}
+
+	jitter := cfg.UpdateJitter
+	if jitter == 0 {
+		jitter = interval / 10
+	}
+	return interval, jitter, nil
+}
+
+// staleAfter returns the period after which a database source is stale: two
+// update intervals with their jitter, so that a single failed update doesn't
+// make it stale.
+func staleAfter(interval, jitter time.Duration) time.Duration {
+	return 2 * (interval + jitter)
+}
+
+// updateDelay returns the delay before the next update. After a successful
+// update, it's the interval plus a random delay of up to jitter, so that the
+// instances started together don't download the databases at the same time.
+// After failed updates, the update is retried with an exponential backoff,
+// from autoUpdateRetry up to the interval.
+func updateDelay(interval, jitter time.Duration, failures int) time.Duration {
+	if failures > 0 {
+		retry := autoUpdateRetry << min(failures-1, maxRetryShift)
+		return min(retry, interval)
+	}
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(jitter)
+}
+
+// autoUpdate updates the databases and the PeeringDB organizations every
+// interval, plus a random jitter. Failed updates, including the initial one
+// if the resolver is degraded, are retried sooner.
+func autoUpdate(u *updater, interval, jitter time.Duration) {
+	failures := 0
+	if u.resolver.Degraded() {
+		failures = 1
+	}
+	for {
+		delay := updateDelay(interval, jitter, failures)
+		log.Debugf("Next database update in %s", delay)
+		time.Sleep(delay)
+
+		if err := u.update(); err != nil {
+			failures++
 			log.Errorf("Cannot update databases: %v", err)
 			continue
 		}
-		log.Info("Databases updated")
+		failures = 0
 	}
 }
 
@@ -61,42 +667,282 @@ func loadConfig(path string) (*config.Configuration, error) {
 	return config.ReadConfig(bytes.NewReader(file))
 }
 
-// hasChanged returns true if the two file infos are different. It only checks
-// the size and the modification time.
+// hasChanged returns true if the two file infos are different. It checks the
+// size, the modification time and whether they're the same file, so that a
+// file replaced by a rename or a symlink swap is also detected.
 func hasChanged(a, b os.FileInfo) bool {
-	return a.Size() != b.Size() || a.ModTime() != b.ModTime()
+	return a.Size() != b.Size() || a.ModTime() != b.ModTime() ||
+		!os.SameFile(a, b)
 }
 
-// autoReload watches the configuration file for changes and updates the engine
-// when it happens.
-func autoReload(engine *rules.Engine, path string) {
-	prevStat, err := os.Stat(path)
+// reloadConfig reloads the configuration file and updates the engine and the
+// database overrides with it, then resolves its PeeringDB organizations. The
+// engine and the resolver are left unchanged if the file can't be read.
+func reloadConfig(
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	fetcher ipres.Fetcher,
+	path string,
+) {
+	cfg, err := loadConfig(path)
+	if err != nil {
+		log.Errorf("Cannot read configuration file: %v", err)
+		health.Report(health.ComponentConfig, health.StateDegraded, err)
+		return
+	}
+	engine.UpdateConfig(&cfg.AccessControl)
+	resolver.SetOverrides(newOverrides(cfg.Databases.Overrides))
+	log.Info("Configuration reloaded")
+	health.Report(health.ComponentConfig, health.StateOK, nil)
+	resolvePeeringDB(engine, fetcher)
+}
+
+// autoReload updates the engine and the database overrides when the
+// configuration file changes or when one of the reload signals is received.
+//
+// The directory of the file is watched rather than the file itself, so that
+// files replaced by a rename or a symlink swap, as Kubernetes does with
+// ConfigMaps, are still detected. Since a single change can emit several
+// events, the file is only checked once no event was received for
+// reloadDelay.
+func autoReload(
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	fetcher ipres.Fetcher,
+	path string,
+) {
+	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Errorf("Cannot watch configuration file: %v", err)
 		return
 	}
+	defer watcher.Close() // #nosec G104
 
-	for range time.Tick(autoReloadInterval) {
-		stat, err := os.Stat(path)
-		if err != nil {
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		log.Errorf("Cannot watch configuration file: %v", err)
+		return
+	}
+
+	// Without signals, signal.Notify would relay all the incoming signals.
+	signals := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(signals, reloadSignals...)
+	}
+
+	var (
+		prevStat, _ = os.Stat(path)
+		pending     <-chan time.Time
+	)
+	for {
+		select {
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			pending = time.After(reloadDelay)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
 			log.Errorf("Cannot watch configuration file: %v", err)
-			continue
+
+		case <-pending:
+			pending = nil
+			stat, err := os.Stat(path)
+			if err != nil {
+				log.Errorf("Cannot watch configuration file: %v", err)
+				continue
+			}
+			if prevStat != nil && !hasChanged(prevStat, stat) {
+				continue
+			}
+			prevStat = stat
+			reloadConfig(engine, resolver, fetcher, path)
+
+		case <-signals:
+			log.Info("Reload signal received")
+			prevStat, _ = os.Stat(path)
+			reloadConfig(engine, resolver, fetcher, path)
 		}
+	}
+}
 
-		if !hasChanged(prevStat, stat) {
-			continue
+// autoStage watches the next configuration file and stages it in the engine
+// whenever it's created or changes, so that it can be promoted instantly. Its
+// PeeringDB organizations are resolved before its promotion. The file may not
+// exist, for example after its promotion.
+func autoStage(engine *rules.Engine, fetcher ipres.Fetcher, path string) {
+	var prevStat os.FileInfo
+	for {
+		stat, err := os.Stat(path)
+		if err == nil && (prevStat == nil || hasChanged(prevStat, stat)) {
+			if cfg, err := loadConfig(path); err != nil {
+				log.Errorf("Cannot read next configuration file: %v", err)
+				health.Report(
+					health.ComponentConfig, health.StateDegraded, err,
+				)
+			} else {
+				engine.StageConfig(&cfg.AccessControl)
+				log.Info("Next configuration staged")
+				resolvePeeringDB(engine, fetcher)
+			}
 		}
 		prevStat = stat
+		time.Sleep(autoStageInterval)
+	}
+}
 
-		cfg, err := loadConfig(path)
-		if err != nil {
-			log.Errorf("Cannot read configuration file: %v", err)
+// promoteOnSignal promotes the staged configuration whenever one of the
+// promotion signals is received.
+func promoteOnSignal(engine *rules.Engine) {
+	if len(promoteSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, promoteSignals...)
+	for range signals {
+		if !engine.PromoteConfig() {
+			log.Warn("No staged configuration to promote")
 			continue
 		}
+		log.Info("Staged configuration promoted")
+	}
+}
+
+// toggleLogLevelOnSignal toggles the log level between info and debug
+// whenever one of the log level signals is received. Levels more verbose than
+// info are switched back to info.
+func toggleLogLevelOnSignal() {
+	if len(logLevelSignals) == 0 {
+		return
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, logLevelSignals...)
+	for range signals {
+		level := log.DebugLevel
+		if log.IsLevelEnabled(log.DebugLevel) {
+			level = log.InfoLevel
+		}
+		log.SetLevel(level)
+		log.WithField("level", level).Info("Log level changed")
+	}
+}
+
+// newSigner returns the signer of allowed decisions, or nil if no signature
+// secret is configured.
+func newSigner(cfg *config.Signature) *server.Signer {
+	if cfg.Secret == "" {
+		return nil
+	}
+	return server.NewSigner([]byte(cfg.Secret), cfg.Header)
+}
 
-		engine.UpdateConfig(&cfg.AccessControl)
-		log.Info("Configuration reloaded")
+// newHeaderPolicies returns the policies selecting the resolution headers of
+// the given domains.
+func newHeaderPolicies(cfg []config.ResponseHeaders) []server.HeaderPolicy {
+	policies := make([]server.HeaderPolicy, 0, len(cfg))
+	for _, headers := range cfg {
+		policies = append(policies, server.HeaderPolicy{
+			Domains: headers.Domains,
+			Headers: headers.Headers,
+			IP:      headers.IP,
+			HashKey: []byte(headers.HashKey),
+		})
 	}
+	return policies
+}
+
+// newFirstSeen returns the tracker of the countries of the sensitive domains,
+// or nil if no domain is configured.
+func newFirstSeen(cfg *config.FirstSeen) *firstseen.Tracker {
+	if len(cfg.Domains) == 0 {
+		return nil
+	}
+
+	tracker := firstseen.NewTracker(cfg.Domains)
+	if cfg.File != "" {
+		if err := tracker.Persist(cfg.File); err != nil {
+			log.Fatalf("Cannot load seen countries: %v", err)
+		}
+	}
+	return tracker
+}
+
+// newHistory returns the history of the requests per country and ASN, or nil
+// if no retention is configured.
+func newHistory(cfg *config.History) *history.Store {
+	if cfg.Retention == 0 {
+		return nil
+	}
+	return history.NewStore(history.Options{
+		Retention: cfg.Retention,
+		MaxASNs:   cfg.MaxASNs,
+	})
+}
+
+// newBreaker returns the circuit breaker of the flooded domains, or nil if no
+// limit is configured.
+func newBreaker(cfg *config.CircuitBreaker) *server.CircuitBreaker {
+	if cfg.MaxDenied == 0 {
+		return nil
+	}
+	return server.NewCircuitBreaker(server.BreakerOptions{
+		MaxDenied: cfg.MaxDenied,
+		Window:    cfg.Window,
+		Duration:  cfg.Duration,
+	})
+}
+
+// newAudit returns the audit log of the decisions, with the IPs anonymized by
+// the given anonymizer, or nil if no file is configured.
+func newAudit(
+	cfg *config.Audit,
+	anonymizer *privacy.Anonymizer,
+) *audit.Logger {
+	if cfg.File == "" {
+		return nil
+	}
+
+	rates := make(map[string]float64)
+	for outcome, rate := range map[string]*float64{
+		audit.OutcomeAllow:   cfg.Sampling.Allow,
+		audit.OutcomeDeny:    cfg.Sampling.Deny,
+		audit.OutcomeInvalid: cfg.Sampling.Invalid,
+	} {
+		if rate != nil {
+			rates[outcome] = *rate
+		}
+	}
+
+	options := audit.Options{
+		MaxSize:     int64(cfg.MaxSize),
+		MaxAge:      cfg.MaxAge,
+		MaxBackups:  cfg.MaxBackups,
+		SampleRates: rates,
+	}
+	if anonymizer.Enabled() {
+		options.Anonymize = anonymizer.AnonymizeString
+	}
+
+	logger, err := audit.Open(cfg.File, options)
+	if err != nil {
+		log.Fatalf("Cannot open audit log: %v", err)
+	}
+	return logger
+}
+
+// newWebhooks creates the notifier of the rule webhooks. It's created even if
+// no rule has a webhook, since rules can be reloaded. The IPs are anonymized
+// by the given anonymizer, if enabled.
+func newWebhooks(anonymizer *privacy.Anonymizer) *webhook.Notifier {
+	var options webhook.Options
+	if anonymizer.Enabled() {
+		options.Anonymize = anonymizer.AnonymizeString
+	}
+	return webhook.NewNotifier(options)
 }
 
 // configureLogger configures the logger with the given log level and sets the
@@ -116,6 +962,10 @@ func configureLogger(level string) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+	}
+
 	options := getOptions()
 	configureLogger(options.logLevel)
 
@@ -124,21 +974,185 @@ func main() {
 	if err != nil {
 		log.Fatalf("Cannot read configuration file: %v", err)
 	}
+	health.Report(health.ComponentConfig, health.StateOK, nil)
+
+	readOnly, err := strconv.ParseBool(options.readOnly)
+	if err != nil {
+		log.Fatalf("Invalid GEOBLOCK_READ_ONLY value: %v", err)
+	}
+	if readOnly {
+		log.Info("Read-only mode, the mutating endpoints are disabled")
+	}
+	updateInterval, updateJitter, err := updateSchedule(
+		options, &cfg.Databases,
+	)
+	if err != nil {
+		log.Fatalf("Invalid GEOBLOCK_UPDATE_INTERVAL value: %v", err)
+	}
+
+	labels := instanceLabels(options.instanceID, &cfg.Instance)
+	if err := configureInstance(labels); err != nil {
+		log.Fatalf("Invalid instance labels: %v", err)
+	}
+	if cfg.Metrics.Labels != nil {
+		if err := metrics.SetRequestLabels(cfg.Metrics.Labels); err != nil {
+			log.Fatalf("Invalid metric labels: %v", err)
+		}
+	}
+	metrics.SetMaxCountries(cfg.Metrics.MaxCountries)
+	anonymizer := configurePrivacy(&cfg.Privacy)
+
+	configureMemory(cfg)
 
 	log.Info("Initializing database resolver")
-	resolver := ipres.NewResolver()
+	var (
+		asn   = loadASN(cfg)
+		stale = cfg.Databases.FailurePolicy == config.FailurePolicyStale
+	)
+	fetcher := newFetcher(&cfg.Databases)
+	countryURL, cityURL, asnURL := databaseURLs(&cfg.Databases)
+	signatureKey, err := cfg.Databases.PublicKey()
+	if err != nil {
+		log.Fatalf("Invalid signature key: %v", err)
+	}
+	resolver := ipres.NewResolver(
+		fetcher,
+		ipres.Options{
+			Format:              cfg.Databases.Format,
+			CountryURL:          countryURL,
+			ASNURL:              asnURL,
+			City:                cfg.Databases.City,
+			CityURL:             cityURL,
+			URLs:                cfg.Databases.URLs,
+			URLOrder:            cfg.Databases.URLOrder,
+			Verifications:       newVerifications(cfg.Databases.Verify),
+			SignatureKey:        signatureKey,
+			MaxInvalidRecords:   cfg.Databases.MaxInvalidRecords,
+			Concurrency:         databaseConcurrency(cfg),
+			FetchTimeout:        cfg.Databases.FetchTimeout,
+			DisableASN:          !asn,
+			CrossCheck:          cfg.Databases.CrossCheck && asn,
+			Registries:          cfg.Databases.Registries,
+			CDN:                 cfg.Databases.CDN,
+			Monitors:            cfg.Databases.Monitors,
+			Anonymizers:         cfg.Databases.Anonymizers,
+			Overrides:           newOverrides(cfg.Databases.Overrides),
+			KeepPartial:         stale,
+			StaleAfter:          staleAfter(updateInterval, updateJitter),
+			ResolutionCacheSize: resolutionCacheSize(cfg),
+			ResolutionCacheTTL:  cfg.Databases.ResolutionCache.TTL,
+			Index:               databaseIndex(cfg),
+			SnapshotFile:        snapshotFile(&cfg.Databases),
+		},
+	)
 	if err := resolver.Update(); err != nil {
-		log.Fatalf("Cannot initialize database resolver: %v", err)
+		if cfg.Databases.FailurePolicy == "" {
+			if errors.Is(err, ipres.ErrDatabaseUnavailable) {
+				log.Error(
+					"Set databases.failure_policy to start while the " +
+						"databases are unavailable",
+				)
+			}
+			log.Fatalf("Cannot initialize database resolver: %v", err)
+		}
+		log.WithField(
+			"failure_policy", cfg.Databases.FailurePolicy,
+		).Errorf("Cannot initialize database resolver: %v", err)
 	}
+	logMemory(resolver)
+	logEmpty(resolver, false)
+	releaseMemory(cfg.LowMemory)
 
 	var (
-		address = ":" + options.serverPort
-		engine  = rules.NewEngine(&cfg.AccessControl)
-		server  = server.NewServer(address, engine, resolver)
+		address       = ":" + options.serverPort
+		engine        = rules.NewEngine(&cfg.AccessControl)
+		serverOptions = server.Options{
+			Signer:         newSigner(&cfg.Signature),
+			DecisionTTL:    cfg.DecisionTTL,
+			HeaderPolicies: newHeaderPolicies(cfg.ResponseHeaders),
+			TrustedProxies: prefixes(cfg.TrustedProxies),
+			ContextHeader:  cfg.ContextHeader,
+			FirstSeen:      newFirstSeen(&cfg.FirstSeen),
+			Audit:          newAudit(&cfg.Audit, anonymizer),
+			Webhooks:       newWebhooks(anonymizer),
+			History:        newHistory(&cfg.History),
+			Breaker:        newBreaker(&cfg.CircuitBreaker),
+		}
+		server = server.NewServer(address, engine, resolver, serverOptions)
 	)
 
-	go autoUpdate(resolver)
-	go autoReload(engine, options.configPath)
+	logReport(options, cfg, resolver, &serverOptions, address)
+
+	if resolver.Degraded() {
+		engine.SetFallbackPolicy(fallbackPolicy(&cfg.Databases))
+	}
+	resolvePeeringDB(engine, fetcher)
+
+	if cfg.Bans.File != "" {
+		if err := engine.Bans().Persist(cfg.Bans.File); err != nil {
+			log.Fatalf("Cannot load bans: %v", err)
+		}
+	}
+
+	check := newCheckServer(cfg, engine, resolver, serverOptions)
+	if check != nil {
+		go func() {
+			log.Infof("Starting TCP check server at %s", check.Addr)
+			log.Fatal(check.ListenAndServe())
+		}()
+	}
+
+	milter := newMilterServer(cfg, engine, resolver, serverOptions)
+	if milter != nil {
+		go func() {
+			log.Infof("Starting milter server at %s", milter.Addr)
+			log.Fatal(milter.ListenAndServe())
+		}()
+	}
+
+	if dnsbl := newDNSBLServer(cfg, engine, resolver); dnsbl != nil {
+		go func() {
+			log.Infof("Starting DNSBL server at %s", dnsbl.Addr)
+			log.Fatal(dnsbl.ListenAndServe())
+		}()
+	}
+
+	updates := &updater{
+		resolver:  resolver,
+		engine:    engine,
+		fetcher:   fetcher,
+		lowMemory: cfg.LowMemory,
+		empty:     resolver.Empty(),
+	}
+	admin := newAdminServer(
+		cfg, engine, updates, serverOptions.History,
+		readOnly, options.nextConfigPath != "",
+	)
+	if admin != nil {
+		go func() {
+			log.Infof("Starting admin server at %s", admin.Addr)
+			if admin.TLSConfig != nil {
+				log.Fatal(admin.ListenAndServeTLS("", ""))
+			}
+			log.Fatal(admin.ListenAndServe())
+		}()
+	}
+
+	extAuthz := newExtAuthzServer(cfg, engine, resolver, serverOptions)
+	if extAuthz != nil {
+		go func() {
+			log.Infof("Starting ext_authz server at %s", extAuthz.Addr)
+			log.Fatal(extAuthz.ListenAndServe())
+		}()
+	}
+
+	go autoUpdate(updates, updateInterval, updateJitter)
+	go autoReload(engine, resolver, fetcher, options.configPath)
+	if options.nextConfigPath != "" {
+		go autoStage(engine, fetcher, options.nextConfigPath)
+		go promoteOnSignal(engine)
+	}
+	go toggleLogLevelOnSignal()
 
 	log.Infof("Starting server at %s", server.Addr)
 	log.Fatal(server.ListenAndServe())
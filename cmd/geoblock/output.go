@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// Output formats of the subcommands.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// Exit codes of the subcommands.
+const (
+	exitOK     = 0 // The command succeeded
+	exitError  = 1 // The command couldn't run, e.g., unreachable instance
+	exitUsage  = 2 // Unknown command, invalid flags or arguments
+	exitFailed = 3 // The command ran, but its check failed
+)
+
+// errUsage is returned by the subcommands when their flags or arguments are
+// invalid.
+var errUsage = errors.New("invalid usage")
+
+// failures are the errors returned by the subcommands whose check failed.
+var failures = []error{
+	errInvalidConfig,
+	errConformance,
+	errDenied,
+	errNotFound,
+	errDatabases,
+}
+
+// exitCode returns the exit code of a subcommand that returned the given
+// error.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	}
+	for _, failure := range failures {
+		if errors.Is(err, failure) {
+			return exitFailed
+		}
+	}
+	return exitError
+}
+
+// outputFormat is the value of the `-output` flag of the subcommands.
+type outputFormat string
+
+// String returns the output format.
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+// Set sets the output format, which must be "table" or "json".
+func (f *outputFormat) Set(value string) error {
+	switch value {
+	case outputTable, outputJSON:
+		*f = outputFormat(value)
+		return nil
+	}
+	return fmt.Errorf("invalid output format %q (table or json)", value)
+}
+
+// outputFlag registers the `-output` flag on the given flag set and returns
+// its value, which defaults to "table".
+func outputFlag(flags *flag.FlagSet) *outputFormat {
+	format := outputFormat(outputTable)
+	flags.Var(&format, "output", "output format: table or json")
+	return &format
+}
+
+// parseFlags parses the given arguments with the given flag set. Invalid
+// flags are usage errors.
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+	return err
+}
+
+// printJSON writes the given value to the standard output as indented JSON.
+func printJSON(value any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// printTable writes the given rows to the standard output as a table with
+// aligned columns, under the given header. Empty cells are written as "-".
+func printTable(header []string, rows [][]string) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	writeRow(writer, header)
+	for _, row := range rows {
+		writeRow(writer, row)
+	}
+	return writer.Flush()
+}
+
+// writeRow writes the given cells to the given writer, separated by tabs.
+func writeRow(writer io.Writer, cells []string) {
+	for i, cell := range cells {
+		if cell == "" {
+			cell = "-"
+		}
+		if i > 0 {
+			fmt.Fprint(writer, "\t")
+		}
+		fmt.Fprint(writer, cell)
+	}
+	fmt.Fprintln(writer)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/privacy"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+// privacyHook is a logger hook that anonymizes the client IPs of all the log
+// entries.
+type privacyHook struct {
+	anonymizer *privacy.Anonymizer
+}
+
+// privacyFields are the log fields containing client IPs or networks.
+var privacyFields = []string{server.FieldSourceIP, server.FieldBanNetwork}
+
+// Levels returns the levels of the entries modified by the hook.
+func (h *privacyHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire anonymizes the client IPs and networks of the given entry, if any.
+func (h *privacyHook) Fire(entry *log.Entry) error {
+	for _, field := range privacyFields {
+		if value, ok := entry.Data[field]; ok {
+			entry.Data[field] = h.anonymizer.AnonymizeString(
+				fmt.Sprint(value),
+			)
+		}
+	}
+	return nil
+}
+
+// configurePrivacy anonymizes the client IPs of all the logs and returns the
+// anonymizer, which is disabled if the IPs are kept as is.
+func configurePrivacy(cfg *config.Privacy) *privacy.Anonymizer {
+	anonymizer := privacy.NewAnonymizer(cfg.IP, cfg.KeyRotation)
+	if anonymizer.Enabled() {
+		log.AddHook(&privacyHook{anonymizer: anonymizer})
+	}
+	return anonymizer
+}
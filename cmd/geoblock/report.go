@@ -0,0 +1,142 @@
+package main
+
+import (
+	"maps"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/privacy"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+// listeners returns the addresses of the enabled servers, by server name.
+func listeners(cfg *config.Configuration, address string) []string {
+	result := []string{"http=" + address}
+	for name, addr := range map[string]string{
+		"tcp_check": cfg.TCPCheck.Address,
+		"milter":    cfg.Milter.Address,
+		"dnsbl":     cfg.DNSBL.Address,
+		"ext_authz": cfg.ExtAuthz.Address,
+		"admin":     cfg.Admin.Address,
+		"acme":      acmeHTTPAddress(&cfg.Admin),
+	} {
+		if addr != "" {
+			result = append(result, name+"="+addr)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// features returns the names of the optional features that are enabled.
+func features(
+	cfg *config.Configuration,
+	options *server.Options,
+	asn bool,
+	readOnly bool,
+	promote bool,
+) []string {
+	var (
+		result  []string
+		private = cfg.Privacy.IP != "" && cfg.Privacy.IP != privacy.ModeFull
+	)
+	for name, enabled := range map[string]bool{
+		"asn":         asn,
+		"city":        cfg.Databases.City,
+		"cross_check": cfg.Databases.CrossCheck && asn,
+		"cdn":         cfg.Databases.CDN,
+		"registries":  len(cfg.Databases.Registries) > 0,
+		"bans_file":   cfg.Bans.File != "",
+		"promote_api": promote && cfg.Admin.Address != "",
+		"read_only":   readOnly,
+		"signature":   options.Signer != nil,
+		"first_seen":  options.FirstSeen != nil,
+		"audit":       options.Audit != nil,
+		"history":     options.History != nil,
+		"breaker":     options.Breaker != nil,
+		"webhooks":    hasWebhooks(&cfg.AccessControl),
+		"verify":      len(cfg.Databases.Verify) > 0,
+		"snapshot":    snapshotFile(&cfg.Databases) != "",
+		"context":     options.ContextHeader != "",
+		"privacy":     private,
+		"low_memory":  cfg.LowMemory,
+		"acme":        cfg.Admin.Address != "" && cfg.Admin.ACME != nil,
+	} {
+		if enabled {
+			result = append(result, name)
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// hasWebhooks returns true if a rule of the given access control
+// configuration has a webhook.
+func hasWebhooks(accessControl *config.AccessControl) bool {
+	for _, rule := range accessControl.Rules {
+		if rule.Webhook != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// logReport logs a summary of the effective options on startup, so that it
+// can be included in bug reports. URLs are redacted.
+func logReport(
+	options *appOptions,
+	cfg *config.Configuration,
+	resolver *ipres.Resolver,
+	serverOptions *server.Options,
+	address string,
+) {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+
+	format := cfg.Databases.Format
+	if format == "" {
+		format = ipres.FormatCSV
+	}
+
+	urls := resolver.SourceURLs()
+	sources := make([]string, 0, len(urls))
+	for _, name := range slices.Sorted(maps.Keys(urls)) {
+		redacted := make([]string, 0, len(urls[name]))
+		for _, rawURL := range urls[name] {
+			redacted = append(redacted, ipres.RedactURL(rawURL))
+		}
+		sources = append(sources, name+"="+strings.Join(redacted, ","))
+	}
+
+	readOnly, _ := strconv.ParseBool(options.readOnly)
+	enabled := features(
+		cfg, serverOptions, loadASN(cfg),
+		readOnly, options.nextConfigPath != "",
+	)
+	log.WithFields(log.Fields{
+		"version":         version,
+		"go_version":      runtime.Version(),
+		"config":          options.configPath,
+		"next_config":     options.nextConfigPath,
+		"log_level":       log.GetLevel().String(),
+		"database_format": format,
+		"database_index":  databaseIndex(cfg),
+		"concurrency":     databaseConcurrency(cfg),
+		"database_cache":  cfg.Databases.Cache.Directory,
+		"failure_policy":  cfg.Databases.FailurePolicy,
+		"sources":         sources,
+		"default_policy":  cfg.AccessControl.DefaultPolicy,
+		"rules":           len(cfg.AccessControl.Rules),
+		"listeners":       listeners(cfg, address),
+		"features":        enabled,
+	}).Info("Startup report")
+}
@@ -0,0 +1,17 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// promoteSignals is empty on platforms without user-defined signals. The
+// staged configuration can still be promoted through the HTTP API.
+var promoteSignals []os.Signal
+
+// reloadSignals is empty on platforms without SIGHUP. The configuration file
+// is still reloaded when it changes.
+var reloadSignals []os.Signal
+
+// logLevelSignals is empty on platforms without user-defined signals. The log
+// level can still be changed through the admin API.
+var logLevelSignals []os.Signal
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// promoteSignals are the signals that promote the staged configuration.
+var promoteSignals = []os.Signal{syscall.SIGUSR1}
+
+// reloadSignals are the signals that reload the configuration file.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// logLevelSignals are the signals that toggle the log level between info and
+// debug.
+var logLevelSignals = []os.Signal{syscall.SIGUSR2}
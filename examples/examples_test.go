@@ -0,0 +1,168 @@
+package examples_test
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// update rewrites the golden files with the current decisions instead of
+// comparing them: go test ./examples -update.
+var update = flag.Bool("update", false, "update the golden files")
+
+// unknown is the golden value of an unknown country.
+const unknown = "-"
+
+// readConfig reads the configuration file at the given path.
+func readConfig(t *testing.T, path string) *config.Configuration {
+	t.Helper()
+	file, err := os.Open(path) // #nosec G304
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	cfg, err := config.ReadConfig(file)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	return cfg
+}
+
+// TestConfigs checks that all the example configurations, including the ones
+// of the reverse proxy examples, are valid.
+func TestConfigs(t *testing.T) {
+	paths, err := filepath.Glob("*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pattern := range []string{"*/config.yaml", "scenarios/*.yaml"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no example configuration found")
+	}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			readConfig(t, path)
+		})
+	}
+}
+
+// decide evaluates the query of a golden line, made of the domain, method,
+// source IP, country and ASN, and returns the decision in golden form.
+func decide(engine *rules.Engine, fields []string) (string, error) {
+	if len(fields) != 5 {
+		return "", fmt.Errorf("got %d query fields, want 5", len(fields))
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return "", err
+	}
+	asn, err := strconv.ParseUint(fields[4], 10, 32)
+	if err != nil {
+		return "", err
+	}
+	country := fields[3]
+	if country == unknown {
+		country = ""
+	}
+
+	decision := engine.Evaluate(&rules.Query{
+		RequestedDomain: fields[0],
+		RequestedMethod: fields[1],
+		SourceIP:        ip,
+		SourceCountry:   country,
+		SourceASN:       uint32(asn),
+	})
+
+	result := config.PolicyDeny
+	if decision.Allowed {
+		result = config.PolicyAllow
+	}
+	switch {
+	case decision.Rule == rules.NoRule:
+		return result + " default", nil
+	case decision.RuleName != "":
+		return result + " " + decision.RuleName, nil
+	default:
+		return result + " " + strconv.Itoa(decision.Rule), nil
+	}
+}
+
+// TestScenarios evaluates the queries of the golden file of each scenario,
+// e.g., `homelab.golden` for `homelab.yaml`, and compares the decisions with
+// the expected ones. Each line of a golden file has the form:
+//
+//	<domain> <method> <ip> <country> <asn> => <policy> <rule>
+//
+// where the country is `-` if unknown, and the rule is the name or index of
+// the matching rule, or `default` if none matched.
+func TestScenarios(t *testing.T) {
+	paths, err := filepath.Glob("scenarios/*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			cfg := readConfig(t, path)
+			engine := rules.NewEngine(&cfg.AccessControl)
+
+			golden := strings.TrimSuffix(path, ".yaml") + ".golden"
+			file, err := os.Open(golden) // #nosec G304
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			var (
+				output  strings.Builder
+				scanner = bufio.NewScanner(file)
+			)
+			for line := 1; scanner.Scan(); line++ {
+				text := scanner.Text()
+				query, want, ok := strings.Cut(text, "=>")
+				if !ok || strings.HasPrefix(text, "#") {
+					output.WriteString(text + "\n")
+					continue
+				}
+
+				got, err := decide(engine, strings.Fields(query))
+				if err != nil {
+					t.Fatalf("%s:%d: %v", golden, line, err)
+				}
+				if want = strings.TrimSpace(want); got != want && !*update {
+					t.Errorf("%s:%d: %s: got %q, want %q", golden, line,
+						strings.TrimSpace(query), got, want)
+				}
+				output.WriteString(query + "=> " + got + "\n")
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if *update {
+				err := os.WriteFile(golden, []byte(output.String()), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Package audit writes the authorization decisions as JSON lines to a
+// dedicated file, rotated by size and age.
+package audit
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of the audited decisions. Invalid requests, e.g., without source
+// IP, are denied without being evaluated.
+const (
+	OutcomeAllow   = "allow"
+	OutcomeDeny    = "deny"
+	OutcomeInvalid = "invalid"
+)
+
+// Default rotation options.
+const (
+	DefaultMaxSize    = 100 << 20
+	DefaultMaxBackups = 5
+)
+
+// backupTimeFormat is the format of the suffix of the rotated files. It sorts
+// chronologically.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// Record is an audited authorization decision.
+type Record struct {
+	Time     time.Time `json:"time"`
+	IP       string    `json:"ip"`
+	Country  string    `json:"country,omitempty"`
+	ASN      uint32    `json:"asn,omitempty"`
+	Service  string    `json:"service,omitempty"`
+	Domain   string    `json:"domain"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path,omitempty"`
+	Rule     *int      `json:"rule,omitempty"`
+	RuleName string    `json:"rule_name,omitempty"`
+	Banned   bool      `json:"banned,omitempty"`
+	Outcome  string    `json:"outcome"`
+}
+
+// Options are the rotation, sampling and anonymization options of a Logger.
+type Options struct {
+	// MaxSize is the size in bytes above which the file is rotated. If zero,
+	// DefaultMaxSize is used.
+	MaxSize int64
+
+	// MaxAge is the time after which the file is rotated, counted from the
+	// first write to it. If zero, the file isn't rotated by age.
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files to keep. If zero,
+	// DefaultMaxBackups is used.
+	MaxBackups int
+
+	// SampleRates are the fractions of the records written to the file, by
+	// outcome, between 0 (none) and 1 (all). The records of the outcomes
+	// without sample rate are all written.
+	SampleRates map[string]float64
+
+	// Anonymize returns the form of the IPs written to the file. If nil, the
+	// IPs are written as is.
+	Anonymize func(ip string) string
+}
+
+// Logger writes the audit records to a file. It's safe for concurrent use.
+//
+// When the file is rotated, it's renamed with the rotation time as suffix,
+// e.g., `audit.log.20250102T150405.000000000`, and the oldest rotated files
+// are removed.
+type Logger struct {
+	path    string
+	options Options
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time // Time of the first write to the file
+	now     func() time.Time
+	random  func() float64 // Random number in [0, 1), for sampling
+}
+
+// Open opens the given audit file for appending, creating it if needed.
+func Open(path string, options Options) (*Logger, error) {
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if options.MaxBackups <= 0 {
+		options.MaxBackups = DefaultMaxBackups
+	}
+
+	logger := &Logger{
+		path:    path,
+		options: options,
+		now:     time.Now,
+		random:  rand.Float64,
+	}
+	if err := logger.open(); err != nil {
+		return nil, err
+	}
+	return logger, nil
+}
+
+// open opens the logger's file. The caller must hold the lock.
+func (l *Logger) open() error {
+	file, err := os.OpenFile( // #nosec G304
+		l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600,
+	)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close() // #nosec G104
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	l.started = time.Time{}
+	return nil
+}
+
+// Log writes the given record to the audit file, rotating it first if
+// needed. Records not selected by the sample rate of their outcome are
+// dropped.
+func (l *Logger) Log(record *Record) error {
+	if !l.sampled(record.Outcome) {
+		return nil
+	}
+	if l.options.Anonymize != nil {
+		anonymized := *record
+		anonymized.IP = l.options.Anonymize(record.IP)
+		record = &anonymized
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.mustRotate(now, int64(len(line))) {
+		if err := l.rotate(now); err != nil {
+			return err
+		}
+	}
+	if l.started.IsZero() {
+		l.started = now
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// sampled returns true if a record with the given outcome must be written,
+// according to the sample rates.
+func (l *Logger) sampled(outcome string) bool {
+	rate, ok := l.options.SampleRates[outcome]
+	return !ok || rate >= 1 || l.random() < rate
+}
+
+// mustRotate returns true if the file must be rotated before writing the
+// given number of bytes. An empty file is never rotated. The caller must
+// hold the lock.
+func (l *Logger) mustRotate(now time.Time, n int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.size+n > l.options.MaxSize {
+		return true
+	}
+	return l.options.MaxAge > 0 && !l.started.IsZero() &&
+		now.Sub(l.started) >= l.options.MaxAge
+}
+
+// rotate renames the current file, opens a new one and removes the oldest
+// rotated files. The caller must hold the lock.
+func (l *Logger) rotate(now time.Time) error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	backup := l.path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.Rename(l.path, backup); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	return l.prune()
+}
+
+// prune removes the oldest rotated files beyond the maximum number of
+// backups. The caller must hold the lock.
+func (l *Logger) prune() error {
+	matches, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return err
+	}
+
+	// Other files may share the prefix of the rotated files.
+	var backups []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, l.path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	slices.Sort(backups)
+	for len(backups) > l.options.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Close closes the audit file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
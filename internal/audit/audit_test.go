@@ -0,0 +1,228 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readRecords returns the records of the given audit file.
+func readRecords(t *testing.T, path string) []Record {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// backups returns the rotated files of the given audit file.
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	rule := 2
+	want := Record{
+		Time:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		IP:      "1.2.3.4",
+		Country: "FR",
+		ASN:     64512,
+		Domain:  "example.com",
+		Method:  "GET",
+		Rule:    &rule,
+		Outcome: OutcomeAllow,
+	}
+	if err := logger.Log(&want); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	got := records[0]
+	if !got.Time.Equal(want.Time) || got.IP != want.IP ||
+		got.Country != want.Country || got.ASN != want.ASN ||
+		got.Domain != want.Domain || got.Method != want.Method ||
+		got.Rule == nil || *got.Rule != rule || got.Outcome != want.Outcome {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestRotateSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, Options{MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// Each record exceeds the maximum size, so each write rotates the file,
+	// except the first one. Only the two latest rotated files are kept.
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 4 {
+		logger.now = func() time.Time {
+			return now.Add(time.Duration(i) * time.Second)
+		}
+		if err := logger.Log(&Record{Domain: "example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := len(readRecords(t, path)); got != 1 {
+		t.Errorf("got %d records, want 1", got)
+	}
+	got := backups(t, path)
+	want := []string{
+		path + ".20250102T030407.000000000",
+		path + ".20250102T030408.000000000",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got backups %v, want %v", got, want)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, Options{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	tests := []struct {
+		offset  time.Duration
+		backups int
+	}{
+		{0, 0},
+		{30 * time.Minute, 0},
+		{time.Hour, 1},
+		{90 * time.Minute, 1},
+		{2 * time.Hour, 2},
+	}
+
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, tt := range tests {
+		logger.now = func() time.Time { return now.Add(tt.offset) }
+		if err := logger.Log(&Record{Domain: "example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(backups(t, path)); got != tt.backups {
+			t.Errorf("at %v: got %d backups, want %d", tt.offset, got,
+				tt.backups)
+		}
+	}
+}
+
+func TestPruneIgnoresOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	other := path + ".old"
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger, err := Open(path, Options{MaxSize: 1, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	for range 3 {
+		if err := logger.Log(&Record{Domain: "example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, Options{SampleRates: map[string]float64{
+		OutcomeAllow:   0.25,
+		OutcomeInvalid: 0,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	// Successive random numbers: 0, 0.1, ..., 0.9.
+	var draws int
+	logger.random = func() float64 {
+		draws++
+		return float64(draws-1) / 10
+	}
+
+	outcomes := []string{OutcomeAllow, OutcomeDeny, OutcomeInvalid}
+	for _, outcome := range outcomes {
+		for range 10 {
+			if err := logger.Log(&Record{Outcome: outcome}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		draws = 0
+	}
+
+	counts := make(map[string]int)
+	for _, record := range readRecords(t, path) {
+		counts[record.Outcome]++
+	}
+	want := map[string]int{OutcomeAllow: 3, OutcomeDeny: 10}
+	if !maps.Equal(counts, want) {
+		t.Errorf("got %v records by outcome, want %v", counts, want)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(path, Options{
+		Anonymize: func(ip string) string { return "anonymized-" + ip },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	record := &Record{IP: "203.0.113.10", Outcome: OutcomeAllow}
+	if err := logger.Log(record); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 1 || records[0].IP != "anonymized-203.0.113.10" {
+		t.Errorf("got records %+v, want the anonymized IP", records)
+	}
+	if record.IP != "203.0.113.10" {
+		t.Errorf("got record IP %q, want it unchanged", record.IP)
+	}
+}
@@ -0,0 +1,429 @@
+// Package autotls obtains and renews a TLS certificate with the ACME
+// protocol, e.g., from Let's Encrypt. The control of the domains of the
+// certificate is proven by a pluggable Solver: HTTP01Solver answers the
+// HTTP-01 challenges, and HookSolver publishes the DNS-01 records with an
+// external command.
+package autotls
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// DefaultDirectoryURL is the directory URL of the Let's Encrypt production
+// ACME server.
+const DefaultDirectoryURL = acme.LetsEncryptURL
+
+// Default options of a Manager.
+const (
+	DefaultRenewBefore = 30 * 24 * time.Hour
+	DefaultTimeout     = 5 * time.Minute
+)
+
+// Intervals between the checks of the certificate, see Manager.Run.
+const (
+	checkInterval = 12 * time.Hour
+	retryInterval = time.Hour
+)
+
+// Names of the files stored in the directory of a Manager.
+const (
+	accountKeyFile  = "account.key"
+	certificateFile = "certificate.pem"
+)
+
+var (
+	// ErrNoCertificate is returned by GetCertificate until a certificate is
+	// obtained.
+	ErrNoCertificate = errors.New("no certificate obtained yet")
+
+	// ErrNoChallenge is returned when the ACME server doesn't offer a
+	// challenge of the type of the solver.
+	ErrNoChallenge = errors.New("no challenge of the solver type")
+)
+
+// Options contains the options of a Manager.
+type Options struct {
+	// Domains are the DNS names of the certificate. Wildcard names, e.g.,
+	// "*.example.com", require a DNS-01 solver.
+	Domains []string
+
+	// Email is the contact address of the ACME account, e.g., to be warned
+	// of expiring certificates. It's optional.
+	Email string
+
+	// DirectoryURL is the directory URL of the ACME server. If empty,
+	// DefaultDirectoryURL is used.
+	DirectoryURL string
+
+	// Directory is the directory where the account key and the certificate
+	// are stored, so that they're reused on restart.
+	Directory string
+
+	// Solver solves the challenges of the ACME server.
+	Solver Solver
+
+	// RenewBefore is the period before the expiry of the certificate during
+	// which it's renewed. If zero, DefaultRenewBefore is used.
+	RenewBefore time.Duration
+
+	// Timeout is the timeout of each attempt to obtain a certificate. If
+	// zero, DefaultTimeout is used.
+	Timeout time.Duration
+
+	// HTTPClient is the client of the ACME server. If nil, the default HTTP
+	// client is used.
+	HTTPClient *http.Client
+}
+
+// Manager obtains a certificate from an ACME server and renews it before it
+// expires. It's safe for concurrent use.
+type Manager struct {
+	options Options
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	now     func() time.Time
+}
+
+// NewManager creates a new manager without certificate. See Renew and Run.
+func NewManager(options Options) *Manager {
+	if options.DirectoryURL == "" {
+		options.DirectoryURL = DefaultDirectoryURL
+	}
+	if options.RenewBefore <= 0 {
+		options.RenewBefore = DefaultRenewBefore
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	return &Manager{options: options, now: time.Now}
+}
+
+// GetCertificate returns the current certificate, whatever the client hello.
+// It's meant to be used as tls.Config.GetCertificate.
+func (m *Manager) GetCertificate(
+	*tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.cert == nil {
+		return nil, ErrNoCertificate
+	}
+	return m.cert, nil
+}
+
+// Run renews the certificate now, then checks every 12 hours whether it must
+// be renewed again. Failed renewals are retried after an hour. It never
+// returns.
+func (m *Manager) Run() {
+	for {
+		delay := checkInterval
+		if err := m.Renew(); err != nil {
+			log.WithError(err).Error("Cannot obtain TLS certificate")
+			delay = retryInterval
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Renew loads the stored certificate, if none is loaded yet, and obtains a
+// new one if there is none, if it expires within the RenewBefore period, or
+// if it doesn't cover all the domains. The new certificate is stored and
+// replaces the current one.
+func (m *Manager) Renew() error {
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+
+	if cert == nil {
+		stored, err := m.load()
+		switch {
+		case err == nil:
+			cert = stored
+			m.setCertificate(cert)
+		case !errors.Is(err, fs.ErrNotExist):
+			log.WithError(err).Warn("Cannot load stored TLS certificate")
+		}
+	}
+	if cert != nil && m.valid(cert.Leaf) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), m.options.Timeout,
+	)
+	defer cancel()
+	cert, err := m.obtain(ctx)
+	if err != nil {
+		return err
+	}
+	if err := m.save(cert); err != nil {
+		log.WithError(err).Warn("Cannot store TLS certificate")
+	}
+	m.setCertificate(cert)
+	log.WithFields(log.Fields{
+		"domains": m.options.Domains,
+		"expiry":  cert.Leaf.NotAfter,
+	}).Info("TLS certificate obtained")
+	return nil
+}
+
+// setCertificate replaces the current certificate.
+func (m *Manager) setCertificate(cert *tls.Certificate) {
+	m.mu.Lock()
+	m.cert = cert
+	m.mu.Unlock()
+	metrics.TLSCertificateExpiry.Set(float64(cert.Leaf.NotAfter.Unix()))
+}
+
+// valid checks that the given certificate covers all the domains and doesn't
+// expire within the RenewBefore period.
+func (m *Manager) valid(leaf *x509.Certificate) bool {
+	for _, domain := range m.options.Domains {
+		if !slices.Contains(leaf.DNSNames, domain) {
+			return false
+		}
+	}
+	return m.now().Add(m.options.RenewBefore).Before(leaf.NotAfter)
+}
+
+// obtain obtains a new certificate for the domains from the ACME server.
+func (m *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	accountKey, err := m.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: m.options.DirectoryURL,
+		HTTPClient:   m.options.HTTPClient,
+		UserAgent:    "geoblock",
+	}
+
+	account := &acme.Account{}
+	if m.options.Email != "" {
+		account.Contact = []string{"mailto:" + m.options.Email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("register account: %w", err)
+	}
+
+	order, err := client.AuthorizeOrder(
+		ctx, acme.DomainIDs(m.options.Domains...),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, url); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(
+		rand.Reader,
+		&x509.CertificateRequest{DNSNames: m.options.Domains},
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalize order: %w", err)
+	}
+	return newCertificate(chain, key)
+}
+
+// authorize solves a challenge of the authorization with the given URL, if
+// it isn't valid yet, and waits until the ACME server validates it.
+func (m *Manager) authorize(
+	ctx context.Context,
+	client *acme.Client,
+	url string,
+) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var (
+		domain    = authz.Identifier.Value
+		solver    = m.options.Solver
+		challenge *acme.Challenge
+	)
+	if authz.Wildcard {
+		domain = "*." + domain
+	}
+	for _, c := range authz.Challenges {
+		if c.Type == solver.Type() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%w: %s: %s", ErrNoChallenge, domain, solver.Type())
+	}
+
+	keyAuth, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	err = solver.Present(ctx, domain, challenge.Token, keyAuth)
+	if err != nil {
+		return fmt.Errorf("present challenge: %s: %w", domain, err)
+	}
+	defer func() {
+		err := solver.CleanUp(ctx, domain, challenge.Token, keyAuth)
+		if err != nil {
+			log.WithError(err).WithField(
+				"domain", domain,
+			).Warn("Cannot clean up ACME challenge")
+		}
+	}()
+
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("accept challenge: %s: %w", domain, err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("wait authorization: %s: %w", domain, err)
+	}
+	return nil
+}
+
+// newCertificate returns the TLS certificate with the given DER chain and
+// private key.
+func newCertificate(
+	chain [][]byte,
+	key crypto.PrivateKey,
+) (*tls.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("empty certificate chain")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// accountKey returns the key of the ACME account, stored in the directory.
+// A new key is generated and stored if there's none.
+func (m *Manager) accountKey() (crypto.Signer, error) {
+	path := filepath.Join(m.options.Directory, accountKeyFile)
+	data, err := os.ReadFile(path) // #nosec G304
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key: %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, writeFile(path, pem.EncodeToMemory(
+		&pem.Block{Type: "EC PRIVATE KEY", Bytes: der},
+	))
+}
+
+// load loads the certificate stored in the directory.
+func (m *Manager) load() (*tls.Certificate, error) {
+	path := filepath.Join(m.options.Directory, certificateFile)
+	data, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	return newCertificate(cert.Certificate, cert.PrivateKey)
+}
+
+// save stores the given certificate, with its chain and private key, in the
+// directory.
+func (m *Manager) save(cert *tls.Certificate) error {
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	for _, block := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: block},
+		)...)
+	}
+	data = append(data, pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: der},
+	)...)
+	return writeFile(filepath.Join(m.options.Directory, certificateFile), data)
+}
+
+// writeFile writes the given private data to the given file, through a
+// temporary file renamed once written, so that the file is never partially
+// written. The directory is created if needed.
+func writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // #nosec G104
+
+	if _, err := file.Write(data); err != nil {
+		file.Close() // #nosec G104
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,323 @@
+package autotls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/autotls"
+)
+
+// fakeACME is a minimal ACME server that validates the HTTP-01 challenges
+// with the given solver and issues self-signed certificates valid for the
+// given period.
+type fakeACME struct {
+	t        *testing.T
+	server   *httptest.Server
+	solver   *autotls.HTTP01Solver
+	validity time.Duration
+
+	mu          sync.Mutex
+	domains     []string
+	valid       []bool
+	certificate []byte
+	issued      int
+}
+
+func newFakeACME(
+	t *testing.T,
+	solver *autotls.HTTP01Solver,
+	validity time.Duration,
+) *fakeACME {
+	f := &fakeACME{t: t, solver: solver, validity: validity}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// payload decodes the payload of the JWS body of the given request.
+func (f *fakeACME) payload(r *http.Request, value any) {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		f.t.Error(err)
+		return
+	}
+	data, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err == nil {
+		err = json.Unmarshal(data, value)
+	}
+	if err != nil {
+		f.t.Error(err)
+	}
+}
+
+func (f *fakeACME) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	url := f.server.URL
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+	w.Header().Set("Replay-Nonce", nonce)
+	switch path := r.URL.Path; {
+	case path == "/directory":
+		writeJSON(w, http.StatusOK, map[string]string{
+			"newNonce":   url + "/nonce",
+			"newAccount": url + "/account",
+			"newOrder":   url + "/order",
+			"revokeCert": url + "/revoke",
+			"keyChange":  url + "/key",
+		})
+	case path == "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case path == "/account":
+		w.Header().Set("Location", url+"/account/1")
+		writeJSON(w, http.StatusCreated, map[string]string{"status": "valid"})
+	case path == "/order":
+		var order struct {
+			Identifiers []struct{ Value string } `json:"identifiers"`
+		}
+		f.payload(r, &order)
+		f.domains, f.valid = nil, nil
+		for _, id := range order.Identifiers {
+			f.domains = append(f.domains, id.Value)
+			f.valid = append(f.valid, false)
+		}
+		w.Header().Set("Location", url+"/order/1")
+		writeJSON(w, http.StatusCreated, f.order())
+	case path == "/order/1":
+		w.Header().Set("Location", url+"/order/1")
+		writeJSON(w, http.StatusOK, f.order())
+	case strings.HasPrefix(path, "/authz/"):
+		i, _ := strconv.Atoi(strings.TrimPrefix(path, "/authz/"))
+		writeJSON(w, http.StatusOK, f.authz(i))
+	case strings.HasPrefix(path, "/challenge/"):
+		i, _ := strconv.Atoi(strings.TrimPrefix(path, "/challenge/"))
+		f.validate(i)
+		writeJSON(w, http.StatusOK, f.challenge(i))
+	case path == "/finalize":
+		var finalize struct {
+			CSR string `json:"csr"`
+		}
+		f.payload(r, &finalize)
+		f.issue(finalize.CSR)
+		w.Header().Set("Location", url+"/order/1")
+		writeJSON(w, http.StatusOK, f.order())
+	case path == "/certificate":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.certificate) // #nosec G104
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value) // #nosec G104
+}
+
+func (f *fakeACME) order() map[string]any {
+	status := "ready"
+	for _, valid := range f.valid {
+		if !valid {
+			status = "pending"
+		}
+	}
+	authzs := make([]string, 0, len(f.domains))
+	for i := range f.domains {
+		authzs = append(authzs, f.server.URL+"/authz/"+strconv.Itoa(i))
+	}
+	order := map[string]any{
+		"status":         status,
+		"authorizations": authzs,
+		"finalize":       f.server.URL + "/finalize",
+	}
+	if f.certificate != nil {
+		order["status"] = "valid"
+		order["certificate"] = f.server.URL + "/certificate"
+	}
+	return order
+}
+
+func (f *fakeACME) authz(i int) map[string]any {
+	status := "pending"
+	if f.valid[i] {
+		status = "valid"
+	}
+	return map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": f.domains[i]},
+		"challenges": []any{f.challenge(i)},
+	}
+}
+
+func (f *fakeACME) challenge(i int) map[string]string {
+	status := "pending"
+	if f.valid[i] {
+		status = "valid"
+	}
+	return map[string]string{
+		"type":   autotls.ChallengeHTTP01,
+		"url":    f.server.URL + "/challenge/" + strconv.Itoa(i),
+		"token":  "token" + strconv.Itoa(i),
+		"status": status,
+	}
+}
+
+// validate checks that the solver serves the key authorization of the
+// challenge with the given index.
+func (f *fakeACME) validate(i int) {
+	token := "token" + strconv.Itoa(i)
+	recorder := httptest.NewRecorder()
+	f.solver.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodGet, "/.well-known/acme-challenge/"+token, nil,
+	))
+	f.valid[i] = recorder.Code == http.StatusOK &&
+		strings.HasPrefix(recorder.Body.String(), token+".")
+}
+
+// issue issues a self-signed certificate for the given base64-encoded CSR.
+func (f *fakeACME) issue(encoded string) {
+	der, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(f.issued + 1)),
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(f.validity),
+	}
+	cert, err := x509.CreateCertificate(
+		rand.Reader, template, template, csr.PublicKey, key,
+	)
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	f.certificate = pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert},
+	)
+	f.issued++
+}
+
+func newTestManager(
+	t *testing.T,
+	dir string,
+	validity time.Duration,
+) (*autotls.Manager, *fakeACME) {
+	solver := autotls.NewHTTP01Solver()
+	server := newFakeACME(t, solver, validity)
+	manager := autotls.NewManager(autotls.Options{
+		Domains:      []string{"admin.example.com", "example.com"},
+		Email:        "admin@example.com",
+		DirectoryURL: server.server.URL + "/directory",
+		Directory:    dir,
+		Solver:       solver,
+		RenewBefore:  24 * time.Hour,
+	})
+	return manager, server
+}
+
+func TestManagerRenew(t *testing.T) {
+	dir := t.TempDir()
+	manager, server := newTestManager(t, dir, 90*24*time.Hour)
+
+	_, err := manager.GetCertificate(nil)
+	if !errors.Is(err, autotls.ErrNoCertificate) {
+		t.Fatalf("got %v, want %v", err, autotls.ErrNoCertificate)
+	}
+
+	for range 2 {
+		if err := manager.Renew(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.issued != 1 {
+		t.Errorf("got %d issued certificates, want 1", server.issued)
+	}
+	cert, err := manager.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"admin.example.com", "example.com"}
+	if got := cert.Leaf.DNSNames; strings.Join(got, ",") !=
+		strings.Join(want, ",") {
+		t.Errorf("got DNS names %v, want %v", got, want)
+	}
+
+	// The stored certificate is reused by another manager.
+	manager, server = newTestManager(t, dir, 90*24*time.Hour)
+	if err := manager.Renew(); err != nil {
+		t.Fatal(err)
+	}
+	if server.issued != 0 {
+		t.Errorf("got %d issued certificates, want the stored one",
+			server.issued)
+	}
+	for _, name := range []string{"account.key", "certificate.pem"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o600 {
+			t.Errorf("got mode %o for %s, want 600", mode, name)
+		}
+	}
+}
+
+func TestManagerRenewExpiring(t *testing.T) {
+	// The certificates expire within the renewal period, so they're
+	// renewed each time.
+	manager, server := newTestManager(t, t.TempDir(), time.Hour)
+	for range 2 {
+		if err := manager.Renew(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if server.issued != 2 {
+		t.Errorf("got %d issued certificates, want 2", server.issued)
+	}
+}
+
+func TestManagerNoChallenge(t *testing.T) {
+	solver := autotls.NewHTTP01Solver()
+	server := newFakeACME(t, solver, time.Hour)
+	manager := autotls.NewManager(autotls.Options{
+		Domains:      []string{"example.com"},
+		DirectoryURL: server.server.URL + "/directory",
+		Directory:    t.TempDir(),
+		Solver:       &autotls.HookSolver{Command: "true"},
+	})
+	if err := manager.Renew(); !errors.Is(err, autotls.ErrNoChallenge) {
+		t.Errorf("got %v, want %v", err, autotls.ErrNoChallenge)
+	}
+}
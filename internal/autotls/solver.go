@@ -0,0 +1,160 @@
+package autotls
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Types of the ACME challenges solved by the built-in solvers.
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// Actions of the DNS-01 hook command, see HookSolver.
+const (
+	HookPresent = "present"
+	HookCleanUp = "cleanup"
+)
+
+// httpChallengePath is the path prefix of the HTTP-01 challenge responses.
+const httpChallengePath = "/.well-known/acme-challenge/"
+
+// Solver proves the control of the domains of a certificate to the ACME
+// server, by solving the challenges of its type.
+type Solver interface {
+	// Type returns the type of the challenges solved by the solver, e.g.,
+	// ChallengeHTTP01.
+	Type() string
+
+	// Present makes the given key authorization of the challenge with the
+	// given token available for the given domain, and returns once the ACME
+	// server can check it.
+	Present(ctx context.Context, domain, token, keyAuth string) error
+
+	// CleanUp removes what Present made available, once the challenge is
+	// solved or has failed.
+	CleanUp(ctx context.Context, domain, token, keyAuth string) error
+}
+
+// HTTP01Solver solves the HTTP-01 challenges. It's an HTTP handler that must
+// be served on port 80 of the domains. It's safe for concurrent use.
+type HTTP01Solver struct {
+	mu        sync.RWMutex
+	responses map[string]string // Key authorizations by token
+}
+
+// NewHTTP01Solver creates a new HTTP-01 solver without pending challenges.
+func NewHTTP01Solver() *HTTP01Solver {
+	return &HTTP01Solver{responses: make(map[string]string)}
+}
+
+// Type returns ChallengeHTTP01.
+func (s *HTTP01Solver) Type() string {
+	return ChallengeHTTP01
+}
+
+// Present serves the given key authorization at the path of the given token.
+func (s *HTTP01Solver) Present(
+	_ context.Context,
+	_, token, keyAuth string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses[token] = keyAuth
+	return nil
+}
+
+// CleanUp stops serving the key authorization of the given token.
+func (s *HTTP01Solver) CleanUp(_ context.Context, _, token, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.responses, token)
+	return nil
+}
+
+// ServeHTTP responds to the requests of the ACME server for the pending
+// challenges. The other requests get a 404 status code.
+func (s *HTTP01Solver) ServeHTTP(writer http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, httpChallengePath)
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(writer, r)
+		return
+	}
+
+	s.mu.RLock()
+	keyAuth, ok := s.responses[token]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(writer, r)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain")
+	writer.Write([]byte(keyAuth)) // #nosec G104
+}
+
+// HookSolver solves the DNS-01 challenges with an external command, so that
+// any DNS provider can be used. The command is run with the action,
+// HookPresent or HookCleanUp, the name of the TXT record, e.g.,
+// "_acme-challenge.example.com", and its value as arguments. On HookPresent,
+// it must return once the record is published by the authoritative servers.
+type HookSolver struct {
+	Command string
+}
+
+// Type returns ChallengeDNS01.
+func (s *HookSolver) Type() string {
+	return ChallengeDNS01
+}
+
+// Present runs the command to publish the TXT record of the challenge.
+func (s *HookSolver) Present(
+	ctx context.Context,
+	domain, _, keyAuth string,
+) error {
+	return s.run(ctx, HookPresent, domain, keyAuth)
+}
+
+// CleanUp runs the command to remove the TXT record of the challenge.
+func (s *HookSolver) CleanUp(
+	ctx context.Context,
+	domain, _, keyAuth string,
+) error {
+	return s.run(ctx, HookCleanUp, domain, keyAuth)
+}
+
+// run runs the command with the given action, for the TXT record of the given
+// domain and key authorization.
+func (s *HookSolver) run(
+	ctx context.Context,
+	action, domain, keyAuth string,
+) error {
+	cmd := exec.CommandContext( // #nosec G204
+		ctx, s.Command, action, dnsRecordName(domain), dnsRecordValue(keyAuth),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf(
+			"%s hook: %w: %s", action, err, strings.TrimSpace(string(output)),
+		)
+	}
+	return nil
+}
+
+// dnsRecordName returns the name of the TXT record of the DNS-01 challenges
+// of the given domain.
+func dnsRecordName(domain string) string {
+	return "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+}
+
+// dnsRecordValue returns the value of the TXT record of the DNS-01 challenge
+// with the given key authorization.
+func dnsRecordValue(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
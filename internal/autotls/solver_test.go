@@ -0,0 +1,108 @@
+package autotls_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/autotls"
+)
+
+func TestHTTP01Solver(t *testing.T) {
+	var (
+		solver = autotls.NewHTTP01Solver()
+		ctx    = context.Background()
+	)
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		solver.ServeHTTP(
+			recorder, httptest.NewRequest(http.MethodGet, path, nil),
+		)
+		return recorder
+	}
+
+	err := solver.Present(ctx, "example.com", "token", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := get("/.well-known/acme-challenge/token")
+	if recorder.Code != http.StatusOK ||
+		recorder.Body.String() != "token.thumbprint" {
+		t.Errorf("got %d %q, want the key authorization", recorder.Code,
+			recorder.Body.String())
+	}
+	for _, path := range []string{"/.well-known/acme-challenge/other", "/"} {
+		if code := get(path).Code; code != http.StatusNotFound {
+			t.Errorf("got status %d for %s, want 404", code, path)
+		}
+	}
+
+	err = solver.CleanUp(ctx, "example.com", "token", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := get("/.well-known/acme-challenge/token").Code; code !=
+		http.StatusNotFound {
+		t.Errorf("got status %d after clean up, want 404", code)
+	}
+}
+
+// writeHook writes a hook script with the given body and returns its path.
+func writeHook(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHookSolver(t *testing.T) {
+	var (
+		output = filepath.Join(t.TempDir(), "output")
+		solver = &autotls.HookSolver{
+			Command: writeHook(t, `echo "$@" >> `+output),
+		}
+		ctx = context.Background()
+	)
+	if solver.Type() != autotls.ChallengeDNS01 {
+		t.Errorf("got type %q, want %q", solver.Type(), autotls.ChallengeDNS01)
+	}
+
+	// The value of the TXT record is the base64url-encoded SHA-256 digest of
+	// the key authorization.
+	err := solver.Present(ctx, "*.example.com", "token", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = solver.CleanUp(ctx, "*.example.com", "token", "token.thumbprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := "_acme-challenge.example.com " +
+		"61rBZ_4knHblO0MNoxFsXZ_eTFUHum0B6IVRbhvUn5I"
+	want := "present " + record + "\ncleanup " + record + "\n"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestHookSolverError(t *testing.T) {
+	solver := &autotls.HookSolver{
+		Command: writeHook(t, "echo 'unknown zone' >&2; exit 1"),
+	}
+	err := solver.Present(context.Background(), "example.com", "t", "t.k")
+	if err == nil || !strings.Contains(err.Error(), "unknown zone") {
+		t.Errorf("got %v, want the output of the hook", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package bans contains the list of temporary IP bans.
+package bans
+
+import (
+	"errors"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danroc/geoblock/internal/utils/statefile"
+)
+
+// ErrInvalidTTL is returned when a ban doesn't expire in the future.
+var ErrInvalidTTL = errors.New("ban must expire in the future")
+
+// stateKind is the kind of the state files of the ban lists.
+const stateKind = "bans"
+
+// Ban is a temporary ban of the addresses of a network.
+type Ban struct {
+	Network netip.Prefix `json:"network"`
+	Expires time.Time    `json:"expires"`
+	Reason  string       `json:"reason,omitempty"`
+}
+
+// List is a list of temporary bans. It's safe for concurrent use.
+//
+// If a file is set, the list is saved to it after each change, so that the
+// bans survive restarts.
+type List struct {
+	mu   sync.RWMutex
+	bans map[netip.Prefix]Ban
+	file string
+}
+
+// NewList creates a new empty ban list that isn't saved to disk.
+func NewList() *List {
+	return &List{bans: make(map[netip.Prefix]Ban)}
+}
+
+// Persist loads the bans saved in the given file, if it exists, and saves the
+// list to this file after each change. A corrupted file isn't loaded, see
+// statefile.Read.
+func (l *List) Persist(file string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var saved []Ban
+	if _, err := statefile.Read(file, stateKind, &saved); err != nil {
+		return err
+	}
+	for _, ban := range saved {
+		l.bans[ban.Network.Masked()] = ban
+	}
+
+	l.file = file
+	return nil
+}
+
+// Add adds the given ban, replacing any ban of the same network, and returns
+// it. The network is masked, so that 10.0.0.1/8 and 10.0.0.0/8 are the same
+// network. If the list can't be saved, the ban is still added.
+func (l *List) Add(ban Ban, now time.Time) (Ban, error) {
+	if !ban.Expires.After(now) {
+		return Ban{}, ErrInvalidTTL
+	}
+	ban.Network = ban.Network.Masked()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	l.bans[ban.Network] = ban
+	return ban, l.save()
+}
+
+// Remove removes the ban of the given network. It returns false if the
+// network isn't banned. If the list can't be saved, the ban is still removed.
+func (l *List) Remove(network netip.Prefix) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	network = network.Masked()
+	if _, ok := l.bans[network]; !ok {
+		return false, nil
+	}
+	delete(l.bans, network)
+	return true, l.save()
+}
+
+// List returns the bans that haven't expired, sorted by network.
+func (l *List) List(now time.Time) []Ban {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := make([]Ban, 0, len(l.bans))
+	for _, ban := range l.bans {
+		if ban.Expires.After(now) {
+			result = append(result, ban)
+		}
+	}
+	slices.SortFunc(result, func(a, b Ban) int {
+		return a.Network.Addr().Compare(b.Network.Addr())
+	})
+	return result
+}
+
+// Banned checks if the given IP address belongs to a banned network.
+// IPv4-mapped IPv6 addresses are checked as IPv4 addresses.
+func (l *List) Banned(ip netip.Addr, now time.Time) bool {
+	ip = ip.Unmap()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// The list is expected to be small, so a linear search is fine.
+	for network, ban := range l.bans {
+		if ban.Expires.After(now) && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes the expired bans. The caller must hold the lock.
+func (l *List) prune(now time.Time) {
+	maps.DeleteFunc(l.bans, func(_ netip.Prefix, ban Ban) bool {
+		return !ban.Expires.After(now)
+	})
+}
+
+// save writes the list to its file, if any, see statefile.Write. The caller
+// must hold the lock.
+func (l *List) save() error {
+	if l.file == "" {
+		return nil
+	}
+	return statefile.Write(
+		l.file, stateKind, slices.Collect(maps.Values(l.bans)),
+	)
+}
@@ -0,0 +1,200 @@
+package bans_test
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/bans"
+	"github.com/danroc/geoblock/internal/utils/statefile"
+)
+
+var now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestListBanned(t *testing.T) {
+	list := bans.NewList()
+	for _, ban := range []bans.Ban{
+		{
+			Network: netip.MustParsePrefix("10.1.2.3/16"),
+			Expires: now.Add(time.Hour),
+		},
+		{
+			Network: netip.MustParsePrefix("192.168.0.1/32"),
+			Expires: now.Add(time.Minute),
+		},
+		{
+			Network: netip.MustParsePrefix("2001:db8::/32"),
+			Expires: now.Add(time.Hour),
+		},
+	} {
+		if _, err := list.Add(ban, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ip   string
+		at   time.Time
+		want bool
+	}{
+		{"10.1.0.1", now, true},
+		{"10.2.0.1", now, false},
+		{"::ffff:10.1.0.1", now, true},
+		{"192.168.0.1", now, true},
+		{"192.168.0.1", now.Add(2 * time.Minute), false},
+		{"192.168.0.2", now, false},
+		{"2001:db8::1", now, true},
+		{"2001:db9::1", now, false},
+		{"10.1.0.1", now.Add(time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := netip.MustParseAddr(tt.ip)
+			if got := list.Banned(ip, tt.at); got != tt.want {
+				t.Errorf("List.Banned(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListAdd(t *testing.T) {
+	list := bans.NewList()
+
+	_, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: now,
+	}, now)
+	if !errors.Is(err, bans.ErrInvalidTTL) {
+		t.Errorf("List.Add() error = %v, want %v", err, bans.ErrInvalidTTL)
+	}
+
+	ban, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.1.2.3/8"),
+		Expires: now.Add(time.Hour),
+		Reason:  "scanner",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParsePrefix("10.0.0.0/8"); ban.Network != want {
+		t.Errorf("List.Add() network = %s, want %s", ban.Network, want)
+	}
+
+	// Banning the same network again replaces the previous ban.
+	if _, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: now.Add(2 * time.Hour),
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+	got := list.List(now)
+	if len(got) != 1 || !got[0].Expires.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("List.List() = %v, want a single replaced ban", got)
+	}
+}
+
+func TestListRemove(t *testing.T) {
+	list := bans.NewList()
+	if _, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: now.Add(time.Hour),
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := list.Remove(netip.MustParsePrefix("10.0.0.0/16"))
+	if removed || err != nil {
+		t.Errorf("List.Remove() = %v, %v, want false, nil", removed, err)
+	}
+
+	removed, err = list.Remove(netip.MustParsePrefix("10.1.0.0/8"))
+	if !removed || err != nil {
+		t.Errorf("List.Remove() = %v, %v, want true, nil", removed, err)
+	}
+	if list.Banned(netip.MustParseAddr("10.0.0.1"), now) {
+		t.Error("List.Banned() = true after removing the ban")
+	}
+}
+
+func TestListList(t *testing.T) {
+	list := bans.NewList()
+	for _, network := range []string{"10.2.0.0/16", "10.1.0.0/16"} {
+		if _, err := list.Add(bans.Ban{
+			Network: netip.MustParsePrefix(network),
+			Expires: now.Add(time.Hour),
+		}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := list.List(now)
+	if len(got) != 2 ||
+		got[0].Network.String() != "10.1.0.0/16" ||
+		got[1].Network.String() != "10.2.0.0/16" {
+		t.Errorf("List.List() = %v, want bans sorted by network", got)
+	}
+	if got := list.List(now.Add(time.Hour)); len(got) != 0 {
+		t.Errorf("List.List() = %v, want no expired bans", got)
+	}
+}
+
+func TestListPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bans.json")
+
+	list := bans.NewList()
+	if err := list.Persist(file); err != nil {
+		t.Fatalf("List.Persist() with a missing file: %v", err)
+	}
+	if _, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: now.Add(time.Hour),
+		Reason:  "scanner",
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := bans.NewList()
+	if err := restored.Persist(file); err != nil {
+		t.Fatal(err)
+	}
+	got := restored.List(now)
+	if len(got) != 1 || got[0].Reason != "scanner" {
+		t.Errorf("restored bans = %v, want the saved ban", got)
+	}
+}
+
+func TestListPersistInvalid(t *testing.T) {
+	if err := bans.NewList().Persist(t.TempDir()); err == nil {
+		t.Error("List.Persist() on a directory: expected an error")
+	}
+
+	file := filepath.Join(t.TempDir(), "bans.json")
+	list := bans.NewList()
+	if err := list.Persist(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: now.Add(time.Hour),
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Replace(data, []byte("10.0.0.0/8"), []byte("11.0.0.0/8"), 1)
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err = bans.NewList().Persist(file)
+	if !errors.Is(err, statefile.ErrCorrupted) {
+		t.Errorf("List.Persist() on a corrupted file: got %v, want %v",
+			err, statefile.ErrCorrupted)
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// challengeDNS01 is the ACME challenge proving the control of a domain with a
+// DNS record.
+const challengeDNS01 = "dns-01"
+
+var (
+	// errAdminAuthentication is returned when the admin API is enabled
+	// without any way to authenticate its clients.
+	errAdminAuthentication = errors.New(
+		"the admin API requires a token or a client CA",
+	)
+
+	// errAdminClientCA is returned when the client certificates of the admin
+	// API are required without serving it over TLS.
+	errAdminClientCA = errors.New(
+		"the client CA requires a certificate file or ACME",
+	)
+
+	// errAdminTokenTLS is returned when the token of the admin API would be
+	// sent in plaintext over the network.
+	errAdminTokenTLS = errors.New(
+		"the token requires a certificate file or ACME on a non-loopback " +
+			"address",
+	)
+
+	// errACMEWildcard is returned when a wildcard certificate is requested
+	// with another challenge than DNS-01.
+	errACMEWildcard = errors.New(
+		"wildcard domains require the " + challengeDNS01 + " challenge",
+	)
+)
+
+// validateAdmin checks that the clients of the admin API are authenticated
+// when it's enabled, that its token isn't sent in plaintext over the network,
+// and that the ACME challenge can prove the control of the domains of its
+// certificate.
+func validateAdmin(a *Admin) *Error {
+	if a.Address != "" && a.Token == "" && a.ClientCA == "" {
+		return &Error{
+			Field:   "admin.address",
+			Message: errAdminAuthentication.Error(),
+		}
+	}
+	tls := a.CertFile != "" || a.ACME != nil
+	if a.ClientCA != "" && !tls {
+		return &Error{
+			Field:   "admin.client_ca",
+			Message: errAdminClientCA.Error(),
+		}
+	}
+	if a.Address != "" && a.Token != "" && !tls && !loopback(a.Address) {
+		return &Error{
+			Field:   "admin.token",
+			Message: errAdminTokenTLS.Error(),
+		}
+	}
+	if a.ACME == nil || a.ACME.Challenge == challengeDNS01 {
+		return nil
+	}
+	for i, domain := range a.ACME.Domains {
+		if strings.HasPrefix(domain, "*.") {
+			return &Error{
+				Field:   "admin.acme.domains[" + strconv.Itoa(i) + "]",
+				Message: errACMEWildcard.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// loopback returns true if the given listen address only accepts connections
+// from the local host.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidASNRange is returned when an ASN range cannot be parsed.
+var ErrInvalidASNRange = errors.New("invalid ASN range")
+
+// ASNRange represents an inclusive range of autonomous system numbers. It's
+// used to support unmarshaling single ASNs such as "1234" and ranges such as
+// "64512-65534" from YAML.
+type ASNRange struct {
+	First uint32
+	Last  uint32
+}
+
+// ParseASNRange parses an ASN or a range of ASNs given as two ASNs separated
+// by a dash. The first ASN of a range can't be greater than the last one.
+func ParseASNRange(s string) (ASNRange, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+
+	start, err := strconv.ParseUint(strings.TrimSpace(first), 10, 32)
+	if err != nil {
+		return ASNRange{}, ErrInvalidASNRange
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(last), 10, 32)
+	if err != nil || start > end {
+		return ASNRange{}, ErrInvalidASNRange
+	}
+	return ASNRange{First: uint32(start), Last: uint32(end)}, nil
+}
+
+// Contains checks if the given ASN is in the range.
+func (r ASNRange) Contains(asn uint32) bool {
+	return r.First <= asn && asn <= r.Last
+}
+
+// UnmarshalYAML unmarshals an ASN or a range of ASNs from YAML.
+func (r *ASNRange) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	parsed, err := ParseASNRange(value)
+	if err != nil {
+		return err
+	}
+
+	*r = parsed
+	return nil
+}
+
+// MarshalYAML marshals the range as an ASN if it contains a single ASN, or as
+// two ASNs separated by a dash otherwise.
+func (r ASNRange) MarshalYAML() (interface{}, error) {
+	first := strconv.FormatUint(uint64(r.First), 10)
+	if r.First == r.Last {
+		return first, nil
+	}
+	return first + "-" + strconv.FormatUint(uint64(r.Last), 10), nil
+}
@@ -0,0 +1,112 @@
+package config_test
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+func TestParseASNRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    config.ASNRange
+		wantErr bool
+	}{
+		{"1234", config.ASNRange{First: 1234, Last: 1234}, false},
+		{"64512-65534", config.ASNRange{First: 64512, Last: 65534}, false},
+		{"64512 - 65534", config.ASNRange{First: 64512, Last: 65534}, false},
+		{"0-4294967295", config.ASNRange{First: 0, Last: 4294967295}, false},
+		{"", config.ASNRange{}, true},
+		{"AS1234", config.ASNRange{}, true},
+		{"-1", config.ASNRange{}, true},
+		{"65534-64512", config.ASNRange{}, true},
+		{"1-2-3", config.ASNRange{}, true},
+		{"4294967296", config.ASNRange{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := config.ParseASNRange(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseASNRange() error = %v, wantErr %v",
+					err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseASNRange() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestASNRangeContains(t *testing.T) {
+	r := config.ASNRange{First: 64512, Last: 65534}
+	tests := []struct {
+		asn  uint32
+		want bool
+	}{
+		{64511, false},
+		{64512, true},
+		{65000, true},
+		{65534, true},
+		{65535, false},
+	}
+
+	for _, tt := range tests {
+		if got := r.Contains(tt.asn); got != tt.want {
+			t.Errorf("Contains(%d) = %v, want %v", tt.asn, got, tt.want)
+		}
+	}
+}
+
+func TestASNRangeUnmarshalYAML(t *testing.T) {
+	var got []config.ASNRange
+	if err := yaml.Unmarshal(
+		[]byte(`[1234, "64512-65534"]`), &got,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []config.ASNRange{
+		{First: 1234, Last: 1234},
+		{First: 64512, Last: 65534},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := yaml.Unmarshal([]byte(`["1-x"]`), &got); err == nil {
+		t.Error("expected an error, got nil")
+	}
+}
+
+func TestASNRangeMarshalYAML(t *testing.T) {
+	tests := []struct {
+		input config.ASNRange
+		want  string
+	}{
+		{config.ASNRange{First: 1234, Last: 1234}, "\"1234\"\n"},
+		{config.ASNRange{First: 64512, Last: 65534}, "64512-65534\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			data, err := yaml.Marshal(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+
+			var got config.ASNRange
+			if err := yaml.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.input {
+				t.Errorf("got %v after round trip, want %v", got, tt.input)
+			}
+		})
+	}
+}
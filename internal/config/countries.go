@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// CountryNegation is the prefix of the entries excluded from a countries
+// condition, e.g., `!RU`.
+const CountryNegation = "!"
+
+// CountryGroups are the predefined groups of countries that can be used in
+// the countries condition of the rules.
+var CountryGroups = map[string][]string{
+	// Member states of the European Union.
+	"EU": {
+		"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR",
+		"GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL",
+		"PT", "RO", "SE", "SI", "SK",
+	},
+
+	// European Economic Area: the European Union, Iceland, Liechtenstein and
+	// Norway.
+	"EEA": {
+		"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR",
+		"GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL",
+		"PT", "RO", "SE", "SI", "SK", "IS", "LI", "NO",
+	},
+
+	// Members of the Schengen Area.
+	"SCHENGEN": {
+		"AT", "BE", "BG", "CH", "CZ", "DE", "DK", "EE", "ES", "FI", "FR",
+		"GR", "HR", "HU", "IS", "IT", "LI", "LT", "LU", "LV", "MT", "NL",
+		"NO", "PL", "PT", "RO", "SE", "SI", "SK",
+	},
+
+	// Members of the Five Eyes intelligence alliance.
+	"FIVE_EYES": {"AU", "CA", "GB", "NZ", "US"},
+}
+
+// countryGroupNameRegex matches a valid user-defined country group name. Names
+// have at least 3 characters so they can't be confused with country codes.
+var countryGroupNameRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]{2,}$`)
+
+var (
+	errCountryGroupName = errors.New("invalid country group name")
+	errCountryGroup     = errors.New("unknown country group")
+	errCountryNegation  = errors.New("negated countries aren't allowed")
+)
+
+// group returns the countries of the given predefined or user-defined group.
+func (a *AccessControl) group(name string) ([]string, bool) {
+	if countries, ok := a.CountryGroups[name]; ok {
+		return countries, true
+	}
+	countries, ok := CountryGroups[name]
+	return countries, ok
+}
+
+// expandGroup returns the country codes of the given entries of a
+// user-defined group. Entries are country codes or predefined groups.
+func expandGroup(entries []string) []string {
+	var codes []string
+	for _, entry := range entries {
+		if countries, ok := CountryGroups[entry]; ok {
+			codes = append(codes, countries...)
+		} else {
+			codes = append(codes, entry)
+		}
+	}
+	return codes
+}
+
+// ExpandCountries returns the country codes included and excluded by the
+// given countries condition. Entries are country codes or group names, and
+// entries prefixed with CountryNegation are excluded. Group names longer than
+// two characters that aren't defined return an error.
+func (a *AccessControl) ExpandCountries(
+	countries []string,
+) (include []string, exclude []string, err error) {
+	for _, entry := range countries {
+		name, negated := strings.CutPrefix(entry, CountryNegation)
+
+		codes := []string{name}
+		if group, ok := a.group(name); ok {
+			codes = expandGroup(group)
+		} else if len(name) != 2 {
+			return nil, nil, fmt.Errorf("%w: %q", errCountryGroup, name)
+		}
+
+		if negated {
+			exclude = append(exclude, codes...)
+		} else {
+			include = append(include, codes...)
+		}
+	}
+	return include, exclude, nil
+}
+
+// validateCountries checks that the user-defined country groups have valid
+// names and that the countries and not_countries conditions of the rules only
+// contain valid country codes or known groups. Entries of not_countries can't
+// be negated.
+func validateCountries(validate *validator.Validate, a *AccessControl) *Error {
+	for _, name := range slices.Sorted(maps.Keys(a.CountryGroups)) {
+		field := "access_control.country_groups." + name
+		if !countryGroupNameRegex.MatchString(name) {
+			return &Error{Field: field, Message: errCountryGroupName.Error()}
+		}
+		if _, ok := CountryGroups[name]; ok {
+			return &Error{
+				Field:   field,
+				Message: errCountryGroupName.Error() + ": it's predefined",
+			}
+		}
+		for _, entry := range a.CountryGroups[name] {
+			if _, ok := CountryGroups[entry]; !ok && len(entry) != 2 {
+				return &Error{
+					Field:   field,
+					Message: fmt.Sprintf("%v: %q", errCountryGroup, entry),
+				}
+			}
+		}
+		codes := expandGroup(a.CountryGroups[name])
+		if err := validate.Var(codes, "dive,iso3166_1_alpha2"); err != nil {
+			return &Error{Field: field, Message: "invalid country code"}
+		}
+	}
+
+	for i, rule := range a.Rules {
+		field := fmt.Sprintf("access_control.rules[%d].countries", i)
+		include, exclude, err := a.ExpandCountries(rule.Countries)
+		if err != nil {
+			return &Error{Field: field, Message: err.Error()}
+		}
+		codes := append(include, exclude...)
+		if err := validate.Var(codes, "dive,iso3166_1_alpha2"); err != nil {
+			return &Error{Field: field, Message: "invalid country code"}
+		}
+
+		field = fmt.Sprintf("access_control.rules[%d].not_countries", i)
+		include, exclude, err = a.ExpandCountries(rule.NotCountries)
+		if err != nil {
+			return &Error{Field: field, Message: err.Error()}
+		}
+		if len(exclude) > 0 {
+			return &Error{Field: field, Message: errCountryNegation.Error()}
+		}
+		if err := validate.Var(include, "dive,iso3166_1_alpha2"); err != nil {
+			return &Error{Field: field, Message: "invalid country code"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"os"
+	"slices"
+)
+
+// LicenseKeyEnv is the environment variable holding the MaxMind license key
+// used to download the MMDB databases directly from MaxMind. It's read from
+// the environment rather than the configuration file so that the key isn't
+// exposed with the configuration.
+const LicenseKeyEnv = "MAXMIND_LICENSE_KEY"
+
+// formatMMDB is the database format of the MaxMind databases.
+const formatMMDB = "mmdb"
+
+// errMissingCountryURL is returned when the MMDB format is used without a
+// country database URL nor a license key to download it from MaxMind.
+var errMissingCountryURL = errors.New(
+	"the mmdb format requires a country URL or " + LicenseKeyEnv,
+)
+
+// errMissingCityURL is returned when the MMDB city database is enabled
+// without a URL nor a license key to download it from MaxMind.
+var errMissingCityURL = errors.New(
+	"the mmdb city database requires a city URL or " + LicenseKeyEnv,
+)
+
+var (
+	// errInvalidSignatureKey is returned when the signature key of the
+	// databases isn't a base64-encoded Ed25519 public key.
+	errInvalidSignatureKey = errors.New(
+		"the signature key must be a base64-encoded Ed25519 public key",
+	)
+
+	// errMissingSignatureKey is returned when a signature URL is set without
+	// the signature key verifying it.
+	errMissingSignatureKey = errors.New(
+		"the signature URL requires a signature key",
+	)
+)
+
+// validateDatabases checks that the country or city database can be
+// downloaded when the MMDB format is used, and that the signatures of the
+// databases can be verified.
+func validateDatabases(d *Databases) *Error {
+	licensed := os.Getenv(LicenseKeyEnv) != ""
+	if d.Format == formatMMDB && d.City && d.CityURL == "" && !licensed {
+		return &Error{
+			Field:   "databases.city_url",
+			Message: errMissingCityURL.Error(),
+		}
+	}
+	if d.Format == formatMMDB && !d.City && d.CountryURL == "" &&
+		!licensed {
+		return &Error{
+			Field:   "databases.country_url",
+			Message: errMissingCountryURL.Error(),
+		}
+	}
+
+	if d.SignatureKey != "" {
+		if _, err := d.PublicKey(); err != nil {
+			return &Error{
+				Field:   "databases.signature_key",
+				Message: errInvalidSignatureKey.Error(),
+			}
+		}
+		return nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(d.Verify)) {
+		if d.Verify[name].SignatureURL != "" {
+			return &Error{
+				Field:   "databases.verify[" + name + "].signature_url",
+				Message: errMissingSignatureKey.Error(),
+			}
+		}
+	}
+	return nil
+}
+
+// PublicKey returns the Ed25519 public key decoded from SignatureKey, or nil
+// if it isn't set.
+func (d *Databases) PublicKey() (ed25519.PublicKey, error) {
+	if d.SignatureKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(d.SignatureKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errInvalidSignatureKey
+	}
+	return key, nil
+}
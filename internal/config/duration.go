@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidDuration is returned when a duration cannot be parsed.
+var ErrInvalidDuration = errors.New("invalid duration")
+
+// durationUnits maps the units accepted by ParseDuration, in addition to the
+// ones of time.ParseDuration, to their length.
+var durationUnits = map[byte]time.Duration{
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// durationType is the type of the duration fields of the configuration.
+var durationType = reflect.TypeFor[time.Duration]()
+
+// ParseDuration parses a duration like time.ParseDuration, but also accepts
+// the d (24 hours) and w (7 days) units, e.g., "1w2d" or "1d12h". Months and
+// years aren't supported since their length varies.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+
+	sign, rest := time.Duration(1), s
+	if after, ok := strings.CutPrefix(rest, "-"); ok {
+		sign, rest = -1, after
+	} else {
+		rest = strings.TrimPrefix(rest, "+")
+	}
+	if rest == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+	}
+
+	// The components with a custom unit are converted, and the others are
+	// left to time.ParseDuration.
+	var (
+		total time.Duration
+		other strings.Builder
+	)
+	for rest != "" {
+		i := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i < 0 {
+			// A number without unit is only valid if it's zero.
+			other.WriteString(rest)
+			break
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+		}
+		j := i + strings.IndexAny(rest[i:], "0123456789.")
+		if j < i {
+			j = len(rest)
+		}
+
+		number, unit := rest[:i], rest[i:j]
+		rest = rest[j:]
+		length, ok := durationUnits[unit[0]]
+		if !ok || len(unit) != 1 {
+			other.WriteString(number + unit)
+			continue
+		}
+
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+		}
+		if value*float64(length) > math.MaxInt64-float64(total) {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+		}
+		total += time.Duration(value * float64(length))
+	}
+
+	if other.Len() > 0 {
+		parsed, err := time.ParseDuration(other.String())
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+		}
+		total += parsed
+	}
+	return sign * total, nil
+}
+
+// normalizeDurations parses the values of the duration fields under the
+// given node with ParseDuration and replaces them with the format of
+// time.Duration, so that they can be decoded. The node is walked along the
+// given type, and path is the path of the node in the configuration. An error
+// is returned for each invalid duration.
+func normalizeDurations(
+	node *yaml.Node,
+	typ reflect.Type,
+	path string,
+) Errors {
+	if node.Kind == yaml.DocumentNode {
+		var errs Errors
+		for _, child := range node.Content {
+			errs = append(errs, normalizeDurations(child, typ, path)...)
+		}
+		return errs
+	}
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	var errs Errors
+	switch {
+	case typ == durationType && node.Kind == yaml.ScalarNode:
+		duration, err := ParseDuration(node.Value)
+		if err != nil {
+			return Errors{
+				{Field: path, Line: node.Line, Message: err.Error()},
+			}
+		}
+		node.Value = duration.String()
+		node.Tag = "!!str"
+
+	case typ.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			for j := range typ.NumField() {
+				field := typ.Field(j)
+				if yamlFieldName(field) == key {
+					errs = append(errs, normalizeDurations(
+						node.Content[i+1], field.Type, join(key),
+					)...)
+					break
+				}
+			}
+		}
+
+	case typ.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, normalizeDurations(
+				node.Content[i+1], typ.Elem(), join(node.Content[i].Value),
+			)...)
+		}
+
+	case typ.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, child := range node.Content {
+			errs = append(errs, normalizeDurations(
+				child, typ.Elem(), fmt.Sprintf("%s[%d]", path, i),
+			)...)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,108 @@
+package config_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+func TestParseDuration(t *testing.T) {
+	const day = 24 * time.Hour
+
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"1d", day, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"1w", 7 * day, false},
+		{"1w2d", 9 * day, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"-1d", -day, false},
+		{" 2d ", 2 * day, false},
+		{"", 0, true},
+		{"-", 0, true},
+		{"10", 0, true},
+		{"d", 0, true},
+		{"1M", 0, true},
+		{"1y", 0, true},
+		{"1dd", 0, true},
+		{"1d-2h", 0, true},
+		{"1000000000w", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := config.ParseDuration(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration() error = %v, wantErr %v", err,
+					tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, config.ErrInvalidDuration) {
+				t.Errorf("got error %v, want %v", err,
+					config.ErrInvalidDuration)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadConfigDurations(t *testing.T) {
+	cfg, err := config.ReadConfig(strings.NewReader(`
+access_control:
+  default_policy: allow
+  rules:
+    - policy: allow
+      quota:
+        requests: 100
+        window: 1d
+databases:
+  cache:
+    max_age: 2w
+decision_ttl: 30s
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cfg.AccessControl.Rules[0].Quota.Window; got != 24*time.Hour {
+		t.Errorf("got quota window %v, want 24h", got)
+	}
+	if got := cfg.Databases.Cache.MaxAge; got != 14*24*time.Hour {
+		t.Errorf("got cache max age %v, want 336h", got)
+	}
+	if got := cfg.DecisionTTL; got != 30*time.Second {
+		t.Errorf("got decision TTL %v, want 30s", got)
+	}
+}
+
+func TestReadConfigInvalidDuration(t *testing.T) {
+	_, err := config.ReadConfig(strings.NewReader(`
+access_control:
+  default_policy: allow
+  rules:
+    - policy: allow
+      rate_limit:
+        requests: 10
+        window: 1 month
+`))
+
+	var errs config.Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("got %v, want one configuration error", err)
+	}
+	want := "access_control.rules[0].rate_limit.window"
+	if errs[0].Field != want || errs[0].Line != 8 {
+		t.Errorf("got field %q at line %d, want %q at line 8",
+			errs[0].Field, errs[0].Line, want)
+	}
+}
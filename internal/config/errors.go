@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidConfig is matched by the errors of the configurations that can't
+// be parsed or are invalid, e.g., with errors.Is, as opposed to the errors
+// reading the configuration.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// Error is an invalid value of the configuration.
+type Error struct {
+	Field   string // Path of the field, e.g. "access_control.rules[0].policy"
+	Line    int    // Line of the field in the configuration, or 0 if unknown
+	Message string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	var prefix string
+	if e.Line > 0 {
+		prefix = "line " + strconv.Itoa(e.Line) + ": "
+	}
+	if e.Field != "" {
+		prefix += e.Field + ": "
+	}
+	return prefix + e.Message
+}
+
+// Is returns true if the target is ErrInvalidConfig.
+func (e *Error) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Errors contains all the invalid values of a configuration.
+type Errors []*Error
+
+// Error implements the error interface.
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Is returns true if the target is ErrInvalidConfig.
+func (e Errors) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// yamlFieldName returns the YAML name of the given struct field, so that the
+// validation errors use the same names as the configuration file.
+func yamlFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// validationMessage describes the failed validation of the given field.
+func validationMessage(err validator.FieldError) string {
+	if err.Tag() == "required" || err.Tag() == "required_with" {
+		return "value is required"
+	}
+
+	rule := err.Tag()
+	if err.Param() != "" {
+		rule += "=" + err.Param()
+	}
+	return fmt.Sprintf("invalid value %v (%s)", err.Value(), rule)
+}
+
+// newErrors converts the given validation error to configuration errors. The
+// fields are given the path of their namespace, without the root struct.
+func newErrors(err error) Errors {
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return Errors{{Message: err.Error()}}
+	}
+
+	result := make(Errors, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		_, path, _ := strings.Cut(fieldError.Namespace(), ".")
+		result = append(result, &Error{
+			Field:   path,
+			Message: validationMessage(fieldError),
+		})
+	}
+	return result
+}
+
+// splitPath splits the given field path into its keys and indices. For
+// example, "rules[0].policy" is split into "rules", "0" and "policy".
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '.' || r == '[' || r == ']'
+	})
+}
+
+// lookup returns the node of the given path under the given node. If the path
+// can't be found, the deepest node found is returned.
+func lookup(node *yaml.Node, path []string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, key := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil &&
+				i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node
+}
+
+// locate sets the line of the given errors from the configuration data. The
+// line is left unset if the data can't be parsed.
+func locate(errs Errors, data []byte) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return
+	}
+	for _, err := range errs {
+		if err.Field != "" {
+			err.Line = lookup(&root, splitPath(err.Field)).Line
+		}
+	}
+}
@@ -3,11 +3,16 @@
 package config
 
 import (
+	"fmt"
+	"html/template"
 	"io"
+	"reflect"
 	"regexp"
 
 	"github.com/go-playground/validator/v10"
 	"gopkg.in/yaml.v3"
+
+	"github.com/danroc/geoblock/internal/notify"
 )
 
 // DomainNameRegex matches a valid domain name as per RFC 1035. It also allows
@@ -16,6 +21,12 @@ var domainNameRegex = regexp.MustCompile(
 	`^(\*|[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.(\*|[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?))*$`,
 )
 
+// labelNameRegex matches a valid Prometheus label name. Names starting with
+// `__` are reserved.
+var labelNameRegex = regexp.MustCompile(
+	`^([a-zA-Z]|_[a-zA-Z0-9])[a-zA-Z0-9_]*$`,
+)
+
 // isDomainNameField checks if the value of the given field is a valid domain
 // name. It also allows labels to be a single `*` wildcard.
 func isDomainNameField(field validator.FieldLevel) bool {
@@ -29,19 +40,81 @@ func isCIDRField(field validator.FieldLevel) bool {
 	return ok
 }
 
+// isLabelNameField checks if the value of the given field is a valid label
+// name.
+func isLabelNameField(field validator.FieldLevel) bool {
+	name, ok := field.Field().Interface().(string)
+	return ok && labelNameRegex.MatchString(name)
+}
+
+// isTemplateField checks if the value of the given field is a valid HTML
+// template.
+func isTemplateField(field validator.FieldLevel) bool {
+	text, ok := field.Field().Interface().(string)
+	if !ok {
+		return false
+	}
+	_, err := template.New("").Parse(text)
+	return err == nil
+}
+
+// isPayloadField checks if the value of the given field is a valid template
+// of notification payloads.
+func isPayloadField(field validator.FieldLevel) bool {
+	text, ok := field.Field().Interface().(string)
+	if !ok {
+		return false
+	}
+	_, err := notify.ParseTemplate(text)
+	return err == nil
+}
+
 // read reads the configuration from the giver bytes slice.
 func read(data []byte) (*Configuration, error) {
+	// Durations are parsed first so that they can use the units of
+	// ParseDuration, which the YAML decoder doesn't know.
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if errs := normalizeDurations(
+		&root, reflect.TypeFor[Configuration](), "",
+	); len(errs) > 0 {
+		return nil, errs
+	}
+
 	var config Configuration
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if err := root.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
 	}
 
 	validate := validator.New()
+	validate.RegisterTagNameFunc(yamlFieldName)
 	validate.RegisterValidation("cidr", isCIDRField)         // #nosec G104
 	validate.RegisterValidation("domain", isDomainNameField) // #nosec G104
+	validate.RegisterValidation("template", isTemplateField) // #nosec G104
+	validate.RegisterValidation("label", isLabelNameField)   // #nosec G104
+	validate.RegisterValidation("payload", isPayloadField)   // #nosec G104
 
+	var errs Errors
 	if err := validate.Struct(config); err != nil {
-		return nil, err
+		errs = append(errs, newErrors(err)...)
+	}
+	if err := validateCountries(validate, &config.AccessControl); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateRuleNames(&config.AccessControl); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateDatabases(&config.Databases); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateAdmin(&config.Admin); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		locate(errs, data)
+		return nil, errs
 	}
 
 	return &config, nil
@@ -1,11 +1,13 @@
 package config_test
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/danroc/geoblock/internal/config"
 )
@@ -115,6 +117,430 @@ access_control:
       policy: allow
 `
 
+const invalidMMDBWithoutURL = `
+access_control:
+  default_policy: allow
+databases:
+  format: mmdb
+`
+
+const invalidMMDBCityWithoutURL = `
+access_control:
+  default_policy: allow
+databases:
+  format: mmdb
+  country_url: https://example.com/GeoLite2-Country.mmdb
+  city: true
+`
+
+const invalidSourceURLs = `
+access_control:
+  default_policy: allow
+databases:
+  urls:
+    country: [https://mirror.example.com/country.csv]
+`
+
+const invalidEmptySourceURLs = `
+access_control:
+  default_policy: allow
+databases:
+  urls:
+    country-ipv4: []
+`
+
+const invalidMetricLabel = `
+access_control:
+  default_policy: allow
+metrics:
+  labels:
+    - country
+    - source_ip
+`
+
+const invalidAdminWithoutAuthentication = `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+`
+
+const invalidAdminClientCA = `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+  client_ca: /etc/geoblock/ca.pem
+`
+
+const invalidAdminTokenPlaintext = `
+access_control:
+  default_policy: allow
+admin:
+  address: 0.0.0.0:9090
+  token: 0123456789abcdef0123456789abcdef
+`
+
+const invalidAuditSampling = `
+access_control:
+  default_policy: allow
+audit:
+  file: /var/log/geoblock/audit.log
+  sampling:
+    allow: 1.5
+`
+
+const invalidAnonymizer = `
+access_control:
+  default_policy: allow
+  rules:
+    - policy: deny
+      anonymizers:
+        - i2p
+`
+
+const invalidResponseHeadersHash = `
+access_control:
+  default_policy: allow
+response_headers:
+  - domains:
+      - app.example.com
+    headers:
+      - ip
+    ip: hash
+`
+
+const invalidPrivacy = `
+access_control:
+  default_policy: allow
+privacy:
+  ip: encrypt
+`
+
+const invalidDuplicateRuleName = `
+access_control:
+  default_policy: allow
+  rules:
+    - name: admins
+      policy: allow
+      countries:
+        - FR
+    - name: admins
+      policy: deny
+`
+
+const invalidURLOrder = `
+access_control:
+  default_policy: allow
+databases:
+  url_order: random
+`
+
+const invalidWebhook = `
+access_control:
+  default_policy: deny
+  rules:
+    - name: admin
+      policy: deny
+      webhook:
+        url: hooks.example.com/admin
+        outcomes: [deny, invalid]
+`
+
+const invalidChecksum = `
+access_control:
+  default_policy: allow
+databases:
+  verify:
+    country-ipv4:
+      sha256: not-a-checksum
+`
+
+const invalidSignatureKey = `
+access_control:
+  default_policy: allow
+databases:
+  signature_key: c2hvcnQ=
+  verify:
+    country-ipv4:
+      signature_url: https://example.com/country-ipv4.csv.sig
+`
+
+const invalidMissingSignatureKey = `
+access_control:
+  default_policy: allow
+databases:
+  verify:
+    country-ipv4:
+      signature_url: https://example.com/country-ipv4.csv.sig
+`
+
+const invalidUpdateInterval = `
+access_control:
+  default_policy: allow
+databases:
+  update_interval: -1h
+`
+
+const invalidHistory = `
+access_control:
+  default_policy: allow
+history:
+  retention: -7d
+`
+
+const invalidWebhookTemplate = `
+access_control:
+  default_policy: deny
+  rules:
+    - name: admin
+      policy: deny
+      webhook:
+        url: https://hooks.example.com/admin
+        template: '{"text": {{json .Text}'
+`
+
+const invalidACMEWildcard = `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+  token: 0123456789abcdef0123456789abcdef
+  acme:
+    domains:
+      - "*.example.com"
+    directory: /var/lib/geoblock/acme
+`
+
+const invalidACMECertFile = `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+  token: 0123456789abcdef0123456789abcdef
+  cert_file: /etc/geoblock/admin.crt
+  key_file: /etc/geoblock/admin.key
+  acme:
+    domains:
+      - admin.example.com
+    directory: /var/lib/geoblock/acme
+`
+
+const invalidACMEWithoutHook = `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+  token: 0123456789abcdef0123456789abcdef
+  acme:
+    domains:
+      - admin.example.com
+    directory: /var/lib/geoblock/acme
+    challenge: dns-01
+`
+
+const invalidContext = `
+access_control:
+  default_policy: deny
+  rules:
+    - context:
+        tier: []
+      policy: allow
+`
+
+const invalidCircuitBreaker = `
+access_control:
+  default_policy: deny
+circuit_breaker:
+  max_denied: -1
+`
+
+const invalidOverrideRegion = `
+access_control:
+  default_policy: deny
+databases:
+  overrides:
+    - network: 203.0.113.0/24
+      region: Bavaria
+`
+
+const invalidRegistry = `
+access_control:
+  default_policy: deny
+databases:
+  registries:
+    - ripe
+`
+
+const invalidFetcherProxy = `
+access_control:
+  default_policy: deny
+databases:
+  fetcher:
+    proxy: proxy
+`
+
+const invalidProtocol = `
+access_control:
+  default_policy: deny
+  rules:
+    - protocols:
+        - ftp
+      policy: allow
+`
+
+const invalidRateLimit = `
+access_control:
+  default_policy: deny
+  rules:
+    - policy: allow
+      rate_limit:
+        requests: 0
+        window: 1m
+`
+
+const invalidQuota = `
+access_control:
+  default_policy: deny
+  rules:
+    - policy: allow
+      quota:
+        requests: 10
+        window: 0s
+`
+
+const invalidPath = `
+access_control:
+  default_policy: deny
+  rules:
+    - policy: allow
+      paths:
+        - admin/*
+`
+
+const invalidDNSBLWithoutZone = `
+access_control:
+  default_policy: allow
+dnsbl:
+  address: 127.0.0.1:5353
+`
+
+const invalidDenyResponse = `
+access_control:
+  default_policy: deny
+  deny_response:
+    status: 500
+`
+
+const invalidDenyDelay = `
+access_control:
+  default_policy: deny
+  deny_response:
+    delay: 1m
+`
+
+const invalidDenyTemplate = `
+access_control:
+  default_policy: deny
+  deny_response:
+    body: "{{ .IP"
+`
+
+const invalidDenyLanguage = `
+access_control:
+  default_policy: deny
+  deny_response:
+    bodies:
+      not_a_language!: "<p>Blocked</p>"
+`
+
+const invalidDenyTranslation = `
+access_control:
+  default_policy: deny
+  deny_response:
+    bodies:
+      fr: "{{ .IP"
+`
+
+const invalidInstanceLabel = `
+access_control:
+  default_policy: allow
+instance:
+  labels:
+    __region: eu
+`
+
+const invalidCountryGroup = `
+access_control:
+  default_policy: deny
+  rules:
+    - countries:
+        - NORDICS
+      policy: allow
+`
+
+const invalidCountryGroupName = `
+access_control:
+  default_policy: deny
+  country_groups:
+    NO:
+      - SE
+`
+
+const invalidCountryInGroup = `
+access_control:
+  default_policy: deny
+  country_groups:
+    NORDICS:
+      - XX
+`
+
+const invalidNotCountriesNegation = `
+access_control:
+  default_policy: allow
+  rules:
+    - not_countries:
+        - "!FR"
+      policy: deny
+`
+
+const invalidNotCountriesCode = `
+access_control:
+  default_policy: allow
+  rules:
+    - not_countries:
+        - XX
+      policy: deny
+`
+
+const invalidNotNetworks = `
+access_control:
+  default_policy: allow
+  rules:
+    - not_networks:
+        - 10.0.0.0/33
+      policy: deny
+`
+
+const validCountryGroups = `
+access_control:
+  default_policy: deny
+  country_groups:
+    NORDICS:
+      - DK
+      - SE
+    FRIENDS:
+      - FIVE_EYES
+      - FR
+  rules:
+    - countries:
+        - EU
+        - NORDICS
+        - FRIENDS
+        - "!HU"
+      policy: allow
+`
+
 func TestReadConfigValid(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -146,9 +572,12 @@ func TestReadConfigValid(t *testing.T) {
 								"example.com",
 								"*.example.com",
 							},
-							Methods:           []string{"GET", "POST"},
-							Countries:         []string{"US", "FR"},
-							AutonomousSystems: []uint32{1234, 5678},
+							Methods:   []string{"GET", "POST"},
+							Countries: []string{"US", "FR"},
+							AutonomousSystems: []config.ASNRange{
+								{First: 1234, Last: 1234},
+								{First: 5678, Last: 5678},
+							},
 						},
 						{
 							Policy:            "deny",
@@ -162,6 +591,27 @@ func TestReadConfigValid(t *testing.T) {
 				},
 			},
 		},
+		{
+			"country groups",
+			validCountryGroups,
+			&config.Configuration{
+				AccessControl: config.AccessControl{
+					DefaultPolicy: "deny",
+					CountryGroups: map[string][]string{
+						"NORDICS": {"DK", "SE"},
+						"FRIENDS": {"FIVE_EYES", "FR"},
+					},
+					Rules: []config.AccessControlRule{
+						{
+							Policy: "allow",
+							Countries: []string{
+								"EU", "NORDICS", "FRIENDS", "!HU",
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -179,6 +629,8 @@ func TestReadConfigValid(t *testing.T) {
 }
 
 func TestReadConfigErr(t *testing.T) {
+	t.Setenv(config.LicenseKeyEnv, "")
+
 	tests := []struct {
 		name string
 		data string
@@ -192,14 +644,61 @@ func TestReadConfigErr(t *testing.T) {
 		{"invalid network number", invalidNetworkNumber},
 		{"invalid network range", invalidNetworkRange},
 		{"invalid domain string", invalidDomainString},
+		{"mmdb format without country URL", invalidMMDBWithoutURL},
+		{"mmdb city without city URL", invalidMMDBCityWithoutURL},
+		{"unknown database source", invalidSourceURLs},
+		{"database source without URL", invalidEmptySourceURLs},
+		{"unknown metric label", invalidMetricLabel},
+		{"admin without authentication", invalidAdminWithoutAuthentication},
+		{"admin client CA without certificate", invalidAdminClientCA},
+		{"admin token in plaintext", invalidAdminTokenPlaintext},
+		{"audit sample rate above 1", invalidAuditSampling},
+		{"unknown anonymizer", invalidAnonymizer},
+		{"hashed IP header without key", invalidResponseHeadersHash},
+		{"unknown privacy mode", invalidPrivacy},
+		{"duplicate rule name", invalidDuplicateRuleName},
+		{"invalid URL order", invalidURLOrder},
+		{"invalid webhook", invalidWebhook},
+		{"invalid checksum", invalidChecksum},
+		{"invalid signature key", invalidSignatureKey},
+		{"signature URL without key", invalidMissingSignatureKey},
+		{"negative update interval", invalidUpdateInterval},
+		{"negative history retention", invalidHistory},
+		{"invalid webhook template", invalidWebhookTemplate},
+		{"wildcard ACME domain with HTTP-01", invalidACMEWildcard},
+		{"ACME with certificate file", invalidACMECertFile},
+		{"ACME DNS-01 without hook", invalidACMEWithoutHook},
+		{"context without values", invalidContext},
+		{"negative circuit breaker limit", invalidCircuitBreaker},
+		{"override region without country", invalidOverrideRegion},
+		{"unknown registry", invalidRegistry},
+		{"invalid fetcher proxy", invalidFetcherProxy},
+		{"unknown protocol", invalidProtocol},
+		{"rate limit without requests", invalidRateLimit},
+		{"quota without window", invalidQuota},
+		{"relative path", invalidPath},
+		{"dnsbl without zone", invalidDNSBLWithoutZone},
+		{"invalid deny status", invalidDenyResponse},
+		{"invalid deny template", invalidDenyTemplate},
+		{"invalid deny language", invalidDenyLanguage},
+		{"invalid deny translation", invalidDenyTranslation},
+		{"deny delay too long", invalidDenyDelay},
+		{"reserved instance label", invalidInstanceLabel},
+		{"unknown country group", invalidCountryGroup},
+		{"invalid country group name", invalidCountryGroupName},
+		{"invalid country in group", invalidCountryInGroup},
+		{"negated not_countries", invalidNotCountriesNegation},
+		{"invalid not_countries code", invalidNotCountriesCode},
+		{"invalid not_networks", invalidNotNetworks},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			reader := strings.NewReader(test.data)
 			_, err := config.ReadConfig(reader)
-			if err == nil {
-				t.Error("expected an error but got nil")
+			if !errors.Is(err, config.ErrInvalidConfig) {
+				t.Errorf("got error %v, want %v", err,
+					config.ErrInvalidConfig)
 			}
 		})
 	}
@@ -211,9 +710,110 @@ func (r *errReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("read error")
 }
 
+func TestReadConfigLicenseKey(t *testing.T) {
+	t.Setenv(config.LicenseKeyEnv, "0123456789")
+
+	_, err := config.ReadConfig(strings.NewReader(invalidMMDBWithoutURL))
+	if err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
+
+func TestReadConfigSignatureKey(t *testing.T) {
+	data := `
+access_control:
+  default_policy: allow
+databases:
+  signature_key: 11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=
+  verify:
+    country-ipv4:
+      signature_url: https://example.com/country-ipv4.csv.sig
+`
+	cfg, err := config.ReadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cfg.Databases.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		t.Errorf("got key of %d bytes, want %d", len(key),
+			ed25519.PublicKeySize)
+	}
+}
+
+func TestReadConfigACME(t *testing.T) {
+	data := `
+access_control:
+  default_policy: allow
+admin:
+  address: 127.0.0.1:9090
+  client_ca: /etc/geoblock/ca.pem
+  acme:
+    domains:
+      - "*.example.com"
+    email: admin@example.com
+    directory: /var/lib/geoblock/acme
+    challenge: dns-01
+    dns_hook: /usr/local/bin/acme-dns-hook
+    renew_before: 20d
+`
+	cfg, err := config.ReadConfig(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := cfg.Admin.ACME
+	if acme == nil || acme.RenewBefore != 20*24*time.Hour ||
+		acme.DNSHook != "/usr/local/bin/acme-dns-hook" {
+		t.Errorf("got ACME configuration %+v", acme)
+	}
+}
+
 func TestReadConfigErrReader(t *testing.T) {
 	_, err := config.ReadConfig(&errReader{})
 	if err == nil {
 		t.Error("expected an error but got nil")
 	}
+	if errors.Is(err, config.ErrInvalidConfig) {
+		t.Errorf("got error %v, want a read error", err)
+	}
+}
+
+func TestReadConfigErrLocation(t *testing.T) {
+	data := `
+access_control:
+  default_policy: maybe
+  rules:
+    - policy: allow
+    - domains:
+        - "-example.com"
+      countries:
+        - NORDICS
+      policy: deny
+`
+	_, err := config.ReadConfig(strings.NewReader(data))
+
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected config.Errors, got %v", err)
+	}
+
+	want := []struct {
+		field string
+		line  int
+	}{
+		{"access_control.default_policy", 3},
+		{"access_control.rules[1].domains[0]", 7},
+		{"access_control.rules[1].countries", 9},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i].Field != w.field || errs[i].Line != w.line {
+			t.Errorf("error %d: expected %s at line %d, got %s at line %d",
+				i, w.field, w.line, errs[i].Field, errs[i].Line)
+		}
+	}
 }
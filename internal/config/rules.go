@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errDuplicateRuleName is returned when several rules have the same name,
+// which would make their decisions indistinguishable in the logs and metrics.
+var errDuplicateRuleName = errors.New("duplicate rule name")
+
+// validateRuleNames checks that the names of the rules are unique. Rules
+// without name aren't checked.
+func validateRuleNames(a *AccessControl) *Error {
+	seen := make(map[string]bool, len(a.Rules))
+	for i, rule := range a.Rules {
+		if rule.Name == "" {
+			continue
+		}
+		if seen[rule.Name] {
+			return &Error{
+				Field: fmt.Sprintf("access_control.rules[%d].name", i),
+				Message: fmt.Sprintf(
+					"%v: %q", errDuplicateRuleName, rule.Name,
+				),
+			}
+		}
+		seen[rule.Name] = true
+	}
+	return nil
+}
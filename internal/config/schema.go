@@ -1,28 +1,360 @@
 package config
 
+import "time"
+
 // Accepted policy values.
 const (
 	PolicyAllow = "allow"
 	PolicyDeny  = "deny"
 )
 
-// AccessControlRule represents an access control rule.
+// FailurePolicyStale is the database failure policy that evaluates the rules
+// with the databases that could be loaded. The other failure policies are
+// PolicyAllow and PolicyDeny.
+const FailurePolicyStale = "stale"
+
+// RateLimit represents the maximum number of requests per source IP during a
+// time window.
+type RateLimit struct {
+	Requests int           `yaml:"requests" validate:"min=1"`
+	Window   time.Duration `yaml:"window"   validate:"min=1"`
+}
+
+// Quota represents the maximum number of requests per source country during
+// a time window.
+type Quota struct {
+	Requests int           `yaml:"requests" validate:"min=1"`
+	Window   time.Duration `yaml:"window"   validate:"min=1"`
+}
+
+// Webhook represents the webhook notified of the decisions of a rule, only
+// for the given outcomes if any. At most RateLimit notifications are sent per
+// window, a default limit being applied if unset. The payload is rendered by
+// the Go template Template, or is the event in JSON if unset, and is sent
+// with the content type ContentType.
+type Webhook struct {
+	URL         string     `yaml:"url"                validate:"required,http_url"`
+	Outcomes    []string   `yaml:"outcomes,omitempty" validate:"dive,oneof=allow deny"`
+	RateLimit   *RateLimit `yaml:"rate_limit,omitempty"`
+	Template    string     `yaml:"template,omitempty" validate:"omitempty,payload"`
+	ContentType string     `yaml:"content_type,omitempty"`
+}
+
+// DenyResponse represents the response sent for denied requests. The body is
+// an HTML template, and the redirect URL takes precedence over the status and
+// body. Bodies contains translations of the body by BCP 47 language tag, and
+// Body is used if none matches the client's languages. The response is sent
+// after a random delay of up to Delay.
+type DenyResponse struct {
+	Status   int               `yaml:"status,omitempty"   validate:"omitempty,oneof=401 403 404 451"`
+	Body     string            `yaml:"body,omitempty"     validate:"omitempty,template"`
+	Bodies   map[string]string `yaml:"bodies,omitempty"   validate:"dive,keys,bcp47_language_tag,endkeys,template"`
+	Redirect string            `yaml:"redirect,omitempty" validate:"omitempty,url"`
+	Delay    time.Duration     `yaml:"delay,omitempty"    validate:"min=0,max=10s"`
+}
+
+// AccessControlRule represents an access control rule. The Not* conditions
+// exclude the queries matching any of their values. The Context condition
+// maps context keys to their accepted values.
 type AccessControlRule struct {
-	Policy            string   `yaml:"policy"                       validate:"required,oneof=allow deny"`
-	Networks          []CIDR   `yaml:"networks,omitempty"           validate:"dive,cidr"`
-	Domains           []string `yaml:"domains,omitempty"            validate:"dive,domain"`
-	Methods           []string `yaml:"methods,omitempty"            validate:"dive,oneof=GET HEAD POST PUT DELETE PATCH"`
-	Countries         []string `yaml:"countries,omitempty"          validate:"dive,iso3166_1_alpha2"`
-	AutonomousSystems []uint32 `yaml:"autonomous_systems,omitempty" validate:"dive,numeric"`
+	Name                   string              `yaml:"name,omitempty"                    validate:"omitempty,max=64"`
+	Description            string              `yaml:"description,omitempty"`
+	Policy                 string              `yaml:"policy"                            validate:"required,oneof=allow deny"`
+	Services               []string            `yaml:"services,omitempty"                validate:"dive,domain"`
+	Networks               []CIDR              `yaml:"networks,omitempty"                validate:"dive,cidr"`
+	Domains                []string            `yaml:"domains,omitempty"                 validate:"dive,domain"`
+	Methods                []string            `yaml:"methods,omitempty"                 validate:"dive,oneof=GET HEAD POST PUT DELETE PATCH OPTIONS"`
+	Paths                  []string            `yaml:"paths,omitempty"                   validate:"dive,startswith=/"`
+	Protocols              []string            `yaml:"protocols,omitempty"               validate:"dive,oneof=http https"`
+	Countries              []string            `yaml:"countries,omitempty"`
+	Regions                []string            `yaml:"regions,omitempty"                 validate:"dive,required"`
+	Cities                 []string            `yaml:"cities,omitempty"                  validate:"dive,required"`
+	AutonomousSystems      []ASNRange          `yaml:"autonomous_systems,omitempty"`
+	Organizations          []string            `yaml:"organizations,omitempty"`
+	PeeringDBOrganizations []string            `yaml:"peeringdb_organizations,omitempty" validate:"dive,required"`
+	IsCDN                  *bool               `yaml:"is_cdn,omitempty"`
+	MinForwardedHops       int                 `yaml:"min_forwarded_hops,omitempty"      validate:"min=0"`
+	MaxForwardedHops       int                 `yaml:"max_forwarded_hops,omitempty"      validate:"min=0"`
+	Monitors               []string            `yaml:"monitors,omitempty"                validate:"dive,oneof=uptimerobot pingdom statuscake"`
+	Anonymizers            []string            `yaml:"anonymizers,omitempty"             validate:"dive,oneof=tor vpn proxy"`
+	Context                map[string][]string `yaml:"context,omitempty"                 validate:"dive,keys,required,endkeys,min=1,dive,required"`
+	RateLimit              *RateLimit          `yaml:"rate_limit,omitempty"`
+	Quota                  *Quota              `yaml:"quota,omitempty"`
+	DenyResponse           *DenyResponse       `yaml:"deny_response,omitempty"`
+	Webhook                *Webhook            `yaml:"webhook,omitempty"`
+	NotDomains             []string            `yaml:"not_domains,omitempty"             validate:"dive,domain"`
+	NotNetworks            []CIDR              `yaml:"not_networks,omitempty"            validate:"dive,cidr"`
+	NotCountries           []string            `yaml:"not_countries,omitempty"`
+	NotAutonomousSystems   []ASNRange          `yaml:"not_autonomous_systems,omitempty"`
+}
+
+// Preflight represents the handling of CORS preflight requests.
+type Preflight struct {
+	Allow   bool     `yaml:"allow,omitempty"`
+	Origins []string `yaml:"origins,omitempty"`
 }
 
 // AccessControl represents the access control configuration.
 type AccessControl struct {
 	DefaultPolicy string              `yaml:"default_policy" validate:"required,oneof=allow deny"`
+	Preflight     Preflight           `yaml:"preflight,omitempty"`
+	CountryGroups map[string][]string `yaml:"country_groups,omitempty"`
+	DenyResponse  *DenyResponse       `yaml:"deny_response,omitempty"`
 	Rules         []AccessControlRule `yaml:"rules"          validate:"dive"`
 }
 
+// Cache represents the configuration of the on-disk database cache. If
+// Snapshot is set, the parsed databases are also saved in the directory.
+type Cache struct {
+	Directory string        `yaml:"directory,omitempty"`
+	MaxAge    time.Duration `yaml:"max_age,omitempty"  validate:"min=0"`
+	MaxSize   ByteSize      `yaml:"max_size,omitempty" validate:"min=0"`
+	Snapshot  bool          `yaml:"snapshot,omitempty"`
+}
+
+// Fetcher represents the configuration of the database downloads. If Proxy
+// isn't set, the proxy is taken from the environment. CABundle is a PEM file
+// of certificate authorities trusted in addition to the system ones.
+type Fetcher struct {
+	Proxy     string `yaml:"proxy,omitempty"      validate:"omitempty,url"`
+	CABundle  string `yaml:"ca_bundle,omitempty"`
+	UserAgent string `yaml:"user_agent,omitempty"`
+}
+
+// ResolutionCache represents the configuration of the in-memory cache of IP
+// resolutions. If zero, the default size and TTL are used.
+type ResolutionCache struct {
+	Size int           `yaml:"size,omitempty" validate:"min=0"`
+	TTL  time.Duration `yaml:"ttl,omitempty"  validate:"min=0"`
+}
+
+// Override represents the replacement of the country, ASN or organization of
+// the addresses of a network. The region and city can only be set along with
+// the country.
+type Override struct {
+	Network      CIDR   `yaml:"network"`
+	Country      string `yaml:"country,omitempty" validate:"omitempty,iso3166_1_alpha2"`
+	Region       string `yaml:"region,omitempty"  validate:"excluded_without=Country"`
+	City         string `yaml:"city,omitempty"    validate:"excluded_without=Country"`
+	ASN          uint32 `yaml:"asn,omitempty"`
+	Organization string `yaml:"organization,omitempty"`
+}
+
+// Verification represents the expected checksums and signature of the content
+// of a database source. SHA256URL is the URL of a checksum file in the format
+// of sha256sum, and SignatureURL the URL of a detached Ed25519 signature
+// verified with the signature key of the databases.
+type Verification struct {
+	SHA256       string `yaml:"sha256,omitempty"        validate:"omitempty,len=64,hexadecimal"`
+	SHA256URL    string `yaml:"sha256_url,omitempty"    validate:"omitempty,url"`
+	SignatureURL string `yaml:"signature_url,omitempty" validate:"omitempty,url"`
+}
+
+// Databases represents the configuration of the IP databases. URLs replace
+// the URLs of the database sources, by source name, and are tried in the
+// order of URLOrder.
+// If FailurePolicy is set, geoblock starts even if the databases can't be
+// loaded and applies the policy until they are. If City is set, the city
+// databases replace the country databases. With the MMDB format, the
+// databases of CountryEdition, CityEdition and ASNEdition are downloaded from
+// MaxMind when their URLs aren't set and a license key is in LicenseKeyEnv.
+// The content of the sources of Verify is rejected if it doesn't match their
+// verification. The databases are updated every UpdateInterval, plus a random
+// delay of up to UpdateJitter.
+type Databases struct {
+	Cache             Cache                   `yaml:"cache,omitempty"`
+	Fetcher           Fetcher                 `yaml:"fetcher,omitempty"`
+	Format            string                  `yaml:"format,omitempty"              validate:"omitempty,oneof=csv mmdb"`
+	CountryURL        string                  `yaml:"country_url,omitempty"`
+	ASNURL            string                  `yaml:"asn_url,omitempty"`
+	CityURL           string                  `yaml:"city_url,omitempty"`
+	CountryEdition    string                  `yaml:"country_edition,omitempty"`
+	ASNEdition        string                  `yaml:"asn_edition,omitempty"`
+	CityEdition       string                  `yaml:"city_edition,omitempty"`
+	ASN               *bool                   `yaml:"asn,omitempty"`
+	City              bool                    `yaml:"city,omitempty"`
+	URLs              map[string][]string     `yaml:"urls,omitempty"                validate:"dive,keys,oneof=country-ipv4 country-ipv6 asn-ipv4 asn-ipv6 city-ipv4 city-ipv6 country-mmdb city-mmdb asn-mmdb cdn-cloudflare-ipv4 cdn-cloudflare-ipv6 cdn-google cdn-cloudfront monitor-uptimerobot monitor-pingdom-ipv4 monitor-pingdom-ipv6 monitor-statuscake anonymizer-tor anonymizer-vpn anonymizer-proxy registry-arin registry-ripencc registry-apnic registry-lacnic registry-afrinic,endkeys,min=1,dive,required"`
+	URLOrder          string                  `yaml:"url_order,omitempty"           validate:"omitempty,oneof=configured fastest"`
+	Verify            map[string]Verification `yaml:"verify,omitempty"              validate:"dive,keys,oneof=country-ipv4 country-ipv6 asn-ipv4 asn-ipv6 city-ipv4 city-ipv6 country-mmdb city-mmdb asn-mmdb cdn-cloudflare-ipv4 cdn-cloudflare-ipv6 cdn-google cdn-cloudfront monitor-uptimerobot monitor-pingdom-ipv4 monitor-pingdom-ipv6 monitor-statuscake anonymizer-tor anonymizer-vpn anonymizer-proxy registry-arin registry-ripencc registry-apnic registry-lacnic registry-afrinic,endkeys,required"`
+	SignatureKey      string                  `yaml:"signature_key,omitempty"       validate:"omitempty,base64"`
+	MaxDownloadSize   ByteSize                `yaml:"max_download_size,omitempty"   validate:"min=0"`
+	MaxInvalidRecords int                     `yaml:"max_invalid_records,omitempty" validate:"min=0"`
+	Concurrency       int                     `yaml:"concurrency,omitempty"         validate:"min=0"`
+	FetchTimeout      time.Duration           `yaml:"fetch_timeout,omitempty"       validate:"min=0"`
+	CrossCheck        bool                    `yaml:"cross_check,omitempty"`
+	Registries        []string                `yaml:"registries,omitempty"          validate:"dive,oneof=arin ripencc apnic lacnic afrinic"`
+	CDN               bool                    `yaml:"cdn,omitempty"`
+	Monitors          []string                `yaml:"monitors,omitempty"            validate:"dive,oneof=uptimerobot pingdom statuscake"`
+	Anonymizers       []string                `yaml:"anonymizers,omitempty"         validate:"dive,oneof=tor vpn proxy"`
+	Overrides         []Override              `yaml:"overrides,omitempty"           validate:"dive"`
+	UpdateInterval    time.Duration           `yaml:"update_interval,omitempty"     validate:"min=0"`
+	UpdateJitter      time.Duration           `yaml:"update_jitter,omitempty"       validate:"min=0"`
+	FailurePolicy     string                  `yaml:"failure_policy,omitempty"      validate:"omitempty,oneof=allow deny stale"`
+	ResolutionCache   ResolutionCache         `yaml:"resolution_cache,omitempty"`
+	Index             string                  `yaml:"index,omitempty"               validate:"omitempty,oneof=tree flat"`
+}
+
+// Signature represents the configuration of the signed decision header.
+type Signature struct {
+	Secret string `yaml:"secret,omitempty" validate:"omitempty,min=32"`
+	Header string `yaml:"header,omitempty"`
+}
+
+// ResponseHeaders represents the resolution headers sent to the upstreams of
+// the domains matching one of the patterns. IP is the form of the client IP
+// sent in the IP header, and HashKey is the key of its HMAC when it's hashed.
+type ResponseHeaders struct {
+	Domains []string `yaml:"domains"            validate:"min=1,dive,domain"`
+	Headers []string `yaml:"headers,omitempty"  validate:"dive,oneof=country asn org rule ip"`
+	IP      string   `yaml:"ip,omitempty"       validate:"omitempty,oneof=full truncate hash"`
+	HashKey string   `yaml:"hash_key,omitempty" validate:"required_if=IP hash,omitempty,min=32"`
+}
+
+// Privacy represents the anonymization of the client IPs in the logs and the
+// audit log: "truncate" zeroes the last bits of the IPs, and "hash" replaces
+// them by their HMAC with a random key replaced every KeyRotation.
+type Privacy struct {
+	IP          string        `yaml:"ip,omitempty"           validate:"omitempty,oneof=full truncate hash"`
+	KeyRotation time.Duration `yaml:"key_rotation,omitempty" validate:"min=0"`
+}
+
+// TCPCheck represents the configuration of the TCP check server.
+type TCPCheck struct {
+	Address string `yaml:"address,omitempty" validate:"omitempty,hostname_port"`
+}
+
+// Milter represents the configuration of the milter server.
+type Milter struct {
+	Address string `yaml:"address,omitempty" validate:"omitempty,hostname_port"`
+}
+
+// ExtAuthz represents the configuration of the Envoy ext_authz gRPC server.
+type ExtAuthz struct {
+	Address string `yaml:"address,omitempty" validate:"omitempty,hostname_port"`
+}
+
+// DNSBL represents the configuration of the DNSBL server.
+type DNSBL struct {
+	Address string `yaml:"address,omitempty" validate:"omitempty,hostname_port"`
+	Zone    string `yaml:"zone,omitempty"    validate:"required_with=Address,omitempty,domain"`
+}
+
+// Instance represents the identity of a geoblock instance, attached to its
+// logs and metrics.
+type Instance struct {
+	ID     string            `yaml:"id,omitempty"`
+	Labels map[string]string `yaml:"labels,omitempty" validate:"dive,keys,label,endkeys"`
+}
+
+// Metrics represents the labels of the request metrics and the cardinality
+// limit of the country label. If nil, the default labels are used, and if
+// zero, the default limit.
+type Metrics struct {
+	Labels       []string `yaml:"labels,omitempty"        validate:"unique,dive,oneof=country domain rule method"`
+	MaxCountries int      `yaml:"max_countries,omitempty" validate:"min=0"`
+}
+
+// Bans represents the configuration of the temporary ban list. If File is
+// set, the bans are saved to it and survive restarts.
+type Bans struct {
+	File string `yaml:"file,omitempty"`
+}
+
+// FirstSeen represents the tracking of the countries of the requests to
+// sensitive domains. If File is set, the seen countries are saved to it and
+// survive restarts.
+type FirstSeen struct {
+	Domains []string `yaml:"domains,omitempty" validate:"dive,domain"`
+	File    string   `yaml:"file,omitempty"`
+}
+
+// History represents the retention of the number of requests per source
+// country and ASN, in hourly buckets. If Retention is zero, the requests
+// aren't kept. If MaxASNs is zero, the default limit is used.
+type History struct {
+	Retention time.Duration `yaml:"retention,omitempty" validate:"min=0"`
+	MaxASNs   int           `yaml:"max_asns,omitempty"  validate:"min=0"`
+}
+
+// CircuitBreaker represents the denial of all the requests of a domain
+// pattern for Duration once it received more than MaxDenied denied requests
+// during Window. If MaxDenied is zero, the circuit breaker is disabled. If
+// Window or Duration is zero, the default is used.
+type CircuitBreaker struct {
+	MaxDenied int           `yaml:"max_denied,omitempty" validate:"min=0"`
+	Window    time.Duration `yaml:"window,omitempty"     validate:"min=0"`
+	Duration  time.Duration `yaml:"duration,omitempty"   validate:"min=0"`
+}
+
+// Audit represents the configuration of the audit log of the decisions. The
+// file is rotated when it exceeds MaxSize or MaxAge, and MaxBackups rotated
+// files are kept.
+type Audit struct {
+	File       string        `yaml:"file,omitempty"`
+	MaxSize    ByteSize      `yaml:"max_size,omitempty"    validate:"min=0"`
+	MaxAge     time.Duration `yaml:"max_age,omitempty"     validate:"min=0"`
+	MaxBackups int           `yaml:"max_backups,omitempty" validate:"min=0"`
+	Sampling   AuditSampling `yaml:"sampling,omitempty"`
+}
+
+// AuditSampling represents the fractions of the decisions written to the
+// audit log, by outcome, between 0 (none) and 1 (all). All the decisions of
+// the outcomes without sample rate are written.
+type AuditSampling struct {
+	Allow   *float64 `yaml:"allow,omitempty"   validate:"omitempty,min=0,max=1"`
+	Deny    *float64 `yaml:"deny,omitempty"    validate:"omitempty,min=0,max=1"`
+	Invalid *float64 `yaml:"invalid,omitempty" validate:"omitempty,min=0,max=1"`
+}
+
+// Admin represents the configuration of the authenticated admin API. Clients
+// are authenticated with the bearer token and, if ClientCA is set, with a
+// client certificate signed by it. The API is served over TLS if CertFile or
+// ACME is set.
+type Admin struct {
+	Address  string `yaml:"address,omitempty"   validate:"omitempty,hostname_port"`
+	Token    string `yaml:"token,omitempty"     validate:"omitempty,min=32"`
+	CertFile string `yaml:"cert_file,omitempty" validate:"required_with=KeyFile,excluded_with=ACME"`
+	KeyFile  string `yaml:"key_file,omitempty"  validate:"required_with=CertFile"`
+	ClientCA string `yaml:"client_ca,omitempty"`
+	ACME     *ACME  `yaml:"acme,omitempty"`
+}
+
+// ACME represents the configuration of the certificate of the admin API
+// obtained and renewed with the ACME protocol. The account key and the
+// certificate are stored in Directory. The HTTP-01 challenges are answered on
+// HTTPAddress, and the DNS-01 records are published by the DNSHook command.
+type ACME struct {
+	Domains      []string      `yaml:"domains"                 validate:"min=1,dive,domain"`
+	Email        string        `yaml:"email,omitempty"         validate:"omitempty,email"`
+	DirectoryURL string        `yaml:"directory_url,omitempty" validate:"omitempty,url"`
+	Directory    string        `yaml:"directory"               validate:"required"`
+	Challenge    string        `yaml:"challenge,omitempty"     validate:"omitempty,oneof=http-01 dns-01"`
+	HTTPAddress  string        `yaml:"http_address,omitempty"  validate:"omitempty,hostname_port"`
+	DNSHook      string        `yaml:"dns_hook,omitempty"      validate:"required_if=Challenge dns-01"`
+	RenewBefore  time.Duration `yaml:"renew_before,omitempty"  validate:"min=0"`
+}
+
 // Configuration represents the configuration of the application.
 type Configuration struct {
-	AccessControl AccessControl `yaml:"access_control"`
+	AccessControl   AccessControl     `yaml:"access_control"`
+	Databases       Databases         `yaml:"databases,omitempty"`
+	Signature       Signature         `yaml:"signature,omitempty"`
+	LowMemory       bool              `yaml:"low_memory,omitempty"`
+	DecisionTTL     time.Duration     `yaml:"decision_ttl,omitempty"     validate:"min=0"`
+	TrustedProxies  []CIDR            `yaml:"trusted_proxies,omitempty"`
+	ContextHeader   string            `yaml:"context_header,omitempty"`
+	ResponseHeaders []ResponseHeaders `yaml:"response_headers,omitempty" validate:"dive"`
+	TCPCheck        TCPCheck          `yaml:"tcp_check,omitempty"`
+	Milter          Milter            `yaml:"milter,omitempty"`
+	DNSBL           DNSBL             `yaml:"dnsbl,omitempty"`
+	ExtAuthz        ExtAuthz          `yaml:"ext_authz,omitempty"`
+	Instance        Instance          `yaml:"instance,omitempty"`
+	Bans            Bans              `yaml:"bans,omitempty"`
+	Metrics         Metrics           `yaml:"metrics,omitempty"`
+	FirstSeen       FirstSeen         `yaml:"first_seen,omitempty"`
+	History         History           `yaml:"history,omitempty"`
+	CircuitBreaker  CircuitBreaker    `yaml:"circuit_breaker,omitempty"`
+	Audit           Audit             `yaml:"audit,omitempty"`
+	Privacy         Privacy           `yaml:"privacy,omitempty"`
+	Admin           Admin             `yaml:"admin,omitempty"`
 }
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidSize is returned when a size cannot be parsed.
+var ErrInvalidSize = errors.New("invalid size")
+
+// sizeUnits maps the accepted size suffixes to their multipliers. Longer
+// suffixes must come first so that "MiB" is not parsed as "B".
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// ByteSize represents a size in bytes. It's used to support unmarshaling
+// human-readable sizes such as "512MiB" from YAML.
+type ByteSize int64
+
+// ParseByteSize parses a size made of a non-negative integer optionally
+// followed by one of the B, KB, MB, GB, KiB, MiB or GiB units.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 || value > math.MaxInt64/multiplier {
+		return 0, ErrInvalidSize
+	}
+	return ByteSize(value * multiplier), nil
+}
+
+// UnmarshalYAML unmarshals a size from YAML.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var size string
+	if err := unmarshal(&size); err != nil {
+		return err
+	}
+
+	parsed, err := ParseByteSize(size)
+	if err != nil {
+		return err
+	}
+
+	*b = parsed
+	return nil
+}
@@ -0,0 +1,58 @@
+package config_test
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    config.ByteSize
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"10B", 10, false},
+		{"2KB", 2000, false},
+		{"2KiB", 2048, false},
+		{"5 MB", 5_000_000, false},
+		{"5MiB", 5 << 20, false},
+		{"1GB", 1_000_000_000, false},
+		{"1GiB", 1 << 30, false},
+		{"", 0, true},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"1.5MB", 0, true},
+		{"10TB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := config.ParseByteSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseByteSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseByteSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestByteSizeUnmarshalYAML(t *testing.T) {
+	var size config.ByteSize
+	if err := yaml.Unmarshal([]byte("100MiB"), &size); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != 100<<20 {
+		t.Errorf("got %d, want %d", size, 100<<20)
+	}
+
+	if err := yaml.Unmarshal([]byte("invalid"), &size); err == nil {
+		t.Error("expected an error but got nil")
+	}
+}
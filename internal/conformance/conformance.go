@@ -0,0 +1,293 @@
+// Package conformance checks that a running geoblock instance implements the
+// forward-auth contract the way the supported reverse proxies call it.
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ForwardAuthPath is the path of the forward-auth endpoint, used when the
+// target URL has no path.
+const ForwardAuthPath = "/v1/forward-auth"
+
+// Reverse proxies whose calls are reproduced by the checks. The checks of
+// ProxyAny apply to all of them.
+const (
+	ProxyAny     = "any"
+	ProxyTraefik = "traefik"
+	ProxyNGINX   = "nginx"
+	ProxyCaddy   = "caddy"
+)
+
+// Forward-auth requests are sent for this domain, method and URI. The client
+// IPs are reserved for documentation, so that they're unlikely to be trusted
+// proxies or to match specific rules.
+const (
+	testDomain     = "example.com"
+	testMethod     = http.MethodGet
+	testURI        = "/conformance?check=1"
+	testClientIPv4 = "203.0.113.10"
+	testClientIPv6 = "2001:db8::10"
+	testProxyIPv4  = "198.51.100.1"
+)
+
+// decisionStatuses are the status codes of the allowed requests and of the
+// denied ones, depending on the deny response and the rate limits.
+var decisionStatuses = []int{
+	http.StatusNoContent,
+	http.StatusFound,
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusTooManyRequests,
+	http.StatusUnavailableForLegalReasons,
+}
+
+// ErrUnexpectedStatus is returned when a check gets an unexpected status
+// code.
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+// Header is an HTTP header. Its name is sent as is, without being
+// canonicalized, and a name can appear several times.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// Check is a forward-auth request, as sent by a reverse proxy, and its
+// expected response.
+type Check struct {
+	Proxy   string
+	Name    string
+	Headers []Header
+
+	// Invalid is true if the request must be rejected with a 400 status
+	// code. Otherwise, it must be allowed (204) or denied, with the status
+	// code of the deny response.
+	Invalid bool
+
+	// SameAs is the name of a previous check whose status code must be the
+	// same, since both requests are for the same client. If empty, the
+	// status code isn't compared.
+	SameAs string
+}
+
+// Result is the result of a check.
+type Result struct {
+	Check  *Check
+	Status int   // Status code of the response, zero if the request failed
+	Err    error // Reason of the failure, nil if the check passed
+}
+
+// Passed returns true if the check passed.
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+
+// forwarded returns the headers sent by all the reverse proxies for the
+// given client IP, with canonical names.
+func forwarded(clientIP string) []Header {
+	return []Header{
+		{"X-Forwarded-For", clientIP},
+		{"X-Forwarded-Host", testDomain},
+		{"X-Forwarded-Method", testMethod},
+		{"X-Forwarded-Uri", testURI},
+	}
+}
+
+// without returns the given headers without the one with the given name.
+func without(headers []Header, name string) []Header {
+	var result []Header
+	for _, header := range headers {
+		if !strings.EqualFold(header.Name, name) {
+			result = append(result, header)
+		}
+	}
+	return result
+}
+
+// lowercase returns the given headers with lowercase names.
+func lowercase(headers []Header) []Header {
+	result := make([]Header, 0, len(headers))
+	for _, header := range headers {
+		result = append(result, Header{
+			strings.ToLower(header.Name), header.Value,
+		})
+	}
+	return result
+}
+
+// Checks are the checks run by Run, in order.
+var Checks = []Check{
+	{
+		Proxy:   ProxyTraefik,
+		Name:    "canonical headers",
+		Headers: forwarded(testClientIPv4),
+	},
+	{
+		Proxy: ProxyTraefik,
+		Name:  "additional forwarded headers",
+		Headers: append(forwarded(testClientIPv4),
+			Header{"X-Forwarded-Proto", "https"},
+			Header{"X-Forwarded-Port", "443"},
+			Header{"X-Forwarded-Server", "traefik"},
+			Header{"X-Real-Ip", testClientIPv4},
+		),
+		SameAs: "canonical headers",
+	},
+	{
+		Proxy: ProxyTraefik,
+		Name:  "forwarded chain",
+		Headers: append(
+			without(forwarded(testClientIPv4), "X-Forwarded-For"),
+			Header{"X-Forwarded-For", testProxyIPv4 + ", " + testClientIPv4},
+		),
+		SameAs: "canonical headers",
+	},
+	{
+		Proxy:   ProxyNGINX,
+		Name:    "lowercase headers",
+		Headers: lowercase(forwarded(testClientIPv4)),
+		SameAs:  "canonical headers",
+	},
+	{
+		Proxy: ProxyNGINX,
+		Name:  "repeated X-Forwarded-For headers",
+		Headers: append(
+			without(forwarded(testClientIPv4), "X-Forwarded-For"),
+			Header{"X-Forwarded-For", testProxyIPv4},
+			Header{"X-Forwarded-For", testClientIPv4},
+		),
+		SameAs: "canonical headers",
+	},
+	{
+		Proxy:   ProxyNGINX,
+		Name:    "missing X-Forwarded-Method",
+		Headers: without(forwarded(testClientIPv4), "X-Forwarded-Method"),
+		Invalid: true,
+	},
+	{
+		Proxy:   ProxyCaddy,
+		Name:    "IPv6 client",
+		Headers: forwarded(testClientIPv6),
+	},
+	{
+		Proxy:   ProxyCaddy,
+		Name:    "without X-Forwarded-Uri",
+		Headers: without(forwarded(testClientIPv4), "X-Forwarded-Uri"),
+	},
+	{
+		Proxy:   ProxyAny,
+		Name:    "missing X-Forwarded-For",
+		Headers: without(forwarded(testClientIPv4), "X-Forwarded-For"),
+		Invalid: true,
+	},
+	{
+		Proxy:   ProxyAny,
+		Name:    "missing X-Forwarded-Host",
+		Headers: without(forwarded(testClientIPv4), "X-Forwarded-Host"),
+		Invalid: true,
+	},
+	{
+		Proxy: ProxyAny,
+		Name:  "invalid X-Forwarded-For",
+		Headers: append(
+			without(forwarded(testClientIPv4), "X-Forwarded-For"),
+			Header{"X-Forwarded-For", "unknown"},
+		),
+		Invalid: true,
+	},
+}
+
+// Endpoint returns the URL of the forward-auth endpoint of the given target.
+// ForwardAuthPath is appended to the targets without path.
+func Endpoint(target string) (string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", fmt.Errorf("invalid target URL: %q", target)
+	}
+	if parsed.Path == "" || parsed.Path == "/" {
+		parsed.Path = ForwardAuthPath
+	}
+	return parsed.String(), nil
+}
+
+// Run runs the checks against the forward-auth endpoint at the given URL and
+// returns their results, in the order of Checks. The checks are run
+// sequentially, so that the status codes of the checks can be compared. The
+// client must not follow redirects, since they're deny responses.
+func Run(client *http.Client, endpoint string) []Result {
+	var (
+		results  = make([]Result, 0, len(Checks))
+		statuses = make(map[string]int)
+	)
+	for i := range Checks {
+		check := &Checks[i]
+		result := Result{Check: check}
+		result.Status, result.Err = send(client, endpoint, check.Headers)
+		if result.Err == nil {
+			result.Err = verify(check, result.Status, statuses)
+		}
+		if result.Err == nil {
+			statuses[check.Name] = result.Status
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// send sends a forward-auth request with the given headers and returns the
+// status code of the response.
+func send(
+	client *http.Client,
+	endpoint string,
+	headers []Header,
+) (int, error) {
+	request, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, header := range headers {
+		request.Header[header.Name] = append(
+			request.Header[header.Name], header.Value,
+		)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close() // #nosec G104
+	return response.StatusCode, nil
+}
+
+// verify returns an error if the given status code isn't the expected one
+// for the given check. The statuses are the status codes of the previous
+// checks, by name.
+func verify(check *Check, status int, statuses map[string]int) error {
+	if check.Invalid {
+		if status != http.StatusBadRequest {
+			return fmt.Errorf("%w: got %d, want %d",
+				ErrUnexpectedStatus, status, http.StatusBadRequest)
+		}
+		return nil
+	}
+
+	if !slices.Contains(decisionStatuses, status) {
+		return fmt.Errorf("%w: got %d, want one of %v",
+			ErrUnexpectedStatus, status, decisionStatuses)
+	}
+	if want, ok := statuses[check.SameAs]; ok && status != want {
+		return fmt.Errorf("%w: got %d, want %d as %q",
+			ErrUnexpectedStatus, status, want, check.SameAs)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package conformance_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/conformance"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+type mockFetcher map[string]string
+
+func (m mockFetcher) Fetch(url string) (*ipres.Resource, error) {
+	return &ipres.Resource{Data: []byte(m[url])}, nil
+}
+
+func newTestServer(t *testing.T, policy string) *httptest.Server {
+	t.Helper()
+	resolver := ipres.NewResolver(mockFetcher{
+		ipres.CountryIPv4URL: "203.0.113.0,203.0.113.255,FR\n",
+	}, ipres.Options{DisableASN: true})
+	if err := resolver.Update(); err != nil {
+		t.Fatal(err)
+	}
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{Countries: []string{"FR"}, Policy: policy},
+		},
+	})
+
+	testServer := httptest.NewServer(
+		server.NewServer("", engine, resolver, server.Options{}).Handler,
+	)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestRun(t *testing.T) {
+	for _, policy := range []string{config.PolicyAllow, config.PolicyDeny} {
+		t.Run(policy, func(t *testing.T) {
+			endpoint, err := conformance.Endpoint(
+				newTestServer(t, policy).URL,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results := conformance.Run(http.DefaultClient, endpoint)
+			if len(results) != len(conformance.Checks) {
+				t.Fatalf("got %d results, want %d",
+					len(results), len(conformance.Checks))
+			}
+			for _, result := range results {
+				if !result.Passed() {
+					t.Errorf("%s: %s: %v", result.Check.Proxy,
+						result.Check.Name, result.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunFailures(t *testing.T) {
+	// This server allows all the requests with a source IP, even the invalid
+	// ones, and denies the others.
+	testServer := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			if _, ok := request.Header["X-Forwarded-For"]; !ok {
+				writer.WriteHeader(http.StatusForbidden)
+				return
+			}
+			writer.WriteHeader(http.StatusNoContent)
+		},
+	))
+	defer testServer.Close()
+
+	failed := make(map[string]bool)
+	results := conformance.Run(http.DefaultClient, testServer.URL)
+	for _, result := range results {
+		if !result.Passed() {
+			failed[result.Check.Name] = true
+			if !errors.Is(result.Err, conformance.ErrUnexpectedStatus) {
+				t.Errorf("%s: got error %v, want %v", result.Check.Name,
+					result.Err, conformance.ErrUnexpectedStatus)
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"missing X-Forwarded-Method",
+		"missing X-Forwarded-For",
+		"missing X-Forwarded-Host",
+		"invalid X-Forwarded-For",
+	} {
+		if !failed[name] {
+			t.Errorf("%s: passed, want a failure", name)
+		}
+	}
+	if len(failed) != 4 {
+		t.Errorf("got %d failures, want 4: %v", len(failed), failed)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{"http://geoblock", "http://geoblock/v1/forward-auth", false},
+		{"http://geoblock:80/", "http://geoblock:80/v1/forward-auth", false},
+		{"https://geoblock/auth", "https://geoblock/auth", false},
+		{"localhost:8080", "", true},
+		{"ftp://localhost", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := conformance.Endpoint(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
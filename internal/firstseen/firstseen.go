@@ -0,0 +1,119 @@
+// Package firstseen tracks the countries seen for sensitive domains, to report
+// the first request from each new country.
+package firstseen
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/danroc/geoblock/internal/utils/glob"
+	"github.com/danroc/geoblock/internal/utils/statefile"
+)
+
+// stateKind is the kind of the state files of the trackers.
+const stateKind = "first_seen"
+
+// Tracker tracks the countries seen for the domains matching its patterns.
+// It's safe for concurrent use.
+//
+// If a file is set, the seen countries are saved to it after each new
+// country, so that they survive restarts.
+type Tracker struct {
+	patterns []string
+	mu       sync.RWMutex
+	seen     map[string]map[string]bool // Countries seen per pattern
+	file     string
+}
+
+// NewTracker creates a new tracker for the domains matching the given
+// patterns. Patterns may contain `*` wildcards and are case-insensitive.
+func NewTracker(patterns []string) *Tracker {
+	return &Tracker{
+		patterns: patterns,
+		seen:     make(map[string]map[string]bool),
+	}
+}
+
+// Persist loads the countries saved in the given file, if it exists, and
+// saves them to this file after each new country. A corrupted file isn't
+// loaded, see statefile.Read.
+func (t *Tracker) Persist(file string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var saved map[string][]string
+	if _, err := statefile.Read(file, stateKind, &saved); err != nil {
+		return err
+	}
+	for pattern, countries := range saved {
+		for _, country := range countries {
+			t.add(pattern, country)
+		}
+	}
+
+	t.file = file
+	return nil
+}
+
+// pattern returns the first pattern matching the given domain.
+func (t *Tracker) pattern(domain string) (string, bool) {
+	for _, pattern := range t.patterns {
+		if glob.Star(strings.ToLower(pattern), strings.ToLower(domain)) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Observe records a request for the given domain from the given country. It
+// returns the pattern matching the domain and true if the country is seen
+// for the first time for this pattern. Unknown countries are ignored. If the
+// countries can't be saved, the country is still recorded.
+func (t *Tracker) Observe(domain, country string) (string, bool, error) {
+	pattern, ok := t.pattern(domain)
+	if !ok || country == "" {
+		return "", false, nil
+	}
+
+	t.mu.RLock()
+	seen := t.seen[pattern][country]
+	t.mu.RUnlock()
+	if seen {
+		return pattern, false, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Another request may have recorded the country in the meantime.
+	if t.seen[pattern][country] {
+		return pattern, false, nil
+	}
+	t.add(pattern, country)
+	return pattern, true, t.save()
+}
+
+// add records the given country for the given pattern. The caller must hold
+// the lock.
+func (t *Tracker) add(pattern, country string) {
+	if t.seen[pattern] == nil {
+		t.seen[pattern] = make(map[string]bool)
+	}
+	t.seen[pattern][country] = true
+}
+
+// save writes the seen countries to the tracker's file, if any, see
+// statefile.Write. The caller must hold the lock.
+func (t *Tracker) save() error {
+	if t.file == "" {
+		return nil
+	}
+
+	saved := make(map[string][]string, len(t.seen))
+	for pattern, countries := range t.seen {
+		saved[pattern] = slices.Sorted(maps.Keys(countries))
+	}
+	return statefile.Write(t.file, stateKind, saved)
+}
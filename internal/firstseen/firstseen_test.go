@@ -0,0 +1,64 @@
+package firstseen_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/firstseen"
+)
+
+func TestTrackerObserve(t *testing.T) {
+	tracker := firstseen.NewTracker([]string{"nas.example.com", "*.home.org"})
+
+	steps := []struct {
+		domain  string
+		country string
+		pattern string
+		isNew   bool
+	}{
+		{"nas.example.com", "FR", "nas.example.com", true},
+		{"nas.example.com", "FR", "nas.example.com", false},
+		{"NAS.example.com", "US", "nas.example.com", true},
+		{"nas.example.com", "", "", false},
+		{"a.home.org", "FR", "*.home.org", true},
+		{"b.home.org", "FR", "*.home.org", false},
+		{"www.example.com", "FR", "", false},
+	}
+	for _, step := range steps {
+		pattern, isNew, err := tracker.Observe(step.domain, step.country)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pattern != step.pattern || isNew != step.isNew {
+			t.Errorf("Observe(%q, %q) = %q, %v, want %q, %v",
+				step.domain, step.country, pattern, isNew,
+				step.pattern, step.isNew)
+		}
+	}
+}
+
+func TestTrackerPersist(t *testing.T) {
+	var (
+		file     = filepath.Join(t.TempDir(), "countries.json")
+		patterns = []string{"nas.example.com"}
+		tracker  = firstseen.NewTracker(patterns)
+	)
+	if err := tracker.Persist(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tracker.Observe("nas.example.com", "FR"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The countries seen before the restart aren't new.
+	restarted := firstseen.NewTracker(patterns)
+	if err := restarted.Persist(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, isNew, _ := restarted.Observe("nas.example.com", "FR"); isNew {
+		t.Error("Observe() = true for a country seen before the restart")
+	}
+	if _, isNew, _ := restarted.Observe("nas.example.com", "US"); !isNew {
+		t.Error("Observe() = false for a new country")
+	}
+}
@@ -0,0 +1,256 @@
+// Package fixtures generates small synthetic country and ASN databases, and a
+// configuration using them, so that proxy setups can be tested hermetically,
+// without downloading the real databases.
+package fixtures
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Names of the generated files.
+const (
+	CountryIPv4File = "country-ipv4.csv"
+	CountryIPv6File = "country-ipv6.csv"
+	ASNIPv4File     = "asn-ipv4.csv"
+	ASNIPv6File     = "asn-ipv6.csv"
+	ConfigFile      = "config.yaml"
+)
+
+// MaxEntries is the maximum number of countries, and of ASNs, of the
+// fixtures, each of them having its own /24 network.
+const MaxEntries = 255
+
+// Errors returned by Generate for invalid options.
+var (
+	ErrNoCountry       = errors.New("no allowed country")
+	ErrTooManyEntries  = errors.New("too many countries or ASNs")
+	ErrInvalidCountry  = errors.New("invalid country code")
+	ErrInvalidASN      = errors.New("invalid ASN")
+	ErrInvalidLocation = errors.New("directory must be an absolute path")
+)
+
+// Decisions expected for the sample clients.
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// Options contains the options of the fixtures.
+type Options struct {
+	// Directory is the absolute path of the directory where the files are
+	// written, which the configuration refers to.
+	Directory string
+
+	// Countries are the countries allowed by the configuration.
+	Countries []string
+
+	// DeniedCountries are countries of the databases that aren't allowed by
+	// the configuration.
+	DeniedCountries []string
+
+	// ASNs are the autonomous systems allowed by the configuration. Their
+	// networks have no country.
+	ASNs []uint32
+}
+
+// Client is a sample client of the fixtures, with the decision expected for
+// its requests.
+type Client struct {
+	IP       netip.Addr `json:"ip"`
+	Country  string     `json:"country,omitempty"`
+	ASN      uint32     `json:"asn,omitempty"`
+	Decision string     `json:"decision"`
+}
+
+// Fixtures are the contents of the generated files, by file name, and the
+// sample clients.
+type Fixtures struct {
+	Files   map[string][]byte
+	Clients []Client
+}
+
+// Networks of the fixtures. They're reserved for benchmarks and for
+// documentation, so they never conflict with real clients. The last network
+// of the countries, of index MaxEntries, is in no database.
+var (
+	countryIPv4Base = netip.MustParseAddr("198.18.0.0")
+	asnIPv4Base     = netip.MustParseAddr("198.19.0.0")
+	countryIPv6Base = netip.MustParseAddr("2001:db8::")
+	asnIPv6Base     = netip.MustParseAddr("2001:db8:8000::")
+)
+
+// network returns the first and last addresses of the given network of the
+// fixtures: the n-th /24 after an IPv4 base, or the n-th /48 after an IPv6
+// base.
+func network(base netip.Addr, n int) (netip.Addr, netip.Addr) {
+	bytes := base.AsSlice()
+	if base.Is4() {
+		bytes[2] += byte(n)
+		first := netip.AddrFrom4([4]byte(bytes))
+		bytes[3] = 0xff
+		return first, netip.AddrFrom4([4]byte(bytes))
+	}
+
+	bytes[4] += byte(n >> 8)
+	bytes[5] += byte(n)
+	first := netip.AddrFrom16([16]byte(bytes))
+	for i := 6; i < len(bytes); i++ {
+		bytes[i] = 0xff
+	}
+	return first, netip.AddrFrom16([16]byte(bytes))
+}
+
+// sample returns the first host of the n-th network after the given base.
+func sample(base netip.Addr, n int) netip.Addr {
+	first, _ := network(base, n)
+	return first.Next()
+}
+
+// isCountryCode checks if the given string is made of two uppercase letters,
+// like the country codes of the databases.
+func isCountryCode(s string) bool {
+	return len(s) == 2 && 'A' <= s[0] && s[0] <= 'Z' && 'A' <= s[1] &&
+		s[1] <= 'Z'
+}
+
+// validate checks the given options, whose allowed and denied countries are
+// given.
+func validate(options *Options, countries []string) error {
+	if !filepath.IsAbs(options.Directory) {
+		return fmt.Errorf("%w: %q", ErrInvalidLocation, options.Directory)
+	}
+	if len(options.Countries) == 0 {
+		return ErrNoCountry
+	}
+	if len(countries) >= MaxEntries || len(options.ASNs) >= MaxEntries {
+		return ErrTooManyEntries
+	}
+	for _, country := range countries {
+		if !isCountryCode(country) {
+			return fmt.Errorf("%w: %q", ErrInvalidCountry, country)
+		}
+	}
+	for _, asn := range options.ASNs {
+		if asn == 0 {
+			return fmt.Errorf("%w: %d", ErrInvalidASN, asn)
+		}
+	}
+	return nil
+}
+
+// Generate generates the fixtures with the given options.
+//
+// Each country has a /24 IPv4 network in 198.18.0.0/16 and a /48 IPv6 network
+// in 2001:db8::/33, and each ASN has a /24 IPv4 network in 198.19.0.0/16 and
+// a /48 IPv6 network in 2001:db8:8000::/33. The configuration allows the
+// countries and the ASNs of the options, and denies the other clients.
+func Generate(options Options) (*Fixtures, error) {
+	countries := slices.Concat(options.Countries, options.DeniedCountries)
+	if err := validate(&options, countries); err != nil {
+		return nil, err
+	}
+
+	var (
+		countryIPv4 strings.Builder
+		countryIPv6 strings.Builder
+		asnIPv4     strings.Builder
+		asnIPv6     strings.Builder
+		clients     []Client
+	)
+	for i, country := range countries {
+		first, last := network(countryIPv4Base, i)
+		fmt.Fprintf(&countryIPv4, "%s,%s,%s\n", first, last, country)
+		first, last = network(countryIPv6Base, i)
+		fmt.Fprintf(&countryIPv6, "%s,%s,%s\n", first, last, country)
+
+		decision := DecisionAllow
+		if i >= len(options.Countries) {
+			decision = DecisionDeny
+		}
+		for _, base := range []netip.Addr{countryIPv4Base, countryIPv6Base} {
+			clients = append(clients, Client{
+				IP:       sample(base, i),
+				Country:  country,
+				Decision: decision,
+			})
+		}
+	}
+	for i, asn := range options.ASNs {
+		org := "Fixture AS" + strconv.FormatUint(uint64(asn), 10)
+		first, last := network(asnIPv4Base, i)
+		fmt.Fprintf(&asnIPv4, "%s,%s,%d,%s\n", first, last, asn, org)
+		first, last = network(asnIPv6Base, i)
+		fmt.Fprintf(&asnIPv6, "%s,%s,%d,%s\n", first, last, asn, org)
+
+		for _, base := range []netip.Addr{asnIPv4Base, asnIPv6Base} {
+			clients = append(clients, Client{
+				IP:       sample(base, i),
+				ASN:      asn,
+				Decision: DecisionAllow,
+			})
+		}
+	}
+	for _, base := range []netip.Addr{countryIPv4Base, countryIPv6Base} {
+		clients = append(clients, Client{
+			IP:       sample(base, MaxEntries),
+			Decision: DecisionDeny,
+		})
+	}
+
+	return &Fixtures{
+		Files: map[string][]byte{
+			CountryIPv4File: []byte(countryIPv4.String()),
+			CountryIPv6File: []byte(countryIPv6.String()),
+			ASNIPv4File:     []byte(asnIPv4.String()),
+			ASNIPv6File:     []byte(asnIPv6.String()),
+			ConfigFile:      configuration(&options),
+		},
+		Clients: clients,
+	}, nil
+}
+
+// configuration returns the configuration using the databases of the
+// fixtures, which allows the countries and ASNs of the given options.
+func configuration(options *Options) []byte {
+	var b strings.Builder
+	b.WriteString("# Generated by `geoblock fixtures`.\n")
+	b.WriteString("access_control:\n")
+	b.WriteString("  default_policy: deny\n")
+	b.WriteString("  rules:\n")
+	b.WriteString("    - name: fixture-countries\n")
+	b.WriteString("      countries:\n")
+	for _, country := range options.Countries {
+		fmt.Fprintf(&b, "        - %s\n", country)
+	}
+	b.WriteString("      policy: allow\n")
+	if len(options.ASNs) > 0 {
+		b.WriteString("    - name: fixture-asns\n")
+		b.WriteString("      autonomous_systems:\n")
+		for _, asn := range options.ASNs {
+			fmt.Fprintf(&b, "        - %d\n", asn)
+		}
+		b.WriteString("      policy: allow\n")
+	}
+
+	b.WriteString("databases:\n")
+	b.WriteString("  urls:\n")
+	for _, source := range []struct{ name, file string }{
+		{"country-ipv4", CountryIPv4File},
+		{"country-ipv6", CountryIPv6File},
+		{"asn-ipv4", ASNIPv4File},
+		{"asn-ipv6", ASNIPv6File},
+	} {
+		fmt.Fprintf(&b, "    %s:\n", source.name)
+		fmt.Fprintf(
+			&b, "      - file://%s\n",
+			filepath.ToSlash(filepath.Join(options.Directory, source.file)),
+		)
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,127 @@
+package fixtures_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/fixtures"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	generated, err := fixtures.Generate(fixtures.Options{
+		Directory:       dir,
+		Countries:       []string{"FR", "DE"},
+		DeniedCountries: []string{"CN"},
+		ASNs:            []uint32{64500},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range generated.Files {
+		err := os.WriteFile(filepath.Join(dir, name), content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The generated configuration is valid and loads the generated
+	// databases.
+	cfg, err := config.ReadConfig(
+		bytes.NewReader(generated.Files[fixtures.ConfigFile]),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := ipres.NewResolver(
+		ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+		ipres.Options{URLs: cfg.Databases.URLs},
+	)
+	if err := resolver.Update(); err != nil {
+		t.Fatal(err)
+	}
+	engine := rules.NewEngine(&cfg.AccessControl)
+
+	if got := len(generated.Clients); got != 10 {
+		t.Errorf("got %d clients, want 10", got)
+	}
+	for _, client := range generated.Clients {
+		resolved := resolver.Resolve(client.IP)
+		if resolved.CountryCode != client.Country ||
+			(client.ASN != 0 && resolved.ASN != client.ASN) {
+			t.Errorf("%s: got %q and %d, want %q and %d", client.IP,
+				resolved.CountryCode, resolved.ASN, client.Country,
+				client.ASN)
+		}
+
+		decision := engine.Evaluate(&rules.Query{
+			RequestedDomain: "example.com",
+			RequestedMethod: "GET",
+			SourceIP:        client.IP,
+			SourceCountry:   resolved.CountryCode,
+			SourceASN:       resolved.ASN,
+		})
+		allowed := client.Decision == fixtures.DecisionAllow
+		if decision.Allowed != allowed {
+			t.Errorf("%s: got allowed %t, want %t", client.IP,
+				decision.Allowed, allowed)
+		}
+	}
+}
+
+func TestGenerateErr(t *testing.T) {
+	tests := []struct {
+		name    string
+		options fixtures.Options
+		want    error
+	}{
+		{
+			"relative directory",
+			fixtures.Options{Directory: "fixtures", Countries: []string{"FR"}},
+			fixtures.ErrInvalidLocation,
+		},
+		{
+			"no country",
+			fixtures.Options{Directory: "/tmp"},
+			fixtures.ErrNoCountry,
+		},
+		{
+			"invalid country",
+			fixtures.Options{Directory: "/tmp", Countries: []string{"fr"}},
+			fixtures.ErrInvalidCountry,
+		},
+		{
+			"invalid ASN",
+			fixtures.Options{
+				Directory: "/tmp",
+				Countries: []string{"FR"},
+				ASNs:      []uint32{0},
+			},
+			fixtures.ErrInvalidASN,
+		},
+		{
+			"too many ASNs",
+			fixtures.Options{
+				Directory: "/tmp",
+				Countries: []string{"FR"},
+				ASNs:      make([]uint32, fixtures.MaxEntries),
+			},
+			fixtures.ErrTooManyEntries,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := fixtures.Generate(tt.options)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
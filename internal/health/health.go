@@ -0,0 +1,123 @@
+// Package health tracks the state of the components of geoblock, e.g., the
+// databases or the webhooks, from the results of their last operations.
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// States of the components, from the healthiest to the least healthy.
+const (
+	StateOK       = "ok"       // The last operation succeeded
+	StateDegraded = "degraded" // The component works with stale data or less
+	StateFailed   = "failed"   // The component can't do its job
+)
+
+// Names of the components.
+const (
+	ComponentConfig    = "config"
+	ComponentDatabases = "databases"
+	ComponentCache     = "cache"
+	ComponentWebhooks  = "webhooks"
+	ComponentAudit     = "audit"
+)
+
+// severity orders the states, from the healthiest to the least healthy.
+var severity = map[string]int{
+	StateOK:       0,
+	StateDegraded: 1,
+	StateFailed:   2,
+}
+
+// Component is the state of a component. The last error is kept once the
+// component recovers, so that intermittent failures can be diagnosed.
+type Component struct {
+	State         string     `json:"state"`
+	Since         time.Time  `json:"since"` // Time of the last state change
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// tracker contains the state of the components, by name.
+type tracker struct {
+	mu         sync.RWMutex
+	components map[string]*Component
+	now        func() time.Time
+}
+
+// newTracker creates a new tracker without components.
+func newTracker() *tracker {
+	return &tracker{components: make(map[string]*Component), now: time.Now}
+}
+
+// defaultTracker is the tracker of the package functions.
+var defaultTracker = newTracker()
+
+// report sets the state of the given component. A successful report of a
+// component that's already OK only takes a read lock, so that it can be made
+// for each request.
+func (t *tracker) report(name, state string, err error) {
+	if err == nil {
+		t.mu.RLock()
+		current, ok := t.components[name]
+		unchanged := ok && current.State == state
+		t.mu.RUnlock()
+		if unchanged {
+			return
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	current, ok := t.components[name]
+	if !ok {
+		current = &Component{State: state, Since: now}
+		t.components[name] = current
+	}
+	if current.State != state {
+		current.State = state
+		current.Since = now
+	}
+	if err != nil {
+		current.LastError = err.Error()
+		current.LastErrorTime = &now
+	}
+}
+
+// snapshot returns a copy of the state of the components.
+func (t *tracker) snapshot() map[string]Component {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make(map[string]Component, len(t.components))
+	for name, component := range t.components {
+		result[name] = *component
+	}
+	return result
+}
+
+// Report sets the state of the given component from the result of its last
+// operation. The error, if any, is kept as the last error of the component.
+func Report(name, state string, err error) {
+	defaultTracker.report(name, state, err)
+}
+
+// Components returns the state of the components that reported it, by name.
+func Components() map[string]Component {
+	return defaultTracker.snapshot()
+}
+
+// Overall returns the state of the least healthy of the given components, or
+// StateOK if there is none.
+func Overall(components map[string]Component) string {
+	state := StateOK
+	for _, component := range components {
+		if severity[component.State] > severity[state] {
+			state = component.State
+		}
+	}
+	return state
+}
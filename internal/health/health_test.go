@@ -0,0 +1,71 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	now := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	tracker := newTracker()
+	tracker.now = func() time.Time { return now }
+
+	tracker.report(ComponentDatabases, StateOK, nil)
+	start := now
+
+	now = now.Add(time.Minute)
+	tracker.report(ComponentDatabases, StateOK, nil)
+	got := tracker.snapshot()[ComponentDatabases]
+	if got.State != StateOK || !got.Since.Equal(start) ||
+		got.LastErrorTime != nil {
+		t.Errorf("got %+v, want OK since the first report", got)
+	}
+
+	now = now.Add(time.Minute)
+	failure := now
+	tracker.report(ComponentDatabases, StateDegraded, errors.New("timeout"))
+	got = tracker.snapshot()[ComponentDatabases]
+	if got.State != StateDegraded || !got.Since.Equal(failure) ||
+		got.LastError != "timeout" || !got.LastErrorTime.Equal(failure) {
+		t.Errorf("got %+v, want degraded with the last error", got)
+	}
+
+	// The last error is kept once the component recovers.
+	now = now.Add(time.Minute)
+	tracker.report(ComponentDatabases, StateOK, nil)
+	got = tracker.snapshot()[ComponentDatabases]
+	if got.State != StateOK || !got.Since.Equal(now) ||
+		got.LastError != "timeout" || !got.LastErrorTime.Equal(failure) {
+		t.Errorf("got %+v, want OK with the last error", got)
+	}
+}
+
+func TestOverall(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []string
+		want   string
+	}{
+		{"no components", nil, StateOK},
+		{"all ok", []string{StateOK, StateOK}, StateOK},
+		{"degraded", []string{StateOK, StateDegraded}, StateDegraded},
+		{
+			"failed",
+			[]string{StateFailed, StateDegraded, StateOK},
+			StateFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			components := make(map[string]Component)
+			for i, state := range tt.states {
+				components[string(rune('a'+i))] = Component{State: state}
+			}
+			if got := Overall(components); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Package history keeps the number of allowed and denied requests per source
+// country and ASN in hourly buckets, so that their trends can be queried
+// without an external metrics stack.
+package history
+
+import (
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Dimensions by which the requests are counted.
+const (
+	DimensionCountry = "country"
+	DimensionASN     = "asn"
+)
+
+// Keys of the requests without source country or ASN, and of the ASNs counted
+// once the limit of an hour is reached.
+const (
+	Unknown = "unknown"
+	Other   = "other"
+)
+
+// Default options of a store.
+const (
+	DefaultRetention = 7 * 24 * time.Hour
+	DefaultMaxASNs   = 100
+)
+
+// BucketSize is the duration of a bucket, and the finest step of the queries.
+const BucketSize = time.Hour
+
+// Counts is the number of allowed and denied requests.
+type Counts struct {
+	Allowed uint64 `json:"allowed"`
+	Denied  uint64 `json:"denied"`
+}
+
+// add counts a request with the given outcome.
+func (c *Counts) add(allowed bool) {
+	if allowed {
+		c.Allowed++
+	} else {
+		c.Denied++
+	}
+}
+
+// bucket counts the requests during one hour.
+type bucket struct {
+	start     int64 // Start of the bucket, in hours since the Unix epoch
+	countries map[string]*Counts
+	asns      map[string]*Counts
+}
+
+// Options contains the options of a store. Zero values select the defaults.
+type Options struct {
+	// Retention is the period during which the requests are kept. It's
+	// rounded up to a whole number of hours.
+	Retention time.Duration
+
+	// MaxASNs is the maximum number of distinct ASNs per hour. The requests
+	// from the other ASNs are counted as Other.
+	MaxASNs int
+}
+
+// Store counts the requests per source country and ASN in a ring of hourly
+// buckets covering the retention period. It's safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	buckets []bucket
+	maxASNs int
+}
+
+// NewStore creates an empty store with the given options.
+func NewStore(options Options) *Store {
+	retention := options.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	maxASNs := options.MaxASNs
+	if maxASNs <= 0 {
+		maxASNs = DefaultMaxASNs
+	}
+	count := (retention + BucketSize - 1) / BucketSize
+	return &Store{buckets: make([]bucket, count), maxASNs: maxASNs}
+}
+
+// Retention returns the period during which the requests are kept.
+func (s *Store) Retention() time.Duration {
+	return time.Duration(len(s.buckets)) * BucketSize
+}
+
+// hour returns the number of hours since the Unix epoch at the given time.
+func hour(t time.Time) int64 {
+	return t.Unix() / int64(BucketSize.Seconds())
+}
+
+// Add counts a request from the given country and ASN at the given time.
+func (s *Store) Add(country string, asn uint32, allowed bool, now time.Time) {
+	countryKey := country
+	if countryKey == "" {
+		countryKey = Unknown
+	}
+	asnKey := Unknown
+	if asn != 0 {
+		asnKey = strconv.FormatUint(uint64(asn), 10)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := hour(now)
+	b := &s.buckets[start%int64(len(s.buckets))]
+	if b.start != start || b.countries == nil {
+		*b = bucket{
+			start:     start,
+			countries: make(map[string]*Counts),
+			asns:      make(map[string]*Counts),
+		}
+	}
+	counts(b.countries, countryKey).add(allowed)
+	if _, ok := b.asns[asnKey]; !ok && len(b.asns) >= s.maxASNs {
+		asnKey = Other
+	}
+	counts(b.asns, asnKey).add(allowed)
+}
+
+// counts returns the counts of the given key, adding them if needed.
+func counts(m map[string]*Counts, key string) *Counts {
+	c, ok := m[key]
+	if !ok {
+		c = &Counts{}
+		m[key] = c
+	}
+	return c
+}
+
+// Point is the number of requests per key of a dimension during a step.
+type Point struct {
+	Start  time.Time         `json:"start"`
+	Counts map[string]Counts `json:"counts"`
+}
+
+// Query returns the number of requests per key of the given dimension, for
+// each step of the given period before the given time, in chronological
+// order. The step is rounded down to a whole number of hours, at least one,
+// and the hourly buckets are summed into points aligned on it. Steps without
+// requests are omitted.
+func (s *Store) Query(
+	dimension string,
+	step time.Duration,
+	period time.Duration,
+	now time.Time,
+) []Point {
+	hours := max(int64(step/BucketSize), 1)
+	current := hour(now)
+	first := current - int64(len(s.buckets)) + 1
+	if period > 0 {
+		first = max(first, current-int64(period/BucketSize)+1)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points := make(map[int64]map[string]Counts)
+	for _, b := range s.buckets {
+		if b.countries == nil || b.start < first || b.start > current {
+			continue
+		}
+		source := b.countries
+		if dimension == DimensionASN {
+			source = b.asns
+		}
+
+		start := b.start - b.start%hours
+		point, ok := points[start]
+		if !ok {
+			point = make(map[string]Counts)
+			points[start] = point
+		}
+		for key, c := range source {
+			sum := point[key]
+			sum.Allowed += c.Allowed
+			sum.Denied += c.Denied
+			point[key] = sum
+		}
+	}
+
+	result := make([]Point, 0, len(points))
+	for start, point := range points {
+		result = append(result, Point{
+			Start:  time.Unix(start*int64(BucketSize.Seconds()), 0).UTC(),
+			Counts: point,
+		})
+	}
+	slices.SortFunc(result, func(a, b Point) int {
+		return a.Start.Compare(b.Start)
+	})
+	return result
+}
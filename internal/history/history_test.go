@@ -0,0 +1,149 @@
+package history_test
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/history"
+)
+
+var start = time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+
+// newTestStore returns a store with a request from FR every hour during the
+// first 6 hours, and a denied request from US during the third hour.
+func newTestStore() *history.Store {
+	store := history.NewStore(history.Options{Retention: 24 * time.Hour})
+	for i := range 6 {
+		at := start.Add(time.Duration(i)*time.Hour + time.Minute)
+		store.Add("FR", 3215, true, at)
+	}
+	store.Add("US", 15169, false, start.Add(2*time.Hour))
+	return store
+}
+
+func TestStoreQuery(t *testing.T) {
+	store := newTestStore()
+	now := start.Add(5*time.Hour + 30*time.Minute)
+
+	tests := []struct {
+		name      string
+		dimension string
+		step      time.Duration
+		period    time.Duration
+		want      []history.Point
+	}{
+		{
+			"hourly countries",
+			history.DimensionCountry,
+			time.Hour,
+			3 * time.Hour,
+			[]history.Point{
+				{start.Add(3 * time.Hour), map[string]history.Counts{
+					"FR": {Allowed: 1},
+				}},
+				{start.Add(4 * time.Hour), map[string]history.Counts{
+					"FR": {Allowed: 1},
+				}},
+				{start.Add(5 * time.Hour), map[string]history.Counts{
+					"FR": {Allowed: 1},
+				}},
+			},
+		},
+		{
+			"downsampled countries",
+			history.DimensionCountry,
+			3 * time.Hour,
+			0,
+			[]history.Point{
+				{start, map[string]history.Counts{
+					"FR": {Allowed: 3},
+					"US": {Denied: 1},
+				}},
+				{start.Add(3 * time.Hour), map[string]history.Counts{
+					"FR": {Allowed: 3},
+				}},
+			},
+		},
+		{
+			"daily ASNs",
+			history.DimensionASN,
+			24 * time.Hour,
+			0,
+			[]history.Point{
+				{start, map[string]history.Counts{
+					"3215":  {Allowed: 6},
+					"15169": {Denied: 1},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := store.Query(tt.dimension, tt.step, tt.period, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points, want %d: %+v",
+					len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if !got[i].Start.Equal(tt.want[i].Start) ||
+					!maps.Equal(got[i].Counts, tt.want[i].Counts) {
+					t.Errorf("got point %+v, want %+v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStoreRetention(t *testing.T) {
+	store := newTestStore()
+	if got := store.Retention(); got != 24*time.Hour {
+		t.Errorf("got retention %v, want 24h", got)
+	}
+
+	// The first hours are replaced by the hours one day later.
+	later := start.Add(26 * time.Hour)
+	store.Add("DE", 0, true, later)
+	got := store.Query(history.DimensionCountry, 24*time.Hour, 0, later)
+	want := []history.Point{
+		{start, map[string]history.Counts{"FR": {Allowed: 3}}},
+		{start.Add(24 * time.Hour), map[string]history.Counts{
+			"DE": {Allowed: 1},
+		}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d points, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range got {
+		if !got[i].Start.Equal(want[i].Start) ||
+			!maps.Equal(got[i].Counts, want[i].Counts) {
+			t.Errorf("got point %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestStoreMaxASNs(t *testing.T) {
+	store := history.NewStore(history.Options{MaxASNs: 2})
+	for _, asn := range []uint32{1, 2, 3, 4, 1, 0} {
+		store.Add("", asn, true, start)
+	}
+
+	got := store.Query(history.DimensionASN, time.Hour, 0, start)
+	if len(got) != 1 {
+		t.Fatalf("got %d points, want 1", len(got))
+	}
+	want := map[string]history.Counts{
+		"1":           {Allowed: 2},
+		"2":           {Allowed: 1},
+		history.Other: {Allowed: 3},
+	}
+	if !maps.Equal(got[0].Counts, want) {
+		t.Errorf("got counts %v, want %v", got[0].Counts, want)
+	}
+
+	countries := store.Query(history.DimensionCountry, time.Hour, 0, start)
+	if got := countries[0].Counts[history.Unknown].Allowed; got != 6 {
+		t.Errorf("got %d requests of unknown country, want 6", got)
+	}
+}
@@ -0,0 +1,85 @@
+package ipres
+
+import (
+	"slices"
+	"strings"
+)
+
+// URLs of the published lists of anonymization networks.
+const (
+	TorExitURL = "https://check.torproject.org/torbulkexitlist"
+	VPNURL     = "https://raw.githubusercontent.com/X4BNet/lists_vpn/main/output/vpn/ipv4.txt"
+	ProxyURL   = "https://raw.githubusercontent.com/firehol/blocklist-ipsets/master/firehol_proxies.netset"
+)
+
+// Names of the anonymization networks.
+const (
+	AnonymizerTor   = "tor"
+	AnonymizerVPN   = "vpn"
+	AnonymizerProxy = "proxy"
+)
+
+// Names of the anonymizer database sources.
+const (
+	SourceTor   = "anonymizer-tor"
+	SourceVPN   = "anonymizer-vpn"
+	SourceProxy = "anonymizer-proxy"
+)
+
+// anonymizerNames are the names of the anonymization networks, in the order
+// of their bits in Anonymizers.
+var anonymizerNames = []string{
+	AnonymizerTor,
+	AnonymizerVPN,
+	AnonymizerProxy,
+}
+
+// Anonymizers is a set of anonymization networks. It's a bit mask rather than
+// a single name, like Resolution.Monitor, since the published lists overlap:
+// a Tor exit node is often listed as a proxy too.
+type Anonymizers uint8
+
+// anonymizer returns the set containing only the given anonymization network.
+// It's empty if the network is unknown.
+func anonymizer(name string) Anonymizers {
+	if i := slices.Index(anonymizerNames, name); i >= 0 {
+		return 1 << i
+	}
+	return 0
+}
+
+// Names returns the names of the anonymization networks of the set, or nil if
+// the set is empty.
+func (a Anonymizers) Names() []string {
+	var names []string
+	for i, name := range anonymizerNames {
+		if a&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// String returns the comma-separated names of the anonymization networks of
+// the set.
+func (a Anonymizers) String() string {
+	return strings.Join(a.Names(), ",")
+}
+
+// anonymizerSources returns the sources of the IP addresses of the given
+// anonymization networks. Unknown networks are ignored.
+func anonymizerSources(anonymizers []string) []source {
+	var sources []source
+	for _, name := range anonymizers {
+		decode := decodePrefixList(Resolution{Anonymizers: anonymizer(name)})
+		switch name {
+		case AnonymizerTor:
+			sources = append(sources, source{SourceTor, TorExitURL, decode})
+		case AnonymizerVPN:
+			sources = append(sources, source{SourceVPN, VPNURL, decode})
+		case AnonymizerProxy:
+			sources = append(sources, source{SourceProxy, ProxyURL, decode})
+		}
+	}
+	return sources
+}
@@ -0,0 +1,71 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveAnonymizers(t *testing.T) {
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.255.255.255,US\n",
+			ipres.TorExitURL:     "1.2.3.4\n2001:db8::1\n",
+			ipres.VPNURL:         "1.2.4.0/24\n",
+			ipres.ProxyURL:       "# Proxies\n1.2.3.0/24\n",
+		}},
+		ipres.Options{
+			DisableASN: true,
+			Anonymizers: []string{
+				ipres.AnonymizerTor,
+				ipres.AnonymizerVPN,
+				ipres.AnonymizerProxy,
+			},
+		},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		want    []string
+		country string
+	}{
+		{"1.2.3.4", []string{"tor", "proxy"}, "US"},
+		{"1.2.3.5", []string{"proxy"}, "US"},
+		{"1.2.4.1", []string{"vpn"}, "US"},
+		{"2001:db8::1", []string{"tor"}, ""},
+		{"1.2.5.1", nil, "US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			res := r.Resolve(netip.MustParseAddr(tt.ip))
+			if got := res.Anonymizers.Names(); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if res.CountryCode != tt.country {
+				t.Errorf("got %q, want %q", res.CountryCode, tt.country)
+			}
+		})
+	}
+}
+
+func TestAnonymizerSourceURLs(t *testing.T) {
+	r := ipres.NewResolver(&mockFetcher{}, ipres.Options{
+		DisableASN:  true,
+		Anonymizers: []string{ipres.AnonymizerTor},
+	})
+
+	urls := r.SourceURLs()
+	want := []string{ipres.TorExitURL}
+	if got := urls[ipres.SourceTor]; !slices.Equal(got, want) {
+		t.Errorf("got Tor URLs %v, want %v", got, want)
+	}
+	if _, ok := urls[ipres.SourceVPN]; ok {
+		t.Errorf("got VPN source, want none")
+	}
+}
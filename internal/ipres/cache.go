@@ -0,0 +1,422 @@
+package ipres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/health"
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// Extensions of the files stored in the cache directory. Only files with these
+// extensions are managed (and evicted) by the cache. The cached files have a
+// neutral extension, since they can be in any format, e.g., CSV, MMDB or
+// gzip-compressed.
+const (
+	cacheFileExt = ".data"
+	metaFileExt  = ".meta"
+)
+
+// ErrCacheCorrupted is returned when a cached file doesn't match its
+// metadata.
+var ErrCacheCorrupted = errors.New("corrupted cache entry")
+
+// cacheMetadata is stored in a sidecar file next to each cached file and is
+// used to verify the integrity of the cached file when it's read.
+type cacheMetadata struct {
+	URL          string    `json:"url"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	SHA256       string    `json:"sha256"`
+}
+
+// CacheOptions contains the options of a cached fetcher.
+type CacheOptions struct {
+	// Directory where the cached files are stored.
+	Directory string
+
+	// MaxAge is the maximum age of a cached file. Files that have not been
+	// refreshed for longer than this are removed. Zero disables age-based
+	// eviction.
+	MaxAge time.Duration
+
+	// MaxSize is the maximum total size, in bytes, of the cached files. When
+	// exceeded, the oldest files are removed first. Zero disables the limit.
+	MaxSize int64
+}
+
+// CachedFetcher is a fetcher that stores a copy of each fetched database on
+// disk. If fetching a database fails, the cached copy is returned instead.
+//
+// Accesses to the cache directory are protected by an advisory lock so that
+// the directory can be shared by multiple processes. The downloads aren't,
+// so that several databases can be fetched concurrently.
+type CachedFetcher struct {
+	fetcher Fetcher
+	options CacheOptions
+	mu      sync.Mutex
+}
+
+// NewCachedFetcher creates a new cached fetcher that wraps the given fetcher.
+func NewCachedFetcher(fetcher Fetcher, options CacheOptions) *CachedFetcher {
+	return &CachedFetcher{
+		fetcher: fetcher,
+		options: options,
+	}
+}
+
+// cachePath returns the path of the cache file of the given URL.
+func (c *CachedFetcher) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:8]) + cacheFileExt
+	return filepath.Join(c.options.Directory, name)
+}
+
+// metaPath returns the path of the metadata file of the given cache file.
+func metaPath(path string) string {
+	return path + metaFileExt
+}
+
+// checksum returns the hex-encoded SHA-256 checksum of the given data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fetch fetches the given URL using the wrapped fetcher and stores the result
+// in the cache. If the wrapped fetcher fails, the cached copy is returned.
+//
+// If the wrapped fetcher is a ConditionalFetcher, the validators of the cached
+// copy are sent with the request, and the cached copy is returned without
+// downloading the content again if it hasn't changed.
+func (c *CachedFetcher) Fetch(url string) (*Resource, error) {
+	return c.FetchIfModified(url, Validators{})
+}
+
+// FetchIfModified is like Fetch, but returns ErrNotModified if the fetched
+// content, or the cached copy if it's still current, has the given
+// validators. A cached copy returned because the URL couldn't be fetched is
+// always returned, so that its use can be reported.
+func (c *CachedFetcher) FetchIfModified(
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	return c.FetchContext(context.Background(), url, validators)
+}
+
+// FetchContext is like FetchIfModified, but the fetch by the wrapped fetcher
+// is aborted when the given context is done, in which case the cached copy
+// is returned.
+func (c *CachedFetcher) FetchContext(
+	ctx context.Context,
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	// The cache files are replaced atomically, so their validators can be
+	// read without the lock.
+	resource, err := fetchContext(ctx, c.fetcher, url, c.validators(url))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resource, err = c.completeLocked(url, resource, err)
+	if err != nil {
+		return nil, err
+	}
+	if validators != (Validators{}) && !resource.Cached &&
+		resource.Validators() == validators {
+		return nil, ErrNotModified
+	}
+	return resource, nil
+}
+
+// completeLocked completes the fetch of the given URL, conditional to the
+// validators of its cached copy, that returned the given resource and error:
+// the cached copy is returned if the URL is unchanged or can't be fetched,
+// and the fetched content is cached otherwise. The caller must hold the lock.
+func (c *CachedFetcher) completeLocked(
+	url string,
+	resource *Resource,
+	err error,
+) (*Resource, error) {
+	if errors.Is(err, ErrNotModified) {
+		cached, cacheErr := c.loadLocked(url)
+		if cacheErr == nil {
+			if err := c.touchLocked(url); err != nil {
+				log.WithError(err).Warnf(
+					"Cannot refresh cached %s", RedactURL(url),
+				)
+			}
+			return cached, nil
+		}
+		// The cached copy has been removed or corrupted in the meantime.
+		resource, err = c.fetcher.Fetch(url)
+	}
+	if err != nil {
+		cached, cacheErr := c.loadLocked(url)
+		if cacheErr != nil {
+			return nil, err
+		}
+		log.WithError(err).Warnf(
+			"Cannot fetch %s, using cached copy", RedactURL(url),
+		)
+		cached.Cached = true
+		return cached, nil
+	}
+
+	if err := c.storeLocked(url, resource); err != nil {
+		log.WithError(err).Warnf("Cannot cache %s", RedactURL(url))
+		health.Report(health.ComponentCache, health.StateDegraded, err)
+	} else {
+		health.Report(health.ComponentCache, health.StateOK, nil)
+	}
+	return resource, nil
+}
+
+// loadLocked reads the cached copy of the given URL while holding a shared
+// lock on the cache directory. Corrupted entries are discarded.
+func (c *CachedFetcher) loadLocked(url string) (*Resource, error) {
+	lock, err := lockDir(c.options.Directory, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock() // #nosec G104
+
+	resource, err := c.load(url)
+	if !errors.Is(err, ErrCacheCorrupted) {
+		return resource, err
+	}
+
+	// Upgrade to an exclusive lock so that no other process is reading the
+	// entry while it's removed. The upgrade isn't atomic: another process
+	// may have stored a new copy meanwhile, so the entry is read again and
+	// only removed if it's still corrupted.
+	if err := flock(lock.file, true); err != nil {
+		return nil, err
+	}
+	resource, err = c.load(url)
+	if errors.Is(err, ErrCacheCorrupted) {
+		log.WithError(err).Warnf("Discarding cached %s", RedactURL(url))
+		c.remove(c.cachePath(url)) // #nosec G104
+	}
+	return resource, err
+}
+
+// validators returns the validators of the cached copy of the given URL, or
+// empty validators if there is none.
+func (c *CachedFetcher) validators(url string) Validators {
+	raw, err := os.ReadFile(metaPath(c.cachePath(url))) // #nosec G304
+	if err != nil {
+		return Validators{}
+	}
+
+	var meta cacheMetadata
+	if err := json.Unmarshal(raw, &meta); err != nil || meta.URL != url {
+		return Validators{}
+	}
+	return Validators{ETag: meta.ETag, LastModified: meta.LastModified}
+}
+
+// touchLocked marks the cached copy of the given URL as refreshed, so that
+// it isn't removed by age while it's still current, while holding an
+// exclusive lock on the cache directory.
+func (c *CachedFetcher) touchLocked(url string) error {
+	lock, err := lockDir(c.options.Directory, true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock() // #nosec G104
+
+	now := time.Now()
+	return os.Chtimes(c.cachePath(url), now, now)
+}
+
+// storeLocked stores the given resource in the cache and prunes the cache
+// while holding an exclusive lock on the cache directory.
+func (c *CachedFetcher) storeLocked(url string, resource *Resource) error {
+	if err := os.MkdirAll(c.options.Directory, 0o750); err != nil {
+		return err
+	}
+
+	lock, err := lockDir(c.options.Directory, true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock() // #nosec G104
+
+	if err := c.store(url, resource); err != nil {
+		return err
+	}
+	if err := c.prune(); err != nil {
+		log.WithError(err).Warn("Cannot prune cache")
+	}
+	return nil
+}
+
+// load reads the cached copy of the given URL and verifies it against its
+// metadata.
+func (c *CachedFetcher) load(url string) (*Resource, error) {
+	path := c.cachePath(url)
+
+	raw, err := os.ReadFile(metaPath(path)) // #nosec G304
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			if _, statErr := os.Stat(path); statErr == nil {
+				return nil, ErrCacheCorrupted
+			}
+		}
+		return nil, err
+	}
+
+	var meta cacheMetadata
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, ErrCacheCorrupted
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.URL != url || meta.SHA256 != checksum(data) {
+		return nil, ErrCacheCorrupted
+	}
+	return &Resource{
+		Data:         data,
+		ETag:         meta.ETag,
+		LastModified: meta.LastModified,
+	}, nil
+}
+
+// store writes the given resource and its metadata to the cache.
+func (c *CachedFetcher) store(url string, resource *Resource) error {
+	meta, err := json.Marshal(cacheMetadata{
+		URL:          url,
+		FetchedAt:    time.Now().UTC(),
+		ETag:         resource.ETag,
+		LastModified: resource.LastModified,
+		SHA256:       checksum(resource.Data),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The data is written before its metadata so that a crash in between is
+	// detected as a checksum mismatch on the next read.
+	path := c.cachePath(url)
+	if err := c.writeFile(path, resource.Data); err != nil {
+		return err
+	}
+	return c.writeFile(metaPath(path), meta)
+}
+
+// writeFile atomically writes data to the given path by writing to a
+// temporary file first and then renaming it.
+func (c *CachedFetcher) writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(c.options.Directory, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // #nosec G104
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() // #nosec G104
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// remove removes the given cache file and its metadata.
+func (c *CachedFetcher) remove(path string) error {
+	var errs []error
+	for _, p := range []string{path, metaPath(path)} {
+		err := os.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cacheEntry describes a file stored in the cache directory.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries returns the files stored in the cache directory, newest first.
+func (c *CachedFetcher) entries() ([]cacheEntry, error) {
+	files, err := os.ReadDir(c.options.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cacheEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), cacheFileExt) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cacheEntry{
+			path:    filepath.Join(c.options.Directory, file.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	slices.SortFunc(entries, func(a, b cacheEntry) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	return entries, nil
+}
+
+// prune removes the cached files that are older than the maximum age, which
+// also covers orphaned files left behind when a database URL changes, and
+// then removes the oldest files until the cache fits within the maximum size.
+func (c *CachedFetcher) prune() error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	var (
+		now   = time.Now()
+		total int64
+	)
+	for _, entry := range entries {
+		expired := c.options.MaxAge > 0 &&
+			now.Sub(entry.modTime) > c.options.MaxAge
+		oversize := c.options.MaxSize > 0 &&
+			total+entry.size > c.options.MaxSize
+
+		if expired || oversize {
+			if err := c.remove(entry.path); err != nil {
+				return err
+			}
+			continue
+		}
+		total += entry.size
+	}
+
+	metrics.CacheSize.Set(float64(total))
+	return nil
+}
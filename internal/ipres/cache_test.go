@@ -0,0 +1,312 @@
+package ipres_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+var errFetch = errors.New("fetch error")
+
+type mockFetcher struct {
+	data map[string]string
+	err  error
+}
+
+func (m *mockFetcher) Fetch(url string) (*ipres.Resource, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &ipres.Resource{Data: []byte(m.data[url]), ETag: "etag"}, nil
+}
+
+func cacheFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCachedFetcherFallback(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		inner   = &mockFetcher{data: map[string]string{"a": "content"}}
+		fetcher = ipres.NewCachedFetcher(
+			inner,
+			ipres.CacheOptions{Directory: dir},
+		)
+	)
+
+	if _, err := fetcher.Fetch("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inner.err = errFetch
+	resource, err := fetcher.Fetch("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resource.Data) != "content" {
+		t.Errorf("got %q, want %q", resource.Data, "content")
+	}
+	if resource.ETag != "etag" {
+		t.Errorf("got %q, want %q", resource.ETag, "etag")
+	}
+
+	if _, err := fetcher.Fetch("b"); !errors.Is(err, errFetch) {
+		t.Errorf("got %v, want %v", err, errFetch)
+	}
+}
+
+// conditionalFetcher is a mock conditional fetcher that counts the downloads
+// and doesn't download the content again for its entity tag.
+type conditionalFetcher struct {
+	mockFetcher
+	downloads int
+}
+
+func (m *conditionalFetcher) FetchIfModified(
+	url string,
+	validators ipres.Validators,
+) (*ipres.Resource, error) {
+	if validators.ETag == "etag" {
+		return nil, ipres.ErrNotModified
+	}
+	m.downloads++
+	return m.Fetch(url)
+}
+
+func TestCachedFetcherIfModified(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		inner = &conditionalFetcher{
+			mockFetcher: mockFetcher{data: map[string]string{"a": "content"}},
+		}
+		fetcher = ipres.NewCachedFetcher(
+			inner,
+			ipres.CacheOptions{Directory: dir},
+		)
+	)
+
+	// The cached copy is returned without being downloaded again.
+	for range 2 {
+		resource, err := fetcher.Fetch("a")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(resource.Data) != "content" {
+			t.Errorf("got %q, want %q", resource.Data, "content")
+		}
+	}
+	if inner.downloads != 1 {
+		t.Errorf("got %d downloads, want 1", inner.downloads)
+	}
+
+	_, err := fetcher.FetchIfModified("a", ipres.Validators{ETag: "etag"})
+	if !errors.Is(err, ipres.ErrNotModified) {
+		t.Errorf("got %v, want %v", err, ipres.ErrNotModified)
+	}
+
+	// Without cached copy, the content is downloaded again.
+	for _, file := range cacheFiles(t, dir) {
+		for _, path := range []string{file, file + ".meta"} {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := fetcher.Fetch("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if inner.downloads != 2 {
+		t.Errorf("got %d downloads, want 2", inner.downloads)
+	}
+}
+
+func TestCachedFetcherMaxAge(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		inner   = &mockFetcher{data: map[string]string{"a": "1", "b": "2"}}
+		fetcher = ipres.NewCachedFetcher(inner, ipres.CacheOptions{
+			Directory: dir,
+			MaxAge:    time.Hour,
+		})
+	)
+
+	if _, err := fetcher.Fetch("a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Age the cached file of "a" so that it's evicted on the next fetch.
+	old := time.Now().Add(-2 * time.Hour)
+	for _, file := range cacheFiles(t, dir) {
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := fetcher.Fetch("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files := cacheFiles(t, dir); len(files) != 1 {
+		t.Errorf("got %d cached files, want 1", len(files))
+	}
+
+	inner.err = errFetch
+	if _, err := fetcher.Fetch("a"); !errors.Is(err, errFetch) {
+		t.Errorf("got %v, want %v", err, errFetch)
+	}
+}
+
+func TestCachedFetcherMaxSize(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		inner = &mockFetcher{data: map[string]string{
+			"a": "1234",
+			"b": "5678",
+			"c": "90",
+		}}
+		fetcher = ipres.NewCachedFetcher(inner, ipres.CacheOptions{
+			Directory: dir,
+			MaxSize:   6,
+		})
+	)
+
+	for i, url := range []string{"a", "b", "c"} {
+		if _, err := fetcher.Fetch(url); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Make sure that files have distinct modification times.
+		mtime := time.Now().Add(time.Duration(i-3) * time.Minute)
+		for _, file := range cacheFiles(t, dir) {
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.ModTime().After(mtime) {
+				if err := os.Chtimes(file, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	// "a" is the oldest file, so it must have been evicted to keep the cache
+	// under 6 bytes.
+	inner.err = errFetch
+	if _, err := fetcher.Fetch("a"); !errors.Is(err, errFetch) {
+		t.Errorf("got %v, want %v", err, errFetch)
+	}
+	for _, url := range []string{"b", "c"} {
+		if _, err := fetcher.Fetch(url); err != nil {
+			t.Errorf("unexpected error for %q: %v", url, err)
+		}
+	}
+}
+
+func TestCachedFetcherCorrupted(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, file string)
+	}{
+		{
+			"modified content",
+			func(t *testing.T, file string) {
+				err := os.WriteFile(file, []byte("edited"), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			"missing metadata",
+			func(t *testing.T, file string) {
+				if err := os.Remove(file + ".meta"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			"invalid metadata",
+			func(t *testing.T, file string) {
+				err := os.WriteFile(file+".meta", []byte("{"), 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				dir     = t.TempDir()
+				inner   = &mockFetcher{data: map[string]string{"a": "content"}}
+				fetcher = ipres.NewCachedFetcher(
+					inner,
+					ipres.CacheOptions{Directory: dir},
+				)
+			)
+
+			if _, err := fetcher.Fetch("a"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, file := range cacheFiles(t, dir) {
+				tt.tamper(t, file)
+			}
+
+			inner.err = errFetch
+			if _, err := fetcher.Fetch("a"); !errors.Is(err, errFetch) {
+				t.Errorf("got %v, want %v", err, errFetch)
+			}
+			if files := cacheFiles(t, dir); len(files) != 0 {
+				t.Errorf("got %d cached files, want 0", len(files))
+			}
+		})
+	}
+}
+
+func TestCachedFetcherSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	// Several fetchers sharing the same directory write different contents
+	// for the same URL concurrently. Every write must leave a consistent
+	// entry behind.
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inner := &mockFetcher{
+				data: map[string]string{"a": strings.Repeat("x", i*1000)},
+			}
+			fetcher := ipres.NewCachedFetcher(
+				inner,
+				ipres.CacheOptions{Directory: dir},
+			)
+			for range 10 {
+				if _, err := fetcher.Fetch("a"); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	fetcher := ipres.NewCachedFetcher(
+		&mockFetcher{err: errFetch},
+		ipres.CacheOptions{Directory: dir},
+	)
+	if _, err := fetcher.Fetch("a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package ipres
+
+import (
+	"encoding/json"
+	"iter"
+)
+
+// URLs of the IP ranges published by CDN and anycast providers.
+const (
+	CloudflareIPv4URL = "https://www.cloudflare.com/ips-v4"
+	CloudflareIPv6URL = "https://www.cloudflare.com/ips-v6"
+	GoogleURL         = "https://www.gstatic.com/ipranges/goog.json"
+	AWSURL            = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+)
+
+// Names of the CDN and anycast providers.
+const (
+	CDNCloudflare = "cloudflare"
+	CDNGoogle     = "google"
+	CDNCloudFront = "cloudfront"
+)
+
+// Names of the CDN database sources.
+const (
+	SourceCloudflareIPv4 = "cdn-cloudflare-ipv4"
+	SourceCloudflareIPv6 = "cdn-cloudflare-ipv6"
+	SourceGoogle         = "cdn-google"
+	SourceCloudFront     = "cdn-cloudfront"
+)
+
+// awsCloudFrontService is the name of the CloudFront service in the AWS IP
+// ranges file.
+const awsCloudFrontService = "CLOUDFRONT"
+
+// cdnSources returns the sources of the CDN and anycast IP ranges.
+func cdnSources() []source {
+	var (
+		cloudflare = decodePrefixList(Resolution{CDN: CDNCloudflare})
+		google     = decodeGoogleRanges(Resolution{CDN: CDNGoogle})
+		cloudfront = decodeAWSRanges(Resolution{CDN: CDNCloudFront})
+	)
+	return []source{
+		{SourceCloudflareIPv4, CloudflareIPv4URL, cloudflare},
+		{SourceCloudflareIPv6, CloudflareIPv6URL, cloudflare},
+		{SourceGoogle, GoogleURL, google},
+		{SourceCloudFront, AWSURL, cloudfront},
+	}
+}
+
+// googleRanges is the format of the IP ranges published by Google.
+type googleRanges struct {
+	Prefixes []struct {
+		IPv4Prefix string `json:"ipv4Prefix"`
+		IPv6Prefix string `json:"ipv6Prefix"`
+	} `json:"prefixes"`
+}
+
+// decodeGoogleRanges returns a decoder for the IP ranges published by Google.
+// The records have the given resolution.
+func decodeGoogleRanges(resolution Resolution) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			var ranges googleRanges
+			if err := json.Unmarshal(data, &ranges); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			prefixes := make([]string, 0, len(ranges.Prefixes))
+			for _, prefix := range ranges.Prefixes {
+				prefixes = append(
+					prefixes,
+					prefix.IPv4Prefix+prefix.IPv6Prefix,
+				)
+			}
+			decodePrefixes(yield, prefixes, resolution)
+		}
+	}
+}
+
+// awsRanges is the format of the IP ranges published by AWS.
+type awsRanges struct {
+	Prefixes []struct {
+		IPPrefix string `json:"ip_prefix"`
+		Service  string `json:"service"`
+	} `json:"prefixes"`
+	IPv6Prefixes []struct {
+		IPv6Prefix string `json:"ipv6_prefix"`
+		Service    string `json:"service"`
+	} `json:"ipv6_prefixes"`
+}
+
+// decodeAWSRanges returns a decoder for the CloudFront IP ranges published by
+// AWS. The records have the given resolution.
+func decodeAWSRanges(resolution Resolution) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			var ranges awsRanges
+			if err := json.Unmarshal(data, &ranges); err != nil {
+				yield(nil, err)
+				return
+			}
+
+			var prefixes []string
+			for _, prefix := range ranges.Prefixes {
+				if prefix.Service == awsCloudFrontService {
+					prefixes = append(prefixes, prefix.IPPrefix)
+				}
+			}
+			for _, prefix := range ranges.IPv6Prefixes {
+				if prefix.Service == awsCloudFrontService {
+					prefixes = append(prefixes, prefix.IPv6Prefix)
+				}
+			}
+			decodePrefixes(yield, prefixes, resolution)
+		}
+	}
+}
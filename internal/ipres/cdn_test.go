@@ -0,0 +1,105 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveCDN(t *testing.T) {
+	dbs := map[string]string{
+		ipres.CountryIPv4URL:    "1.0.0.0,1.255.255.255,US\n",
+		ipres.CountryIPv6URL:    "",
+		ipres.ASNIPv4URL:        "1.0.0.0,1.255.255.255,1,Test1\n",
+		ipres.ASNIPv6URL:        "",
+		ipres.CloudflareIPv4URL: "# comment\n1.1.1.0/24\n\n",
+		ipres.CloudflareIPv6URL: "2606:4700::/32\n",
+		ipres.GoogleURL: `{"prefixes": [
+			{"ipv4Prefix": "8.8.8.0/24"},
+			{"ipv6Prefix": "2001:4860::/32"}
+		]}`,
+		ipres.AWSURL: `{
+			"prefixes": [
+				{"ip_prefix": "3.0.0.0/24", "service": "CLOUDFRONT"},
+				{"ip_prefix": "4.0.0.0/24", "service": "EC2"}
+			],
+			"ipv6_prefixes": [
+				{"ipv6_prefix": "2600:9000::/28", "service": "CLOUDFRONT"}
+			]
+		}`,
+	}
+
+	tests := []struct {
+		ip      string
+		cdn     string
+		country string
+	}{
+		{"1.1.1.0", ipres.CDNCloudflare, "US"},
+		{"1.1.1.255", ipres.CDNCloudflare, "US"},
+		{"1.1.2.0", "", "US"},
+		{"2606:4700:ffff::1", ipres.CDNCloudflare, ""},
+		{"8.8.8.8", ipres.CDNGoogle, ""},
+		{"2001:4860::8888", ipres.CDNGoogle, ""},
+		{"3.0.0.1", ipres.CDNCloudFront, ""},
+		{"4.0.0.1", "", ""},
+		{"2600:900f::1", ipres.CDNCloudFront, ""},
+	}
+
+	withRT(newRTWithDBs(dbs), func() {
+		r := ipres.NewResolver(
+			ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+			ipres.Options{CDN: true},
+		)
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.ip, func(t *testing.T) {
+				res := r.Resolve(netip.MustParseAddr(tt.ip))
+				if res.CDN != tt.cdn {
+					t.Errorf("got %q, want %q", res.CDN, tt.cdn)
+				}
+				if res.IsCDN() != (tt.cdn != "") {
+					t.Errorf("got IsCDN()=%v", res.IsCDN())
+				}
+				if res.CountryCode != tt.country {
+					t.Errorf("got %q, want %q", res.CountryCode, tt.country)
+				}
+			})
+		}
+	})
+}
+
+func TestUpdateInvalidCDN(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		data string
+	}{
+		{"invalid prefix", ipres.CloudflareIPv4URL, "invalid\n"},
+		{"invalid Google JSON", ipres.GoogleURL, "{"},
+		{"invalid AWS JSON", ipres.AWSURL, "["},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbs := map[string]string{
+				ipres.GoogleURL: `{"prefixes": []}`,
+				ipres.AWSURL:    `{"prefixes": []}`,
+			}
+			dbs[tt.url] = tt.data
+
+			withRT(newRTWithDBs(dbs), func() {
+				r := ipres.NewResolver(
+					ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+					ipres.Options{CDN: true},
+				)
+				if err := r.Update(); err == nil {
+					t.Error("expected an error, got nil")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package ipres
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// URLs of the CSV city databases, and of their mirrors.
+const (
+	CityIPv4URL       = "https://cdn.jsdelivr.net/npm/@ip-location-db/geolite2-city/geolite2-city-ipv4.csv.gz"
+	CityIPv6URL       = "https://cdn.jsdelivr.net/npm/@ip-location-db/geolite2-city/geolite2-city-ipv6.csv.gz"
+	CityIPv4MirrorURL = "https://unpkg.com/@ip-location-db/geolite2-city/geolite2-city-ipv4.csv.gz"
+	CityIPv6MirrorURL = "https://unpkg.com/@ip-location-db/geolite2-city/geolite2-city-ipv6.csv.gz"
+)
+
+// Names of the city database sources.
+const (
+	SourceCityIPv4 = "city-ipv4"
+	SourceCityIPv6 = "city-ipv6"
+	SourceCityMMDB = "city-mmdb"
+)
+
+// cityRecordLength is the length of the CSV city records: the range, the
+// country code, two levels of regions, the city, the postal code, the
+// coordinates and the time zone.
+const cityRecordLength = 10
+
+// Indexes of the fields of the CSV city records used by the resolver.
+const (
+	cityRegionField = 3
+	cityNameField   = 5
+)
+
+// parseCityRecord parses a city database record. Only the first level of
+// regions is kept.
+func parseCityRecord(record []string) (*DBRecord, error) {
+	if len(record) != cityRecordLength {
+		return nil, ErrRecordLength
+	}
+
+	entry, err := parseCountryRecord(record[:countryRecordLength])
+	if err != nil {
+		return nil, err
+	}
+	entry.Resolution.Region = record[cityRegionField]
+	entry.Resolution.City = record[cityNameField]
+	return entry, nil
+}
+
+// mmdbName contains the English name of an MMDB location.
+type mmdbName struct {
+	Names struct {
+		English string `maxminddb:"en"`
+	} `maxminddb:"names"`
+}
+
+// mmdbCityRecord is the part of a GeoLite2 City record used by the resolver.
+type mmdbCityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Subdivisions []mmdbName `maxminddb:"subdivisions"`
+	City         mmdbName   `maxminddb:"city"`
+}
+
+// parseMMDBCity decodes a GeoLite2 City network. The registered country is
+// used when the country is unknown, and the region is the first, least
+// specific, subdivision.
+func parseMMDBCity(
+	networks *maxminddb.Networks,
+) (*net.IPNet, Resolution, error) {
+	var record mmdbCityRecord
+	network, err := networks.Network(&record)
+	if err != nil {
+		return nil, Resolution{}, err
+	}
+
+	resolution := Resolution{
+		CountryCode: record.Country.ISOCode,
+		City:        record.City.Names.English,
+	}
+	if resolution.CountryCode == "" {
+		resolution.CountryCode = record.RegisteredCountry.ISOCode
+	}
+	if len(record.Subdivisions) > 0 {
+		resolution.Region = record.Subdivisions[0].Names.English
+	}
+	return network, resolution, nil
+}
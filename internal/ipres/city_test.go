@@ -0,0 +1,104 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveCity(t *testing.T) {
+	fetcher := &mockFetcher{data: map[string]string{
+		ipres.CityIPv4URL: "1.0.0.0,1.0.0.255,US,California,,Los Angeles," +
+			"90001,34.05,-118.24,America/Los_Angeles\n" +
+			"1.0.1.0,1.0.1.255,FR,,,,,48.85,2.35,Europe/Paris\n" +
+			"1.0.2.0,1.0.2.255,DE\n",
+		ipres.CityIPv6URL: "1::,1::ff,DE,Bavaria,Upper Bavaria,Munich," +
+			"80331,48.13,11.57,Europe/Berlin\n",
+	}}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN:        true,
+		City:              true,
+		MaxInvalidRecords: 1,
+		Overrides: []ipres.Override{{
+			Prefix:     netip.MustParsePrefix("1.0.0.128/25"),
+			Resolution: ipres.Resolution{CountryCode: "MX"},
+		}},
+	})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		region  string
+		city    string
+	}{
+		{"1.0.0.1", "US", "California", "Los Angeles"},
+		{"1.0.0.129", "MX", "", ""},
+		{"1.0.1.1", "FR", "", ""},
+		{"1.0.2.1", "", "", ""},
+		{"1::1", "DE", "Bavaria", "Munich"},
+	}
+	for _, tt := range tests {
+		res := r.Resolve(netip.MustParseAddr(tt.ip))
+		if res.CountryCode != tt.country || res.Region != tt.region ||
+			res.City != tt.city {
+			t.Errorf("%s: got %q, %q and %q, want %q, %q and %q", tt.ip,
+				res.CountryCode, res.Region, res.City,
+				tt.country, tt.region, tt.city)
+		}
+	}
+
+	// The city databases replace the country databases.
+	statuses := r.SourceStatuses()
+	if len(statuses) != 2 || statuses[0].Name != ipres.SourceCityIPv4 ||
+		statuses[1].Name != ipres.SourceCityIPv6 {
+		t.Fatalf("got sources %+v, want the city databases", statuses)
+	}
+	if got := statuses[0].Records; got != 2 {
+		t.Errorf("got %d records, want 2", got)
+	}
+}
+
+func TestResolveMMDBCity(t *testing.T) {
+	city := newMMDB(t, map[string]mmdbValue{
+		"1.0.0.0/8": mmdbMap(
+			"country", mmdbMap("iso_code", mmdbString("US")),
+			"subdivisions", mmdbArray(
+				mmdbMap("names", mmdbMap("en", mmdbString("California"))),
+			),
+			"city", mmdbMap("names", mmdbMap("en", mmdbString("Fresno"))),
+		),
+		"2.0.0.0/8": mmdbMap(
+			"registered_country", mmdbMap("iso_code", mmdbString("FR")),
+		),
+	})
+
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{"city": string(city)}},
+		ipres.Options{
+			Format:     ipres.FormatMMDB,
+			CountryURL: "country",
+			City:       true,
+			CityURL:    "city",
+		},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	res := r.Resolve(netip.MustParseAddr("1.0.0.1"))
+	if res.CountryCode != "US" || res.Region != "California" ||
+		res.City != "Fresno" {
+		t.Errorf("got %q, %q and %q, want US, California and Fresno",
+			res.CountryCode, res.Region, res.City)
+	}
+	res = r.Resolve(netip.MustParseAddr("2.0.0.1"))
+	if res.CountryCode != "FR" || res.Region != "" || res.City != "" {
+		t.Errorf("got %q, %q and %q, want FR only",
+			res.CountryCode, res.Region, res.City)
+	}
+}
@@ -0,0 +1,36 @@
+package ipres
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// gzipMagic is the header of gzip-compressed files.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// maxExtractedSize is the maximum size of a decompressed database file.
+const maxExtractedSize int64 = 1 << 30
+
+// gunzip decompresses the given gzip-compressed data, e.g., a `.csv.gz`
+// database. Data that isn't gzip-compressed is returned as is.
+func gunzip(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err = io.ReadAll(io.LimitReader(reader, maxExtractedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxExtractedSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,145 @@
+package ipres
+
+import (
+	"iter"
+	"sync"
+	"time"
+)
+
+// Default concurrency of the updates and timeout of the fetches.
+const (
+	DefaultConcurrency  = 4
+	DefaultFetchTimeout = 10 * time.Minute
+)
+
+// parallel calls the given function with each index from 0 to n, with at most
+// Concurrency calls at a time, and waits for all the calls to return.
+func (r *Resolver) parallel(n int, f func(i int)) {
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, r.options.Concurrency)
+	)
+	for i := range n {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			f(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// fetchMissing fetches concurrently the given sources that have no resource,
+// and adds their resources and versions to the given maps. It returns the
+// errors of the sources that couldn't be fetched, in order.
+func (r *Resolver) fetchMissing(
+	sources []source,
+	resources map[string]*Resource,
+	versions map[string]sourceVersion,
+) []error {
+	var (
+		fetched  = make([]*Resource, len(sources))
+		fetchedV = make([]sourceVersion, len(sources))
+		errs     = make([]error, len(sources))
+	)
+	r.parallel(len(sources), func(i int) {
+		if _, ok := resources[sources[i].name]; ok {
+			return
+		}
+		fetched[i], fetchedV[i], errs[i] = r.fetch(sources[i])
+	})
+
+	var failed []error
+	for i, src := range sources {
+		switch {
+		case errs[i] != nil:
+			failed = append(failed, errs[i])
+		case fetched[i] != nil:
+			resources[src.name] = fetched[i]
+			versions[src.name] = fetchedV[i]
+		}
+	}
+	return failed
+}
+
+// decodedRecord is a record yielded by a decoder.
+type decodedRecord struct {
+	entry *DBRecord
+	err   error
+}
+
+// parseAhead returns the given sources with decoders that yield the records
+// of their resources, by name, decoded in the background. The sources are
+// decoded in order, and at most Concurrency of them are decoded or waiting to
+// be loaded at a time, so that the memory holding their records is bounded.
+// Each source must be loaded in order, see Resolver.build.
+//
+// The decoding of a source stops after more than MaxInvalidRecords invalid
+// records, since the update of the source then fails anyway.
+func (r *Resolver) parseAhead(
+	sources []source,
+	resources map[string]*Resource,
+) []source {
+	var (
+		parsed = make([]source, len(sources))
+		slots  = make(chan struct{}, r.options.Concurrency)
+	)
+	type pending struct {
+		decode  DecodeFn
+		data    []byte
+		records []decodedRecord
+		done    chan struct{}
+	}
+	var queue []*pending
+	for i, src := range sources {
+		parsed[i] = src
+		resource, ok := resources[src.name]
+		if !ok {
+			continue
+		}
+
+		p := &pending{
+			decode: src.decode,
+			data:   resource.Data,
+			done:   make(chan struct{}),
+		}
+		queue = append(queue, p)
+		var release sync.Once
+		parsed[i].decode = func([]byte) iter.Seq2[*DBRecord, error] {
+			return func(yield func(*DBRecord, error) bool) {
+				defer release.Do(func() {
+					p.records = nil
+					<-slots
+				})
+				<-p.done
+				for _, record := range p.records {
+					if !yield(record.entry, record.err) {
+						return
+					}
+				}
+			}
+		}
+	}
+
+	go func() {
+		for _, p := range queue {
+			slots <- struct{}{}
+			go func() {
+				defer close(p.done)
+				invalid := 0
+				for entry, err := range p.decode(p.data) {
+					p.records = append(p.records, decodedRecord{entry, err})
+					if err != nil {
+						invalid++
+						if invalid > r.options.MaxInvalidRecords {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return parsed
+}
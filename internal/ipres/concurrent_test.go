@@ -0,0 +1,121 @@
+package ipres_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// waitingFetcher is a mock fetcher whose fetch of the IPv4 database waits for
+// the fetch of the IPv6 database to start.
+type waitingFetcher struct {
+	mockFetcher
+	started chan struct{}
+}
+
+func (m *waitingFetcher) Fetch(url string) (*ipres.Resource, error) {
+	switch url {
+	case ipres.CountryIPv4URL:
+		select {
+		case <-m.started:
+		case <-time.After(time.Second):
+			return nil, errFetch
+		}
+	case ipres.CountryIPv6URL:
+		close(m.started)
+	}
+	return m.mockFetcher.Fetch(url)
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	fetcher := &waitingFetcher{
+		mockFetcher: mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "1::,1::ff,DE\n",
+		}},
+		started: make(chan struct{}),
+	}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{DisableASN: true})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{"1.0.0.1": "FR", "1::1": "DE"} {
+		got := r.Resolve(netip.MustParseAddr(ip)).CountryCode
+		if got != want {
+			t.Errorf("got country %q for %s, want %q", got, ip, want)
+		}
+	}
+}
+
+// stalledFetcher is a mock context fetcher whose fetch of the stalled URL
+// only returns when its context is done.
+type stalledFetcher struct {
+	mockFetcher
+	stalled string
+}
+
+func (m *stalledFetcher) FetchIfModified(
+	url string,
+	validators ipres.Validators,
+) (*ipres.Resource, error) {
+	return m.FetchContext(context.Background(), url, validators)
+}
+
+func (m *stalledFetcher) FetchContext(
+	ctx context.Context,
+	url string,
+	_ ipres.Validators,
+) (*ipres.Resource, error) {
+	if url == m.stalled {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return m.Fetch(url)
+}
+
+func TestUpdateFetchTimeout(t *testing.T) {
+	const (
+		stalled = "https://stalled.example.com/country-ipv4.csv"
+		mirror  = "https://mirror.example.com/country-ipv4.csv"
+	)
+	fetcher := &stalledFetcher{
+		mockFetcher: mockFetcher{data: map[string]string{
+			mirror:               "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "",
+		}},
+		stalled: stalled,
+	}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN:   true,
+		FetchTimeout: 10 * time.Millisecond,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {stalled, mirror},
+		},
+	})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+	if got != "FR" {
+		t.Errorf("got country %q, want FR", got)
+	}
+
+	// The update fails if all the URLs stall.
+	fetcher.stalled = mirror
+	r = ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN:   true,
+		FetchTimeout: 10 * time.Millisecond,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {mirror},
+		},
+	})
+	if err := r.Update(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
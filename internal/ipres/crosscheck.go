@@ -0,0 +1,74 @@
+package ipres
+
+import (
+	"maps"
+	"net/netip"
+)
+
+// minCountryShare is the minimum share of an ASN's ranges that must be
+// located in the resolved country of an IP for both databases to agree.
+const minCountryShare = 0.1
+
+// asnCountries maps each ASN to the number of its ranges located in each
+// country.
+type asnCountries map[uint32]map[string]int
+
+// add accounts for a range of the given ASN located in the given country.
+// Ranges without a known country are ignored.
+func (a asnCountries) add(asn uint32, country string) {
+	if country == "" {
+		return
+	}
+	if a[asn] == nil {
+		a[asn] = make(map[string]int)
+	}
+	a[asn][country]++
+}
+
+// CrossCheck contains the result of cross-checking the country and ASN
+// databases for an IP address.
+type CrossCheck struct {
+	// Number of ranges of the IP's ASN located in each country.
+	ASNCountries map[string]int
+
+	// Country where most of the ranges of the IP's ASN are located.
+	ASNCountry string
+
+	// Whether the resolved country of the IP disagrees with the countries of
+	// its ASN, i.e. the resolved country hosts less than 10% of the ASN's
+	// ranges. This is typical of anycast and CDN ranges.
+	Mismatch bool
+}
+
+// CrossCheck compares the resolved country of the given IP with the countries
+// where the ranges of its ASN are located. It returns false if cross-checking
+// is disabled or if the IP has no known ASN or country.
+func (r *Resolver) CrossCheck(ip netip.Addr) (*CrossCheck, bool) {
+	db := r.db.Load()
+	if db == nil || db.countries == nil {
+		return nil, false
+	}
+
+	resolution := db.query(ip)
+	countries := db.countries[resolution.ASN]
+	if resolution.CountryCode == "" || len(countries) == 0 {
+		return nil, false
+	}
+
+	var (
+		total int
+		check = CrossCheck{ASNCountries: maps.Clone(countries)}
+	)
+	for country, count := range countries {
+		total += count
+		if count > countries[check.ASNCountry] ||
+			(count == countries[check.ASNCountry] &&
+				country < check.ASNCountry) {
+			check.ASNCountry = country
+		}
+	}
+
+	share := float64(countries[resolution.CountryCode]) / float64(total)
+	check.Mismatch = share < minCountryShare
+	return &check, true
+}
@@ -0,0 +1,84 @@
+package ipres_test
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestCrossCheck(t *testing.T) {
+	// AS1 has 10 ranges in the US and a single one in FR, AS2 has a single
+	// range in DE.
+	var country, asn strings.Builder
+	for i := range 10 {
+		fmt.Fprintf(&country, "1.0.%d.0,1.0.%d.255,US\n", i, i)
+		fmt.Fprintf(&asn, "1.0.%d.0,1.0.%d.255,1,Test1\n", i, i)
+	}
+	country.WriteString("2.0.0.0,2.0.0.255,FR\n3.0.0.0,3.0.0.255,DE\n")
+	asn.WriteString("2.0.0.0,2.0.0.255,1,Test1\n3.0.0.0,3.0.0.255,2,Test2\n")
+
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: country.String(),
+		ipres.CountryIPv6URL: "",
+		ipres.ASNIPv4URL:     asn.String(),
+		ipres.ASNIPv6URL:     "",
+	}
+
+	tests := []struct {
+		ip         string
+		ok         bool
+		asnCountry string
+		mismatch   bool
+	}{
+		{"1.0.5.1", true, "US", false},
+		{"2.0.0.1", true, "US", true},
+		{"3.0.0.1", true, "DE", false},
+		{"4.0.0.1", false, "", false},
+	}
+
+	withRT(newRTWithDBs(dbs), func() {
+		for _, index := range []string{ipres.IndexTree, ipres.IndexFlat} {
+			r := ipres.NewResolver(
+				ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+				ipres.Options{CrossCheck: true, Index: index},
+			)
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range tests {
+				t.Run(index+" "+tt.ip, func(t *testing.T) {
+					check, ok := r.CrossCheck(netip.MustParseAddr(tt.ip))
+					if ok != tt.ok {
+						t.Fatalf("got ok=%v, want %v", ok, tt.ok)
+					}
+					if !ok {
+						return
+					}
+					if got := check.ASNCountry; got != tt.asnCountry {
+						t.Errorf("got %q, want %q", got, tt.asnCountry)
+					}
+					if got := check.Mismatch; got != tt.mismatch {
+						t.Errorf("got mismatch=%v, want %v",
+							got, tt.mismatch)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestCrossCheckDisabled(t *testing.T) {
+	withRT(newDummyRT(), func() {
+		r := newResolver()
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := r.CrossCheck(netip.MustParseAddr("1.0.1.1")); ok {
+			t.Error("expected cross-check to be disabled")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package ipres
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Thresholds used to decide whether the number of records of a key changed
+// significantly between two updates.
+const (
+	significantChangeRatio = 0.1
+	significantChangeMin   = 10
+)
+
+// SourceStats contains statistics about the records loaded from a database
+// source.
+type SourceStats struct {
+	Records int            // Total number of records
+	Invalid int            // Number of skipped invalid records
+	Keys    map[string]int // Number of records per country code or ASN
+
+	// Memory is the estimated memory used by the records in bytes: the nodes
+	// of the resolution tree and the strings that weren't already loaded by
+	// a previous source of the same update.
+	Memory int64
+}
+
+// newSourceStats creates empty source statistics.
+func newSourceStats() *SourceStats {
+	return &SourceStats{Keys: make(map[string]int)}
+}
+
+// add accounts for the given record in the statistics.
+func (s *SourceStats) add(record *DBRecord) {
+	s.Records++
+	s.Keys[recordKey(record)]++
+}
+
+// recordKey returns the key used to group the records in the statistics: the
+// country code for country databases, the ASN for ASN databases and the
+// provider, service or network for CDN, monitoring and anonymizer databases.
+func recordKey(record *DBRecord) string {
+	if record.Resolution.CDN != "" {
+		return record.Resolution.CDN
+	}
+	if record.Resolution.Monitor != "" {
+		return record.Resolution.Monitor
+	}
+	if record.Resolution.Anonymizers != 0 {
+		return record.Resolution.Anonymizers.String()
+	}
+	if record.Resolution.CountryCode != "" {
+		return record.Resolution.CountryCode
+	}
+	return "AS" + strconv.FormatUint(uint64(record.Resolution.ASN), 10)
+}
+
+// KeyChange describes a country code or ASN whose number of records changed
+// significantly between two updates.
+type KeyChange struct {
+	Key    string
+	Before int
+	After  int
+}
+
+// SourceDiff summarizes the changes of a database source between two updates.
+//
+// Added and Removed are computed per key (country code or ASN): a key that
+// gained N records counts as N added records, and a key that lost N records
+// counts as N removed records.
+type SourceDiff struct {
+	Source  string
+	Before  int // Number of records before the update
+	After   int // Number of records after the update
+	Added   int
+	Removed int
+	Changed []KeyChange // Keys whose number of records changed significantly
+	Initial bool        // Whether this is the first update of the source
+}
+
+// isSignificant returns whether the change from `before` to `after` records
+// is significant.
+func isSignificant(before, after int) bool {
+	delta := after - before
+	if delta < 0 {
+		delta = -delta
+	}
+	return delta >= significantChangeMin &&
+		float64(delta) >= significantChangeRatio*float64(before)
+}
+
+// Shrunk returns whether the source lost a significant number of records.
+func (d *SourceDiff) Shrunk() bool {
+	return !d.Initial && d.After < d.Before && isSignificant(d.Before, d.After)
+}
+
+// diffStats computes the differences between the previous and current
+// statistics of a source. If there are no previous statistics, the diff is
+// marked as initial.
+func diffStats(source string, prev, curr *SourceStats) SourceDiff {
+	diff := SourceDiff{
+		Source:  source,
+		After:   curr.Records,
+		Initial: prev == nil,
+	}
+	if prev == nil {
+		diff.Added = curr.Records
+		return diff
+	}
+	diff.Before = prev.Records
+
+	keys := make(map[string]struct{}, len(curr.Keys))
+	for key := range prev.Keys {
+		keys[key] = struct{}{}
+	}
+	for key := range curr.Keys {
+		keys[key] = struct{}{}
+	}
+
+	for key := range keys {
+		before, after := prev.Keys[key], curr.Keys[key]
+		if after > before {
+			diff.Added += after - before
+		} else {
+			diff.Removed += before - after
+		}
+		if isSignificant(before, after) {
+			diff.Changed = append(diff.Changed, KeyChange{
+				Key:    key,
+				Before: before,
+				After:  after,
+			})
+		}
+	}
+
+	slices.SortFunc(diff.Changed, func(a, b KeyChange) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return diff
+}
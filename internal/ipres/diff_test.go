@@ -0,0 +1,88 @@
+package ipres_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// countryRecords returns n IPv4 country records for the given country code.
+func countryRecords(country string, offset, n int) string {
+	var b strings.Builder
+	for i := range n {
+		n := offset + i
+		fmt.Fprintf(&b, "%d.0.0.0,%d.0.0.255,%s\n", n, n, country)
+	}
+	return b.String()
+}
+
+func findDiff(diffs []ipres.SourceDiff, source string) *ipres.SourceDiff {
+	for _, diff := range diffs {
+		if diff.Source == source {
+			return &diff
+		}
+	}
+	return nil
+}
+
+func TestResolverDiff(t *testing.T) {
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: countryRecords("US", 1, 50) +
+			countryRecords("FR", 100, 20),
+		ipres.CountryIPv6URL: "1:0::,1:1::,US\n",
+		ipres.ASNIPv4URL:     "1.0.0.0,1.0.2.2,1,Test1\n",
+		ipres.ASNIPv6URL:     "1:0::,1:1::,3,Test3\n",
+	}
+
+	r := newResolver()
+	withRT(newRTWithDBs(dbs), func() {
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	diff := findDiff(r.Diff(), ipres.SourceCountryIPv4)
+	if diff == nil || !diff.Initial || diff.Added != 70 {
+		t.Fatalf("unexpected initial diff: %+v", diff)
+	}
+
+	// The US loses most of its records and a new country appears.
+	dbs[ipres.CountryIPv4URL] = countryRecords("US", 1, 10) +
+		countryRecords("FR", 100, 20) +
+		countryRecords("DE", 200, 5)
+	withRT(newRTWithDBs(dbs), func() {
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	diff = findDiff(r.Diff(), ipres.SourceCountryIPv4)
+	if diff == nil {
+		t.Fatal("missing diff")
+	}
+	if diff.Initial {
+		t.Error("diff should not be initial")
+	}
+	if diff.Before != 70 || diff.After != 35 {
+		t.Errorf("got %d->%d records, want 70->35", diff.Before, diff.After)
+	}
+	if diff.Added != 5 || diff.Removed != 40 {
+		t.Errorf("got +%d/-%d, want +5/-40", diff.Added, diff.Removed)
+	}
+	if !diff.Shrunk() {
+		t.Error("diff should be reported as shrunk")
+	}
+
+	// DE gained only 5 records, which is below the significance threshold.
+	want := []ipres.KeyChange{{Key: "US", Before: 50, After: 10}}
+	if len(diff.Changed) != 1 || diff.Changed[0] != want[0] {
+		t.Errorf("got %+v, want %+v", diff.Changed, want)
+	}
+
+	diff = findDiff(r.Diff(), ipres.SourceASNIPv4)
+	if diff == nil || diff.Added != 0 || diff.Removed != 0 || diff.Shrunk() {
+		t.Errorf("unexpected ASN diff: %+v", diff)
+	}
+}
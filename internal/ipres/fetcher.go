@@ -0,0 +1,332 @@
+package ipres
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxDownloadSize is the maximum size of a downloaded database when no
+// limit is configured.
+const DefaultMaxDownloadSize int64 = 256 << 20
+
+// DefaultUserAgent is the User-Agent header of the downloads when no other is
+// configured.
+const DefaultUserAgent = "geoblock"
+
+// ErrTooLarge is returned when a database exceeds the maximum download size.
+var ErrTooLarge = errors.New("database too large")
+
+// ErrUnsupportedEncoding is returned when a database is downloaded with a
+// content encoding that the fetcher didn't request.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// acceptEncoding is the Accept-Encoding header of the downloads: the
+// databases are mostly text and compress well.
+const acceptEncoding = "zstd, gzip"
+
+// ErrNotModified is returned by the conditional fetches of the databases that
+// haven't changed.
+var ErrNotModified = errors.New("database not modified")
+
+// Resource is a database fetched by a fetcher.
+type Resource struct {
+	Data         []byte // Raw content of the database
+	ETag         string // Entity tag returned by the server, if any
+	LastModified string // Last modification time returned by the server
+
+	// Cached is true if the content is a cached copy, returned because the
+	// URL couldn't be fetched. See CachedFetcher.
+	Cached bool
+}
+
+// Validators returns the validators identifying the version of the resource.
+func (r *Resource) Validators() Validators {
+	return Validators{ETag: r.ETag, LastModified: r.LastModified}
+}
+
+// Validators identify a version of a database, see ConditionalFetcher.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Fetcher retrieves the raw content of a database from its URL.
+type Fetcher interface {
+	Fetch(url string) (*Resource, error)
+}
+
+// ConditionalFetcher is a fetcher that can skip the download of the databases
+// that haven't changed.
+type ConditionalFetcher interface {
+	Fetcher
+
+	// FetchIfModified fetches the given URL unless its content still has the
+	// given validators, in which case ErrNotModified is returned. Empty
+	// validators always fetch the content.
+	FetchIfModified(url string, validators Validators) (*Resource, error)
+}
+
+// ContextFetcher is a conditional fetcher whose fetches can be canceled, e.g.,
+// when they time out.
+type ContextFetcher interface {
+	ConditionalFetcher
+
+	// FetchContext is like FetchIfModified, but the fetch is aborted when the
+	// given context is done.
+	FetchContext(
+		ctx context.Context,
+		url string,
+		validators Validators,
+	) (*Resource, error)
+}
+
+// fetchIfModified fetches the given URL with the given fetcher, conditionally
+// if the fetcher supports it.
+func fetchIfModified(
+	fetcher Fetcher,
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	if conditional, ok := fetcher.(ConditionalFetcher); ok {
+		return conditional.FetchIfModified(url, validators)
+	}
+	return fetcher.Fetch(url)
+}
+
+// fetchContext is like fetchIfModified, but the fetch is aborted when the
+// given context is done, if the fetcher supports it.
+func fetchContext(
+	ctx context.Context,
+	fetcher Fetcher,
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	if f, ok := fetcher.(ContextFetcher); ok {
+		return f.FetchContext(ctx, url, validators)
+	}
+	return fetchIfModified(fetcher, url, validators)
+}
+
+// HTTPOptions contains the options of an HTTP fetcher.
+type HTTPOptions struct {
+	// MaxSize is the maximum size, in bytes, of a downloaded database. If
+	// zero, DefaultMaxDownloadSize is used.
+	MaxSize int64
+
+	// Proxy is the URL of the proxy through which the databases are
+	// downloaded. If nil, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables.
+	Proxy *neturl.URL
+
+	// RootCAs are the certificate authorities verifying the certificates of
+	// the servers. If nil, the system certificate authorities are used.
+	RootCAs *x509.CertPool
+
+	// UserAgent is the User-Agent header of the downloads. If empty,
+	// DefaultUserAgent is used.
+	UserAgent string
+}
+
+// HTTPFetcher is a fetcher that downloads databases over HTTP(S).
+type HTTPFetcher struct {
+	options HTTPOptions
+	client  *http.Client
+}
+
+// NewHTTPFetcher creates a new HTTP fetcher.
+func NewHTTPFetcher(options HTTPOptions) *HTTPFetcher {
+	if options.MaxSize == 0 {
+		options.MaxSize = DefaultMaxDownloadSize
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = DefaultUserAgent
+	}
+
+	// The default transport, which uses the proxy of the environment, is only
+	// replaced when needed, so that its connections are shared.
+	client := &http.Client{}
+	if options.Proxy != nil || options.RootCAs != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if options.Proxy != nil {
+			transport.Proxy = http.ProxyURL(options.Proxy)
+		}
+		if options.RootCAs != nil {
+			transport.TLSClientConfig = &tls.Config{
+				RootCAs:    options.RootCAs,
+				MinVersion: tls.VersionTLS12,
+			}
+		}
+		client.Transport = transport
+	}
+	return &HTTPFetcher{options: options, client: client}
+}
+
+// fileScheme is the prefix of the URLs of local files.
+const fileScheme = "file://"
+
+// Fetch downloads the content of the given URL. It fails without reading the
+// whole response if the content is larger than the maximum download size.
+//
+// URLs starting with `file://` are read from the local filesystem instead.
+func (f *HTTPFetcher) Fetch(url string) (*Resource, error) {
+	return f.FetchIfModified(url, Validators{})
+}
+
+// FetchIfModified is like Fetch, but sends the given validators in the
+// If-None-Match and If-Modified-Since headers, so that the server doesn't
+// send the content again if it hasn't changed. The local files are compared
+// with their modification time.
+func (f *HTTPFetcher) FetchIfModified(
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	return f.FetchContext(context.Background(), url, validators)
+}
+
+// FetchContext is like FetchIfModified, but the download is aborted when the
+// given context is done.
+func (f *HTTPFetcher) FetchContext(
+	ctx context.Context,
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	if path, ok := strings.CutPrefix(url, fileScheme); ok {
+		return f.readFile(path, validators)
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, url, nil,
+	)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	if validators.ETag != "" {
+		request.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		request.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	request.Header.Set("Accept-Encoding", acceptEncoding)
+	request.Header.Set("User-Agent", f.options.UserAgent)
+
+	resp, err := f.client.Do(request)
+	if err != nil {
+		return nil, redactError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified &&
+		validators != (Validators{}) {
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.options.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := f.read(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Resource{
+		Data:         data,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// decodeBody returns the body of the given response, decompressed according
+// to its Content-Encoding header. The maximum download size applies to the
+// decompressed body.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	switch encoding := resp.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip":
+		return gzip.NewReader(resp.Body)
+	case "zstd":
+		decoder, err := zstd.NewReader(
+			resp.Body, zstd.WithDecoderConcurrency(1),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)
+	}
+}
+
+// redactError redacts the URL of the given HTTP client error, which may
+// contain a license key.
+func redactError(err error) error {
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
+
+// readFile reads the content of the given local file, unless its
+// modification time is the one of the given validators.
+func (f *HTTPFetcher) readFile(
+	path string,
+	validators Validators,
+) (*Resource, error) {
+	file, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// The precise modification time is used, since a file can be replaced
+	// within the same second as its previous version.
+	modified := info.ModTime().UTC().Format(time.RFC3339Nano)
+	if validators.LastModified == modified {
+		return nil, ErrNotModified
+	}
+
+	data, err := f.read(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Resource{Data: data, LastModified: modified}, nil
+}
+
+// read reads the given reader up to the maximum download size.
+func (f *HTTPFetcher) read(reader io.Reader) ([]byte, error) {
+	// Read one byte more than the limit to detect oversized contents that
+	// don't advertise their length.
+	data, err := io.ReadAll(io.LimitReader(reader, f.options.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.options.MaxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
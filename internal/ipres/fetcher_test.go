@@ -0,0 +1,375 @@
+package ipres_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func newSizedRT(body string, contentLength int64) http.RoundTripper {
+	return &mockRT{
+		respond: func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				ContentLength: contentLength,
+				Body:          io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+}
+
+func TestHTTPFetcherMaxSize(t *testing.T) {
+	tooLarge := ipres.ErrTooLarge
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantErr       error
+	}{
+		{"within limit", strings.Repeat("x", 10), 10, nil},
+		{"unknown length within limit", strings.Repeat("x", 10), -1, nil},
+		{"too large", strings.Repeat("x", 10), 11, tooLarge},
+		{"unknown length too large", strings.Repeat("x", 11), -1, tooLarge},
+	}
+
+	fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{MaxSize: 10})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRT(newSizedRT(tt.body, tt.contentLength), func() {
+				resource, err := fetcher.Fetch("http://example.com/db.csv")
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && string(resource.Data) != tt.body {
+					t.Errorf("got %q, want %q", resource.Data, tt.body)
+				}
+			})
+		})
+	}
+}
+
+// compress compresses the given content with the given content encoding.
+func compress(t *testing.T, encoding, content string) string {
+	t.Helper()
+
+	var (
+		buf    bytes.Buffer
+		writer io.WriteCloser
+	)
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "zstd":
+		encoder, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer = encoder
+	default:
+		return content
+	}
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestHTTPFetcherEncoding(t *testing.T) {
+	tooLarge := ipres.ErrTooLarge
+	tests := []struct {
+		encoding string
+		content  string
+		wantErr  error
+	}{
+		{"", "1.0.0.0,1.0.0.255,FR", nil},
+		{"gzip", "1.0.0.0,1.0.0.255,FR", nil},
+		{"zstd", "1.0.0.0,1.0.0.255,FR", nil},
+		{"gzip", strings.Repeat("x", 64), tooLarge},
+		{"zstd", strings.Repeat("x", 64), tooLarge},
+		{"br", "", ipres.ErrUnsupportedEncoding},
+	}
+
+	// The compressed contents that are too large fit in the limit.
+	fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{MaxSize: 32})
+	for _, tt := range tests {
+		t.Run(tt.encoding+" "+tt.content, func(t *testing.T) {
+			rt := &mockRT{
+				respond: func(req *http.Request) (*http.Response, error) {
+					accept := req.Header.Get("Accept-Encoding")
+					if !strings.Contains(accept, "zstd") {
+						t.Errorf("got Accept-Encoding %q", accept)
+					}
+					body := compress(t, tt.encoding, tt.content)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header: http.Header{
+							"Content-Encoding": {tt.encoding},
+						},
+						Body: io.NopCloser(bytes.NewBufferString(body)),
+					}, nil
+				},
+			}
+			withRT(rt, func() {
+				resource, err := fetcher.Fetch("http://example.com/db.csv")
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && string(resource.Data) != tt.content {
+					t.Errorf("got %q, want %q", resource.Data, tt.content)
+				}
+			})
+		})
+	}
+}
+
+func TestHTTPFetcherStatus(t *testing.T) {
+	rt := &mockRT{
+		respond: func(_ *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+
+	withRT(rt, func() {
+		fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+		if _, err := fetcher.Fetch("http://example.com/db.csv"); err == nil {
+			t.Error("expected an error, got nil")
+		}
+	})
+}
+
+// newETagRT returns a round tripper serving the given content with the given
+// entity tag, and a 304 status code to the requests having this tag.
+func newETagRT(body, etag string) http.RoundTripper {
+	return &mockRT{
+		respond: func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("If-None-Match") == etag {
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Body:       io.NopCloser(bytes.NewBufferString("")),
+				}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header: http.Header{
+					"Etag":          {etag},
+					"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+				},
+				Body: io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+}
+
+func TestHTTPFetcherIfModified(t *testing.T) {
+	withRT(newETagRT("content", `"v1"`), func() {
+		fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+		resource, err := fetcher.Fetch("http://example.com/db.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ipres.Validators{
+			ETag:         `"v1"`,
+			LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+		}
+		if got := resource.Validators(); got != want {
+			t.Errorf("got validators %+v, want %+v", got, want)
+		}
+
+		_, err = fetcher.FetchIfModified("http://example.com/db.csv", want)
+		if !errors.Is(err, ipres.ErrNotModified) {
+			t.Errorf("got %v, want %v", err, ipres.ErrNotModified)
+		}
+
+		resource, err = fetcher.FetchIfModified(
+			"http://example.com/db.csv", ipres.Validators{ETag: `"v0"`},
+		)
+		if err != nil || string(resource.Data) != "content" {
+			t.Errorf("got %v, %v, want the content", resource, err)
+		}
+	})
+}
+
+func TestHTTPFetcherFileIfModified(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.csv")
+	if err := os.WriteFile(path, []byte("content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+	resource, err := fetcher.Fetch("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validators := resource.Validators()
+	if validators.LastModified == "" {
+		t.Fatal("got no modification time")
+	}
+
+	_, err = fetcher.FetchIfModified("file://"+path, validators)
+	if !errors.Is(err, ipres.ErrNotModified) {
+		t.Errorf("got %v, want %v", err, ipres.ErrNotModified)
+	}
+
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fetcher.FetchIfModified("file://"+path, validators)
+	if err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+func TestHTTPFetcherFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.csv")
+	if err := os.WriteFile(path, []byte("content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		url     string
+		wantErr bool
+	}{
+		{"existing file", 10, "file://" + path, false},
+		{"too large", 5, "file://" + path, true},
+		{"missing file", 10, "file://" + path + ".missing", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := ipres.NewHTTPFetcher(
+				ipres.HTTPOptions{MaxSize: tt.maxSize},
+			)
+			resource, err := fetcher.Fetch(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && string(resource.Data) != "content" {
+				t.Errorf("got %q, want %q", resource.Data, "content")
+			}
+		})
+	}
+}
+
+func TestHTTPFetcherRedactsError(t *testing.T) {
+	rt := &mockRT{
+		respond: func(_ *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+
+	withRT(rt, func() {
+		fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+		_, err := fetcher.Fetch(ipres.MaxMindURL("GeoLite2-ASN", "secret"))
+		if err == nil {
+			t.Fatal("expected an error, got nil")
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("got error %q containing the license key", err)
+		}
+	})
+}
+
+func TestHTTPFetcherUserAgent(t *testing.T) {
+	var got []string
+	rt := &mockRT{
+		respond: func(req *http.Request) (*http.Response, error) {
+			got = append(got, req.Header.Get("User-Agent"))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString("")),
+			}, nil
+		},
+	}
+
+	withRT(rt, func() {
+		for _, options := range []ipres.HTTPOptions{
+			{},
+			{UserAgent: "custom/1.0"},
+		} {
+			fetcher := ipres.NewHTTPFetcher(options)
+			_, err := fetcher.Fetch("http://example.com/db.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	want := []string{ipres.DefaultUserAgent, "custom/1.0"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got user agents %v, want %v", got, want)
+	}
+}
+
+func TestHTTPFetcherProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			// The requests sent to a proxy have an absolute URL.
+			fmt.Fprint(writer, request.URL.String())
+		},
+	))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{Proxy: proxyURL})
+	resource, err := fetcher.Fetch("http://example.com/db.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resource.Data); got != "http://example.com/db.csv" {
+		t.Errorf("got %q, want the URL of the database", got)
+	}
+}
+
+func TestHTTPFetcherRootCAs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, _ *http.Request) {
+			fmt.Fprint(writer, "content")
+		},
+	))
+	defer server.Close()
+
+	// The certificate of the test server isn't trusted by the system.
+	fetcher := ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+	if _, err := fetcher.Fetch(server.URL); err == nil {
+		t.Error("got no error with the system certificate authorities")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	fetcher = ipres.NewHTTPFetcher(ipres.HTTPOptions{RootCAs: pool})
+	resource, err := fetcher.Fetch(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(resource.Data); got != "content" {
+		t.Errorf("got %q, want content", got)
+	}
+}
@@ -0,0 +1,98 @@
+package ipres
+
+import (
+	"net/netip"
+	"reflect"
+	"strings"
+
+	"github.com/danroc/geoblock/internal/itree"
+	"github.com/danroc/geoblock/internal/utils/orgname"
+)
+
+// nodeSize is the size, in bytes, of a node of the resolution tree, without
+// the content of the strings of its resolution.
+var nodeSize = int64(
+	reflect.TypeFor[itree.Node[netip.Addr, Resolution]]().Size(),
+)
+
+// flatEntrySize is the size, in bytes, of a range of the flat resolution
+// index, without the content of the strings of its resolution.
+var flatEntrySize = 2*int64(reflect.TypeFor[netip.Addr]().Size()) +
+	int64(reflect.TypeFor[Resolution]().Size())
+
+// InternStats contains statistics about the strings of the records loaded
+// during an update.
+type InternStats struct {
+	Strings      int   // Number of distinct strings
+	Bytes        int64 // Total size of the distinct strings in bytes
+	Deduplicated int   // Number of strings sharing an existing copy
+}
+
+// stringPool interns the strings of the records loaded during an update, so
+// that records with the same country code or organization share the same
+// copy. Pooled strings are cloned, so that they don't keep the raw content of
+// the databases alive. It also caches the normalized names of the
+// organizations, which saves both time and memory.
+type stringPool struct {
+	values map[string]string
+	keys   map[string]string // Organization keys, by organization name
+	stats  InternStats
+}
+
+// newStringPool creates an empty string pool.
+func newStringPool() *stringPool {
+	return &stringPool{
+		values: make(map[string]string),
+		keys:   make(map[string]string),
+	}
+}
+
+// intern returns the pooled copy of the given string and the number of bytes
+// allocated for it, which is zero if the string was already pooled.
+func (p *stringPool) intern(s string) (string, int64) {
+	if s == "" {
+		return "", 0
+	}
+	if pooled, ok := p.values[s]; ok {
+		p.stats.Deduplicated++
+		return pooled, 0
+	}
+
+	pooled := strings.Clone(s)
+	p.values[pooled] = pooled
+	p.stats.Strings++
+	p.stats.Bytes += int64(len(pooled))
+	return pooled, int64(len(pooled))
+}
+
+// internResolution interns the strings of the given resolution and sets its
+// organization key. It returns the number of bytes allocated for the strings
+// that weren't pooled yet.
+func (p *stringPool) internResolution(resolution *Resolution) int64 {
+	var total, size int64
+	for _, field := range []*string{
+		&resolution.CountryCode,
+		&resolution.Region,
+		&resolution.City,
+		&resolution.Organization,
+		&resolution.CDN,
+		&resolution.Monitor,
+		&resolution.Registry,
+	} {
+		*field, size = p.intern(*field)
+		total += size
+	}
+
+	name := resolution.Organization
+	if name == "" {
+		return total
+	}
+	key, ok := p.keys[name]
+	if !ok {
+		key, size = p.intern(orgname.Normalize(name))
+		total += size
+		p.keys[name] = key
+	}
+	resolution.OrganizationKey = key
+	return total
+}
@@ -0,0 +1,48 @@
+package ipres_test
+
+import (
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestMemory(t *testing.T) {
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n" +
+				"2.0.0.0,2.0.0.255,FR\n",
+			ipres.ASNIPv4URL: "1.0.0.0,1.0.0.255,1,Example Inc\n" +
+				"2.0.0.0,2.0.0.255,2,Example Inc\n",
+		}},
+		ipres.Options{},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	memory, intern := r.Memory()
+	if len(memory) != 4 {
+		t.Fatalf("got %d sources, want 4", len(memory))
+	}
+	if memory[ipres.SourceCountryIPv4] <= 0 {
+		t.Errorf("got memory %d for %s, want more than 0",
+			memory[ipres.SourceCountryIPv4], ipres.SourceCountryIPv4)
+	}
+	if memory[ipres.SourceCountryIPv6] != 0 {
+		t.Errorf("got memory %d for %s, want 0",
+			memory[ipres.SourceCountryIPv6], ipres.SourceCountryIPv6)
+	}
+
+	// Both sources have the same number of records, but the ASN records also
+	// have an organization name ("Example Inc") and its key ("example"),
+	// while the country code ("FR") is shared by the country records.
+	diff := memory[ipres.SourceASNIPv4] - memory[ipres.SourceCountryIPv4]
+	if diff != int64(len("Example Inc")+len("example")-len("FR")) {
+		t.Errorf("got memory difference %d, want 16", diff)
+	}
+
+	want := ipres.InternStats{Strings: 3, Bytes: 20, Deduplicated: 2}
+	if intern != want {
+		t.Errorf("got intern stats %+v, want %+v", intern, want)
+	}
+}
@@ -0,0 +1,45 @@
+package ipres
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockFileName is the name of the lock file created in the cache directory.
+const lockFileName = ".lock"
+
+// fileLock is an advisory lock held on the lock file of a cache directory. It
+// coordinates the access to a cache directory shared by multiple processes,
+// e.g. several replicas mounting the same volume.
+type fileLock struct {
+	file *os.File
+}
+
+// lockDir acquires an advisory lock on the given directory. Exclusive locks
+// are used for writes, shared locks for reads. It blocks until the lock is
+// acquired.
+func lockDir(dir string, exclusive bool) (*fileLock, error) {
+	file, err := os.OpenFile( // #nosec G304
+		filepath.Join(dir, lockFileName),
+		os.O_RDWR|os.O_CREATE,
+		0o600,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flock(file, exclusive); err != nil {
+		file.Close() // #nosec G104
+		return nil, err
+	}
+	return &fileLock{file: file}, nil
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() error {
+	if err := funlock(l.file); err != nil {
+		l.file.Close() // #nosec G104
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package ipres
+
+import "os"
+
+// flock is a no-op on platforms without advisory file locks.
+func flock(_ *os.File, _ bool) error {
+	return nil
+}
+
+// funlock is a no-op on platforms without advisory file locks.
+func funlock(_ *os.File) error {
+	return nil
+}
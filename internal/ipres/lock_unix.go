@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package ipres
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock acquires an advisory lock on the given file.
+func flock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how) // #nosec G115
+}
+
+// funlock releases the advisory lock held on the given file.
+func funlock(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN) // #nosec G115
+}
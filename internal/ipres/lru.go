@@ -0,0 +1,102 @@
+package ipres
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// Default size and TTL of the resolution cache.
+const (
+	DefaultResolutionCacheSize = 10000
+	DefaultResolutionCacheTTL  = 10 * time.Minute
+)
+
+// resolutionEntry is a cached resolution of an IP address.
+type resolutionEntry struct {
+	ip         netip.Addr
+	resolution Resolution
+	expires    time.Time
+}
+
+// resolutionCache is a least recently used cache of resolutions, so that the
+// repeated requests of a client don't query the interval tree again. It's
+// safe for concurrent use.
+type resolutionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[netip.Addr]*list.Element
+	order   *list.List // Most recently used entries first
+}
+
+// newResolutionCache creates an empty cache that holds up to size entries
+// for ttl.
+func newResolutionCache(size int, ttl time.Duration) *resolutionCache {
+	return &resolutionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[netip.Addr]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached resolution of the given IP address. It returns false
+// if the address isn't cached or its entry has expired.
+func (c *resolutionCache) get(
+	ip netip.Addr,
+	now time.Time,
+) (Resolution, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[ip]
+	if !ok {
+		metrics.ResolutionCacheLookups.WithLabelValues("miss").Inc()
+		return Resolution{}, false
+	}
+
+	entry := element.Value.(*resolutionEntry)
+	if now.After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, ip)
+		metrics.ResolutionCacheLookups.WithLabelValues("miss").Inc()
+		return Resolution{}, false
+	}
+
+	c.order.MoveToFront(element)
+	metrics.ResolutionCacheLookups.WithLabelValues("hit").Inc()
+	return entry.resolution, true
+}
+
+// add caches the resolution of the given IP address, evicting the least
+// recently used entry if the cache is full.
+func (c *resolutionCache) add(
+	ip netip.Addr,
+	resolution Resolution,
+	now time.Time,
+) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &resolutionEntry{
+		ip:         ip,
+		resolution: resolution,
+		expires:    now.Add(c.ttl),
+	}
+	if element, ok := c.entries[ip]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*resolutionEntry).ip)
+	}
+	c.entries[ip] = c.order.PushFront(entry)
+}
@@ -0,0 +1,36 @@
+package ipres
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestResolutionCache(t *testing.T) {
+	var (
+		cache = newResolutionCache(2, time.Minute)
+		now   = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		ip1   = netip.MustParseAddr("1.0.0.1")
+		ip2   = netip.MustParseAddr("1.0.0.2")
+		ip3   = netip.MustParseAddr("1.0.0.3")
+	)
+
+	cache.add(ip1, Resolution{CountryCode: "FR"}, now)
+	cache.add(ip2, Resolution{CountryCode: "US"}, now)
+	if got, ok := cache.get(ip1, now); !ok || got.CountryCode != "FR" {
+		t.Errorf("get(%s) = %+v, %v, want FR", ip1, got, ok)
+	}
+
+	// ip2 is now the least recently used entry.
+	cache.add(ip3, Resolution{CountryCode: "DE"}, now)
+	if _, ok := cache.get(ip2, now); ok {
+		t.Errorf("get(%s) = true after its eviction", ip2)
+	}
+	if _, ok := cache.get(ip1, now); !ok {
+		t.Errorf("get(%s) = false, want true", ip1)
+	}
+
+	if _, ok := cache.get(ip3, now.Add(2*time.Minute)); ok {
+		t.Errorf("get(%s) = true after its expiration", ip3)
+	}
+}
@@ -0,0 +1,42 @@
+package ipres
+
+import (
+	"net/url"
+)
+
+// MaxMindDownloadURL is the URL from which the MaxMind databases are
+// downloaded with a license key.
+const MaxMindDownloadURL = "https://download.maxmind.com/app/geoip_download"
+
+// Editions of the free MaxMind databases. The paid editions, e.g.,
+// GeoIP2-Country, can be downloaded the same way.
+const (
+	EditionGeoLite2Country = "GeoLite2-Country"
+	EditionGeoLite2City    = "GeoLite2-City"
+	EditionGeoLite2ASN     = "GeoLite2-ASN"
+)
+
+// MaxMindURL returns the URL of the tar.gz archive of the given edition of
+// the MaxMind databases, downloaded with the given license key. The URL
+// contains the license key, so it must be redacted before being logged, see
+// RedactURL.
+func MaxMindURL(edition, licenseKey string) string {
+	query := url.Values{
+		"edition_id":  {edition},
+		"license_key": {licenseKey},
+		"suffix":      {"tar.gz"},
+	}
+	return MaxMindDownloadURL + "?" + query.Encode()
+}
+
+// RedactURL removes the credentials and the query of the given URL, since
+// they may contain license keys.
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	return parsed.String()
+}
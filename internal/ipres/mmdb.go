@@ -0,0 +1,188 @@
+package ipres
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"iter"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Formats of the country and ASN databases.
+const (
+	FormatCSV  = "csv"
+	FormatMMDB = "mmdb"
+)
+
+// Names of the MMDB database sources.
+const (
+	SourceCountryMMDB = "country-mmdb"
+	SourceASNMMDB     = "asn-mmdb"
+)
+
+// ErrNoMMDB is returned when an archive doesn't contain an MMDB file.
+var ErrNoMMDB = errors.New("no MMDB file in archive")
+
+// mmdbCountryRecord is the part of a GeoLite2 Country record used by the
+// resolver.
+type mmdbCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// mmdbASNRecord is a GeoLite2 ASN record.
+type mmdbASNRecord struct {
+	ASN          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// mmdbSources returns the sources of the MMDB databases at the given URLs. If
+// cityURL is set, the city database is loaded instead of the country
+// database. If asnURL is empty, the ASN database isn't loaded.
+func mmdbSources(countryURL, cityURL, asnURL string) []source {
+	sources := []source{
+		{SourceCountryMMDB, countryURL, decodeMMDB(parseMMDBCountry)},
+	}
+	if cityURL != "" {
+		sources[0] = source{
+			SourceCityMMDB, cityURL, decodeMMDB(parseMMDBCity),
+		}
+	}
+	if asnURL != "" {
+		sources = append(sources, source{
+			SourceASNMMDB, asnURL, decodeMMDB(parseMMDBASN),
+		})
+	}
+	return sources
+}
+
+// mmdbParserFn decodes the current network of an MMDB database and its
+// resolution. Networks with an empty resolution are skipped.
+type mmdbParserFn func(*maxminddb.Networks) (*net.IPNet, Resolution, error)
+
+// parseMMDBCountry decodes a GeoLite2 Country network. The registered country
+// is used when the country is unknown.
+func parseMMDBCountry(
+	networks *maxminddb.Networks,
+) (*net.IPNet, Resolution, error) {
+	var record mmdbCountryRecord
+	network, err := networks.Network(&record)
+	if err != nil {
+		return nil, Resolution{}, err
+	}
+
+	country := record.Country.ISOCode
+	if country == "" {
+		country = record.RegisteredCountry.ISOCode
+	}
+	return network, Resolution{CountryCode: country}, nil
+}
+
+// parseMMDBASN decodes a GeoLite2 ASN network.
+func parseMMDBASN(
+	networks *maxminddb.Networks,
+) (*net.IPNet, Resolution, error) {
+	var record mmdbASNRecord
+	network, err := networks.Network(&record)
+	if err != nil {
+		return nil, Resolution{}, err
+	}
+
+	return network, Resolution{
+		ASN:          record.ASN,
+		Organization: record.Organization,
+	}, nil
+}
+
+// decodeMMDB returns a decoder for MMDB databases that uses the given parser
+// to decode the resolution of each network. Gzip-compressed files and the
+// tar.gz archives distributed by MaxMind are also accepted.
+func decodeMMDB(parser mmdbParserFn) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			data, err := extractMMDB(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			reader, err := maxminddb.FromBytes(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			defer reader.Close()
+
+			networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+			for networks.Next() {
+				network, resolution, err := parser(networks)
+				if err != nil {
+					if !yield(nil, err) {
+						return
+					}
+					continue
+				}
+				if resolution == (Resolution{}) {
+					continue
+				}
+
+				addr, _ := netip.AddrFromSlice(network.IP)
+				bits, _ := network.Mask.Size()
+				prefix := netip.PrefixFrom(addr.Unmap(), bits)
+				start, end := prefixRange(prefix)
+				if !yield(&DBRecord{
+					StartIP:    start,
+					EndIP:      end,
+					Resolution: resolution,
+				}, nil) {
+					return
+				}
+			}
+			if err := networks.Err(); err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+}
+
+// extractMMDB returns the MMDB file contained in the given data. The data can
+// be an MMDB file, a gzip-compressed MMDB file or a tar.gz archive containing
+// an MMDB file.
+func extractMMDB(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+
+	data, err := gunzip(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// A tar archive has the "ustar" magic at offset 257.
+	if len(data) < 262 || string(data[257:262]) != "ustar" {
+		return data, nil
+	}
+
+	archive := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoMMDB
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(header.Name, "."+FormatMMDB) {
+			return io.ReadAll(archive)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package ipres_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// mmdbValue encodes a value in the MaxMind DB data format.
+type mmdbValue []byte
+
+func mmdbString(s string) mmdbValue {
+	// Sizes from 29 to 284 are stored in an extra byte.
+	if len(s) >= 29 {
+		return append(mmdbValue{2<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append(mmdbValue{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint16(v uint16) mmdbValue {
+	return binary.BigEndian.AppendUint16(mmdbValue{5<<5 | 2}, v)
+}
+
+func mmdbUint32(v uint32) mmdbValue {
+	return binary.BigEndian.AppendUint32(mmdbValue{6<<5 | 4}, v)
+}
+
+func mmdbUint64(v uint64) mmdbValue {
+	return binary.BigEndian.AppendUint64(mmdbValue{8, 9 - 7}, v)
+}
+
+func mmdbArray(values ...mmdbValue) mmdbValue {
+	encoded := mmdbValue{byte(len(values)), 11 - 7}
+	for _, value := range values {
+		encoded = append(encoded, value...)
+	}
+	return encoded
+}
+
+// mmdbMap encodes a map whose keys and values alternate in kv.
+func mmdbMap(kv ...any) mmdbValue {
+	encoded := mmdbValue{7<<5 | byte(len(kv)/2)}
+	for i := 0; i < len(kv); i += 2 {
+		encoded = append(encoded, mmdbString(kv[i].(string))...)
+		encoded = append(encoded, kv[i+1].(mmdbValue)...)
+	}
+	return encoded
+}
+
+// mmdbNode is a node of the search tree of a test database. A leaf is the
+// offset of its data plus one; zero means that there's no data.
+type mmdbNode struct {
+	children [2]*mmdbNode
+	leaves   [2]int
+}
+
+// newMMDB builds an IPv4 MaxMind DB database with 24-bit records that maps
+// each prefix to its value.
+func newMMDB(t *testing.T, values map[string]mmdbValue) []byte {
+	t.Helper()
+
+	var (
+		root = &mmdbNode{}
+		data []byte
+	)
+	for prefix, value := range values {
+		parsed := netip.MustParsePrefix(prefix)
+		addr := parsed.Addr().As4()
+
+		node := root
+		for i := range parsed.Bits() {
+			bit := addr[i/8] >> (7 - i%8) & 1
+			if i == parsed.Bits()-1 {
+				node.leaves[bit] = len(data) + 1
+				break
+			}
+			if node.children[bit] == nil {
+				node.children[bit] = &mmdbNode{}
+			}
+			node = node.children[bit]
+		}
+		data = append(data, value...)
+	}
+
+	// Number the nodes in breadth-first order.
+	nodes := []*mmdbNode{root}
+	for i := 0; i < len(nodes); i++ {
+		for _, child := range nodes[i].children {
+			if child != nil {
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	numbers := make(map[*mmdbNode]int, len(nodes))
+	for i, node := range nodes {
+		numbers[node] = i
+	}
+
+	var db []byte
+	count := len(nodes)
+	for _, node := range nodes {
+		for bit := range 2 {
+			record := count
+			if child := node.children[bit]; child != nil {
+				record = numbers[child]
+			} else if leaf := node.leaves[bit]; leaf != 0 {
+				record = count + 16 + leaf - 1
+			}
+			db = append(db, byte(record>>16), byte(record>>8), byte(record))
+		}
+	}
+	db = append(db, make([]byte, 16)...)
+	db = append(db, data...)
+
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, mmdbMap(
+		"binary_format_major_version", mmdbUint16(2),
+		"binary_format_minor_version", mmdbUint16(0),
+		"build_epoch", mmdbUint64(0),
+		"database_type", mmdbString("Test"),
+		"description", mmdbMap(),
+		"ip_version", mmdbUint16(4),
+		"languages", mmdbArray(),
+		"node_count", mmdbUint32(uint32(count)),
+		"record_size", mmdbUint16(24),
+	)...)
+	return db
+}
+
+// tarGz returns a tar.gz archive containing the given file.
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(content))}
+	if err := archive.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResolveMMDB(t *testing.T) {
+	country := newMMDB(t, map[string]mmdbValue{
+		"1.0.0.0/8": mmdbMap(
+			"country", mmdbMap("iso_code", mmdbString("FR")),
+		),
+		"2.0.0.0/16": mmdbMap(
+			"registered_country", mmdbMap("iso_code", mmdbString("US")),
+		),
+		"3.0.0.0/8": mmdbMap(),
+	})
+	asn := newMMDB(t, map[string]mmdbValue{
+		"1.0.0.0/16": mmdbMap(
+			"autonomous_system_number", mmdbUint32(1),
+			"autonomous_system_organization", mmdbString("Test1"),
+		),
+	})
+
+	tests := []struct {
+		ip      string
+		country string
+		asn     uint32
+		org     string
+	}{
+		{"1.0.0.1", "FR", 1, "Test1"},
+		{"1.1.0.1", "FR", ipres.AS0, ""},
+		{"2.0.255.255", "US", ipres.AS0, ""},
+		{"2.1.0.0", "", ipres.AS0, ""},
+		{"3.0.0.1", "", ipres.AS0, ""},
+	}
+
+	archives := map[string][]byte{
+		"mmdb":   country,
+		"tar.gz": tarGz(t, "GeoLite2-Country/GeoLite2-Country.mmdb", country),
+	}
+	for name, archive := range archives {
+		t.Run(name, func(t *testing.T) {
+			r := ipres.NewResolver(
+				&mockFetcher{data: map[string]string{
+					"country": string(archive),
+					"asn":     string(asn),
+				}},
+				ipres.Options{
+					Format:     ipres.FormatMMDB,
+					CountryURL: "country",
+					ASNURL:     "asn",
+				},
+			)
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tt := range tests {
+				res := r.Resolve(netip.MustParseAddr(tt.ip))
+				if res.CountryCode != tt.country {
+					t.Errorf("%s: got %q, want %q",
+						tt.ip, res.CountryCode, tt.country)
+				}
+				if res.ASN != tt.asn {
+					t.Errorf("%s: got %d, want %d", tt.ip, res.ASN, tt.asn)
+				}
+				if res.Organization != tt.org {
+					t.Errorf("%s: got %q, want %q",
+						tt.ip, res.Organization, tt.org)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateInvalidMMDB(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not an MMDB file", []byte("invalid")},
+		{"archive without MMDB file", tarGz(t, "README.txt", []byte("x"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ipres.NewResolver(
+				&mockFetcher{data: map[string]string{
+					"country": string(tt.data),
+				}},
+				ipres.Options{Format: ipres.FormatMMDB, CountryURL: "country"},
+			)
+			if err := r.Update(); err == nil {
+				t.Error("expected an error, got nil")
+			}
+		})
+	}
+}
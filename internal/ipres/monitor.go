@@ -0,0 +1,49 @@
+package ipres
+
+// URLs of the IP addresses published by uptime monitoring services.
+const (
+	UptimeRobotURL = "https://uptimerobot.com/inc/files/ips/IPv4andIPv6.txt"
+	PingdomIPv4URL = "https://my.pingdom.com/probes/ipv4"
+	PingdomIPv6URL = "https://my.pingdom.com/probes/ipv6"
+	StatusCakeURL  = "https://app.statuscake.com/Workfloor/Locations.php?format=txt"
+)
+
+// Names of the uptime monitoring services.
+const (
+	MonitorUptimeRobot = "uptimerobot"
+	MonitorPingdom     = "pingdom"
+	MonitorStatusCake  = "statuscake"
+)
+
+// Names of the monitoring database sources.
+const (
+	SourceUptimeRobot = "monitor-uptimerobot"
+	SourcePingdomIPv4 = "monitor-pingdom-ipv4"
+	SourcePingdomIPv6 = "monitor-pingdom-ipv6"
+	SourceStatusCake  = "monitor-statuscake"
+)
+
+// monitorSources returns the sources of the IP addresses of the given uptime
+// monitoring services. Unknown services are ignored.
+func monitorSources(monitors []string) []source {
+	var sources []source
+	for _, monitor := range monitors {
+		decode := decodePrefixList(Resolution{Monitor: monitor})
+		switch monitor {
+		case MonitorUptimeRobot:
+			sources = append(sources, source{
+				SourceUptimeRobot, UptimeRobotURL, decode,
+			})
+		case MonitorPingdom:
+			sources = append(sources,
+				source{SourcePingdomIPv4, PingdomIPv4URL, decode},
+				source{SourcePingdomIPv6, PingdomIPv6URL, decode},
+			)
+		case MonitorStatusCake:
+			sources = append(sources, source{
+				SourceStatusCake, StatusCakeURL, decode,
+			})
+		}
+	}
+	return sources
+}
@@ -0,0 +1,59 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveMonitor(t *testing.T) {
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.255.255.255,US\n",
+		ipres.CountryIPv6URL: "",
+		ipres.ASNIPv4URL:     "",
+		ipres.ASNIPv6URL:     "",
+		ipres.UptimeRobotURL: "1.2.3.4\r\n2001:db8::1\r\n",
+		ipres.PingdomIPv4URL: "1.2.3.5\n",
+		ipres.PingdomIPv6URL: "2001:db8::2\n",
+	}
+
+	tests := []struct {
+		ip      string
+		monitor string
+		country string
+	}{
+		{"1.2.3.4", ipres.MonitorUptimeRobot, "US"},
+		{"2001:db8::1", ipres.MonitorUptimeRobot, ""},
+		{"1.2.3.5", ipres.MonitorPingdom, "US"},
+		{"2001:db8::2", ipres.MonitorPingdom, ""},
+		{"1.2.3.6", "", "US"},
+	}
+
+	withRT(newRTWithDBs(dbs), func() {
+		r := ipres.NewResolver(
+			ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+			ipres.Options{
+				Monitors: []string{
+					ipres.MonitorUptimeRobot,
+					ipres.MonitorPingdom,
+				},
+			},
+		)
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.ip, func(t *testing.T) {
+				res := r.Resolve(netip.MustParseAddr(tt.ip))
+				if res.Monitor != tt.monitor {
+					t.Errorf("got %q, want %q", res.Monitor, tt.monitor)
+				}
+				if res.CountryCode != tt.country {
+					t.Errorf("got %q, want %q", res.CountryCode, tt.country)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveOrganizationKey(t *testing.T) {
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{
+			ipres.ASNIPv4URL: "1.0.0.0,1.0.0.255,1,\"Example, Inc.\"\n" +
+				"2.0.0.0,2.0.0.255,2,EXAMPLE Inc\n" +
+				"3.0.0.0,3.0.0.255,3,\n",
+		}},
+		ipres.Options{
+			Overrides: []ipres.Override{
+				{
+					Prefix: netip.MustParsePrefix("1.0.0.128/25"),
+					Resolution: ipres.Resolution{
+						Organization: "Other Networks GmbH",
+					},
+				},
+			},
+		},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		org  string
+		want string
+	}{
+		{"1.0.0.1", "Example, Inc.", "example"},
+		{"2.0.0.1", "EXAMPLE Inc", "example"},
+		{"1.0.0.129", "Other Networks GmbH", "other networks"},
+		{"3.0.0.1", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			res := r.Resolve(netip.MustParseAddr(tt.ip))
+			if res.Organization != tt.org {
+				t.Errorf("got %q, want %q", res.Organization, tt.org)
+			}
+			if res.OrganizationKey != tt.want {
+				t.Errorf("got %q, want %q", res.OrganizationKey, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package ipres
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+)
+
+// Override replaces the resolution of the addresses of a network. Only the
+// non-zero fields of the resolution are replaced.
+type Override struct {
+	Prefix     netip.Prefix
+	Resolution Resolution
+}
+
+// sortOverrides returns a copy of the given overrides sorted from the least
+// to the most specific network, so that the most specific override is applied
+// last. The organization keys of the copy are set.
+func sortOverrides(overrides []Override) []Override {
+	sorted := slices.Clone(overrides)
+	pool := newStringPool()
+	for i := range sorted {
+		pool.internResolution(&sorted[i].Resolution)
+	}
+	slices.SortStableFunc(sorted, func(a, b Override) int {
+		return cmp.Compare(a.Prefix.Bits(), b.Prefix.Bits())
+	})
+	return sorted
+}
+
+// SetOverrides replaces the overrides of the resolver, e.g., after a reload
+// of the configuration. The cached resolutions are dropped, so that the new
+// overrides apply to the next requests.
+func (r *Resolver) SetOverrides(overrides []Override) {
+	sorted := sortOverrides(overrides)
+
+	r.storeMu.Lock()
+	defer r.storeMu.Unlock()
+
+	r.overrides = sorted
+	db := *r.db.Load()
+	db.overrides = sorted
+	db.cache = newResolutionCache(
+		r.options.ResolutionCacheSize, r.options.ResolutionCacheTTL,
+	)
+	r.db.Store(&db)
+}
+
+// applyOverrides applies the overrides of the database whose network
+// contains the given IP to the given resolution.
+func (db *database) applyOverrides(
+	ip netip.Addr,
+	resolution Resolution,
+) Resolution {
+	resolutions := []Resolution{resolution}
+	for _, override := range db.overrides {
+		if override.Prefix.Contains(ip) {
+			resolutions = append(resolutions, override.Resolution)
+		}
+	}
+	if len(resolutions) == 1 {
+		return resolution
+	}
+	return mergeResolutions(resolutions)
+}
@@ -0,0 +1,116 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestResolveOverrides(t *testing.T) {
+	overrides := []ipres.Override{
+		{
+			Prefix:     netip.MustParsePrefix("1.0.1.0/28"),
+			Resolution: ipres.Resolution{CountryCode: "DE"},
+		},
+		{
+			Prefix: netip.MustParsePrefix("1.0.0.0/16"),
+			Resolution: ipres.Resolution{
+				CountryCode:  "FR",
+				ASN:          64500,
+				Organization: "Override",
+			},
+		},
+		{
+			Prefix:     netip.MustParsePrefix("1:4::/32"),
+			Resolution: ipres.Resolution{CountryCode: "CH"},
+		},
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		asn     uint32
+		org     string
+	}{
+		{"1.0.0.1", "FR", 64500, "Override"},
+		{"1.0.1.1", "DE", 64500, "Override"},
+		{"1.1.1.1", "FR", 2, "Test2"},
+		{"1:0::", "US", 3, "Test3"},
+		{"1:4::", "CH", ipres.AS0, ""},
+	}
+
+	withRT(newDummyRT(), func() {
+		r := ipres.NewResolver(
+			ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+			ipres.Options{Overrides: overrides},
+		)
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.ip, func(t *testing.T) {
+				res := r.Resolve(netip.MustParseAddr(tt.ip))
+				if res.CountryCode != tt.country {
+					t.Errorf("got %q, want %q", res.CountryCode, tt.country)
+				}
+				if res.ASN != tt.asn {
+					t.Errorf("got %d, want %d", res.ASN, tt.asn)
+				}
+				if res.Organization != tt.org {
+					t.Errorf("got %q, want %q", res.Organization, tt.org)
+				}
+			})
+		}
+	})
+}
+
+func TestSetOverrides(t *testing.T) {
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,US\n",
+			ipres.CountryIPv6URL: "",
+		}},
+		ipres.Options{
+			DisableASN: true,
+			Overrides: []ipres.Override{{
+				Prefix:     netip.MustParsePrefix("1.0.0.0/28"),
+				Resolution: ipres.Resolution{CountryCode: "FR"},
+			}},
+		},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	ip := netip.MustParseAddr("1.0.0.1")
+	if got := r.Resolve(ip).CountryCode; got != "FR" {
+		t.Fatalf("got %q, want FR", got)
+	}
+
+	// The new overrides apply to the cached resolutions, and to the next
+	// updates.
+	r.SetOverrides([]ipres.Override{{
+		Prefix: netip.MustParsePrefix("1.0.0.0/24"),
+		Resolution: ipres.Resolution{
+			CountryCode: "DE",
+			Region:      "Bavaria",
+		},
+	}})
+	for range 2 {
+		res := r.Resolve(ip)
+		if res.CountryCode != "DE" || res.Region != "Bavaria" {
+			t.Errorf("got %q and %q, want DE and Bavaria", res.CountryCode,
+				res.Region)
+		}
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r.SetOverrides(nil)
+	if got := r.Resolve(ip).CountryCode; got != "US" {
+		t.Errorf("got %q, want US", got)
+	}
+}
@@ -0,0 +1,91 @@
+package ipres
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PeeringDBURL is the base URL of the PeeringDB API.
+const PeeringDBURL = "https://www.peeringdb.com/api"
+
+// ErrUnknownOrganization is returned when no PeeringDB organization has the
+// requested name.
+var ErrUnknownOrganization = errors.New("unknown PeeringDB organization")
+
+// peeringDBResponse is a response of the PeeringDB API. Only the fields used
+// by geoblock are decoded.
+type peeringDBResponse struct {
+	Data []struct {
+		ID  int    `json:"id"`
+		ASN uint32 `json:"asn"`
+	} `json:"data"`
+}
+
+// fetchPeeringDB fetches and decodes the objects of the given type matching
+// the given query.
+func fetchPeeringDB(
+	fetcher Fetcher,
+	baseURL string,
+	object string,
+	query url.Values,
+) (*peeringDBResponse, error) {
+	resource, err := fetcher.Fetch(
+		strings.TrimSuffix(baseURL, "/") + "/" + object + "?" + query.Encode(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var response peeringDBResponse
+	if err := json.Unmarshal(resource.Data, &response); err != nil {
+		return nil, fmt.Errorf("invalid PeeringDB response: %w", err)
+	}
+	return &response, nil
+}
+
+// OrganizationASNs returns the sorted ASNs of the networks of the PeeringDB
+// organization with the given name, using the API at the given base URL. The
+// name must match exactly, as registered in PeeringDB.
+func OrganizationASNs(
+	fetcher Fetcher,
+	baseURL string,
+	name string,
+) ([]uint32, error) {
+	orgs, err := fetchPeeringDB(
+		fetcher, baseURL, "org", url.Values{"name": {name}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(orgs.Data) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownOrganization, name)
+	}
+
+	ids := make([]string, 0, len(orgs.Data))
+	for _, org := range orgs.Data {
+		ids = append(ids, strconv.Itoa(org.ID))
+	}
+	nets, err := fetchPeeringDB(
+		fetcher,
+		baseURL,
+		"net",
+		url.Values{"org_id__in": {strings.Join(ids, ",")}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	asns := make([]uint32, 0, len(nets.Data))
+	for _, net := range nets.Data {
+		if net.ASN != AS0 {
+			asns = append(asns, net.ASN)
+		}
+	}
+	slices.Sort(asns)
+	return slices.Compact(asns), nil
+}
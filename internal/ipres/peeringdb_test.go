@@ -0,0 +1,60 @@
+package ipres_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestOrganizationASNs(t *testing.T) {
+	const baseURL = "https://peeringdb.example.com/api/"
+	fetcher := &mockFetcher{data: map[string]string{
+		baseURL + "org?name=Example+Inc": `{"data": [{"id": 1}, {"id": 2}]}`,
+		baseURL + "org?name=Nobody":      `{"data": []}`,
+		baseURL + "org?name=Broken":      `{"data": [{"id": 3}]}`,
+		baseURL + "net?org_id__in=1%2C2": `{"data": [
+			{"asn": 64512}, {"asn": 0}, {"asn": 13}, {"asn": 64512}
+		]}`,
+		baseURL + "net?org_id__in=3": `not json`,
+	}}
+
+	tests := []struct {
+		name    string
+		want    []uint32
+		wantErr error
+	}{
+		{"Example Inc", []uint32{13, 64512}, nil},
+		{"Nobody", nil, ipres.ErrUnknownOrganization},
+		{"Broken", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ipres.OrganizationASNs(fetcher, baseURL, tt.name)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	_, err := ipres.OrganizationASNs(
+		&mockFetcher{err: errFetch}, baseURL, "Example Inc",
+	)
+	if !errors.Is(err, errFetch) {
+		t.Errorf("got error %v, want %v", err, errFetch)
+	}
+}
@@ -0,0 +1,92 @@
+package ipres
+
+import (
+	"bufio"
+	"bytes"
+	"iter"
+	"net/netip"
+	"strings"
+)
+
+// prefixRange returns the first and last addresses of the given prefix.
+func prefixRange(prefix netip.Prefix) (netip.Addr, netip.Addr) {
+	start := prefix.Masked().Addr()
+
+	// Set all the host bits of the start address to get the end address.
+	addr := start.AsSlice()
+	for i := range addr {
+		networkBits := min(max(prefix.Bits()-8*i, 0), 8)
+		addr[i] |= byte(1<<(8-networkBits) - 1)
+	}
+
+	end, _ := netip.AddrFromSlice(addr)
+	return start, end
+}
+
+// parsePrefixRecord parses a CIDR prefix, or a single IP address, into a
+// database record with the given resolution.
+func parsePrefixRecord(
+	prefix string,
+	resolution Resolution,
+) (*DBRecord, error) {
+	prefix = strings.TrimSpace(prefix)
+	if !strings.Contains(prefix, "/") {
+		addr, err := netip.ParseAddr(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return &DBRecord{
+			StartIP:    addr,
+			EndIP:      addr,
+			Resolution: resolution,
+		}, nil
+	}
+
+	parsed, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := prefixRange(parsed)
+	return &DBRecord{
+		StartIP:    start,
+		EndIP:      end,
+		Resolution: resolution,
+	}, nil
+}
+
+// decodePrefixList returns a decoder for lists of CIDR prefixes or IP
+// addresses, one per line. Empty lines and lines starting with `#` are
+// ignored. The records have the given resolution.
+func decodePrefixList(resolution Resolution) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				if !yield(parsePrefixRecord(line, resolution)) {
+					return
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+}
+
+// decodePrefixes yields a record for each of the given prefixes.
+func decodePrefixes(
+	yield func(*DBRecord, error) bool,
+	prefixes []string,
+	resolution Resolution,
+) {
+	for _, prefix := range prefixes {
+		if !yield(parsePrefixRecord(prefix, resolution)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package ipres
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// URLs of the delegated-extended statistics files published by the Regional
+// Internet Registries.
+const (
+	ARINURL    = "https://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest"
+	RIPENCCURL = "https://ftp.ripe.net/pub/stats/ripencc/delegated-ripencc-extended-latest"
+	APNICURL   = "https://ftp.apnic.net/stats/apnic/delegated-apnic-extended-latest"
+	LACNICURL  = "https://ftp.lacnic.net/pub/stats/lacnic/delegated-lacnic-extended-latest"
+	AFRINICURL = "https://ftp.afrinic.net/pub/stats/afrinic/delegated-afrinic-extended-latest"
+)
+
+// Names of the Regional Internet Registries.
+const (
+	RegistryARIN    = "arin"
+	RegistryRIPENCC = "ripencc"
+	RegistryAPNIC   = "apnic"
+	RegistryLACNIC  = "lacnic"
+	RegistryAFRINIC = "afrinic"
+)
+
+// Names of the registry database sources.
+const (
+	SourceARIN    = "registry-arin"
+	SourceRIPENCC = "registry-ripencc"
+	SourceAPNIC   = "registry-apnic"
+	SourceLACNIC  = "registry-lacnic"
+	SourceAFRINIC = "registry-afrinic"
+)
+
+// ErrInvalidDelegation is returned when an IP record of a delegation file
+// can't be parsed.
+var ErrInvalidDelegation = errors.New("invalid delegation record")
+
+// Fields of the records of the delegation files.
+const (
+	delegationCountry = 1
+	delegationType    = 2
+	delegationStart   = 3
+	delegationValue   = 4
+	delegationStatus  = 6
+	delegationFields  = 7
+)
+
+// registrySources returns the sources of the delegation files of the given
+// registries. Unknown registries are ignored.
+func registrySources(registries []string) []source {
+	var sources []source
+	for _, name := range registries {
+		decode := decodeDelegations(name)
+		switch name {
+		case RegistryARIN:
+			sources = append(sources, source{SourceARIN, ARINURL, decode})
+		case RegistryRIPENCC:
+			sources = append(sources, source{
+				SourceRIPENCC, RIPENCCURL, decode,
+			})
+		case RegistryAPNIC:
+			sources = append(sources, source{SourceAPNIC, APNICURL, decode})
+		case RegistryLACNIC:
+			sources = append(sources, source{SourceLACNIC, LACNICURL, decode})
+		case RegistryAFRINIC:
+			sources = append(sources, source{
+				SourceAFRINIC, AFRINICURL, decode,
+			})
+		}
+	}
+	return sources
+}
+
+// decodeDelegations returns a decoder for the delegated-extended statistics
+// files of the given registry. Gzip-compressed files are also accepted.
+//
+// Only the allocated and assigned IPv4 and IPv6 records are loaded. The
+// comments, the version and summary lines, the ASN records and the available
+// and reserved blocks, which have no country, are ignored.
+func decodeDelegations(registry string) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			data, err := gunzip(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				// The version line and the summary lines, whose country is
+				// `*`, have other fields than the records.
+				fields := strings.Split(line, "|")
+				if len(fields) <= delegationType ||
+					fields[delegationCountry] == "*" {
+					continue
+				}
+				kind := fields[delegationType]
+				if kind != "ipv4" && kind != "ipv6" {
+					continue
+				}
+				entry, err := parseDelegation(fields, registry)
+				if entry == nil && err == nil {
+					continue
+				}
+				if !yield(entry, err) {
+					return
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				yield(nil, err)
+			}
+		}
+	}
+}
+
+// parseDelegation parses an IP record of a delegation file of the given
+// registry, whose fields are `registry|cc|type|start|value|date|status`. It
+// returns a nil record and no error for the blocks that aren't delegated.
+func parseDelegation(fields []string, registry string) (*DBRecord, error) {
+	if len(fields) < delegationFields {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDelegation,
+			strings.Join(fields, "|"))
+	}
+	country := fields[delegationCountry]
+	status := fields[delegationStatus]
+	if country == "" || country == "ZZ" ||
+		(status != "allocated" && status != "assigned") {
+		return nil, nil
+	}
+
+	start, err := netip.ParseAddr(fields[delegationStart])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDelegation, err)
+	}
+	value, err := strconv.ParseUint(fields[delegationValue], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDelegation, err)
+	}
+
+	// The value of the IPv4 records is the number of addresses of the block,
+	// which isn't always a power of two, and the one of the IPv6 records is
+	// the length of the prefix.
+	var end netip.Addr
+	switch {
+	case fields[delegationType] == "ipv4" && start.Is4():
+		addr := start.As4()
+		first := uint64(binary.BigEndian.Uint32(addr[:]))
+		if value == 0 || first+value-1 > math.MaxUint32 {
+			return nil, fmt.Errorf("%w: %s: invalid size %d",
+				ErrInvalidDelegation, start, value)
+		}
+		binary.BigEndian.PutUint32(addr[:], uint32(first+value-1))
+		end = netip.AddrFrom4(addr)
+	case fields[delegationType] == "ipv6" && start.Is6():
+		prefix, err := start.Prefix(int(value)) // #nosec G115
+		if err != nil || prefix.Addr() != start {
+			return nil, fmt.Errorf("%w: %s/%d", ErrInvalidDelegation, start,
+				value)
+		}
+		start, end = prefixRange(prefix)
+	default:
+		return nil, fmt.Errorf("%w: %s: not an %s address",
+			ErrInvalidDelegation, start, fields[delegationType])
+	}
+
+	return &DBRecord{
+		StartIP: start,
+		EndIP:   end,
+		Resolution: Resolution{
+			CountryCode: country,
+			Registry:    registry,
+		},
+	}, nil
+}
+
+// isASNSource checks if the given source is an ASN source, before which the
+// registry sources are loaded, see Resolver.sources.
+func isASNSource(src source) bool {
+	switch src.name {
+	case SourceASNIPv4, SourceASNIPv6, SourceASNMMDB:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package ipres_test
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+const ripeDelegations = `2|ripencc|20260101|5|19830705|20260101|+0100
+# Comment
+ripencc|*|ipv4|*|3|summary
+ripencc|*|asn|*|1|summary
+ripencc|FR|ipv4|1.2.0.0|768|20100101|allocated|a
+ripencc|FR|asn|64500|1|20100101|allocated|a
+ripencc||ipv4|1.3.0.0|256||available
+ripencc|ZZ|ipv4|1.4.0.0|256||reserved
+ripencc|DE|ipv6|2001:db8::|32|20100101|assigned|b
+`
+
+func TestResolveRegistries(t *testing.T) {
+	r := ipres.NewResolver(
+		&mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.255.255.255,US\n" +
+				"2.0.0.0,2.255.255.255,DE\n",
+			ipres.RIPENCCURL: ripeDelegations,
+		}},
+		ipres.Options{
+			DisableASN: true,
+			Registries: []string{ipres.RegistryRIPENCC},
+			Overrides: []ipres.Override{{
+				Prefix:     netip.MustParsePrefix("1.2.1.0/24"),
+				Resolution: ipres.Resolution{CountryCode: "BE"},
+			}},
+		},
+	)
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip       string
+		country  string
+		registry string
+	}{
+		{"1.2.0.1", "FR", ipres.RegistryRIPENCC},
+		{"1.2.1.1", "BE", ipres.RegistryRIPENCC},
+		{"1.2.2.255", "FR", ipres.RegistryRIPENCC},
+		{"1.2.3.0", "US", ""},
+		{"1.3.0.1", "US", ""},
+		{"1.4.0.1", "US", ""},
+		{"2.0.0.1", "DE", ""},
+		{"2001:db8::1", "DE", ipres.RegistryRIPENCC},
+		{"2001:db9::1", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			res := r.Resolve(netip.MustParseAddr(tt.ip))
+			if res.CountryCode != tt.country || res.Registry != tt.registry {
+				t.Errorf("got %q and %q, want %q and %q", res.CountryCode,
+					res.Registry, tt.country, tt.registry)
+			}
+		})
+	}
+}
+
+func TestDecodeDelegationsErr(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"missing fields", "ripencc|FR|ipv4|1.2.0.0|256"},
+		{"invalid start", "ripencc|FR|ipv4|1.2.0|256|20100101|allocated"},
+		{"invalid size", "ripencc|FR|ipv4|1.2.0.0|x|20100101|allocated"},
+		{"empty block", "ripencc|FR|ipv4|1.2.0.0|0|20100101|allocated"},
+		{"overflow", "ripencc|FR|ipv4|255.255.255.0|512|20100101|allocated"},
+		{"unaligned", "ripencc|FR|ipv6|2001:db8::1|32|20100101|assigned"},
+		{"invalid prefix", "ripencc|FR|ipv6|2001:db8::|129|20100101|assigned"},
+		{"type mismatch", "ripencc|FR|ipv6|1.2.0.0|24|20100101|assigned"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ipres.NewResolver(
+				&mockFetcher{data: map[string]string{
+					ipres.CountryIPv4URL: "1.0.0.0,1.255.255.255,US\n",
+					ipres.RIPENCCURL:     tt.line + "\n",
+				}},
+				ipres.Options{
+					DisableASN: true,
+					Registries: []string{ipres.RegistryRIPENCC},
+				},
+			)
+			err := r.Update()
+			if !errors.Is(err, ipres.ErrInvalidDelegation) {
+				t.Errorf("got error %v, want %v", err,
+					ipres.ErrInvalidDelegation)
+			}
+		})
+	}
+}
+
+func TestRegistrySourceURLs(t *testing.T) {
+	r := ipres.NewResolver(&mockFetcher{}, ipres.Options{
+		Registries: []string{ipres.RegistryARIN, "unknown"},
+	})
+
+	urls := r.SourceURLs()
+	want := []string{ipres.ARINURL}
+	if got := urls[ipres.SourceARIN]; !slices.Equal(got, want) {
+		t.Errorf("got ARIN URLs %v, want %v", got, want)
+	}
+	if got := len(urls); got != 5 {
+		t.Errorf("got %d sources, want 5", got)
+	}
+}
@@ -3,14 +3,19 @@
 package ipres
 
 import (
-	"encoding/csv"
+	"cmp"
+	"crypto/ed25519"
 	"errors"
-	"net/http"
 	"net/netip"
+	"slices"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/danroc/geoblock/internal/health"
 	"github.com/danroc/geoblock/internal/itree"
+	"github.com/danroc/geoblock/internal/metrics"
 )
 
 // URLs of the CSV IP location databases.
@@ -21,6 +26,29 @@ const (
 	ASNIPv6URL     = "https://cdn.jsdelivr.net/npm/@ip-location-db/geolite2-asn/geolite2-asn-ipv6.csv"
 )
 
+// URLs of the mirrors of the CSV IP location databases, tried when jsDelivr
+// can't be reached. They serve the same npm packages.
+const (
+	CountryIPv4MirrorURL = "https://unpkg.com/@ip-location-db/geolite2-country/geolite2-country-ipv4.csv"
+	CountryIPv6MirrorURL = "https://unpkg.com/@ip-location-db/geolite2-country/geolite2-country-ipv6.csv"
+	ASNIPv4MirrorURL     = "https://unpkg.com/@ip-location-db/geolite2-asn/geolite2-asn-ipv4.csv"
+	ASNIPv6MirrorURL     = "https://unpkg.com/@ip-location-db/geolite2-asn/geolite2-asn-ipv6.csv"
+)
+
+// Orders in which the URLs of a database source are tried.
+const (
+	URLOrderConfigured = "configured" // The order of the URLs
+	URLOrderFastest    = "fastest"    // The fastest URLs first
+)
+
+// Names of the database sources.
+const (
+	SourceCountryIPv4 = "country-ipv4"
+	SourceCountryIPv6 = "country-ipv6"
+	SourceASNIPv4     = "asn-ipv4"
+	SourceASNIPv6     = "asn-ipv6"
+)
+
 // Length of the CSV records (number of fields).
 const (
 	countryRecordLength = 3
@@ -29,10 +57,23 @@ const (
 
 // ErrRecordLength is returned when a CSV record has an unexpected length.
 var (
-	ErrRecordLength = errors.New("invalid record length")
-	ErrInvalidANS   = errors.New("invalid ASN")
+	ErrRecordLength          = errors.New("invalid record length")
+	ErrInvalidANS            = errors.New("invalid ASN")
+	ErrTooManyInvalidRecords = errors.New("too many invalid records")
 )
 
+// ErrDatabaseUnavailable is returned by Update, wrapping the errors of the
+// URLs, when a database source can't be fetched and verified from any of its
+// URLs.
+var ErrDatabaseUnavailable = errors.New("database unavailable")
+
+// ErrNotReady is returned by Ready when the resolver has no country data.
+var ErrNotReady = errors.New("no country data loaded")
+
+// maxReportedErrors is the maximum number of record errors included in the
+// error returned when a source exceeds its error budget.
+const maxReportedErrors = 10
+
 // AS0 represents the default ASN value for unknown addresses.
 const AS0 uint32 = 0
 
@@ -50,77 +91,635 @@ type ParserFn func([]string) (*DBRecord, error)
 // resolutions.
 type ResTree = itree.ITree[netip.Addr, Resolution]
 
+// ResFlat is a type alias for a flat interval index that maps IP addresses
+// to resolutions.
+type ResFlat = itree.Flat[netip.Addr, Resolution]
+
+// Indexes of the database ranges, see Options.Index.
+const (
+	IndexTree = "tree"
+	IndexFlat = "flat"
+)
+
+// resIndex maps IP ranges to their resolutions. It's implemented by ResTree
+// and ResFlat.
+type resIndex interface {
+	Insert(interval itree.Interval[netip.Addr], value Resolution)
+	Query(key netip.Addr) []Resolution
+}
+
 // Resolution contains the result of resolving an IP address.
 type Resolution struct {
 	CountryCode  string // ISO 3166-1 alpha-2 country code
+	Region       string // Name of the region, e.g., a state, if known
+	City         string // Name of the city, if known
 	Organization string // Organization name
 	ASN          uint32 // Autonomous System Number
+
+	// Anonymizers are the anonymization networks, e.g., Tor, whose published
+	// lists contain the IP. It's placed after ASN to use its padding.
+	Anonymizers Anonymizers
+
+	CDN      string // Name of the CDN or anycast provider, if any
+	Monitor  string // Name of the uptime monitoring service, if any
+	Registry string // Name of the RIR that delegated the IP, if loaded
+
+	// OrganizationKey is the normalized organization name, computed when the
+	// databases are loaded. It's used to match the organizations of the rules.
+	OrganizationKey string
+}
+
+// IsCDN returns whether the IP belongs to a known CDN or anycast range.
+func (r *Resolution) IsCDN() bool {
+	return r.CDN != ""
 }
 
 // mergeResolutions merges the given resolutions into a single resolution.
 //
 // The fields of the resulting resolution are the LAST non-zero fields of the
-// input resolutions.
+// input resolutions, except for the anonymizers, which are combined. The
+// region and city are taken along with the country, so that an override of
+// the country doesn't keep the region and city of another country. They're
+// kept if the country doesn't change, e.g., when a registry confirms it.
 func mergeResolutions(resolutions []Resolution) Resolution {
 	var merged Resolution
 	for _, r := range resolutions {
 		if r.CountryCode != "" {
+			if r.CountryCode != merged.CountryCode || r.Region != "" ||
+				r.City != "" {
+				merged.Region = r.Region
+				merged.City = r.City
+			}
 			merged.CountryCode = r.CountryCode
 		}
 		if r.Organization != "" {
 			merged.Organization = r.Organization
+			merged.OrganizationKey = r.OrganizationKey
 		}
 		if r.ASN != 0 {
 			merged.ASN = r.ASN
 		}
+		if r.CDN != "" {
+			merged.CDN = r.CDN
+		}
+		if r.Monitor != "" {
+			merged.Monitor = r.Monitor
+		}
+		if r.Registry != "" {
+			merged.Registry = r.Registry
+		}
+		merged.Anonymizers |= r.Anonymizers
 	}
 	return merged
 }
 
 // Resolver is an IP resolver that returns information about an IP address.
 type Resolver struct {
-	db atomic.Pointer[ResTree]
+	db        atomic.Pointer[database]
+	degraded  atomic.Bool // No update has succeeded yet
+	fetcher   Fetcher
+	options   Options
+	overrides []Override // Sorted from the least to the most specific
+
+	// storeMu serializes the changes of the database and of the overrides,
+	// so that each stored database applies the current overrides.
+	storeMu sync.Mutex
+
+	// Statistics of the last successful update and the differences with the
+	// update before it.
+	mu     sync.RWMutex
+	stats  map[string]*SourceStats
+	diffs  []SourceDiff
+	intern InternStats
+
+	// Versions of the sources loaded by the last successful update, by
+	// source name, used to skip the updates of unchanged sources.
+	versions map[string]sourceVersion
+
+	// Contents of the sources loaded by the last successful update, by
+	// source name, loaded again when a source fails, see Update.
+	loaded map[string]*Resource
+
+	// States of the sources loaded by the successful updates, by source
+	// name, see SourceStatuses.
+	sourceStates map[string]*sourceState
+
+	// Durations of the last fetch of each URL, see Resolver.urls.
+	latencyMu sync.Mutex
+	latencies map[string]time.Duration
+}
+
+// Options contains the options of a resolver.
+type Options struct {
+	// MaxInvalidRecords is the number of invalid records that can be skipped
+	// per database source. The update fails if a source has more invalid
+	// records than this.
+	MaxInvalidRecords int
+
+	// Format is the format of the country and ASN databases, FormatCSV or
+	// FormatMMDB. If empty, the CSV databases are used.
+	Format string
+
+	// CountryURL and ASNURL are the URLs of the MMDB country and ASN
+	// databases. They are only used with FormatMMDB. If ASNURL is empty, no
+	// ASN database is loaded.
+	CountryURL string
+	ASNURL     string
+
+	// City enables the loading of the city databases, which replace the
+	// country databases, so that the regions and cities of the IPs are
+	// resolved. With FormatMMDB, the city database is downloaded from
+	// CityURL.
+	City    bool
+	CityURL string
+
+	// URLs replace the URLs of the database sources, by source name. The
+	// URLs of a source are tried in order until one of them can be fetched,
+	// so that mirrors can be used as fallbacks. They also replace the default
+	// mirrors of the source.
+	URLs map[string][]string
+
+	// URLOrder is the order in which the URLs of a source are tried,
+	// URLOrderConfigured or URLOrderFastest. If empty, URLOrderConfigured is
+	// used.
+	URLOrder string
+
+	// Verifications are the expected checksums and signatures of the
+	// database sources, by source name. The content of a source that
+	// doesn't match its verification is rejected, and the next URL of the
+	// source is tried.
+	Verifications map[string]Verification
+
+	// SignatureKey is the Ed25519 public key verifying the signatures of the
+	// Verifications.
+	SignatureKey ed25519.PublicKey
+
+	// DisableASN disables the loading of the ASN databases to reduce memory
+	// usage. Resolved ASNs and organizations are then always empty.
+	DisableASN bool
+
+	// CrossCheck enables the cross-checking of the ASN and country databases.
+	// See Resolver.CrossCheck.
+	CrossCheck bool
+
+	// Registries are the Regional Internet Registries, e.g., RegistryRIPENCC,
+	// whose delegation files are loaded as an additional country database.
+	// Their countries take precedence over the ones of the other databases.
+	Registries []string
+
+	// CDN enables the loading of the IP ranges published by CDN and anycast
+	// providers.
+	CDN bool
+
+	// Monitors are the uptime monitoring services whose published IP
+	// addresses are loaded.
+	Monitors []string
+
+	// Anonymizers are the anonymization networks, AnonymizerTor,
+	// AnonymizerVPN or AnonymizerProxy, whose published IP addresses are
+	// loaded.
+	Anonymizers []string
+
+	// Overrides replace the resolution of specific networks, for example to
+	// correct known-wrong database entries. When several overrides contain
+	// the same address, the most specific one takes precedence. They can be
+	// replaced at runtime, see Resolver.SetOverrides.
+	Overrides []Override
+
+	// ResolutionCacheSize and ResolutionCacheTTL are the maximum number of
+	// cached resolutions and the time during which they're used. If zero,
+	// DefaultResolutionCacheSize and DefaultResolutionCacheTTL are used. The
+	// cache is emptied after each update.
+	ResolutionCacheSize int
+	ResolutionCacheTTL  time.Duration
+
+	// KeepPartial makes the resolver use the sources that could be loaded
+	// when an update fails, as long as no update has succeeded. Otherwise,
+	// failed updates leave the databases unchanged.
+	KeepPartial bool
+
+	// StaleAfter is the period after which a source that hasn't been
+	// updated is stale, see SourceStatus. If zero, the sources are only
+	// stale until they're loaded.
+	StaleAfter time.Duration
+
+	// Index is the index of the database ranges, IndexTree or IndexFlat.
+	// The flat index uses less memory than the interval tree, but the
+	// ranges of each source are sorted again after it's loaded. If empty,
+	// IndexTree is used.
+	Index string
+
+	// Concurrency is the number of sources fetched and decoded concurrently
+	// by an update. Their records are still loaded one source at a time, in
+	// order. With 1, the records are loaded as they're decoded, which uses
+	// the least memory. If zero, DefaultConcurrency is used.
+	Concurrency int
+
+	// FetchTimeout is the timeout of the fetch of each URL of a source, so
+	// that a stalled URL falls back to the next one. It only applies to the
+	// ContextFetchers. If zero, DefaultFetchTimeout is used.
+	FetchTimeout time.Duration
+
+	// SnapshotFile is the file in which the parsed records of the sources are
+	// saved after each successful update. On startup, they're loaded from it
+	// instead of being parsed again if the fetcher is a ConditionalFetcher
+	// and none of the sources has changed. If empty, no snapshot is saved.
+	SnapshotFile string
+}
+
+// database contains the data built by an update. It's replaced as a whole so
+// that readers always see a consistent state.
+type database struct {
+	index      resIndex
+	countries  asnCountries // nil if cross-checking is disabled
+	geoRecords int          // Number of records with a country code
+	overrides  []Override   // See Resolver.overrides
+	registries bool         // Whether records of registries are loaded
+	cache      *resolutionCache
 }
 
-// NewResolver creates a new IP resolver.
-func NewResolver() *Resolver {
-	return &Resolver{}
+// newDatabase creates an empty database, with the index of the resolver's
+// options and an empty resolution cache.
+func (r *Resolver) newDatabase() *database {
+	var index resIndex = itree.NewITree[netip.Addr, Resolution]()
+	if r.options.Index == IndexFlat {
+		index = itree.NewFlat[netip.Addr, Resolution]()
+	}
+	return &database{
+		index: index,
+		cache: newResolutionCache(
+			r.options.ResolutionCacheSize, r.options.ResolutionCacheTTL,
+		),
+	}
+}
+
+// query returns the merged resolution of the ranges of the database index
+// that contain the given IP, without the overrides.
+//
+// The ranges of the registries are merged last, so that their country takes
+// precedence over the one of the other databases.
+func (db *database) query(ip netip.Addr) Resolution {
+	resolutions := db.index.Query(ip)
+	if db.registries {
+		slices.SortStableFunc(resolutions, func(a, b Resolution) int {
+			return cmp.Compare(registryRank(a), registryRank(b))
+		})
+	}
+	return mergeResolutions(resolutions)
+}
+
+// registryRank returns 1 if the given resolution comes from a registry, and 0
+// otherwise.
+func registryRank(resolution Resolution) int {
+	if resolution.Registry != "" {
+		return 1
+	}
+	return 0
+}
+
+// entrySize returns the size, in bytes, of a range of the database index,
+// without the content of the strings of its resolution.
+func (db *database) entrySize() int64 {
+	if _, ok := db.index.(*ResFlat); ok {
+		return flatEntrySize
+	}
+	return nodeSize
+}
+
+// build makes the ranges inserted in the database index queryable, if it's a
+// flat index, see itree.Flat.Build.
+func (db *database) build() {
+	if flat, ok := db.index.(*ResFlat); ok {
+		flat.Build()
+	}
+}
+
+// NewResolver creates a new IP resolver that uses the given fetcher to
+// retrieve the databases.
+//
+// Until its first successful update, the resolver is degraded and resolves
+// every IP address to an empty resolution.
+func NewResolver(fetcher Fetcher, options Options) *Resolver {
+	if options.ResolutionCacheSize == 0 {
+		options.ResolutionCacheSize = DefaultResolutionCacheSize
+	}
+	if options.ResolutionCacheTTL == 0 {
+		options.ResolutionCacheTTL = DefaultResolutionCacheTTL
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.FetchTimeout <= 0 {
+		options.FetchTimeout = DefaultFetchTimeout
+	}
+
+	r := &Resolver{
+		fetcher:   fetcher,
+		options:   options,
+		overrides: sortOverrides(options.Overrides),
+	}
+	db := r.newDatabase()
+	db.overrides = r.overrides
+	r.db.Store(db)
+	r.degraded.Store(true)
+	for _, src := range r.sources() {
+		metrics.DatabaseSourceStale.WithLabelValues(src.name).Set(1)
+	}
+	return r
 }
 
 // Update updates the databases used by the resolver.
 //
 // If an error occurs while updating a database, the function proceeds to
 // update the next database and returns all the errors at the end.
+//
+// The sources that can't be fetched, or whose content can't be loaded, are
+// loaded from the content of the last successful update instead, so that a
+// transient failure of a source doesn't drop its data. The databases are then
+// replaced, but the errors of the failed sources are still returned. Until
+// an update succeeds, there is no such content and the update fails.
+//
+// If the fetcher is a ConditionalFetcher and none of the databases has changed
+// since the last successful update, the databases are neither downloaded nor
+// parsed again. On startup, they're loaded from the snapshot file instead, if
+// any, when none of them has changed since it was saved.
 func (r *Resolver) Update() error {
-	items := []struct {
-		parser ParserFn
-		url    string
-	}{
-		{parseCountryRecord, CountryIPv4URL},
-		{parseCountryRecord, CountryIPv6URL},
-		{parseASNRecord, ASNIPv4URL},
-		{parseASNRecord, ASNIPv6URL},
+	items := r.sources()
+
+	r.mu.RLock()
+	previous := r.versions
+	r.mu.RUnlock()
+	snap := r.readSnapshot(items)
+	if snap != nil {
+		previous = snap.versions()
 	}
 
-	// A new database is created for each update so that it can be atomically
-	// swapped with the current database.
-	db := itree.NewITree[netip.Addr, Resolution]()
+	resources, versions, unchanged := r.fetchModified(items, previous)
+	if unchanged && snap != nil {
+		r.restore(items, snap, versions)
+		metrics.DatabaseLastUpdate.SetToCurrentTime()
+		r.reportHealth(nil)
+		return nil
+	}
+	if unchanged {
+		metrics.DatabaseLastUpdate.SetToCurrentTime()
+		r.mu.Lock()
+		r.touchSources(time.Now())
+		r.mu.Unlock()
+		r.reportHealth(nil)
+		return nil
+	}
+
+	errs := r.fetchMissing(items, resources, versions)
 
-	var errs []error
+	// The sources that couldn't be fetched, or whose fresh content fails to
+	// load, are loaded from their previous content instead. The previous
+	// contents were loaded by the last successful update, so the second
+	// build doesn't fail for them. The snapshot is only saved if all the
+	// sources are loaded from their fresh content.
+	var snapshot *snapshotWriter
+	if len(errs) == 0 {
+		snapshot = r.newSnapshotWriter(versions)
+	}
+	r.keepLoaded(items, resources, versions, nil)
+	db, stats, pool, failed := r.build(items, resources, snapshot)
 	for _, item := range items {
-		if err := update(db, item.parser, item.url); err != nil {
+		if err, ok := failed[item.name]; ok {
 			errs = append(errs, err)
 		}
 	}
+	if len(failed) > 0 {
+		snapshot.abort()
+		snapshot = nil
+	}
+	if r.keepLoaded(items, resources, versions, failed) {
+		db, stats, pool, failed = r.build(items, resources, nil)
+	}
+	if len(failed) > 0 || len(resources) < len(items) {
+		metrics.DatabaseUpdateFailures.Inc()
+		if r.degraded.Load() {
+			metrics.DatabaseDegraded.Set(1)
+			if r.options.KeepPartial {
+				r.store(db)
+			}
+		}
+		err := errors.Join(errs...)
+		r.reportHealth(err)
+		return err
+	}
+
+	r.commit(items, db, stats, pool, resources, versions, resources)
+	snapshot.save()
 	if len(errs) > 0 {
-		return errors.Join(errs...)
+		metrics.DatabaseUpdateFailures.Inc()
+		err := errors.Join(errs...)
+		r.reportHealth(err)
+		return err
+	}
+	metrics.DatabaseLastUpdate.SetToCurrentTime()
+	r.reportHealth(nil)
+	return nil
+}
+
+// commit replaces the databases with the given database, built from the given
+// resources and versions, and records its statistics. The loaded resources
+// are the ones kept for the next updates, see keepLoaded.
+func (r *Resolver) commit(
+	items []source,
+	db *database,
+	stats map[string]*SourceStats,
+	pool *stringPool,
+	resources map[string]*Resource,
+	versions map[string]sourceVersion,
+	loaded map[string]*Resource,
+) {
+	r.store(db)
+	r.degraded.Store(false)
+	metrics.DatabaseDegraded.Set(0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	diffs := make([]SourceDiff, 0, len(items))
+	for _, item := range items {
+		diff := diffStats(item.name, r.stats[item.name], stats[item.name])
+		diffs = append(diffs, diff)
+		metrics.DatabaseRecords.WithLabelValues(item.name).Set(
+			float64(diff.After),
+		)
+		metrics.DatabaseChanges.WithLabelValues(item.name, "added").Set(
+			float64(diff.Added),
+		)
+		metrics.DatabaseChanges.WithLabelValues(item.name, "removed").Set(
+			float64(diff.Removed),
+		)
+		metrics.DatabaseInvalidRecords.WithLabelValues(item.name).Set(
+			float64(stats[item.name].Invalid),
+		)
+		metrics.DatabaseMemory.WithLabelValues(item.name).Set(
+			float64(stats[item.name].Memory),
+		)
+	}
+	metrics.DatabaseStrings.WithLabelValues("distinct").Set(
+		float64(pool.stats.Strings),
+	)
+	metrics.DatabaseStrings.WithLabelValues("deduplicated").Set(
+		float64(pool.stats.Deduplicated),
+	)
+	r.stats, r.diffs, r.intern = stats, diffs, pool.stats
+	r.recordSources(items, resources, stats, versions, time.Now())
+	r.versions, r.loaded = versions, loaded
+}
+
+// build builds a new database from the given resources, by source name. The
+// sources without resource are skipped. It returns the database, the
+// statistics of the sources, the interned strings, and the errors of the
+// sources whose content couldn't be loaded, by source name. The loaded records
+// are also written to the given snapshot, if any. The sources are decoded
+// ahead of their loading, in the background, see parseAhead.
+func (r *Resolver) build(
+	sources []source,
+	resources map[string]*Resource,
+	snapshot *snapshotWriter,
+) (*database, map[string]*SourceStats, *stringPool, map[string]error) {
+	// A new database is created for each update so that it can be atomically
+	// swapped with the current database.
+	db := r.newDatabase()
+	if r.options.CrossCheck {
+		db.countries = make(asnCountries)
+	}
+
+	var (
+		stats  = make(map[string]*SourceStats, len(sources))
+		pool   = newStringPool()
+		failed = make(map[string]error)
+	)
+	if r.options.Concurrency > 1 {
+		sources = r.parseAhead(sources, resources)
+	}
+	for _, src := range sources {
+		stats[src.name] = newSourceStats()
+		resource, ok := resources[src.name]
+		if !ok {
+			continue
+		}
+		if snapshot != nil {
+			src.decode = snapshot.recording(src.name, src.decode)
+		}
+		err := r.update(db, stats[src.name], pool, src, resource)
+		if err != nil {
+			failed[src.name] = err
+		}
+		snapshot.add(stats[src.name].Invalid)
+	}
+	db.build()
+	return db, stats, pool, failed
+}
+
+// keepLoaded replaces the given resources and versions of the sources that
+// couldn't be fetched, or that failed to load, with the ones loaded by the
+// last successful update, if any. It returns whether a source was replaced.
+func (r *Resolver) keepLoaded(
+	sources []source,
+	resources map[string]*Resource,
+	versions map[string]sourceVersion,
+	failed map[string]error,
+) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kept := false
+	for _, src := range sources {
+		previous, ok := r.loaded[src.name]
+		resource, fetched := resources[src.name]
+		_, fails := failed[src.name]
+		if !ok || resource == previous || (fetched && !fails) {
+			continue
+		}
+		resources[src.name] = previous
+		versions[src.name] = r.versions[src.name]
+		kept = true
+	}
+	return kept
+}
+
+// reportHealth reports the state of the databases after an update that
+// returned the given error. The databases have failed if they have no country
+// data, and are degraded if the update failed but the previous data is kept.
+func (r *Resolver) reportHealth(err error) {
+	switch {
+	case r.Empty():
+		health.Report(
+			health.ComponentDatabases,
+			health.StateFailed,
+			cmp.Or(err, ErrNotReady),
+		)
+	case err != nil:
+		health.Report(health.ComponentDatabases, health.StateDegraded, err)
+	default:
+		health.Report(health.ComponentDatabases, health.StateOK, nil)
 	}
+}
 
-	// Atomically swap the current database with the new one.
+// store atomically swaps the current database with the given one, which
+// applies the current overrides.
+func (r *Resolver) store(db *database) {
+	r.storeMu.Lock()
+	db.overrides = r.overrides
 	r.db.Store(db)
+	r.storeMu.Unlock()
+
+	if db.geoRecords == 0 {
+		metrics.DatabaseEmpty.Set(1)
+	} else {
+		metrics.DatabaseEmpty.Set(0)
+	}
+}
+
+// Degraded checks if no update of the resolver has succeeded yet, in which
+// case it has no databases or, with KeepPartial, incomplete ones.
+func (r *Resolver) Degraded() bool {
+	return r.degraded.Load()
+}
+
+// Empty checks if the resolver has no country data, either because it was
+// never updated or because its databases contain no country records. An empty
+// resolver resolves every IP address to an empty country code, so that the
+// countries conditions of the rules never match.
+func (r *Resolver) Empty() bool {
+	return r.db.Load().geoRecords == 0
+}
+
+// Ready returns ErrNotReady if the resolver is empty, see Empty.
+func (r *Resolver) Ready() error {
+	if r.Empty() {
+		return ErrNotReady
+	}
 	return nil
 }
 
+// Diff returns, for each database source, the differences between the last
+// successful update and the one before it.
+func (r *Resolver) Diff() []SourceDiff {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.diffs)
+}
+
+// Memory returns the estimated memory used by each database source, in bytes,
+// and the statistics of the interned strings of the last successful update.
+// The estimate doesn't include the overhead of the allocator.
+func (r *Resolver) Memory() (map[string]int64, InternStats) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	memory := make(map[string]int64, len(r.stats))
+	for name, stats := range r.stats {
+		memory[name] = stats.Memory
+	}
+	return memory, r.intern
+}
+
 // Resolve resolves the given IP address to a country code and an ASN.
 //
 // It is the caller's responsibility to check if the IP is valid.
@@ -129,42 +728,87 @@ func (r *Resolver) Update() error {
 // will be an empty string. If the ASN of the IP is not found, the ASN field of
 // the result will be zero.
 //
-// The Organization field is present for informational purposes only. It is not
-// used by the rules engine.
+// The Organization field is present for informational purposes only. The
+// rules engine uses the normalized OrganizationKey field instead.
+//
+// Overrides are applied last, so they take precedence over the databases.
+//
+// Resolutions are cached until the next update, so that the repeated
+// requests of a client don't query the databases again.
 func (r *Resolver) Resolve(ip netip.Addr) Resolution {
-	return mergeResolutions(r.db.Load().Query(ip))
-}
-
-// update adds the records fetched from the given URL to the database.
-func update(db *ResTree, parser ParserFn, url string) error {
-	records, err := fetchCSV(url)
-	if err != nil {
-		return err
+	var (
+		db  = r.db.Load()
+		now = time.Now()
+	)
+	if resolution, ok := db.cache.get(ip, now); ok {
+		return resolution
 	}
 
-	var errs []error
-	for _, record := range records {
-		entry, err := parser(record)
+	resolution := db.applyOverrides(ip, db.query(ip))
+	db.cache.add(ip, resolution, now)
+	return resolution
+}
+
+// update adds the records of the given resource, fetched from the given
+// source, to the database and accounts for them in the given statistics.
+//
+// Invalid records are skipped and counted. If the number of invalid records
+// exceeds the resolver's error budget, the update of the source is aborted.
+//
+// When cross-checking is enabled, the country of each ASN record is looked up
+// in the database being built, which is why the country sources must be
+// updated before the ASN sources. The index is then built before the first
+// lookup of the source, so that it contains the ranges of the previous
+// sources. Otherwise, it's only built once all the sources are loaded.
+func (r *Resolver) update(
+	db *database,
+	stats *SourceStats,
+	pool *stringPool,
+	src source,
+	resource *Resource,
+) error {
+	var (
+		errs      []error
+		entrySize = db.entrySize()
+		built     bool
+	)
+	for entry, err := range src.decode(resource.Data) {
 		if err != nil {
-			errs = append(errs, err)
+			stats.Invalid++
+			if len(errs) < maxReportedErrors {
+				errs = append(errs, err)
+			}
+			if stats.Invalid > r.options.MaxInvalidRecords {
+				return errors.Join(
+					append([]error{ErrTooManyInvalidRecords}, errs...)...,
+				)
+			}
 			continue
 		}
-		db.Insert(
+
+		if db.countries != nil && entry.Resolution.ASN != AS0 {
+			if !built {
+				db.build()
+				built = true
+			}
+			country := db.query(entry.StartIP)
+			db.countries.add(entry.Resolution.ASN, country.CountryCode)
+		}
+
+		if entry.Resolution.CountryCode != "" {
+			db.geoRecords++
+		}
+		if entry.Resolution.Registry != "" {
+			db.registries = true
+		}
+		stats.Memory += entrySize + pool.internResolution(&entry.Resolution)
+		db.index.Insert(
 			itree.NewInterval(entry.StartIP, entry.EndIP),
 			entry.Resolution,
 		)
+		stats.add(entry)
 	}
-	return errors.Join(errs...)
-}
-
-// fetchCSV returns the CSV records fetched from the given URL.
-func fetchCSV(url string) ([][]string, error) {
-	resp, err := http.Get(url) // #nosec G107
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return csv.NewReader(resp.Body).ReadAll()
+	return nil
 }
 
 // parseCountryRecord parses a country database record.
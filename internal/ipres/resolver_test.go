@@ -2,11 +2,18 @@ package ipres_test
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"maps"
 	"net/http"
 	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/danroc/geoblock/internal/ipres"
 )
@@ -56,11 +63,20 @@ func withRT(rt http.RoundTripper, f func()) {
 	f()
 }
 
+func newResolver() *ipres.Resolver {
+	return ipres.NewResolver(
+		ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+		ipres.Options{},
+	)
+}
+
 func TestUpdateError(t *testing.T) {
 	withRT(newErrRT(), func() {
-		r := ipres.NewResolver()
-		if err := r.Update(); err == nil {
-			t.Fatal("expected an error, got nil")
+		r := newResolver()
+		err := r.Update()
+		if !errors.Is(err, ipres.ErrDatabaseUnavailable) {
+			t.Fatalf("got error %v, want %v", err,
+				ipres.ErrDatabaseUnavailable)
 		}
 	})
 }
@@ -80,27 +96,529 @@ func TestResolve(t *testing.T) {
 			{"1:2::", "FR", "Test4", 4},
 			{"1:4::", "", "", ipres.AS0},
 		}
-		r := ipres.NewResolver()
+		for _, index := range []string{ipres.IndexTree, ipres.IndexFlat} {
+			r := ipres.NewResolver(
+				ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+				ipres.Options{Index: index},
+			)
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+			for _, tt := range tests {
+				t.Run(index+"/"+tt.ip, func(t *testing.T) {
+					result := r.Resolve(netip.MustParseAddr(tt.ip))
+					if result.CountryCode != tt.country {
+						t.Errorf("got %q, want %q", result.CountryCode,
+							tt.country)
+					}
+					if result.ASN != tt.asn {
+						t.Errorf("got %q, want %q", result.ASN, tt.asn)
+					}
+					if result.Organization != tt.org {
+						t.Errorf("got %q, want %q", result.Organization,
+							tt.org)
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestResolveCacheInvalidation(t *testing.T) {
+	r := newResolver()
+	ip := netip.MustParseAddr("1.0.0.1")
+
+	for _, country := range []string{"US", "FR"} {
+		dbs := map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.2.2," + country + "\n",
+		}
+		withRT(newRTWithDBs(dbs), func() {
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+		})
+
+		// The second resolution comes from the cache, which must not
+		// survive the update.
+		for range 2 {
+			if got := r.Resolve(ip).CountryCode; got != country {
+				t.Errorf("Resolve() = %q, want %q", got, country)
+			}
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	r := newResolver()
+	if !r.Empty() {
+		t.Error("Empty() = false before the first update")
+	}
+
+	// The ASN databases alone don't provide any country data.
+	dbs := map[string]string{
+		ipres.ASNIPv4URL: "1.0.0.0,1.0.2.2,1,Test1\n",
+	}
+	withRT(newRTWithDBs(dbs), func() {
 		if err := r.Update(); err != nil {
 			t.Fatal(err)
 		}
-		for _, tt := range tests {
-			t.Run(tt.ip, func(t *testing.T) {
-				result := r.Resolve(netip.MustParseAddr(tt.ip))
-				if result.CountryCode != tt.country {
-					t.Errorf("got %q, want %q", result.CountryCode, tt.country)
+	})
+	if !r.Empty() {
+		t.Error("Empty() = false without country records")
+	}
+	if err := r.Ready(); !errors.Is(err, ipres.ErrNotReady) {
+		t.Errorf("Ready() = %v, want %v", err, ipres.ErrNotReady)
+	}
+
+	withRT(newDummyRT(), func() {
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if r.Empty() {
+		t.Error("Empty() = true with country records")
+	}
+	if err := r.Ready(); err != nil {
+		t.Errorf("Ready() = %v, want nil", err)
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	// Only the country databases can be fetched.
+	partialRT := &mockRT{
+		respond: func(req *http.Request) (*http.Response, error) {
+			if strings.Contains(req.URL.String(), "asn") {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return newDummyRT().RoundTrip(req)
+		},
+	}
+	ip := netip.MustParseAddr("1.0.0.1")
+
+	tests := []struct {
+		name        string
+		keepPartial bool
+		country     string
+	}{
+		{"discard partial", false, ""},
+		{"keep partial", true, "US"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ipres.NewResolver(
+				ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+				ipres.Options{KeepPartial: tt.keepPartial},
+			)
+			if !r.Degraded() {
+				t.Error("Degraded() = false before the first update")
+			}
+
+			withRT(partialRT, func() {
+				if err := r.Update(); err == nil {
+					t.Fatal("expected an error, got nil")
 				}
-				if result.ASN != tt.asn {
-					t.Errorf("got %q, want %q", result.ASN, tt.asn)
+			})
+			if !r.Degraded() {
+				t.Error("Degraded() = false after a failed update")
+			}
+			if got := r.Resolve(ip).CountryCode; got != tt.country {
+				t.Errorf("Resolve() = %q, want %q", got, tt.country)
+			}
+
+			withRT(newDummyRT(), func() {
+				if err := r.Update(); err != nil {
+					t.Fatal(err)
 				}
-				if result.Organization != tt.org {
-					t.Errorf("got %q, want %q", result.Organization, tt.org)
+			})
+			if r.Degraded() {
+				t.Error("Degraded() = true after a successful update")
+			}
+
+			// The failed sources keep their previous content.
+			withRT(partialRT, func() {
+				if err := r.Update(); err == nil {
+					t.Fatal("expected an error, got nil")
 				}
 			})
+			if r.Degraded() || r.Resolve(ip).ASN == 0 {
+				t.Error("complete databases replaced by a failed update")
+			}
+		})
+	}
+}
+
+func TestUpdateKeepsFailedSources(t *testing.T) {
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.2.2,US\n",
+		ipres.ASNIPv4URL:     "1.0.0.0,1.0.2.2,1,Test1\n",
+	}
+	down := false
+	rt := &mockRT{
+		respond: func(req *http.Request) (*http.Response, error) {
+			if down && strings.Contains(req.URL.String(), "asn") {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return newRTWithDBs(dbs).RoundTrip(req)
+		},
+	}
+	ip := netip.MustParseAddr("1.0.0.1")
+
+	withRT(rt, func() {
+		r := newResolver()
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name    string
+			down    bool
+			asn     string
+			country string
+			err     error
+		}{
+			{
+				"unavailable source", true, "", "FR",
+				ipres.ErrDatabaseUnavailable,
+			},
+			{
+				"invalid source", false, "invalid\n", "DE",
+				ipres.ErrTooManyInvalidRecords,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				dbs[ipres.CountryIPv4URL] = "1.0.0.0,1.0.2.2," +
+					tt.country + "\n"
+				dbs[ipres.ASNIPv4URL] = tt.asn
+				down = tt.down
+				if err := r.Update(); !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+
+				// The fresh sources are loaded, and the failed ones keep
+				// their previous content.
+				got := r.Resolve(ip)
+				if got.CountryCode != tt.country || got.ASN != 1 {
+					t.Errorf("got %+v, want country %s and ASN 1",
+						got, tt.country)
+				}
+			})
+		}
+	})
+}
+
+func TestResolveWithoutASN(t *testing.T) {
+	// The ASN databases must not be fetched at all.
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.2.2,US\n",
+		ipres.CountryIPv6URL: "",
+		ipres.ASNIPv4URL:     "invalid",
+		ipres.ASNIPv6URL:     "invalid",
+	}
+
+	withRT(newRTWithDBs(dbs), func() {
+		r := ipres.NewResolver(
+			ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+			ipres.Options{DisableASN: true},
+		)
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+
+		result := r.Resolve(netip.MustParseAddr("1.0.1.1"))
+		if result.CountryCode != "US" {
+			t.Errorf("got %q, want %q", result.CountryCode, "US")
+		}
+		if result.ASN != ipres.AS0 {
+			t.Errorf("got %d, want %d", result.ASN, ipres.AS0)
 		}
 	})
 }
 
+func TestSourceURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ipres.Options
+		want    map[string][]string
+	}{
+		{
+			name:    "csv without asn",
+			options: ipres.Options{DisableASN: true},
+			want: map[string][]string{
+				ipres.SourceCountryIPv4: {
+					ipres.CountryIPv4URL, ipres.CountryIPv4MirrorURL,
+				},
+				ipres.SourceCountryIPv6: {
+					ipres.CountryIPv6URL, ipres.CountryIPv6MirrorURL,
+				},
+			},
+		},
+		{
+			name: "mmdb with monitors",
+			options: ipres.Options{
+				Format:     ipres.FormatMMDB,
+				CountryURL: "https://example.com/country.mmdb",
+				ASNURL:     "https://example.com/asn.mmdb",
+				Monitors:   []string{ipres.MonitorUptimeRobot},
+			},
+			want: map[string][]string{
+				ipres.SourceCountryMMDB: {"https://example.com/country.mmdb"},
+				ipres.SourceASNMMDB:     {"https://example.com/asn.mmdb"},
+				ipres.SourceUptimeRobot: {ipres.UptimeRobotURL},
+			},
+		},
+		{
+			name: "csv with mirrors",
+			options: ipres.Options{
+				DisableASN: true,
+				URLs: map[string][]string{
+					ipres.SourceCountryIPv4: {
+						"https://mirror.example.com/country-ipv4.csv",
+						ipres.CountryIPv4URL,
+					},
+				},
+			},
+			want: map[string][]string{
+				ipres.SourceCountryIPv4: {
+					"https://mirror.example.com/country-ipv4.csv",
+					ipres.CountryIPv4URL,
+				},
+				ipres.SourceCountryIPv6: {
+					ipres.CountryIPv6URL, ipres.CountryIPv6MirrorURL,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ipres.NewResolver(
+				ipres.NewHTTPFetcher(ipres.HTTPOptions{}), tt.options,
+			)
+			got := r.SourceURLs()
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mirrorFetcher is a mock fetcher that fails for the URLs that are down,
+// delays the slow ones and records the fetched URLs.
+type mirrorFetcher struct {
+	mockFetcher
+	down    map[string]bool
+	slow    map[string]bool
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (m *mirrorFetcher) Fetch(url string) (*ipres.Resource, error) {
+	m.mu.Lock()
+	m.fetched = append(m.fetched, url)
+	m.mu.Unlock()
+	if m.slow[url] {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if m.down[url] {
+		return nil, errFetch
+	}
+	return m.mockFetcher.Fetch(url)
+}
+
+// fetchedIPv4 returns the fetched URLs, in order, except the IPv6 one, which
+// is fetched concurrently.
+func (m *mirrorFetcher) fetchedIPv4() []string {
+	return slices.DeleteFunc(slices.Clone(m.fetched), func(url string) bool {
+		return url == ipres.CountryIPv6URL
+	})
+}
+
+func TestUpdateMirrors(t *testing.T) {
+	const (
+		down   = "https://down.example.com/country-ipv4.csv"
+		mirror = "https://mirror.example.com/country-ipv4.csv"
+	)
+	fetcher := &mirrorFetcher{
+		mockFetcher: mockFetcher{data: map[string]string{
+			mirror:               "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "",
+		}},
+		down: map[string]bool{down: true},
+	}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN: true,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {down, mirror, ipres.CountryIPv4URL},
+		},
+	})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+	if got != "FR" {
+		t.Errorf("got country %q, want FR", got)
+	}
+	want := []string{down, mirror}
+	if got := fetcher.fetchedIPv4(); !slices.Equal(got, want) {
+		t.Errorf("got fetched URLs %v, want %v", got, want)
+	}
+
+	// The update fails if none of the URLs can be fetched.
+	fetcher.down[mirror] = true
+	fetcher.down[ipres.CountryIPv4URL] = true
+	if err := r.Update(); !errors.Is(err, errFetch) {
+		t.Errorf("got error %v, want %v", err, errFetch)
+	}
+}
+
+func TestUpdateDefaultMirrors(t *testing.T) {
+	fetcher := &mirrorFetcher{
+		mockFetcher: mockFetcher{data: map[string]string{
+			ipres.CountryIPv4MirrorURL: "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL:       "",
+		}},
+		down: map[string]bool{ipres.CountryIPv4URL: true},
+	}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{DisableASN: true})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+	if got != "FR" {
+		t.Errorf("got country %q, want FR", got)
+	}
+}
+
+func TestUpdateFastestURL(t *testing.T) {
+	const (
+		down   = "https://down.example.com/country-ipv4.csv"
+		slow   = "https://slow.example.com/country-ipv4.csv"
+		mirror = "https://mirror.example.com/country-ipv4.csv"
+	)
+	fetcher := &mirrorFetcher{
+		mockFetcher: mockFetcher{data: map[string]string{
+			slow:                 "1.0.0.0,1.0.0.255,FR\n",
+			mirror:               "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "",
+		}},
+		down: map[string]bool{down: true},
+		slow: map[string]bool{slow: true},
+	}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN: true,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {down, slow, mirror},
+			ipres.SourceCountryIPv6: {ipres.CountryIPv6URL},
+		},
+		URLOrder: ipres.URLOrderFastest,
+	})
+
+	// The URLs that were never fetched are tried first, then the fastest
+	// ones, and the ones that failed last.
+	want := [][]string{{down, slow}, {mirror}, {mirror}}
+	for i, urls := range want {
+		fetcher.fetched = nil
+		if err := r.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if got := fetcher.fetchedIPv4(); !slices.Equal(got, urls) {
+			t.Errorf("update %d: got fetched URLs %v, want %v", i, got, urls)
+		}
+	}
+
+	got := r.SourceURLs()[ipres.SourceCountryIPv4]
+	if urls := []string{mirror, slow, down}; !slices.Equal(got, urls) {
+		t.Errorf("got URLs %v, want %v", got, urls)
+	}
+}
+
+func TestUpdateNotModified(t *testing.T) {
+	var (
+		dbs = map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "",
+		}
+		downloads atomic.Int32
+	)
+	rt := &mockRT{
+		respond: func(req *http.Request) (*http.Response, error) {
+			body := dbs[req.URL.String()]
+			etag := strconv.Quote(body)
+			if req.Header.Get("If-None-Match") == etag {
+				return &http.Response{
+					StatusCode: http.StatusNotModified,
+					Body:       io.NopCloser(bytes.NewBufferString("")),
+				}, nil
+			}
+			downloads.Add(1)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Etag": {etag}},
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	withRT(rt, func() {
+		r := ipres.NewResolver(
+			ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+			ipres.Options{DisableASN: true},
+		)
+		tests := []struct {
+			name      string
+			country   string
+			downloads int32
+		}{
+			{"first update", "FR", 2},
+			{"unchanged", "FR", 0},
+			{"changed", "DE", 2},
+		}
+		for _, tt := range tests {
+			if tt.name == "changed" {
+				dbs[ipres.CountryIPv4URL] = "1.0.0.0,1.0.0.255,DE\n"
+			}
+			downloads.Store(0)
+			if err := r.Update(); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			ip := netip.MustParseAddr("1.0.0.1")
+			if got := r.Resolve(ip).CountryCode; got != tt.country {
+				t.Errorf("%s: got country %q, want %q", tt.name, got,
+					tt.country)
+			}
+			if got := downloads.Load(); got != tt.downloads {
+				t.Errorf("%s: got %d downloads, want %d", tt.name,
+					got, tt.downloads)
+			}
+		}
+	})
+}
+
+func TestUpdateGzip(t *testing.T) {
+	fetcher := &mockFetcher{data: map[string]string{
+		ipres.CountryIPv4URL: compress(t, "gzip", "1.0.0.0,1.0.0.255,FR\n"),
+		ipres.CountryIPv6URL: "",
+	}}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{DisableASN: true})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+	if got != "FR" {
+		t.Errorf("got country %q, want FR", got)
+	}
+
+	fetcher.data[ipres.CountryIPv4URL] = "\x1f\x8binvalid"
+	if err := r.Update(); err == nil {
+		t.Error("expected an error, got nil")
+	}
+}
+
 func TestUpdateInvalidData(t *testing.T) {
 	tests := []struct {
 		dbs    map[string]string
@@ -209,7 +727,7 @@ func TestUpdateInvalidData(t *testing.T) {
 
 	for _, tt := range tests {
 		withRT(newRTWithDBs(tt.dbs), func() {
-			r := ipres.NewResolver()
+			r := newResolver()
 			err := r.Update()
 			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
 				t.Errorf("got %v, want %v", err, tt.errMsg)
@@ -217,3 +735,52 @@ func TestUpdateInvalidData(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateErrorBudget(t *testing.T) {
+	dbs := map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.2.2,US\n" +
+			"invalid\n" +
+			"1.2.0.0,bare\"quote,US\n" +
+			"1.1.0.0,1.1.2.2,FR\n",
+		ipres.CountryIPv6URL: "1:0::,1:1::,US\n",
+		ipres.ASNIPv4URL:     "1.0.0.0,1.0.2.2,1,Test1\n",
+		ipres.ASNIPv6URL:     "1:0::,1:1::,3,Test3\n",
+	}
+
+	tests := []struct {
+		name    string
+		budget  int
+		wantErr bool
+	}{
+		{"no budget", 0, true},
+		{"budget too small", 1, true},
+		{"budget large enough", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withRT(newRTWithDBs(dbs), func() {
+				r := ipres.NewResolver(
+					ipres.NewHTTPFetcher(ipres.HTTPOptions{}),
+					ipres.Options{MaxInvalidRecords: tt.budget},
+				)
+				err := r.Update()
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					if !errors.Is(err, ipres.ErrTooManyInvalidRecords) {
+						t.Errorf("got %v, want %v", err,
+							ipres.ErrTooManyInvalidRecords)
+					}
+					return
+				}
+
+				res := r.Resolve(netip.MustParseAddr("1.1.1.1"))
+				if res.CountryCode != "FR" {
+					t.Errorf("got %q, want %q", res.CountryCode, "FR")
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package ipres
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// snapshotVersion is the version of the format of the snapshot files. It must
+// be incremented when DBRecord or Resolution changes, since gob silently
+// ignores the fields it doesn't know.
+const snapshotVersion = 2
+
+// ErrSnapshotVersion is returned when a snapshot file has another version
+// than snapshotVersion.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// snapshotHeader is the first value of a snapshot file.
+type snapshotHeader struct {
+	Version int
+}
+
+// snapshotSource is a source of a snapshot file: the valid records parsed
+// from the content of the source with the given URL and validators, and the
+// number of invalid records that were skipped.
+type snapshotSource struct {
+	Name       string
+	URL        string
+	Validators Validators
+	Invalid    int
+	Records    []DBRecord
+}
+
+// snapshot contains the sources of a snapshot file, by name.
+type snapshot map[string]*snapshotSource
+
+// versions returns the versions of the sources of the snapshot, by name.
+func (s snapshot) versions() map[string]sourceVersion {
+	versions := make(map[string]sourceVersion, len(s))
+	for name, src := range s {
+		versions[name] = sourceVersion{src.URL, src.Validators}
+	}
+	return versions
+}
+
+// decodeSnapshot returns a decoder yielding the given records, whatever the
+// content it's given.
+func decodeSnapshot(records []DBRecord) DecodeFn {
+	return func([]byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			for i := range records {
+				if !yield(&records[i], nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// readSnapshot reads the snapshot file of the resolver, on startup. It
+// returns nil if there is none, if the resolver was already updated, or if
+// the snapshot doesn't contain the current sources or their current URLs.
+func (r *Resolver) readSnapshot(sources []source) snapshot {
+	if r.options.SnapshotFile == "" || !r.degraded.Load() {
+		return nil
+	}
+
+	snap, err := readSnapshotFile(r.options.SnapshotFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).Warn("Cannot read database snapshot")
+		}
+		return nil
+	}
+	for _, src := range sources {
+		saved, ok := snap[src.name]
+		if !ok || !slices.Contains(r.urls(src), saved.URL) ||
+			saved.Validators == (Validators{}) {
+			log.WithField(
+				"source", src.name,
+			).Info("Database snapshot is stale")
+			return nil
+		}
+	}
+	return snap
+}
+
+// readSnapshotFile reads the sources of the given snapshot file.
+func readSnapshotFile(path string) (snapshot, error) {
+	file, err := os.Open(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		decoder = gob.NewDecoder(file)
+		header  snapshotHeader
+	)
+	if err := decoder.Decode(&header); err != nil {
+		return nil, err
+	}
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, header.Version)
+	}
+
+	snap := make(snapshot)
+	for {
+		var src snapshotSource
+		err := decoder.Decode(&src)
+		if errors.Is(err, io.EOF) {
+			return snap, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		snap[src.Name] = &src
+	}
+}
+
+// restore loads the databases from the given snapshot, whose sources were
+// found unchanged with the given versions.
+func (r *Resolver) restore(
+	sources []source,
+	snap snapshot,
+	versions map[string]sourceVersion,
+) {
+	var (
+		restored  = make([]source, 0, len(sources))
+		resources = make(map[string]*Resource, len(sources))
+	)
+	for _, src := range sources {
+		src.decode = decodeSnapshot(snap[src.name].Records)
+		restored = append(restored, src)
+		resources[src.name] = &Resource{}
+	}
+
+	// The snapshot only contains valid records, so its sources don't fail.
+	db, stats, pool, _ := r.build(restored, resources, nil)
+	for _, src := range sources {
+		stats[src.name].Invalid = snap[src.name].Invalid
+	}
+	r.commit(sources, db, stats, pool, resources, versions, nil)
+	log.Info("Databases loaded from snapshot")
+}
+
+// snapshotWriter writes a snapshot file, one source at a time, so that the
+// records of a single source are held in memory. The file is written to a
+// temporary file, which replaces the snapshot file once all the sources are
+// written. Its methods are no-ops on a nil writer.
+type snapshotWriter struct {
+	path     string
+	versions map[string]sourceVersion
+	file     *os.File
+	encoder  *gob.Encoder
+	current  *snapshotSource
+	err      error
+}
+
+// newSnapshotWriter creates a writer of the snapshot file of the resolver, for
+// the sources with the given versions, by name. It returns nil if the
+// snapshots are disabled.
+func (r *Resolver) newSnapshotWriter(
+	versions map[string]sourceVersion,
+) *snapshotWriter {
+	if r.options.SnapshotFile == "" {
+		return nil
+	}
+
+	w := &snapshotWriter{path: r.options.SnapshotFile, versions: versions}
+	w.file, w.err = os.CreateTemp(filepath.Dir(w.path), "*.tmp")
+	if w.err == nil {
+		w.encoder = gob.NewEncoder(w.file)
+		w.err = w.encoder.Encode(snapshotHeader{Version: snapshotVersion})
+	}
+	return w
+}
+
+// recording starts the given source, and returns a decoder that yields the
+// records of the given decoder and records the valid ones once they're
+// loaded, so that their strings are interned.
+func (w *snapshotWriter) recording(name string, decode DecodeFn) DecodeFn {
+	src := &snapshotSource{
+		Name:       name,
+		URL:        w.versions[name].url,
+		Validators: w.versions[name].validators,
+	}
+	w.current = src
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			for entry, err := range decode(data) {
+				if !yield(entry, err) {
+					return
+				}
+				if err == nil {
+					src.Records = append(src.Records, *entry)
+				}
+			}
+		}
+	}
+}
+
+// add writes the current source, with the given number of invalid records,
+// and releases its records.
+func (w *snapshotWriter) add(invalid int) {
+	if w == nil || w.current == nil {
+		return
+	}
+	src := w.current
+	w.current = nil
+	if w.err == nil {
+		src.Invalid = invalid
+		w.err = w.encoder.Encode(src)
+	}
+}
+
+// abort removes the temporary file.
+func (w *snapshotWriter) abort() {
+	if w == nil || w.file == nil {
+		return
+	}
+	w.file.Close()           // #nosec G104
+	os.Remove(w.file.Name()) // #nosec G104
+}
+
+// save replaces the snapshot file with the written sources. Since the
+// snapshot is only an optimization, its errors are only logged.
+func (w *snapshotWriter) save() {
+	if w == nil {
+		return
+	}
+	if err := w.commit(); err != nil {
+		log.WithError(err).Warn("Cannot save database snapshot")
+	}
+}
+
+// commit replaces the snapshot file with the written sources.
+func (w *snapshotWriter) commit() error {
+	if w.err != nil {
+		w.abort()
+		return w.err
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.file.Name()) // #nosec G104
+		return err
+	}
+	return os.Rename(w.file.Name(), w.path)
+}
@@ -0,0 +1,100 @@
+package ipres_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// countingFetcher is a mock conditional fetcher that also counts the
+// unconditional fetches.
+type countingFetcher struct {
+	conditionalFetcher
+	fetches atomic.Int32
+}
+
+func (m *countingFetcher) Fetch(url string) (*ipres.Resource, error) {
+	m.fetches.Add(1)
+	return m.conditionalFetcher.Fetch(url)
+}
+
+func TestUpdateSnapshot(t *testing.T) {
+	var (
+		file    = filepath.Join(t.TempDir(), "snapshot.gob")
+		fetcher = &countingFetcher{}
+		options = ipres.Options{
+			DisableASN:        true,
+			MaxInvalidRecords: 1,
+			SnapshotFile:      file,
+		}
+	)
+	fetcher.data = map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\ninvalid\n",
+		ipres.CountryIPv6URL: "1::,1::ff,DE\n",
+	}
+	if err := ipres.NewResolver(fetcher, options).Update(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Fatalf("got %v, want a snapshot file", err)
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(options *ipres.Options)
+		fetches int32
+	}{
+		{"unchanged", func(*ipres.Options) {}, 0},
+		{"other URL", func(options *ipres.Options) {
+			options.URLs = map[string][]string{
+				ipres.SourceCountryIPv6: {ipres.CountryIPv6URL + "?v=2"},
+			}
+			fetcher.data[ipres.CountryIPv6URL+"?v=2"] = "1::,1::ff,DE\n"
+		}, 2},
+		{"corrupted", func(*ipres.Options) {
+			err := os.WriteFile(file, []byte("invalid"), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := options
+			tt.prepare(&options)
+			fetcher.fetches.Store(0)
+
+			r := ipres.NewResolver(fetcher, options)
+			if err := r.Update(); err != nil {
+				t.Fatal(err)
+			}
+			if got := fetcher.fetches.Load(); got != tt.fetches {
+				t.Errorf("got %d fetches, want %d", got, tt.fetches)
+			}
+			if r.Degraded() {
+				t.Error("got a degraded resolver")
+			}
+			for ip, want := range map[string]string{
+				"1.0.0.1": "FR",
+				"1::1":    "DE",
+			} {
+				got := r.Resolve(netip.MustParseAddr(ip)).CountryCode
+				if got != want {
+					t.Errorf("got country %q for %s, want %q", got, ip, want)
+				}
+			}
+
+			for _, diff := range r.Diff() {
+				if diff.After != 1 {
+					t.Errorf("got %d records for %s, want 1", diff.After,
+						diff.Source)
+				}
+			}
+		})
+	}
+}
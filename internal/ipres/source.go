@@ -0,0 +1,289 @@
+package ipres
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math"
+	"slices"
+	"time"
+)
+
+// failedFetch is the duration recorded for the URLs whose last fetch failed,
+// so that they're tried last with URLOrderFastest.
+const failedFetch = time.Duration(math.MaxInt64)
+
+// defaultMirrors are the URLs tried, by source name, when the default URL of
+// a source can't be fetched.
+var defaultMirrors = map[string][]string{
+	SourceCountryIPv4: {CountryIPv4MirrorURL},
+	SourceCountryIPv6: {CountryIPv6MirrorURL},
+	SourceASNIPv4:     {ASNIPv4MirrorURL},
+	SourceASNIPv6:     {ASNIPv6MirrorURL},
+	SourceCityIPv4:    {CityIPv4MirrorURL},
+	SourceCityIPv6:    {CityIPv6MirrorURL},
+}
+
+// DecodeFn decodes the raw content of a database into database records. For
+// each invalid record, a nil record and the corresponding error are yielded.
+type DecodeFn func(data []byte) iter.Seq2[*DBRecord, error]
+
+// source describes a database source.
+type source struct {
+	name   string
+	url    string
+	decode DecodeFn
+}
+
+// decodeCSV returns a decoder for CSV databases that uses the given parser to
+// parse each record. Gzip-compressed files are also accepted.
+func decodeCSV(parser ParserFn) DecodeFn {
+	return func(data []byte) iter.Seq2[*DBRecord, error] {
+		return func(yield func(*DBRecord, error) bool) {
+			data, err := gunzip(data)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			// The number of fields is checked by the parsers, so that records
+			// with an unexpected length are reported like any other parsing
+			// error.
+			reader := csv.NewReader(bytes.NewReader(data))
+			reader.FieldsPerRecord = -1
+
+			for {
+				record, err := reader.Read()
+				if errors.Is(err, io.EOF) {
+					return
+				}
+
+				var entry *DBRecord
+				if err == nil {
+					entry, err = parser(record)
+				}
+				if !yield(entry, err) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// csvSources returns the sources of the CSV country and ASN databases. The
+// city databases, which also contain the countries, replace the country
+// databases if city is true. The ASN databases are only included if asn is
+// true.
+func csvSources(city, asn bool) []source {
+	sources := []source{
+		{SourceCountryIPv4, CountryIPv4URL, decodeCSV(parseCountryRecord)},
+		{SourceCountryIPv6, CountryIPv6URL, decodeCSV(parseCountryRecord)},
+	}
+	if city {
+		sources = []source{
+			{SourceCityIPv4, CityIPv4URL, decodeCSV(parseCityRecord)},
+			{SourceCityIPv6, CityIPv6URL, decodeCSV(parseCityRecord)},
+		}
+	}
+	if asn {
+		sources = append(sources,
+			source{SourceASNIPv4, ASNIPv4URL, decodeCSV(parseASNRecord)},
+			source{SourceASNIPv6, ASNIPv6URL, decodeCSV(parseASNRecord)},
+		)
+	}
+	return sources
+}
+
+// sources returns the database sources used by the resolver. The country,
+// city and registry sources must come before the ASN sources, see
+// Resolver.update.
+func (r *Resolver) sources() []source {
+	var sources []source
+	if r.options.Format == FormatMMDB {
+		asnURL := r.options.ASNURL
+		if r.options.DisableASN {
+			asnURL = ""
+		}
+		cityURL := r.options.CityURL
+		if !r.options.City {
+			cityURL = ""
+		}
+		sources = mmdbSources(r.options.CountryURL, cityURL, asnURL)
+	} else {
+		sources = csvSources(r.options.City, !r.options.DisableASN)
+	}
+	if registries := registrySources(r.options.Registries); registries != nil {
+		i := slices.IndexFunc(sources, isASNSource)
+		if i < 0 {
+			i = len(sources)
+		}
+		sources = slices.Insert(sources, i, registries...)
+	}
+	if r.options.CDN {
+		sources = append(sources, cdnSources()...)
+	}
+	sources = append(sources, monitorSources(r.options.Monitors)...)
+	sources = append(sources, anonymizerSources(r.options.Anonymizers)...)
+	return sources
+}
+
+// urls returns the URLs of the given source, in the order in which they're
+// tried. The configured URLs of a source replace its default URL and mirrors.
+//
+// With URLOrderFastest, the URLs are sorted by the duration of their last
+// fetch. The URLs that were never fetched come first, so that each of them is
+// eventually measured, and the ones whose last fetch failed come last.
+func (r *Resolver) urls(src source) []string {
+	urls := r.options.URLs[src.name]
+	if len(urls) == 0 {
+		urls = append([]string{src.url}, defaultMirrors[src.name]...)
+	}
+	if r.options.URLOrder != URLOrderFastest {
+		return urls
+	}
+
+	r.latencyMu.Lock()
+	defer r.latencyMu.Unlock()
+
+	urls = slices.Clone(urls)
+	slices.SortStableFunc(urls, func(a, b string) int {
+		return cmp.Compare(r.latencies[a], r.latencies[b])
+	})
+	return urls
+}
+
+// recordLatency records the duration of the last fetch of the given URL, or
+// failedFetch if it failed.
+func (r *Resolver) recordLatency(
+	url string,
+	latency time.Duration,
+	err error,
+) {
+	if err != nil {
+		latency = failedFetch
+	}
+
+	r.latencyMu.Lock()
+	defer r.latencyMu.Unlock()
+
+	if r.latencies == nil {
+		r.latencies = make(map[string]time.Duration)
+	}
+	r.latencies[url] = latency
+}
+
+// sourceVersion is the version of a source loaded by an update: the URL it
+// was fetched from and the validators of its content.
+type sourceVersion struct {
+	url        string
+	validators Validators
+}
+
+// fetchURL fetches the given URL, conditionally to the given validators if
+// there are any, with the FetchTimeout option.
+func (r *Resolver) fetchURL(
+	url string,
+	validators Validators,
+) (*Resource, error) {
+	_, ok := r.fetcher.(ContextFetcher)
+	if !ok && validators == (Validators{}) {
+		return r.fetcher.Fetch(url)
+	}
+	ctx, cancel := context.WithTimeout(
+		context.Background(), r.options.FetchTimeout,
+	)
+	defer cancel()
+	return fetchContext(ctx, r.fetcher, url, validators)
+}
+
+// fetch fetches the given source from the first of its URLs that can be
+// fetched and verified, and returns it with its version. If none can, an
+// ErrDatabaseUnavailable wrapping the errors of all the URLs is returned.
+func (r *Resolver) fetch(src source) (*Resource, sourceVersion, error) {
+	var errs []error
+	for _, url := range r.urls(src) {
+		start := time.Now()
+		resource, err := r.fetchURL(url, Validators{})
+		r.recordLatency(url, time.Since(start), err)
+		if err == nil {
+			err = r.verify(src, resource)
+		}
+		if err == nil {
+			return resource, sourceVersion{url, resource.Validators()}, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, sourceVersion{}, fmt.Errorf(
+		"%w: %s: %w", ErrDatabaseUnavailable, src.name, errors.Join(errs...),
+	)
+}
+
+// fetchModified fetches the given sources conditionally to the given
+// versions, loaded by the last successful update or saved in the snapshot,
+// if the fetcher is a ConditionalFetcher. It returns the fetched sources, by
+// name, and whether none of the sources has changed, in which case the update
+// can be skipped.
+//
+// The sources without validators, or whose conditional fetch or verification
+// fails, aren't returned and must be fetched again.
+func (r *Resolver) fetchModified(
+	sources []source,
+	previous map[string]sourceVersion,
+) (map[string]*Resource, map[string]sourceVersion, bool) {
+	var (
+		resources = make(map[string]*Resource)
+		versions  = make(map[string]sourceVersion, len(sources))
+		unchanged = true
+	)
+	if _, ok := r.fetcher.(ConditionalFetcher); !ok || len(previous) == 0 {
+		return resources, versions, false
+	}
+
+	var (
+		fetched = make([]*Resource, len(sources))
+		errs    = make([]error, len(sources))
+	)
+	r.parallel(len(sources), func(i int) {
+		version, ok := previous[sources[i].name]
+		if !ok || version.validators == (Validators{}) {
+			errs[i] = ErrDatabaseUnavailable
+			return
+		}
+		fetched[i], errs[i] = r.fetchURL(version.url, version.validators)
+		if errs[i] == nil {
+			errs[i] = r.verify(sources[i], fetched[i])
+		}
+	})
+
+	for i, src := range sources {
+		version := previous[src.name]
+		switch {
+		case errors.Is(errs[i], ErrNotModified):
+			versions[src.name] = version
+		case errs[i] == nil:
+			resources[src.name] = fetched[i]
+			versions[src.name] = sourceVersion{
+				version.url, fetched[i].Validators(),
+			}
+			unchanged = false
+		default:
+			unchanged = false
+		}
+	}
+	return resources, versions, unchanged
+}
+
+// SourceURLs returns the URLs of the database sources used by the resolver,
+// by source name, in the order in which they're tried.
+func (r *Resolver) SourceURLs() map[string][]string {
+	urls := make(map[string][]string)
+	for _, source := range r.sources() {
+		urls[source.name] = r.urls(source)
+	}
+	return urls
+}
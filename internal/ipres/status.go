@@ -0,0 +1,147 @@
+package ipres
+
+import (
+	"time"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// SourceStatus is the state of a database source.
+type SourceStatus struct {
+	Name    string
+	URL     string // URL of the loaded content, see RedactURL
+	Records int    // Number of records loaded
+
+	// LastUpdate is the time at which the source was last fetched from its
+	// URL, or found unchanged. It's zero if the source was never fetched.
+	LastUpdate time.Time
+
+	// Cached is true if the loaded content is a cached copy, loaded because
+	// the URLs of the source couldn't be fetched.
+	Cached bool
+
+	// Stale is true if the source was never fetched, or not for longer than
+	// the StaleAfter option.
+	Stale bool
+}
+
+// sourceState is the state of a database source loaded by an update.
+type sourceState struct {
+	url     string
+	records int
+	updated time.Time
+	cached  bool
+	timer   *time.Timer // Marks the source as stale, if StaleAfter is set
+}
+
+// stale checks if the state was never updated or not for longer than the
+// given period, at the given time. A zero period disables the check.
+func (s *sourceState) stale(staleAfter time.Duration, now time.Time) bool {
+	if s.updated.IsZero() {
+		return true
+	}
+	return staleAfter > 0 && now.Sub(s.updated) > staleAfter
+}
+
+// recordSources records the state of the given sources, loaded at the given
+// time from the given resources. The sources not loaded from a cached copy,
+// nor from the content of the last successful update, are marked as updated.
+// The caller must hold the write lock, and call it before replacing the
+// loaded contents.
+func (r *Resolver) recordSources(
+	sources []source,
+	resources map[string]*Resource,
+	stats map[string]*SourceStats,
+	versions map[string]sourceVersion,
+	now time.Time,
+) {
+	if r.sourceStates == nil {
+		r.sourceStates = make(map[string]*sourceState)
+	}
+	for _, src := range sources {
+		state, ok := r.sourceStates[src.name]
+		if !ok {
+			state = &sourceState{}
+			r.sourceStates[src.name] = state
+		}
+		state.url = RedactURL(versions[src.name].url)
+		state.records = stats[src.name].Records
+		state.cached = resources[src.name] != nil &&
+			resources[src.name].Cached
+		if !state.cached && resources[src.name] != r.loaded[src.name] {
+			r.touchSource(src.name, state, now)
+		}
+		metrics.DatabaseSourceCached.WithLabelValues(src.name).Set(
+			boolGauge(state.cached),
+		)
+	}
+}
+
+// touchSource marks the given source as updated at the given time, and
+// schedules it to be marked as stale after the StaleAfter option. The caller
+// must hold the write lock.
+func (r *Resolver) touchSource(
+	name string,
+	state *sourceState,
+	now time.Time,
+) {
+	state.updated = now
+	metrics.DatabaseSourceLastUpdate.WithLabelValues(name).Set(
+		float64(now.Unix()),
+	)
+	metrics.DatabaseSourceStale.WithLabelValues(name).Set(0)
+	if r.options.StaleAfter <= 0 {
+		return
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	state.timer = time.AfterFunc(r.options.StaleAfter, func() {
+		metrics.DatabaseSourceStale.WithLabelValues(name).Set(1)
+	})
+}
+
+// touchSources marks the loaded sources as updated at the given time, after
+// an update finding them unchanged. The caller must hold the write lock.
+func (r *Resolver) touchSources(now time.Time) {
+	for name, state := range r.sourceStates {
+		state.cached = false
+		metrics.DatabaseSourceCached.WithLabelValues(name).Set(0)
+		r.touchSource(name, state, now)
+	}
+}
+
+// boolGauge returns the value of a gauge for the given boolean.
+func boolGauge(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// SourceStatuses returns the state of the database sources used by the
+// resolver, in the order in which they're loaded. The sources that were never
+// loaded only have a name and are stale.
+func (r *Resolver) SourceStatuses() []SourceStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	sources := r.sources()
+	statuses := make([]SourceStatus, 0, len(sources))
+	for _, src := range sources {
+		status := SourceStatus{Name: src.name, Stale: true}
+		if state, ok := r.sourceStates[src.name]; ok {
+			status = SourceStatus{
+				Name:       src.name,
+				URL:        state.url,
+				Records:    state.records,
+				LastUpdate: state.updated,
+				Cached:     state.cached,
+				Stale:      state.stale(r.options.StaleAfter, now),
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
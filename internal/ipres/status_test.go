@@ -0,0 +1,76 @@
+package ipres_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+func TestSourceStatuses(t *testing.T) {
+	var (
+		inner = &mockFetcher{data: map[string]string{
+			ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n",
+			ipres.CountryIPv6URL: "",
+		}}
+		fetcher = ipres.NewCachedFetcher(
+			inner,
+			ipres.CacheOptions{Directory: t.TempDir()},
+		)
+		r = ipres.NewResolver(fetcher, ipres.Options{
+			DisableASN: true,
+			StaleAfter: time.Hour,
+		})
+	)
+
+	statuses := r.SourceStatuses()
+	if len(statuses) != 2 {
+		t.Fatalf("got %d sources, want 2", len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Stale || !status.LastUpdate.IsZero() {
+			t.Errorf("got %+v before the first update, want stale", status)
+		}
+	}
+
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	status := r.SourceStatuses()[0]
+	if status.Name != ipres.SourceCountryIPv4 ||
+		status.URL != ipres.CountryIPv4URL || status.Records != 1 {
+		t.Errorf("got %+v, want 1 record from %s",
+			status, ipres.CountryIPv4URL)
+	}
+	if status.Cached || status.Stale || status.LastUpdate.IsZero() {
+		t.Errorf("got %+v, want an up-to-date source", status)
+	}
+
+	// The cached copy is loaded, but doesn't count as an update.
+	inner.err = errFetch
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	cached := r.SourceStatuses()[0]
+	if !cached.Cached || !cached.LastUpdate.Equal(status.LastUpdate) {
+		t.Errorf("got %+v, want a cached copy updated at %v",
+			cached, status.LastUpdate)
+	}
+}
+
+func TestSourceStatusesStale(t *testing.T) {
+	r := ipres.NewResolver(&mockFetcher{data: map[string]string{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n",
+		ipres.CountryIPv6URL: "",
+	}}, ipres.Options{DisableASN: true, StaleAfter: time.Millisecond})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	for _, status := range r.SourceStatuses() {
+		if !status.Stale {
+			t.Errorf("got %+v, want a stale source", status)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package ipres
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// Results of the database verifications, see metrics.DatabaseVerifications.
+const (
+	VerificationValid   = "valid"
+	VerificationInvalid = "invalid"
+	VerificationError   = "error"
+)
+
+var (
+	// ErrChecksumMismatch is returned when the content of a database doesn't
+	// have the expected SHA-256 checksum.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrInvalidSignature is returned when the signature of the content of a
+	// database isn't valid.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrInvalidChecksumFile is returned when a downloaded checksum file
+	// doesn't start with a hex-encoded SHA-256 checksum.
+	ErrInvalidChecksumFile = errors.New("invalid checksum file")
+)
+
+// Verification is the expected integrity of the content of a database source,
+// checked before the content is loaded. The content must match all the given
+// checksums and signatures.
+type Verification struct {
+	// SHA256 is the hex-encoded SHA-256 checksum of the content.
+	SHA256 string
+
+	// SHA256URL is the URL of a file starting with the hex-encoded SHA-256
+	// checksum of the content, in the format of sha256sum, e.g., the .sha256
+	// files published by MaxMind.
+	SHA256URL string
+
+	// SignatureURL is the URL of the detached Ed25519 signature of the
+	// content, raw or base64-encoded. It's verified with the SignatureKey
+	// option of the resolver.
+	SignatureURL string
+}
+
+// verify checks the given content of the given source against the expected
+// checksums and signature of the source, if any.
+func (r *Resolver) verify(src source, resource *Resource) error {
+	verification, ok := r.options.Verifications[src.name]
+	if !ok {
+		return nil
+	}
+
+	err := r.verifyContent(&verification, resource.Data)
+	result := VerificationValid
+	switch {
+	case errors.Is(err, ErrChecksumMismatch),
+		errors.Is(err, ErrInvalidSignature):
+		result = VerificationInvalid
+	case err != nil:
+		result = VerificationError
+	}
+	metrics.DatabaseVerifications.WithLabelValues(src.name, result).Inc()
+
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", src.name, err)
+	}
+	return nil
+}
+
+// verifyContent checks the given content against the given verification.
+func (r *Resolver) verifyContent(
+	verification *Verification,
+	data []byte,
+) error {
+	if verification.SHA256 != "" {
+		if err := verifyChecksum(data, verification.SHA256); err != nil {
+			return err
+		}
+	}
+
+	if verification.SHA256URL != "" {
+		resource, err := r.fetcher.Fetch(verification.SHA256URL)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(resource.Data))
+		if len(fields) == 0 || !isChecksum(fields[0]) {
+			return ErrInvalidChecksumFile
+		}
+		if err := verifyChecksum(data, fields[0]); err != nil {
+			return err
+		}
+	}
+
+	if verification.SignatureURL != "" {
+		resource, err := r.fetcher.Fetch(verification.SignatureURL)
+		if err != nil {
+			return err
+		}
+		if !verifySignature(r.options.SignatureKey, data, resource.Data) {
+			return ErrInvalidSignature
+		}
+	}
+	return nil
+}
+
+// isChecksum returns true if the given value is a hex-encoded SHA-256
+// checksum.
+func isChecksum(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// verifyChecksum checks that the given data has the given hex-encoded SHA-256
+// checksum, case-insensitively.
+func verifyChecksum(data []byte, expected string) error {
+	if !strings.EqualFold(checksum(data), expected) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// verifySignature returns true if the given signature, raw or
+// base64-encoded, is a valid Ed25519 signature of the given data made with
+// the private key of the given public key.
+func verifySignature(key ed25519.PublicKey, data, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(
+			string(bytes.TrimSpace(signature)),
+		)
+		if err != nil {
+			return false
+		}
+		signature = decoded
+	}
+	return ed25519.Verify(key, data, signature)
+}
@@ -0,0 +1,216 @@
+package ipres_test
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+const (
+	verifiedData = "1.0.0.0,1.0.0.255,FR\n"
+	sha256URL    = "https://example.com/country-ipv4.csv.sha256"
+	signatureURL = "https://example.com/country-ipv4.csv.sig"
+)
+
+// sha256Hex returns the hex-encoded SHA-256 checksum of the given data.
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifiedResolver returns a resolver loading the given country data with
+// the given verification, and the files of the verification.
+func verifiedResolver(
+	data string,
+	files map[string]string,
+	verification ipres.Verification,
+	key ed25519.PublicKey,
+) *ipres.Resolver {
+	fetcher := &mockFetcher{data: map[string]string{
+		ipres.CountryIPv4URL: data,
+		ipres.CountryIPv6URL: "",
+	}}
+	for url, content := range files {
+		fetcher.data[url] = content
+	}
+	return ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN: true,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {ipres.CountryIPv4URL},
+		},
+		Verifications: map[string]ipres.Verification{
+			ipres.SourceCountryIPv4: verification,
+		},
+		SignatureKey: key,
+	})
+}
+
+func TestUpdateVerifyChecksum(t *testing.T) {
+	checksum := sha256Hex(verifiedData)
+
+	tests := []struct {
+		name         string
+		data         string
+		files        map[string]string
+		verification ipres.Verification
+		want         error
+	}{
+		{
+			"valid checksum",
+			verifiedData,
+			nil,
+			ipres.Verification{SHA256: checksum},
+			nil,
+		},
+		{
+			"uppercase checksum",
+			verifiedData,
+			nil,
+			ipres.Verification{SHA256: strings.ToUpper(checksum)},
+			nil,
+		},
+		{
+			"tampered data",
+			"1.0.0.0,1.0.0.255,US\n",
+			nil,
+			ipres.Verification{SHA256: checksum},
+			ipres.ErrChecksumMismatch,
+		},
+		{
+			"valid checksum file",
+			verifiedData,
+			map[string]string{sha256URL: checksum + "  country-ipv4.csv\n"},
+			ipres.Verification{SHA256URL: sha256URL},
+			nil,
+		},
+		{
+			"mismatching checksum file",
+			verifiedData,
+			map[string]string{sha256URL: sha256Hex("other") + "\n"},
+			ipres.Verification{SHA256URL: sha256URL},
+			ipres.ErrChecksumMismatch,
+		},
+		{
+			"invalid checksum file",
+			verifiedData,
+			map[string]string{sha256URL: "<html>Not found</html>"},
+			ipres.Verification{SHA256URL: sha256URL},
+			ipres.ErrInvalidChecksumFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := verifiedResolver(tt.data, tt.files, tt.verification, nil)
+			err := r.Update()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			want := "FR"
+			if tt.want != nil {
+				want = ""
+			}
+			got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+			if got != want {
+				t.Errorf("got country %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUpdateVerifySignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := ed25519.Sign(privateKey, []byte(verifiedData))
+
+	tests := []struct {
+		name      string
+		data      string
+		signature string
+		key       ed25519.PublicKey
+		want      error
+	}{
+		{"raw signature", verifiedData, string(signature), publicKey, nil},
+		{
+			"base64 signature",
+			verifiedData,
+			base64.StdEncoding.EncodeToString(signature) + "\n",
+			publicKey,
+			nil,
+		},
+		{
+			"tampered data",
+			"1.0.0.0,1.0.0.255,US\n",
+			string(signature),
+			publicKey,
+			ipres.ErrInvalidSignature,
+		},
+		{
+			"other key",
+			verifiedData,
+			string(signature),
+			otherKey,
+			ipres.ErrInvalidSignature,
+		},
+		{
+			"invalid signature",
+			verifiedData,
+			"invalid",
+			publicKey,
+			ipres.ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := verifiedResolver(
+				tt.data,
+				map[string]string{signatureURL: tt.signature},
+				ipres.Verification{SignatureURL: signatureURL},
+				tt.key,
+			)
+			if err := r.Update(); !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateVerifyMirror(t *testing.T) {
+	const tampered = "https://tampered.example.com/country-ipv4.csv"
+	fetcher := &mirrorFetcher{mockFetcher: mockFetcher{data: map[string]string{
+		tampered:             "1.0.0.0,1.0.0.255,US\n",
+		ipres.CountryIPv4URL: verifiedData,
+		ipres.CountryIPv6URL: "",
+	}}}
+
+	r := ipres.NewResolver(fetcher, ipres.Options{
+		DisableASN: true,
+		URLs: map[string][]string{
+			ipres.SourceCountryIPv4: {tampered, ipres.CountryIPv4URL},
+		},
+		Verifications: map[string]ipres.Verification{
+			ipres.SourceCountryIPv4: {SHA256: sha256Hex(verifiedData)},
+		},
+	})
+	if err := r.Update(); err != nil {
+		t.Fatal(err)
+	}
+	got := r.Resolve(netip.MustParseAddr("1.0.0.1")).CountryCode
+	if got != "FR" {
+		t.Errorf("got country %q, want FR", got)
+	}
+}
@@ -0,0 +1,122 @@
+package itree
+
+import "slices"
+
+// Flat is an immutable interval index backed by sorted slices. It's an
+// alternative to ITree that uses less memory, since it has no pointers per
+// interval, and whose lookups are cache-friendly binary searches.
+//
+// The inserted intervals are only queried after a call to Build, which sorts
+// them together with the intervals of the previous builds. Since building is
+// O(n log n), the intervals are meant to be inserted in a few large batches.
+type Flat[K Comparable[K], V any] struct {
+	layers  []layer[K, V]
+	pending []entry[K, V] // Inserted since the last build
+}
+
+// entry is an interval of a Flat index and its value.
+type entry[K Comparable[K], V any] struct {
+	interval Interval[K]
+	value    V
+}
+
+// layer contains non-overlapping intervals sorted by their low value, so that
+// a binary search finds the only one that can contain a key. The bounds are
+// stored apart from the values to keep the searched data contiguous.
+type layer[K Comparable[K], V any] struct {
+	lows   []K
+	highs  []K
+	values []V
+}
+
+// NewFlat creates a new empty flat index.
+func NewFlat[K Comparable[K], V any]() *Flat[K, V] {
+	return &Flat[K, V]{}
+}
+
+// Insert adds an interval to the index. It's queried after the next Build.
+func (f *Flat[K, V]) Insert(interval Interval[K], value V) {
+	f.pending = append(f.pending, entry[K, V]{interval, value})
+}
+
+// Build makes the intervals inserted since the last build queryable.
+//
+// The intervals are sorted by their low value and each of them is placed in
+// the first layer whose last interval ends before it starts. There are few
+// layers when the intervals overlap little, e.g., when they come from a few
+// sources of non-overlapping intervals.
+func (f *Flat[K, V]) Build() {
+	if len(f.pending) == 0 {
+		return
+	}
+
+	entries := f.pending
+	for _, l := range f.layers {
+		for i := range l.lows {
+			entries = append(entries, entry[K, V]{
+				Interval[K]{l.lows[i], l.highs[i]}, l.values[i],
+			})
+		}
+	}
+	f.layers, f.pending = nil, nil
+	slices.SortStableFunc(entries, func(a, b entry[K, V]) int {
+		return a.interval.Low.Compare(b.interval.Low)
+	})
+
+	// The layers are assigned first, so that they're allocated with their
+	// exact sizes.
+	var (
+		assigned = make([]int, len(entries))
+		lasts    []K // High value of the last interval of each layer
+		sizes    []int
+	)
+	for i, e := range entries {
+		index := slices.IndexFunc(lasts, func(high K) bool {
+			return high.Compare(e.interval.Low) < 0
+		})
+		if index < 0 {
+			index = len(lasts)
+			lasts, sizes = append(lasts, e.interval.High), append(sizes, 0)
+		}
+		lasts[index] = e.interval.High
+		sizes[index]++
+		assigned[i] = index
+	}
+
+	f.layers = make([]layer[K, V], len(sizes))
+	for i, size := range sizes {
+		f.layers[i] = layer[K, V]{
+			lows:   make([]K, 0, size),
+			highs:  make([]K, 0, size),
+			values: make([]V, 0, size),
+		}
+	}
+	for i, e := range entries {
+		l := &f.layers[assigned[i]]
+		l.lows = append(l.lows, e.interval.Low)
+		l.highs = append(l.highs, e.interval.High)
+		l.values = append(l.values, e.value)
+	}
+}
+
+// Query returns the values associated with the built intervals that contain
+// the given key.
+func (f *Flat[K, V]) Query(key K) []V {
+	var results []V
+	for i := range f.layers {
+		l := &f.layers[i]
+
+		// Index of the first interval starting after the key: the interval
+		// before it is the only one of the layer that can contain the key.
+		index, _ := slices.BinarySearchFunc(l.lows, key, func(low, key K) int {
+			if low.Compare(key) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		if index > 0 && key.Compare(l.highs[index-1]) <= 0 {
+			results = append(results, l.values[index-1])
+		}
+	}
+	return results
+}
@@ -0,0 +1,174 @@
+package itree_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/itree"
+)
+
+func TestFlatQuery(t *testing.T) {
+	flat := itree.NewFlat[ComparableInt, int]()
+
+	// 1: [------]
+	// 2:          [------------]
+	// 3:                [------------]
+	// 4:                               [------]
+	// 5: [------------------------------------]
+	//    01 02 03 04 05 06 07 08 09 10 11 12 13
+	flat.Insert(itree.NewInterval[ComparableInt](1, 3), 1)
+	flat.Insert(itree.NewInterval[ComparableInt](4, 8), 2)
+	flat.Insert(itree.NewInterval[ComparableInt](6, 10), 3)
+	flat.Insert(itree.NewInterval[ComparableInt](11, 13), 4)
+	flat.Insert(itree.NewInterval[ComparableInt](1, 13), 5)
+	flat.Build()
+
+	// Intervals inserted after a build are queried after the next one.
+	flat.Insert(itree.NewInterval[ComparableInt](3, 3), 6)
+	flat.Insert(itree.NewInterval[ComparableInt](3, 3), 7)
+	if got := flat.Query(3); !slices.Contains(got, 1) ||
+		slices.Contains(got, 6) {
+		t.Fatalf("got %v before the build, want 1 and 5", got)
+	}
+	flat.Build()
+
+	tests := []struct {
+		key     ComparableInt
+		matches []int
+	}{
+		{0, []int{}},
+		{1, []int{1, 5}},
+		{3, []int{1, 5, 6, 7}},
+		{4, []int{2, 5}},
+		{7, []int{2, 3, 5}},
+		{10, []int{3, 5}},
+		{13, []int{4, 5}},
+		{14, []int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("Query(%d)", test.key), func(t *testing.T) {
+			matches := flat.Query(test.key)
+			got := newSet[int]()
+			got.add(matches...)
+
+			want := newSet[int]()
+			want.add(test.matches...)
+
+			if !want.equal(got) || len(matches) != len(test.matches) {
+				t.Errorf("expected %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+// randomIntervals returns n random intervals of up to the given width, in
+// [0, n*width[.
+func randomIntervals(n, width int) []itree.Interval[ComparableInt] {
+	random := rand.New(rand.NewPCG(1, 2)) // #nosec G404
+	intervals := make([]itree.Interval[ComparableInt], n)
+	for i := range intervals {
+		low := random.IntN(n * width)
+		intervals[i] = itree.NewInterval(
+			ComparableInt(low), ComparableInt(low+random.IntN(width)),
+		)
+	}
+	return intervals
+}
+
+func TestFlatMatchesTree(t *testing.T) {
+	var (
+		tree = itree.NewITree[ComparableInt, int]()
+		flat = itree.NewFlat[ComparableInt, int]()
+	)
+	for i, interval := range randomIntervals(1000, 50) {
+		tree.Insert(interval, i)
+		flat.Insert(interval, i)
+	}
+	flat.Build()
+
+	for key := range ComparableInt(1000 * 50) {
+		got, want := flat.Query(key), tree.Query(key)
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Fatalf("Query(%d): got %v, want %v", key, got, want)
+		}
+	}
+}
+
+// benchmarkRanges is the number of ranges of the benchmarks, about the number
+// of ranges of the country and ASN databases.
+const benchmarkRanges = 500_000
+
+// index is the common interface of the benchmarked indexes.
+type index interface {
+	Insert(interval itree.Interval[ComparableInt], value int)
+	Query(key ComparableInt) []int
+}
+
+// benchmarkIndexes returns the benchmarked indexes, filled with the given
+// intervals, by name.
+func benchmarkIndexes(
+	intervals []itree.Interval[ComparableInt],
+) map[string]func() index {
+	return map[string]func() index{
+		"tree": func() index {
+			tree := itree.NewITree[ComparableInt, int]()
+			for i, interval := range intervals {
+				tree.Insert(interval, i)
+			}
+			return tree
+		},
+		"flat": func() index {
+			flat := itree.NewFlat[ComparableInt, int]()
+			for i, interval := range intervals {
+				flat.Insert(interval, i)
+			}
+			flat.Build()
+			return flat
+		},
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	intervals := randomIntervals(benchmarkRanges, 100)
+	for name, build := range benchmarkIndexes(intervals) {
+		b.Run(name, func(b *testing.B) {
+			var (
+				idx    = build()
+				random = rand.New(rand.NewPCG(3, 4)) // #nosec G404
+			)
+			b.ResetTimer()
+			for range b.N {
+				idx.Query(ComparableInt(random.IntN(benchmarkRanges * 100)))
+			}
+		})
+	}
+}
+
+// BenchmarkMemory reports the heap memory used per range by each index.
+func BenchmarkMemory(b *testing.B) {
+	intervals := randomIntervals(benchmarkRanges, 100)
+	for name, build := range benchmarkIndexes(intervals) {
+		b.Run(name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			for range b.N {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				idx := build()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(idx)
+			}
+			b.ReportMetric(
+				float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/
+					benchmarkRanges,
+				"B/range",
+			)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// OtherLabel is the label value of the countries seen after the cardinality
+// limit of Requests is reached, of the domains matching no configured domain
+// pattern, and of the unknown methods.
+const OtherLabel = "other"
+
+// Optional labels of Requests.
+const (
+	LabelCountry = "country"
+	LabelDomain  = "domain"
+	LabelRule    = "rule"
+	LabelMethod  = "method"
+)
+
+// DefaultRequestLabels are the optional labels of Requests used by default.
+var DefaultRequestLabels = []string{LabelCountry, LabelDomain}
+
+// methods are the HTTP methods counted in the method label of Requests. Other
+// methods are counted as OtherLabel, since clients can send any method.
+var methods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// RequestLabels contains the values of the optional labels of a request.
+// Empty values are unknown, e.g., for invalid requests.
+type RequestLabels struct {
+	Country string // Source country code
+	Domain  string // Domain pattern matching the requested domain
+	Method  string // Requested HTTP method
+	Rule    string // Index of the matching rule, if any
+}
+
+// DefaultMaxCountries is the default cardinality limit of the country label
+// of Requests. There are fewer country codes than this limit, so countries are
+// only limited if the limit is lowered.
+const DefaultMaxCountries = 256
+
+// labelLimiter limits the number of distinct values of a label. Once the limit
+// is reached, new values are replaced by OtherLabel, while the values already
+// seen are kept.
+type labelLimiter struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]struct{}
+}
+
+// newLabelLimiter creates a limiter allowing up to max distinct values.
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{max: max, values: make(map[string]struct{})}
+}
+
+// reset changes the maximum number of distinct values and forgets the values
+// seen so far. If zero, the given default is used.
+func (l *labelLimiter) reset(max, fallback int) {
+	if max == 0 {
+		max = fallback
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+	l.values = make(map[string]struct{})
+}
+
+// value returns the label value to use for the given value. Empty values are
+// kept as is.
+func (l *labelLimiter) value(value string) string {
+	if value == "" {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.values[value]; ok {
+		return value
+	}
+	if len(l.values) >= l.max {
+		return OtherLabel
+	}
+	l.values[value] = struct{}{}
+	return value
+}
+
+var countryLabels = newLabelLimiter(DefaultMaxCountries)
+
+// SetMaxCountries sets the maximum number of distinct countries in the labels
+// of Requests. If zero, the default limit is used. It must be called before
+// counting requests, since the countries seen so far are forgotten.
+func SetMaxCountries(countries int) {
+	countryLabels.reset(countries, DefaultMaxCountries)
+}
+
+// requestLabels are the optional labels of Requests. See SetRequestLabels.
+var requestLabels = DefaultRequestLabels
+
+// SetRequestLabels sets the optional labels of Requests, among LabelCountry,
+// LabelDomain, LabelRule and LabelMethod. It must be called before counting
+// requests and serving the metrics, since the requests counted so far are
+// forgotten.
+func SetRequestLabels(labels []string) error {
+	previous := Requests
+	Requests = newRequests(labels)
+	if err := register(constLabels); err != nil {
+		Requests = previous
+		return err
+	}
+	requestLabels = slices.Clone(labels)
+	return nil
+}
+
+// CountRequest counts a request with the given result and labels. Only the
+// labels set with SetRequestLabels are used. The domain is expected to be a
+// configured domain pattern, or OtherLabel, since it isn't limited. Domains
+// are case-insensitive, so they're lowercased, and methods are uppercased.
+func CountRequest(result string, labels RequestLabels) {
+	values := make([]string, 0, len(requestLabels)+1)
+	values = append(values, result)
+	for _, label := range requestLabels {
+		var value string
+		switch label {
+		case LabelCountry:
+			value = countryLabels.value(labels.Country)
+		case LabelDomain:
+			value = strings.ToLower(labels.Domain)
+		case LabelRule:
+			value = labels.Rule
+		case LabelMethod:
+			value = methodLabel(labels.Method)
+		}
+		values = append(values, value)
+	}
+	Requests.WithLabelValues(values...).Inc()
+}
+
+// methodLabel returns the label value of the given method.
+func methodLabel(method string) string {
+	method = strings.ToUpper(method)
+	if method == "" || methods[method] {
+		return method
+	}
+	return OtherLabel
+}
@@ -0,0 +1,98 @@
+package metrics_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+func TestCountRequestLimits(t *testing.T) {
+	metrics.SetMaxCountries(2)
+	defer metrics.SetMaxCountries(0)
+
+	for _, country := range []string{"DE", "FR", "IT", "DE"} {
+		metrics.CountRequest(metrics.ResultDenied, metrics.RequestLabels{
+			Country: country,
+			Domain:  "*.Example.org",
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	body := recorder.Body.String()
+
+	for country, count := range map[string]int{
+		"DE":               2,
+		"FR":               1,
+		metrics.OtherLabel: 1,
+	} {
+		want := fmt.Sprintf(`country=%q,domain="*.example.org"`, country)
+		line := ""
+		for _, l := range strings.Split(body, "\n") {
+			if strings.HasPrefix(l, "geoblock_requests_total{") &&
+				strings.Contains(l, want) {
+				line = l
+			}
+		}
+		if !strings.HasSuffix(line, fmt.Sprintf("} %d", count)) {
+			t.Errorf("country %s: got %q, want count %d", country, line, count)
+		}
+	}
+}
+
+func TestSetRequestLabels(t *testing.T) {
+	err := metrics.SetRequestLabels([]string{
+		metrics.LabelRule, metrics.LabelMethod,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := metrics.SetRequestLabels(metrics.DefaultRequestLabels)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for _, labels := range []metrics.RequestLabels{
+		{Country: "FR", Domain: "a.example.org", Method: "get", Rule: "0"},
+		{Country: "DE", Domain: "b.example.org", Method: "GET", Rule: "0"},
+		{Country: "DE", Domain: "b.example.org", Method: "BREW"},
+	} {
+		metrics.CountRequest(metrics.ResultAllowed, labels)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	body := recorder.Body.String()
+
+	for _, want := range []string{
+		`geoblock_requests_total{method="GET",result="allowed",rule="0"} 2`,
+		`geoblock_requests_total{method="other",result="allowed",rule=""} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't contain %q", want)
+		}
+	}
+	if strings.Contains(body, `country="`) {
+		t.Error("metrics contain the country label")
+	}
+
+	// Duplicate labels are rejected.
+	err = metrics.SetRequestLabels([]string{
+		metrics.LabelRule, metrics.LabelRule,
+	})
+	if err == nil {
+		t.Error("expected an error, got nil")
+	}
+}
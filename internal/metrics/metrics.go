@@ -0,0 +1,386 @@
+// Package metrics contains the Prometheus metrics exported by geoblock.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prefix of all the metrics exported by geoblock.
+const Namespace = "geoblock"
+
+// registry is the registry where all the geoblock metrics are registered.
+var registry = prometheus.NewRegistry()
+
+// CacheSize is the total size, in bytes, of the database cache directory.
+var CacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "cache",
+	Name:      "size_bytes",
+	Help:      "Total size of the database cache directory in bytes.",
+})
+
+// DatabaseRecords is the number of records loaded from each database source
+// during the last successful update.
+var DatabaseRecords = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "records",
+		Help:      "Number of records loaded from each database source.",
+	},
+	[]string{"source"},
+)
+
+// DatabaseChanges is the number of records added and removed from each
+// database source during the last successful update.
+var DatabaseChanges = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "record_changes",
+		Help:      "Number of records added or removed by the last update.",
+	},
+	[]string{"source", "change"},
+)
+
+// DatabaseInvalidRecords is the number of invalid records skipped in each
+// database source during the last successful update.
+var DatabaseInvalidRecords = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "invalid_records",
+		Help:      "Number of invalid records skipped per database source.",
+	},
+	[]string{"source"},
+)
+
+// DatabaseMemory is the estimated memory, in bytes, used by the records of
+// each database source during the last successful update.
+var DatabaseMemory = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "memory_bytes",
+		Help:      "Estimated memory used per database source in bytes.",
+	},
+	[]string{"source"},
+)
+
+// DatabaseStrings is the number of distinct strings of the records loaded
+// during the last successful update, and the number of strings that share
+// the copy of a distinct string.
+var DatabaseStrings = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "interned_strings",
+		Help:      "Number of distinct and deduplicated record strings.",
+	},
+	[]string{"kind"},
+)
+
+// DatabaseVerifications is the number of verifications of the downloaded
+// databases against their expected checksum or signature, by source and by
+// result: "valid", "invalid", or "error" if the checksum or signature can't
+// be downloaded.
+var DatabaseVerifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "verifications_total",
+		Help:      "Number of database verifications by source and result.",
+	},
+	[]string{"source", "result"},
+)
+
+// DatabaseSourceLastUpdate is the Unix time at which each database source was
+// last fetched from one of its URLs, or found unchanged.
+var DatabaseSourceLastUpdate = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "source_last_update_timestamp_seconds",
+		Help:      "Unix time of the last successful update of each source.",
+	},
+	[]string{"source"},
+)
+
+// DatabaseSourceCached is whether the content loaded for each database source
+// is a cached copy, loaded because its URLs couldn't be fetched.
+var DatabaseSourceCached = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "source_cached",
+		Help:      "Whether each source is a cached copy (1) or not (0).",
+	},
+	[]string{"source"},
+)
+
+// DatabaseSourceStale is whether each database source hasn't been updated
+// for longer than the stale period.
+var DatabaseSourceStale = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Subsystem: "database",
+		Name:      "source_stale",
+		Help:      "Whether each source is stale (1) or not (0).",
+	},
+	[]string{"source"},
+)
+
+// Results of the forward-auth requests, used as values of the "result"
+// label of Requests.
+const (
+	ResultAllowed = "allowed"
+	ResultDenied  = "denied"
+	ResultInvalid = "invalid"
+)
+
+// requestsOpts are the options of Requests. They are kept apart so that the
+// generated Prometheus rules use the same metric name.
+var requestsOpts = prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "requests_total",
+	Help:      "Number of requests by result and decision attributes.",
+}
+
+// Requests is the number of forward-auth requests by result and by the
+// optional labels set with SetRequestLabels: by default, the source country
+// and the domain pattern of the requested domain. Use CountRequest to limit
+// the cardinality of the labels.
+var Requests = newRequests(DefaultRequestLabels)
+
+// newRequests creates the Requests metric with the given optional labels.
+func newRequests(labels []string) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		requestsOpts,
+		append([]string{"result"}, labels...),
+	)
+}
+
+// databaseLastUpdateOpts are the options of DatabaseLastUpdate.
+var databaseLastUpdateOpts = prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "database",
+	Name:      "last_update_timestamp_seconds",
+	Help:      "Unix time of the last successful database update.",
+}
+
+// DatabaseLastUpdate is the Unix time of the last successful database update.
+var DatabaseLastUpdate = prometheus.NewGauge(databaseLastUpdateOpts)
+
+// databaseUpdateFailuresOpts are the options of DatabaseUpdateFailures.
+var databaseUpdateFailuresOpts = prometheus.CounterOpts{
+	Namespace: Namespace,
+	Subsystem: "database",
+	Name:      "update_failures_total",
+	Help:      "Number of failed database updates.",
+}
+
+// DatabaseUpdateFailures is the number of failed database updates.
+var DatabaseUpdateFailures = prometheus.NewCounter(databaseUpdateFailuresOpts)
+
+// databaseEmptyOpts are the options of DatabaseEmpty.
+var databaseEmptyOpts = prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "database",
+	Name:      "empty",
+	Help:      "Whether no country data is loaded (1) or not (0).",
+}
+
+// DatabaseEmpty is 1 if the databases contain no country data. Requests are
+// then served without geolocation, so countries conditions never match.
+var DatabaseEmpty = prometheus.NewGauge(databaseEmptyOpts)
+
+// databaseDegradedOpts are the options of DatabaseDegraded.
+var databaseDegradedOpts = prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "database",
+	Name:      "degraded",
+	Help:      "Whether the databases have never been loaded (1) or not (0).",
+}
+
+// DatabaseDegraded is 1 if no database update has succeeded since the start,
+// in which case the failure policy of the databases is applied.
+var DatabaseDegraded = prometheus.NewGauge(databaseDegradedOpts)
+
+// newCountriesOpts are the options of NewCountries.
+var newCountriesOpts = prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "new_countries_total",
+	Help:      "Number of countries seen for the first time per domain.",
+}
+
+// NewCountries is the number of countries from which a sensitive domain
+// received its first allowed request, by domain pattern.
+var NewCountries = prometheus.NewCounterVec(
+	newCountriesOpts,
+	[]string{"domain"},
+)
+
+// breakerTripsOpts are the options of BreakerTrips.
+var breakerTripsOpts = prometheus.CounterOpts{
+	Namespace: Namespace,
+	Subsystem: "circuit_breaker",
+	Name:      "trips_total",
+	Help:      "Number of times the circuit breaker opened per domain.",
+}
+
+// BreakerTrips is the number of times the circuit breaker of a domain pattern
+// opened, by domain pattern.
+var BreakerTrips = prometheus.NewCounterVec(
+	breakerTripsOpts,
+	[]string{"domain"},
+)
+
+// BreakerDenied is the number of requests denied by an open circuit breaker,
+// without resolution nor rule evaluation, by domain pattern.
+var BreakerDenied = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "circuit_breaker",
+		Name:      "denied_total",
+		Help:      "Number of requests denied by an open circuit breaker.",
+	},
+	[]string{"domain"},
+)
+
+// ResolutionCacheLookups is the number of lookups of the resolution cache by
+// result, "hit" or "miss".
+var ResolutionCacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "resolution_cache",
+		Name:      "lookups_total",
+		Help:      "Number of lookups of the resolution cache by result.",
+	},
+	[]string{"result"},
+)
+
+// ConfigGeneration is the generation of the access control configuration,
+// incremented each time the configuration is reloaded or promoted.
+var ConfigGeneration = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "config",
+	Name:      "generation",
+	Help:      "Generation of the access control configuration.",
+})
+
+// RulesEvaluated is the number of rules evaluated to decide each request, by
+// result: the position of the matching rule, starting from 1, or the number
+// of rules if the default policy applies. Rules matching many requests should
+// come first, so that most requests are decided early.
+var RulesEvaluated = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "rules",
+		Name:      "evaluated",
+		Help:      "Number of rules evaluated to decide a request.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+	},
+	[]string{"result"},
+)
+
+// WebhookNotifications is the number of notifications of the rule webhooks
+// by result: "sent", "failed", "limited" by the rate limit of the webhook or
+// "dropped" because too many notifications were pending.
+var WebhookNotifications = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "webhook",
+		Name:      "notifications_total",
+		Help:      "Number of notifications of the rule webhooks by result.",
+	},
+	[]string{"result"},
+)
+
+// TLSCertificateExpiry is the Unix time at which the TLS certificate obtained
+// with the ACME protocol expires.
+var TLSCertificateExpiry = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Subsystem: "tls",
+	Name:      "certificate_expiry_timestamp_seconds",
+	Help:      "Unix time at which the ACME TLS certificate expires.",
+})
+
+// InstanceLabel is the name of the label identifying the geoblock instance.
+// It's not named "instance" to avoid clashing with the target label set by
+// Prometheus.
+const InstanceLabel = "instance_id"
+
+// allCollectors returns all the collectors exported by geoblock.
+func allCollectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		CacheSize,
+		DatabaseRecords,
+		DatabaseChanges,
+		DatabaseInvalidRecords,
+		DatabaseMemory,
+		DatabaseStrings,
+		DatabaseVerifications,
+		DatabaseSourceLastUpdate,
+		DatabaseSourceCached,
+		DatabaseSourceStale,
+		Requests,
+		DatabaseLastUpdate,
+		DatabaseUpdateFailures,
+		DatabaseEmpty,
+		DatabaseDegraded,
+		NewCountries,
+		BreakerTrips,
+		BreakerDenied,
+		ResolutionCacheLookups,
+		ConfigGeneration,
+		RulesEvaluated,
+		WebhookNotifications,
+		TLSCertificateExpiry,
+	}
+}
+
+func init() {
+	registry.MustRegister(allCollectors()...)
+}
+
+// constLabels are the labels attached to all the metrics. See
+// SetConstLabels.
+var constLabels prometheus.Labels
+
+// register replaces the registry with a new one, where all the collectors are
+// registered with the constant labels. The registry is left unchanged if a
+// collector can't be registered.
+func register(labels prometheus.Labels) error {
+	wrapped := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(labels, wrapped)
+	for _, collector := range allCollectors() {
+		if err := registerer.Register(collector); err != nil {
+			return err
+		}
+	}
+	registry = wrapped
+	return nil
+}
+
+// SetConstLabels attaches the given labels to all the metrics, e.g., to
+// identify the instance in multi-replica deployments. It must be called
+// before serving the metrics.
+func SetConstLabels(labels prometheus.Labels) error {
+	if err := register(labels); err != nil {
+		return err
+	}
+	constLabels = labels
+	return nil
+}
+
+// Handler returns an HTTP handler that exposes the metrics in the Prometheus
+// text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,44 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+func TestSetConstLabels(t *testing.T) {
+	// Labels can't clash with the labels of the metrics.
+	err := metrics.SetConstLabels(prometheus.Labels{"result": "x"})
+	if err == nil {
+		t.Error("expected an error, got nil")
+	}
+
+	err = metrics.SetConstLabels(prometheus.Labels{
+		metrics.InstanceLabel: "geoblock-1",
+		"region":              "eu",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics.CountRequest(metrics.ResultAllowed, metrics.RequestLabels{
+		Country: "FR",
+		Domain:  "example.com",
+	})
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+
+	want := `geoblock_requests_total{country="FR",domain="example.com",` +
+		`instance_id="geoblock-1",region="eu",result="allowed"} 1`
+	if body := recorder.Body.String(); !strings.Contains(body, want) {
+		t.Errorf("metrics don't contain %q", want)
+	}
+}
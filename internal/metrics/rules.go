@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// RulesOptions contains the thresholds of the generated Prometheus rules.
+type RulesOptions struct {
+	// StaleAfter is the age after which the databases are considered stale.
+	StaleAfter time.Duration
+
+	// DenySpikeFactor is the ratio between the short-term and long-term rates
+	// of denied requests above which a spike is reported.
+	DenySpikeFactor float64
+
+	// InvalidRatio is the ratio of invalid requests above which an alert is
+	// raised.
+	InvalidRatio float64
+}
+
+// DefaultRulesOptions returns the default thresholds of the generated rules.
+func DefaultRulesOptions() RulesOptions {
+	return RulesOptions{
+		StaleAfter:      48 * time.Hour,
+		DenySpikeFactor: 3,
+		InvalidRatio:    0.05,
+	}
+}
+
+// Names of the generated recording rules.
+const (
+	recordRequestsRate5m = Namespace + ":requests:rate5m"
+	recordRequestsRate1h = Namespace + ":requests:rate1h"
+)
+
+// ruleFile is a Prometheus rule file.
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+// ruleGroup is a group of Prometheus rules.
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+// rule is a Prometheus recording or alerting rule.
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// fqName returns the fully-qualified name of the metric with the given
+// options.
+func fqName(opts prometheus.Opts) string {
+	return prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+}
+
+// Rules returns a Prometheus rule file with recording rules and alerts based
+// on the metrics exported by geoblock.
+func Rules(options RulesOptions) ([]byte, error) {
+	var (
+		requests       = fqName(prometheus.Opts(requestsOpts))
+		lastUpdate     = fqName(prometheus.Opts(databaseLastUpdateOpts))
+		updateFailures = fqName(prometheus.Opts(databaseUpdateFailuresOpts))
+		databaseEmpty  = fqName(prometheus.Opts(databaseEmptyOpts))
+		degraded       = fqName(prometheus.Opts(databaseDegradedOpts))
+		newCountries   = fqName(prometheus.Opts(newCountriesOpts))
+		breakerTrips   = fqName(prometheus.Opts(breakerTripsOpts))
+	)
+
+	file := ruleFile{Groups: []ruleGroup{
+		{
+			Name: Namespace + "-recording",
+			Rules: []rule{
+				{
+					Record: recordRequestsRate5m,
+					Expr: fmt.Sprintf(
+						"sum by (result) (rate(%s[5m]))",
+						requests,
+					),
+				},
+				{
+					Record: recordRequestsRate1h,
+					Expr: fmt.Sprintf(
+						"sum by (result) (rate(%s[1h]))",
+						requests,
+					),
+				},
+			},
+		},
+		{
+			Name: Namespace + "-alerts",
+			Rules: []rule{
+				{
+					Alert: "GeoblockDatabaseStale",
+					Expr: fmt.Sprintf(
+						"time() - %s > %d",
+						lastUpdate,
+						int64(options.StaleAfter.Seconds()),
+					),
+					For:    "10m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": fmt.Sprintf(
+							"Geoblock databases not updated for more than %s",
+							options.StaleAfter,
+						),
+					},
+				},
+				{
+					Alert: "GeoblockDatabaseUpdateFailing",
+					Expr: fmt.Sprintf(
+						"increase(%s[1h]) > 0",
+						updateFailures,
+					),
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "Geoblock cannot update its databases",
+					},
+				},
+				{
+					Alert:  "GeoblockDatabaseEmpty",
+					Expr:   fmt.Sprintf("%s == 1", databaseEmpty),
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary": "Geoblock is serving requests without " +
+							"country data",
+					},
+				},
+				{
+					Alert:  "GeoblockDatabaseDegraded",
+					Expr:   fmt.Sprintf("%s == 1", degraded),
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"summary": "Geoblock is applying its database " +
+							"failure policy",
+					},
+				},
+				{
+					Alert: "GeoblockNewCountry",
+					Expr: fmt.Sprintf(
+						"increase(%s[10m]) > 0",
+						newCountries,
+					),
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary": "First request from a new country " +
+							"for {{ $labels.domain }}",
+					},
+				},
+				{
+					Alert: "GeoblockCircuitBreakerOpen",
+					Expr: fmt.Sprintf(
+						"increase(%s[10m]) > 0",
+						breakerTrips,
+					),
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "Flood of denied requests to " +
+							"{{ $labels.domain }}, circuit breaker open",
+					},
+				},
+				{
+					Alert: "GeoblockDeniedRequestsSpike",
+					Expr: fmt.Sprintf(
+						"%[1]s{result=%[3]q} > %[4]g * %[2]s{result=%[3]q}",
+						recordRequestsRate5m,
+						recordRequestsRate1h,
+						ResultDenied,
+						options.DenySpikeFactor,
+					),
+					For:    "5m",
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"summary": "Spike of requests denied by Geoblock",
+					},
+				},
+				{
+					Alert: "GeoblockInvalidRequests",
+					Expr: fmt.Sprintf(
+						"%[1]s{result=%[2]q} / ignoring(result) "+
+							"sum(%[1]s) > %[3]g",
+						recordRequestsRate5m,
+						ResultInvalid,
+						options.InvalidRatio,
+					),
+					For:    "10m",
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary": "High ratio of invalid requests to " +
+							"Geoblock, check the reverse proxy headers",
+					},
+				},
+			},
+		},
+	}}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,56 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+func TestRules(t *testing.T) {
+	data, err := metrics.Rules(metrics.DefaultRulesOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var file struct {
+		Groups []struct {
+			Rules []struct {
+				Alert string `yaml:"alert"`
+				Expr  string `yaml:"expr"`
+			} `yaml:"rules"`
+		} `yaml:"groups"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		t.Fatalf("invalid rule file: %v", err)
+	}
+
+	alerts := make(map[string]string)
+	for _, group := range file.Groups {
+		for _, rule := range group.Rules {
+			if rule.Alert != "" {
+				alerts[rule.Alert] = rule.Expr
+			}
+		}
+	}
+
+	tests := map[string]string{
+		"GeoblockDatabaseStale": "geoblock_database_last_update_timestamp" +
+			"_seconds > 172800",
+		"GeoblockDatabaseUpdateFailing": "geoblock_database_update_failures",
+		"GeoblockDatabaseEmpty":         "geoblock_database_empty == 1",
+		"GeoblockDatabaseDegraded":      "geoblock_database_degraded == 1",
+		"GeoblockNewCountry":            "geoblock_new_countries_total",
+		"GeoblockCircuitBreakerOpen":    "circuit_breaker_trips_total",
+		"GeoblockDeniedRequestsSpike":   `{result="denied"}`,
+		"GeoblockInvalidRequests":       `{result="invalid"}`,
+	}
+	for alert, want := range tests {
+		if !strings.Contains(alerts[alert], want) {
+			t.Errorf("alert %s: got %q, want it to contain %q",
+				alert, alerts[alert], want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package notify defines the targets of the notifications and renders their
+// payloads from Go templates, so that new targets, e.g., chat services or
+// mailers, can be added without changing how the notifications are queued and
+// rate-limited.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Event is a notified decision. Text summarizes the event for the chat
+// services that display it, e.g., Slack or Mattermost.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Outcome  string    `json:"outcome"`
+	Rule     int       `json:"rule"`
+	RuleName string    `json:"rule_name,omitempty"`
+	IP       string    `json:"ip"`
+	Country  string    `json:"country,omitempty"`
+	ASN      uint32    `json:"asn,omitempty"`
+	Domain   string    `json:"domain"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path,omitempty"`
+	Text     string    `json:"text"`
+}
+
+// Notifier is a target of the notifications, e.g., a webhook. Its Notify
+// method is called from a single goroutine, one event at a time, and must
+// return once the given context is done.
+type Notifier interface {
+	Notify(ctx context.Context, event *Event) error
+}
+
+// Summary returns the text summarizing the given event, e.g., "deny of GET
+// admin.example.com/login from 203.0.113.10 (FR, AS64500) by rule admin".
+func Summary(event *Event) string {
+	rule := event.RuleName
+	if rule == "" {
+		rule = fmt.Sprint(event.Rule)
+	}
+	source := event.IP
+	switch {
+	case event.Country != "" && event.ASN != 0:
+		source += fmt.Sprintf(" (%s, AS%d)", event.Country, event.ASN)
+	case event.Country != "":
+		source += " (" + event.Country + ")"
+	case event.ASN != 0:
+		source += fmt.Sprintf(" (AS%d)", event.ASN)
+	}
+	return fmt.Sprintf(
+		"%s of %s %s%s from %s by rule %s", event.Outcome, event.Method,
+		event.Domain, event.Path, source, rule,
+	)
+}
@@ -0,0 +1,116 @@
+package notify_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/notify"
+)
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		event notify.Event
+		want  string
+	}{
+		{
+			"named rule",
+			notify.Event{
+				Outcome:  "allow",
+				Rule:     0,
+				RuleName: "office",
+				IP:       "203.0.113.10",
+				Country:  "FR",
+				Domain:   "example.com",
+				Method:   http.MethodPost,
+			},
+			"allow of POST example.com from 203.0.113.10 (FR) by rule office",
+		},
+		{
+			"unnamed rule",
+			notify.Event{
+				Outcome: "deny",
+				Rule:    3,
+				IP:      "2001:db8::1",
+				ASN:     64500,
+				Domain:  "example.com",
+				Method:  http.MethodGet,
+				Path:    "/admin",
+			},
+			"deny of GET example.com/admin from 2001:db8::1 (AS64500) " +
+				"by rule 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := notify.Summary(&tt.event); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	event := &notify.Event{
+		Outcome: "deny",
+		IP:      "203.0.113.10",
+		Domain:  "example.com",
+		Method:  http.MethodGet,
+		Text:    `deny of "GET"`,
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			"default",
+			"",
+			`{"time":"0001-01-01T00:00:00Z","outcome":"deny","rule":0,` +
+				`"ip":"203.0.113.10","domain":"example.com",` +
+				`"method":"GET","text":"deny of \"GET\""}`,
+		},
+		{
+			"chat",
+			`{"text": {{json .Text}}}`,
+			`{"text": "deny of \"GET\""}`,
+		},
+		{
+			"plain text",
+			"{{.Outcome}} of {{.Domain}} from {{.IP}}",
+			"deny of example.com from 203.0.113.10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := notify.ParseTemplate(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := template.Render(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	if _, err := notify.ParseTemplate("{{.Outcome"); err == nil {
+		t.Error("expected a parse error, got nil")
+	}
+
+	template, err := notify.ParseTemplate("{{.Unknown}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := template.Render(&notify.Event{}); err == nil {
+		t.Error("expected a render error, got nil")
+	}
+}
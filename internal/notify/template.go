@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+)
+
+// DefaultTemplate is the template of the payloads of the targets without
+// template: the event encoded in JSON.
+const DefaultTemplate = "{{json .}}"
+
+// funcs are the functions available to the templates, besides the built-in
+// ones. The "json" function encodes its argument in JSON, e.g., to quote a
+// string in a JSON payload.
+var funcs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// Template renders the payloads of the notifications from a Go text template
+// executed with the Event.
+type Template struct {
+	template *template.Template
+}
+
+// ParseTemplate parses the given template text. If it's empty,
+// DefaultTemplate is used.
+func ParseTemplate(text string) (*Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	parsed, err := template.New("payload").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{template: parsed}, nil
+}
+
+// Render renders the payload of the given event.
+func (t *Template) Render(event *Event) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := t.template.Execute(&buffer, event); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
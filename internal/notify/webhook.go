@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefaultContentType is the content type of the payloads of the webhooks
+// without one.
+const DefaultContentType = "application/json"
+
+// Webhook is a Notifier that posts the payloads rendered by its template to
+// a URL. With the right template, it also posts to the incoming webhooks of
+// chat services.
+type Webhook struct {
+	client      *http.Client
+	url         string
+	contentType string
+	template    *Template
+}
+
+// NewWebhook creates a notifier posting the payloads rendered by the given
+// template to the given URL, with the given content type. If the content type
+// is empty, DefaultContentType is used.
+func NewWebhook(
+	client *http.Client,
+	url string,
+	template *Template,
+	contentType string,
+) *Webhook {
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+	return &Webhook{
+		client:      client,
+		url:         url,
+		contentType: contentType,
+		template:    template,
+	}
+}
+
+// Notify posts the payload of the given event to the webhook.
+func (w *Webhook) Notify(ctx context.Context, event *Event) error {
+	body, err := w.template.Render(event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, w.url, bytes.NewReader(body),
+	)
+	if err != nil {
+		// The error contains the URL, which often contains a secret token.
+		return errors.New("invalid webhook URL")
+	}
+	request.Header.Set("Content-Type", w.contentType)
+
+	resp, err := w.client.Do(request)
+	if err != nil {
+		// The error of the HTTP client contains the URL.
+		return errors.New("cannot post to webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Package privacy anonymizes the client IPs written to the logs and to the
+// audit log, while the decisions keep using the full IPs.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// Anonymization modes of the IPs.
+const (
+	ModeFull     = "full"     // The IPs aren't anonymized
+	ModeTruncate = "truncate" // The IPs are replaced by their network
+	ModeHash     = "hash"     // The IPs are replaced by their HMAC
+)
+
+// Prefix lengths of the networks of the truncated IPs: the last octet of the
+// IPv4 addresses and the interface identifier of the IPv6 addresses are
+// zeroed.
+const (
+	TruncateIPv4Bits = 24
+	TruncateIPv6Bits = 64
+)
+
+// DefaultKeyRotation is the time after which the key of the hashed IPs is
+// replaced when no rotation period is set.
+const DefaultKeyRotation = 24 * time.Hour
+
+// keySize is the size in bytes of the keys of the hashed IPs.
+const keySize = 32
+
+// Invalid replaces the values that aren't IPs, since they may still contain
+// personal data, e.g., a malformed X-Forwarded-For header.
+const Invalid = "invalid"
+
+// Anonymizer anonymizes IPs. It's safe for concurrent use.
+//
+// The hashed IPs use a random key that is only kept in memory and replaced
+// after each rotation period, so that the hashes of an IP can be correlated
+// within a period but not across periods or restarts.
+type Anonymizer struct {
+	mode     string
+	rotation time.Duration
+	mu       sync.Mutex
+	key      []byte
+	expires  time.Time // Time after which the key is replaced
+	now      func() time.Time
+}
+
+// NewAnonymizer creates a new anonymizer with the given mode. The hash key is
+// replaced after the given rotation period, or DefaultKeyRotation if zero. An
+// empty mode is ModeFull.
+func NewAnonymizer(mode string, rotation time.Duration) *Anonymizer {
+	if mode == "" {
+		mode = ModeFull
+	}
+	if rotation <= 0 {
+		rotation = DefaultKeyRotation
+	}
+	return &Anonymizer{mode: mode, rotation: rotation, now: time.Now}
+}
+
+// Enabled returns true if the anonymizer changes the IPs.
+func (a *Anonymizer) Enabled() bool {
+	return a.mode != ModeFull
+}
+
+// Anonymize returns the anonymized form of the given IP.
+func (a *Anonymizer) Anonymize(ip netip.Addr) string {
+	ip = ip.Unmap()
+	switch a.mode {
+	case ModeTruncate:
+		bits := TruncateIPv6Bits
+		if ip.Is4() {
+			bits = TruncateIPv4Bits
+		}
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			return Invalid
+		}
+		return prefix.Addr().String()
+	case ModeHash:
+		mac := hmac.New(sha256.New, a.currentKey())
+		mac.Write([]byte(ip.String())) // #nosec G104
+		return hex.EncodeToString(mac.Sum(nil))
+	default:
+		return ip.String()
+	}
+}
+
+// AnonymizeString returns the anonymized form of the given IP, IP and port,
+// or network in text form. The port and the prefix length are dropped, since
+// the network of a ban may contain a single IP. Values that aren't IPs are
+// replaced by Invalid, unless the anonymizer is disabled.
+func (a *Anonymizer) AnonymizeString(value string) string {
+	if !a.Enabled() {
+		return value
+	}
+	if ip, err := netip.ParseAddr(value); err == nil {
+		return a.Anonymize(ip)
+	}
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return a.Anonymize(addrPort.Addr())
+	}
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return a.Anonymize(prefix.Addr())
+	}
+	return Invalid
+}
+
+// currentKey returns the key of the hashed IPs, replacing it if it has
+// expired.
+func (a *Anonymizer) currentKey() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if a.key == nil || !now.Before(a.expires) {
+		key := make([]byte, keySize)
+		rand.Read(key) // #nosec G104
+		a.key = key
+		a.expires = now.Add(a.rotation)
+	}
+	return a.key
+}
@@ -0,0 +1,77 @@
+package privacy
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeTruncate(t *testing.T) {
+	anonymizer := NewAnonymizer(ModeTruncate, 0)
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"203.0.113.10", "203.0.113.0"},
+		{"::ffff:203.0.113.10", "203.0.113.0"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::"},
+		{"203.0.113.10:51234", "203.0.113.0"},
+		{"[2001:db8::1]:443", "2001:db8::"},
+		{"203.0.113.10/32", "203.0.113.0"},
+		{"2001:db8::/48", "2001:db8::"},
+		{"unknown", Invalid},
+		{"", Invalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := anonymizer.AnonymizeString(tt.value); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizeHash(t *testing.T) {
+	now := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	anonymizer := NewAnonymizer(ModeHash, time.Hour)
+	anonymizer.now = func() time.Time { return now }
+
+	var (
+		ip    = netip.MustParseAddr("203.0.113.10")
+		other = netip.MustParseAddr("203.0.113.11")
+	)
+
+	hash := anonymizer.Anonymize(ip)
+	if len(hash) != 64 {
+		t.Errorf("got hash %q, want 64 hex digits", hash)
+	}
+	if got := anonymizer.AnonymizeString("::ffff:203.0.113.10"); got != hash {
+		t.Errorf("got hash %q for mapped IP, want %q", got, hash)
+	}
+	if got := anonymizer.Anonymize(other); got == hash {
+		t.Errorf("got the same hash %q for another IP", got)
+	}
+
+	now = now.Add(59 * time.Minute)
+	if got := anonymizer.Anonymize(ip); got != hash {
+		t.Errorf("got hash %q before rotation, want %q", got, hash)
+	}
+	now = now.Add(time.Minute)
+	if got := anonymizer.Anonymize(ip); got == hash {
+		t.Errorf("got the same hash %q after rotation", got)
+	}
+}
+
+func TestAnonymizeFull(t *testing.T) {
+	anonymizer := NewAnonymizer("", 0)
+	if anonymizer.Enabled() {
+		t.Error("got enabled anonymizer, want disabled")
+	}
+	for _, value := range []string{"203.0.113.10", "unknown"} {
+		if got := anonymizer.AnonymizeString(value); got != value {
+			t.Errorf("got %q, want %q", got, value)
+		}
+	}
+}
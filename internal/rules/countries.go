@@ -0,0 +1,80 @@
+package rules
+
+import (
+	"strings"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/utils/orgname"
+)
+
+// countrySet is the set of countries matched by a countries condition.
+type countrySet struct {
+	include map[string]struct{} // Included countries, nil to include all
+	exclude map[string]struct{} // Excluded countries
+}
+
+// newCountrySet creates a new country set from the given country codes.
+// Codes are case-insensitive.
+func newCountrySet(include, exclude []string) countrySet {
+	toSet := func(codes []string) map[string]struct{} {
+		set := make(map[string]struct{}, len(codes))
+		for _, code := range codes {
+			set[strings.ToUpper(code)] = struct{}{}
+		}
+		return set
+	}
+
+	set := countrySet{exclude: toSet(exclude)}
+	if len(include) > 0 {
+		set.include = toSet(include)
+	}
+	return set
+}
+
+// contains checks if the given country is in the set. A set that only
+// excludes countries contains all the other countries, including unknown
+// ones.
+func (s *countrySet) contains(country string) bool {
+	country = strings.ToUpper(country)
+	if _, ok := s.exclude[country]; ok {
+		return false
+	}
+	if s.include == nil {
+		return true
+	}
+	_, ok := s.include[country]
+	return ok
+}
+
+// compile expands the countries conditions and normalizes the organizations
+// conditions of the rules of the given configuration. The countries of the
+// not_countries conditions are excluded from the countries conditions.
+func compile(cfg *config.AccessControl) *compiledConfig {
+	compiled := &compiledConfig{
+		AccessControl: cfg,
+		countries:     make([]countrySet, len(cfg.Rules)),
+		organizations: make([][]string, len(cfg.Rules)),
+	}
+	for i, rule := range cfg.Rules {
+		for _, organization := range rule.Organizations {
+			compiled.organizations[i] = append(
+				compiled.organizations[i], orgname.Normalize(organization),
+			)
+		}
+
+		include, exclude, err := cfg.ExpandCountries(rule.Countries)
+		if err == nil {
+			var excluded []string
+			excluded, _, err = cfg.ExpandCountries(rule.NotCountries)
+			exclude = append(exclude, excluded...)
+		}
+		if err != nil {
+			// The configuration is validated when it's read, so this should
+			// never happen. Matching no country is the safest fallback.
+			compiled.countries[i] = countrySet{include: map[string]struct{}{}}
+			continue
+		}
+		compiled.countries[i] = newCountrySet(include, exclude)
+	}
+	return compiled
+}
@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/utils/glob"
+)
+
+// DomainSummary summarizes how the rules apply to a domain pattern.
+type DomainSummary struct {
+	Pattern string // Domain pattern, as written in the configuration
+	Rules   []int  // Indices of the rules that may apply to the pattern
+	Policy  string // Policy applied when no conditional rule matches
+}
+
+// isUnconditional checks if the given rule has no conditions other than
+// domains, i.e., it applies to all the requests for its domains.
+func isUnconditional(rule *config.AccessControlRule) bool {
+	return len(rule.Services) == 0 &&
+		len(rule.Networks) == 0 && len(rule.Methods) == 0 &&
+		len(rule.Paths) == 0 && len(rule.Protocols) == 0 &&
+		len(rule.Countries) == 0 && len(rule.AutonomousSystems) == 0 &&
+		len(rule.Organizations) == 0 &&
+		len(rule.PeeringDBOrganizations) == 0 &&
+		len(rule.Monitors) == 0 && len(rule.Anonymizers) == 0 &&
+		rule.IsCDN == nil &&
+		rule.MinForwardedHops == 0 && rule.MaxForwardedHops == 0 &&
+		rule.RateLimit == nil && rule.Quota == nil &&
+		len(rule.NotDomains) == 0 &&
+		len(rule.NotNetworks) == 0 && len(rule.NotCountries) == 0 &&
+		len(rule.NotAutonomousSystems) == 0
+}
+
+// appliesToPattern checks if the given rule may apply to the requests for the
+// given domain pattern. A rule without domains applies to all patterns, and
+// a rule doesn't apply to the patterns covered by its not_domains.
+func appliesToPattern(rule *config.AccessControlRule, pattern string) bool {
+	covers := func(domain string) bool {
+		return glob.Star(strings.ToLower(domain), strings.ToLower(pattern))
+	}
+	return match(rule.Domains, covers) && matchNone(rule.NotDomains, covers)
+}
+
+// patterns returns the unique domain patterns of the given rules, in the
+// order they first appear. Patterns are case-insensitive.
+func patterns(rules []config.AccessControlRule) []string {
+	var result []string
+	for _, rule := range rules {
+		for _, domain := range rule.Domains {
+			if !slices.ContainsFunc(result, func(p string) bool {
+				return strings.EqualFold(p, domain)
+			}) {
+				result = append(result, domain)
+			}
+		}
+	}
+	return result
+}
+
+// Domains summarizes, for each domain pattern of the rules, the rules that
+// may apply to it and the policy applied to the requests that don't match
+// any conditional rule.
+//
+// The policy is the one of the first unconditional rule that applies to the
+// pattern or, if there's none, the default policy.
+func (e *Engine) Domains() []DomainSummary {
+	cfg := e.config.Load()
+
+	var summaries []DomainSummary
+	for _, pattern := range patterns(cfg.Rules) {
+		summary := DomainSummary{
+			Pattern: pattern,
+			Rules:   []int{},
+			Policy:  cfg.DefaultPolicy,
+		}
+		for i := range cfg.Rules {
+			rule := &cfg.Rules[i]
+			if !appliesToPattern(rule, pattern) {
+				continue
+			}
+			summary.Rules = append(summary.Rules, i)
+			if isUnconditional(rule) {
+				summary.Policy = rule.Policy
+				break
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// DomainPattern returns the first domain pattern of the rules that matches
+// the given domain. It returns false if no pattern matches.
+func (e *Engine) DomainPattern(domain string) (string, bool) {
+	cfg := e.config.Load()
+	for _, rule := range cfg.Rules {
+		for _, pattern := range rule.Domains {
+			if glob.Star(strings.ToLower(pattern), strings.ToLower(domain)) {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
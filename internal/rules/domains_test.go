@@ -0,0 +1,128 @@
+package rules_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+func TestEngineDomains(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"app.example.com"},
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Domains: []string{"*.example.com", "example.org"},
+				Policy:  config.PolicyAllow,
+			},
+			{
+				Countries: []string{"US"},
+				Policy:    config.PolicyDeny,
+			},
+			{
+				Domains: []string{"APP.example.com"},
+				Policy:  config.PolicyDeny,
+			},
+		},
+	})
+
+	want := []rules.DomainSummary{
+		{
+			Pattern: "app.example.com",
+			Rules:   []int{0, 1},
+			Policy:  config.PolicyAllow,
+		},
+		{
+			Pattern: "*.example.com",
+			Rules:   []int{1},
+			Policy:  config.PolicyAllow,
+		},
+		{
+			Pattern: "example.org",
+			Rules:   []int{1},
+			Policy:  config.PolicyAllow,
+		},
+	}
+	if got := engine.Domains(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestEngineDomainsNotDomains(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:    []string{"*.example.com"},
+				NotDomains: []string{"admin.example.com"},
+				Policy:     config.PolicyAllow,
+			},
+			{
+				Domains: []string{"admin.example.com"},
+				Policy:  config.PolicyDeny,
+			},
+		},
+	})
+
+	// The first rule doesn't apply to all the domains of its pattern, so it's
+	// conditional.
+	want := []rules.DomainSummary{
+		{
+			Pattern: "*.example.com",
+			Rules:   []int{0},
+			Policy:  config.PolicyDeny,
+		},
+		{
+			Pattern: "admin.example.com",
+			Rules:   []int{1},
+			Policy:  config.PolicyDeny,
+		},
+	}
+	if got := engine.Domains(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestEngineDomainPattern(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"app.example.com"},
+				Policy:  config.PolicyAllow,
+			},
+			{
+				Domains: []string{"*.example.com"},
+				Policy:  config.PolicyAllow,
+			},
+		},
+	})
+
+	tests := []struct {
+		domain  string
+		pattern string
+		ok      bool
+	}{
+		{"app.example.com", "app.example.com", true},
+		{"API.example.com", "*.example.com", true},
+		{"example.org", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			pattern, ok := engine.DomainPattern(tt.domain)
+			if pattern != tt.pattern || ok != tt.ok {
+				t.Errorf(
+					"got (%q, %v), want (%q, %v)",
+					pattern, ok, tt.pattern, tt.ok,
+				)
+			}
+		})
+	}
+}
@@ -2,35 +2,109 @@
 package rules
 
 import (
+	"maps"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/bans"
 	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/metrics"
 	"github.com/danroc/geoblock/internal/utils/glob"
 )
 
+// FieldSourceIP is the log field of the source IP of the queries. The server
+// logs use the same field, so that the IPs are anonymized the same way.
+const FieldSourceIP = "source_ip"
+
 // Engine is the access control egine that checks if a given query is allowed
 // by the rules.
 type Engine struct {
-	config atomic.Pointer[config.AccessControl]
+	config  atomic.Pointer[compiledConfig]
+	next    atomic.Pointer[compiledConfig] // Staged configuration, if any
+	limiter atomic.Pointer[rateLimiter]
+	quotas  atomic.Pointer[quotaCounter]
+	bans    *bans.List
+
+	// generation is the generation of the last configuration applied. See
+	// Generation.
+	generation atomic.Uint64
+
+	// fallback is the policy of the queries without source country, if any.
+	// See SetFallbackPolicy.
+	fallback atomic.Pointer[string]
+
+	// maintenance is the policy of all the queries in maintenance mode, if
+	// any. See SetMaintenancePolicy.
+	maintenance atomic.Pointer[string]
+
+	// peeringDB contains the ASNs of the PeeringDB organizations, by
+	// lowercase name. See SetOrganizationASNs.
+	peeringDB   atomic.Pointer[organizationASNs]
+	peeringDBMu sync.Mutex // Serializes the updates of peeringDB
+}
+
+// organizationASNs contains the ASNs of organizations, by lowercase name.
+type organizationASNs map[string]map[uint32]bool
+
+// contains checks if the given organization has the given ASN.
+func (o organizationASNs) contains(organization string, asn uint32) bool {
+	return o[strings.ToLower(organization)][asn]
+}
+
+// compiledConfig is an access control configuration with the countries
+// conditions of its rules expanded, so that they're matched in constant time.
+type compiledConfig struct {
+	*config.AccessControl
+	countries     []countrySet // Expanded countries condition of each rule
+	organizations [][]string   // Normalized organizations of each rule
+	generation    uint64       // Set when the configuration is applied
 }
 
 // NewEngine creates a new access control engine for the given access control
 // configuration.
 func NewEngine(config *config.AccessControl) *Engine {
-	e := &Engine{}
-	e.config.Store(config)
+	e := &Engine{bans: bans.NewList()}
+	e.UpdateConfig(config)
 	return e
 }
 
 // Query represents a query to be checked by the access control engine.
 type Query struct {
+	Service         string // Name of the non-HTTP service, if any
 	RequestedDomain string
 	RequestedMethod string
+	RequestedPath   string // URL path, without query, if known
 	SourceIP        netip.Addr
 	SourceCountry   string
+	SourceRegion    string // Region of the source, if the city is resolved
+	SourceCity      string // City of the source, if the city is resolved
 	SourceASN       uint32
+	SourceOrg       string // Normalized organization name of the source
+	SourceIsCDN     bool
+	SourceMonitor   string // Uptime monitoring service of the source, if any
+	ForwardedHops   int    // Number of addresses in the X-Forwarded-For chain
+
+	// RequestedProtocol is the protocol of the request, "http" or "https", if
+	// known.
+	RequestedProtocol string
+
+	// SourceAnonymizers are the anonymization networks, e.g., "tor", whose
+	// published lists contain the source IP.
+	SourceAnonymizers []string
+
+	// PreflightOrigin is the origin of a CORS preflight request. It's empty
+	// if the query isn't a preflight request.
+	PreflightOrigin string
+
+	// Context contains the key-value pairs passed by the proxy, e.g., the
+	// tenant of the request, by lowercase key.
+	Context map[string]string
 }
 
 // match checks if any of the conditions match the given matchFunc.
@@ -43,55 +117,582 @@ func match[T any](conditions []T, matchFunc func(T) bool) bool {
 	return len(conditions) == 0
 }
 
-// ruleApplies checks if the given query is allowed or denied by the given
-// rule. For a rule to be applicable, the query must match all of the rule's
-// conditions.
+// matchNone checks if none of the conditions match the given matchFunc.
+// Empty conditions match nothing, so they never exclude a query.
+func matchNone[T any](conditions []T, matchFunc func(T) bool) bool {
+	return !slices.ContainsFunc(conditions, matchFunc)
+}
+
+// ruleMatch contains the result of each condition of a rule for a query.
+type ruleMatch struct {
+	service       bool
+	domain        bool
+	method        bool
+	path          bool
+	protocol      bool
+	network       bool
+	country       bool
+	region        bool
+	city          bool
+	asn           bool
+	organization  bool
+	peeringDB     bool
+	monitor       bool
+	anonymizer    bool
+	cdn           bool
+	forwardedHops bool
+	context       bool
+}
+
+// applies checks if all the conditions of the rule match, in which case the
+// rule applies to the query.
+func (m *ruleMatch) applies() bool {
+	return m.service && m.domain && m.method && m.path && m.protocol &&
+		m.network && m.country && m.region && m.city && m.asn &&
+		m.organization && m.peeringDB && m.monitor && m.anonymizer &&
+		m.cdn && m.forwardedHops && m.context
+}
+
+// conditions returns the result of each condition, by condition name.
+func (m *ruleMatch) conditions() map[string]bool {
+	return map[string]bool{
+		"service":        m.service,
+		"domain":         m.domain,
+		"method":         m.method,
+		"path":           m.path,
+		"protocol":       m.protocol,
+		"network":        m.network,
+		"country":        m.country,
+		"region":         m.region,
+		"city":           m.city,
+		"asn":            m.asn,
+		"organization":   m.organization,
+		"peeringdb":      m.peeringDB,
+		"monitor":        m.monitor,
+		"anonymizer":     m.anonymizer,
+		"cdn":            m.cdn,
+		"forwarded_hops": m.forwardedHops,
+		"context":        m.context,
+	}
+}
+
+// fields returns the result of each condition as log fields.
+func (m *ruleMatch) fields() log.Fields {
+	fields := make(log.Fields)
+	for name, matched := range m.conditions() {
+		fields["match_"+name] = matched
+	}
+	return fields
+}
+
+// matchRule evaluates each condition of the given rule against the given
+// query. For a rule to be applicable, the query must match all of the rule's
+// conditions, see ruleMatch.applies.
 //
 // Empty conditions are considered as "match all". For example, if a rule has
-// no domains, it will match all domains.
+// no domains, it will match all domains. The negated conditions (not_domains,
+// not_networks, not_countries and not_autonomous_systems) are ANDed with the
+// others: queries matching any of their values are excluded.
 //
-// Domains, methods and countries are case-insensitive.
-func ruleApplies(rule *config.AccessControlRule, query *Query) bool {
-	matchDomain := match(rule.Domains, func(domain string) bool {
+// Services, domains, methods, protocols, countries, regions and cities are
+// case-insensitive. Paths are case-sensitive and may contain `*` and `**`
+// wildcards, see glob.Path. The countries condition is given expanded, as
+// countries, and the organizations condition normalized, as organizations.
+// Organizations may contain `*` wildcards.
+//
+// The PeeringDB organizations condition matches the ASNs of the organizations
+// given in peeringDB. Organizations that haven't been resolved match nothing.
+//
+// The CDN and forwarded hops conditions are optional: if they're not set, they
+// match all queries.
+//
+// The context condition matches if, for each of its keys, the value of the
+// key in the query context matches one of the key's values. Keys are
+// case-insensitive, and values are case-sensitive and may contain `*`
+// wildcards. Queries without the key don't match.
+func matchRule(
+	rule *config.AccessControlRule,
+	countries *countrySet,
+	organizations []string,
+	peeringDB organizationASNs,
+	query *Query,
+) ruleMatch {
+	domainMatches := func(domain string) bool {
 		return glob.Star(
 			strings.ToLower(domain),
 			strings.ToLower(query.RequestedDomain),
 		)
+	}
+	matchDomain := match(rule.Domains, domainMatches) &&
+		matchNone(rule.NotDomains, domainMatches)
+
+	matchService := match(rule.Services, func(service string) bool {
+		return strings.EqualFold(service, query.Service)
 	})
 
 	matchMethod := match(rule.Methods, func(method string) bool {
 		return strings.EqualFold(method, query.RequestedMethod)
 	})
 
-	matchIP := match(rule.Networks, func(network config.CIDR) bool {
+	matchPath := match(rule.Paths, func(path string) bool {
+		return glob.Path(path, query.RequestedPath)
+	})
+
+	matchProtocol := match(rule.Protocols, func(protocol string) bool {
+		return strings.EqualFold(protocol, query.RequestedProtocol)
+	})
+
+	networkMatches := func(network config.CIDR) bool {
 		return network.Contains(query.SourceIP)
+	}
+	matchIP := match(rule.Networks, networkMatches) &&
+		matchNone(rule.NotNetworks, networkMatches)
+
+	matchCountry := countries.contains(query.SourceCountry)
+
+	matchRegion := match(rule.Regions, func(region string) bool {
+		return strings.EqualFold(region, query.SourceRegion)
+	})
+
+	matchCity := match(rule.Cities, func(city string) bool {
+		return strings.EqualFold(city, query.SourceCity)
+	})
+
+	asnMatches := func(asns config.ASNRange) bool {
+		return asns.Contains(query.SourceASN)
+	}
+	matchANS := match(rule.AutonomousSystems, asnMatches) &&
+		matchNone(rule.NotAutonomousSystems, asnMatches)
+
+	matchOrg := match(organizations, func(organization string) bool {
+		return glob.Star(organization, query.SourceOrg)
 	})
 
-	matchCountry := match(rule.Countries, func(country string) bool {
-		return strings.EqualFold(country, query.SourceCountry)
+	matchPeeringDB := match(
+		rule.PeeringDBOrganizations,
+		func(organization string) bool {
+			return peeringDB.contains(organization, query.SourceASN)
+		},
+	)
+
+	matchMonitor := match(rule.Monitors, func(monitor string) bool {
+		return strings.EqualFold(monitor, query.SourceMonitor)
 	})
 
-	matchANS := match(rule.AutonomousSystems, func(asn uint32) bool {
-		return asn == query.SourceASN
+	matchAnonymizer := match(rule.Anonymizers, func(name string) bool {
+		return slices.Contains(query.SourceAnonymizers, name)
 	})
 
-	return matchDomain && matchMethod && matchIP && matchCountry && matchANS
+	matchCDN := rule.IsCDN == nil || *rule.IsCDN == query.SourceIsCDN
+
+	matchHops := (rule.MinForwardedHops == 0 ||
+		query.ForwardedHops >= rule.MinForwardedHops) &&
+		(rule.MaxForwardedHops == 0 ||
+			query.ForwardedHops <= rule.MaxForwardedHops)
+
+	matchContext := true
+	for key, values := range rule.Context {
+		value, ok := query.Context[strings.ToLower(key)]
+		if !ok || !slices.ContainsFunc(values, func(pattern string) bool {
+			return glob.Star(pattern, value)
+		}) {
+			matchContext = false
+			break
+		}
+	}
+
+	return ruleMatch{
+		service:       matchService,
+		domain:        matchDomain,
+		method:        matchMethod,
+		path:          matchPath,
+		protocol:      matchProtocol,
+		network:       matchIP,
+		country:       matchCountry,
+		region:        matchRegion,
+		city:          matchCity,
+		asn:           matchANS,
+		organization:  matchOrg,
+		peeringDB:     matchPeeringDB,
+		monitor:       matchMonitor,
+		anonymizer:    matchAnonymizer,
+		cdn:           matchCDN,
+		forwardedHops: matchHops,
+		context:       matchContext,
+	}
+}
+
+// allowPreflight checks if the given query is a CORS preflight request that is
+// allowed without evaluating the rules. If no origins are configured, all
+// origins are allowed. Origins are case-insensitive.
+func allowPreflight(preflight *config.Preflight, query *Query) bool {
+	if !preflight.Allow || query.PreflightOrigin == "" {
+		return false
+	}
+	return match(preflight.Origins, func(origin string) bool {
+		return glob.Star(
+			strings.ToLower(origin),
+			strings.ToLower(query.PreflightOrigin),
+		)
+	})
+}
+
+// Bans returns the list of temporary bans of the engine. Bans are kept when
+// the configuration is updated.
+func (e *Engine) Bans() *bans.List {
+	return e.bans
 }
 
 // UpdateConfig updates the engine's configuration with the given access
-// control configuration.
+// control configuration. The rate limits are reset since the rules may have
+// changed, and the quotas are kept for the rules whose quota didn't change.
 func (e *Engine) UpdateConfig(config *config.AccessControl) {
-	e.config.Store(config)
+	e.apply(compile(config))
+}
+
+// apply replaces the engine's configuration with the given compiled one,
+// resets the rate limits and carries the quotas over. The configuration is
+// given the next generation number.
+func (e *Engine) apply(cfg *compiledConfig) {
+	quotas := newQuotaCounter()
+	if prev := e.config.Load(); prev != nil {
+		quotas = e.quotas.Load().carryOver(prev.Rules, cfg.Rules)
+	}
+
+	cfg.generation = e.generation.Add(1)
+	e.config.Store(cfg)
+	e.limiter.Store(newRateLimiter())
+	e.quotas.Store(quotas)
+	metrics.ConfigGeneration.Set(float64(cfg.generation))
+}
+
+// Sandbox returns an engine with the given access control configuration and
+// the bans, fallback and maintenance policies, and PeeringDB organizations of
+// the engine, so that a candidate configuration can be tested against the
+// current state without replacing the engine's configuration. The ASNs of the
+// PeeringDB organizations only used by the candidate configuration are
+// unknown.
+func (e *Engine) Sandbox(config *config.AccessControl) *Engine {
+	sandbox := &Engine{bans: e.bans}
+	sandbox.config.Store(compile(config))
+	sandbox.limiter.Store(newRateLimiter())
+	sandbox.quotas.Store(newQuotaCounter())
+	sandbox.fallback.Store(e.fallback.Load())
+	sandbox.maintenance.Store(e.maintenance.Load())
+	sandbox.peeringDB.Store(e.peeringDB.Load())
+	return sandbox
+}
+
+// Generation returns the generation of the engine's configuration. It starts
+// at 1 and is incremented each time the configuration is updated or
+// promoted, so that replicas can be checked to have applied the same number
+// of configurations.
+func (e *Engine) Generation() uint64 {
+	return e.config.Load().generation
+}
+
+// StageConfig compiles the given access control configuration and keeps it
+// until it's promoted, replacing any previously staged configuration. Since
+// the configuration is compiled in advance, its promotion is instantaneous.
+func (e *Engine) StageConfig(config *config.AccessControl) {
+	e.next.Store(compile(config))
+}
+
+// PromoteConfig replaces the engine's configuration with the staged one, as
+// UpdateConfig does. It returns false if no configuration is staged.
+func (e *Engine) PromoteConfig() bool {
+	next := e.next.Swap(nil)
+	if next == nil {
+		return false
+	}
+	e.apply(next)
+	return true
+}
+
+// SetFallbackPolicy sets the policy, PolicyAllow or PolicyDeny, of the
+// queries whose source country is unknown. It's applied instead of the rules
+// while the databases are unavailable. An empty policy restores the
+// evaluation of the rules.
+func (e *Engine) SetFallbackPolicy(policy string) {
+	if policy == "" {
+		e.fallback.Store(nil)
+		return
+	}
+	e.fallback.Store(&policy)
+}
+
+// SetMaintenancePolicy enables the maintenance mode, in which the given
+// policy, PolicyAllow or PolicyDeny, is applied to all the queries instead of
+// the bans and the rules. An empty policy disables the maintenance mode.
+func (e *Engine) SetMaintenancePolicy(policy string) {
+	if policy == "" {
+		e.maintenance.Store(nil)
+		return
+	}
+	e.maintenance.Store(&policy)
+}
+
+// MaintenancePolicy returns the policy of the maintenance mode, or an empty
+// string if the maintenance mode is disabled.
+func (e *Engine) MaintenancePolicy() string {
+	if policy := e.maintenance.Load(); policy != nil {
+		return *policy
+	}
+	return ""
+}
+
+// Config returns the current access control configuration of the engine and
+// the staged one, which is nil if no configuration is staged.
+func (e *Engine) Config() (current, staged *config.AccessControl) {
+	if next := e.next.Load(); next != nil {
+		staged = next.AccessControl
+	}
+	return e.config.Load().AccessControl, staged
+}
+
+// PeeringDBOrganizations returns the PeeringDB organizations used by the
+// rules of the current and staged configurations, in order of appearance.
+func (e *Engine) PeeringDBOrganizations() []string {
+	var names []string
+	for _, cfg := range []*compiledConfig{e.config.Load(), e.next.Load()} {
+		if cfg == nil {
+			continue
+		}
+		for _, rule := range cfg.Rules {
+			for _, name := range rule.PeeringDBOrganizations {
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// SetOrganizationASNs sets the ASNs of the given PeeringDB organizations, by
+// name. Names are case-insensitive. The ASNs of the other organizations are
+// kept, so that an organization that can't be resolved keeps its previous
+// ASNs.
+func (e *Engine) SetOrganizationASNs(asns map[string][]uint32) {
+	e.peeringDBMu.Lock()
+	defer e.peeringDBMu.Unlock()
+
+	updated := make(organizationASNs)
+	if current := e.peeringDB.Load(); current != nil {
+		maps.Copy(updated, *current)
+	}
+	for name, list := range asns {
+		set := make(map[uint32]bool, len(list))
+		for _, asn := range list {
+			set[asn] = true
+		}
+		updated[strings.ToLower(name)] = set
+	}
+	e.peeringDB.Store(&updated)
+}
+
+// NoRule is the rule index of the decisions that aren't made by a rule: the
+// default and fallback policies, allowed CORS preflight requests and bans.
+const NoRule = -1
+
+// Decision is the result of the evaluation of a query.
+type Decision struct {
+	Allowed bool
+	Banned  bool // Whether the source IP is temporarily banned
+	Rule    int  // Index of the matching rule, or NoRule if none matched
+
+	// RuleName is the name of the matching rule. It's empty if no rule
+	// matched or if the rule has no name.
+	RuleName string
+
+	// RetryAfter is the time until the rate limit of the matching rule lets
+	// the source IP make a new request. It's zero unless the query is denied
+	// by a rate limit.
+	RetryAfter time.Duration
+
+	// DenyResponse is the response to send if the query is denied: the one of
+	// the matching rule or, if it has none, the default one. It's nil if
+	// neither is configured.
+	DenyResponse *config.DenyResponse
+
+	// Webhook is the webhook of the matching rule. It's nil if no rule
+	// matched or if the rule has no webhook.
+	Webhook *config.Webhook
+
+	// Generation is the generation of the configuration that made the
+	// decision. See Engine.Generation.
+	Generation uint64
+
+	// Evaluated is the number of rules evaluated to make the decision: the
+	// position of the matching rule, starting from 1, or the number of rules
+	// if none matched. It's zero if the decision was made before evaluating
+	// the rules, e.g., for a banned source IP.
+	Evaluated int
 }
 
 // Authorize checks if the given query is allowed by the engine's rules. The
 // engine will return true if the query is allowed, false otherwise.
 func (e *Engine) Authorize(query *Query) bool {
+	return e.Decide(query).Allowed
+}
+
+// Decide evaluates the given query against the engine's rules and returns the
+// decision.
+//
+// In maintenance mode, the maintenance policy is applied to all the queries.
+//
+// Banned source IPs are denied before evaluating the rules.
+//
+// If a fallback policy is set, it's applied to the queries without source
+// country, after the bans.
+//
+// Allowed CORS preflight requests are authorized before evaluating the rules,
+// since blocking them causes confusing browser errors for allowed users.
+//
+// Allow rules with a rate limit deny the requests of a source IP once it
+// exceeds the limit, and allow rules with a quota deny the requests of a
+// source country once its quota is used.
+func (e *Engine) Decide(query *Query) Decision {
+	return e.decide(query, true)
+}
+
+// Evaluate evaluates the given query like Decide, but without consuming the
+// rate limits and quotas: rules with a rate limit or a quota apply their
+// policy. It lets queries be tested without affecting the decisions of the
+// actual requests.
+func (e *Engine) Evaluate(query *Query) Decision {
+	return e.decide(query, false)
+}
+
+// decide evaluates the given query with the engine's configuration. If limit
+// is false, the rate limits and quotas aren't applied, and the evaluated
+// rules aren't counted in the metrics.
+func (e *Engine) decide(query *Query, limit bool) Decision {
 	cfg := e.config.Load()
-	for _, rule := range cfg.Rules {
-		if ruleApplies(&rule, query) {
-			return rule.Policy == config.PolicyAllow
+	decision := e.decideWith(cfg, query, limit, nil)
+	decision.Generation = cfg.generation
+	if limit && decision.Evaluated > 0 {
+		result := metrics.ResultDenied
+		if decision.Allowed {
+			result = metrics.ResultAllowed
+		}
+		metrics.RulesEvaluated.WithLabelValues(result).Observe(
+			float64(decision.Evaluated),
+		)
+	}
+	return decision
+}
+
+// decideWith evaluates the given query with the given configuration. At the
+// trace log level, the result of each condition of the evaluated rules is
+// logged. If explanation isn't nil, the reason of the decision and the
+// evaluated rules are recorded in it.
+func (e *Engine) decideWith(
+	cfg *compiledConfig,
+	query *Query,
+	limit bool,
+	explanation *Explanation,
+) Decision {
+	if maintenance := e.maintenance.Load(); maintenance != nil {
+		explanation.setReason(ReasonMaintenance)
+		return Decision{
+			Allowed:      *maintenance == config.PolicyAllow,
+			Rule:         NoRule,
+			DenyResponse: cfg.DenyResponse,
+		}
+	}
+	if e.bans.Banned(query.SourceIP, time.Now()) {
+		explanation.setReason(ReasonBanned)
+		return Decision{
+			Banned:       true,
+			Rule:         NoRule,
+			DenyResponse: cfg.DenyResponse,
+		}
+	}
+	if fallback := e.fallback.Load(); fallback != nil &&
+		query.SourceCountry == "" {
+		explanation.setReason(ReasonFallback)
+		return Decision{
+			Allowed:      *fallback == config.PolicyAllow,
+			Rule:         NoRule,
+			DenyResponse: cfg.DenyResponse,
+		}
+	}
+	if allowPreflight(&cfg.Preflight, query) {
+		explanation.setReason(ReasonPreflight)
+		return Decision{Allowed: true, Rule: NoRule}
+	}
+	var peeringDB organizationASNs
+	if asns := e.peeringDB.Load(); asns != nil {
+		peeringDB = *asns
+	}
+	trace := log.IsLevelEnabled(log.TraceLevel)
+	for i, rule := range cfg.Rules {
+		result := matchRule(
+			&rule,
+			&cfg.countries[i],
+			cfg.organizations[i],
+			peeringDB,
+			query,
+		)
+		if trace {
+			log.WithFields(result.fields()).WithFields(log.Fields{
+				"rule":             i,
+				"rule_name":        rule.Name,
+				"rule_applies":     result.applies(),
+				FieldSourceIP:      query.SourceIP,
+				"request_domain":   query.RequestedDomain,
+				"request_method":   query.RequestedMethod,
+				"request_path":     query.RequestedPath,
+				"request_protocol": query.RequestedProtocol,
+			}).Trace("Rule evaluated")
+		}
+		explanation.addRule(i, &rule, &result)
+		if !result.applies() {
+			continue
 		}
+
+		var (
+			allowed    = rule.Policy == config.PolicyAllow
+			retryAfter time.Duration
+		)
+		if allowed && limit && rule.RateLimit != nil {
+			allowed, retryAfter = e.limiter.Load().allow(
+				i,
+				query.SourceIP,
+				rule.RateLimit,
+				time.Now(),
+			)
+		}
+		if allowed && limit && rule.Quota != nil {
+			allowed = e.quotas.Load().allow(
+				i,
+				query.SourceCountry,
+				rule.Quota,
+				time.Now(),
+			)
+		}
+
+		response := rule.DenyResponse
+		if response == nil {
+			response = cfg.DenyResponse
+		}
+		explanation.setReason(ReasonRule)
+		return Decision{
+			Allowed:      allowed,
+			Rule:         i,
+			RuleName:     rule.Name,
+			RetryAfter:   retryAfter,
+			DenyResponse: response,
+			Webhook:      rule.Webhook,
+			Evaluated:    i + 1,
+		}
+	}
+	explanation.setReason(ReasonDefault)
+	return Decision{
+		Allowed:      cfg.DefaultPolicy == config.PolicyAllow,
+		Rule:         NoRule,
+		DenyResponse: cfg.DenyResponse,
+		Evaluated:    len(cfg.Rules),
 	}
-	return cfg.DefaultPolicy == config.PolicyAllow
 }
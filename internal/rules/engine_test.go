@@ -2,13 +2,21 @@ package rules_test
 
 import (
 	"net/netip"
+	"slices"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/danroc/geoblock/internal/bans"
 	"github.com/danroc/geoblock/internal/config"
 	"github.com/danroc/geoblock/internal/rules"
 )
 
 func TestEngineAuthorize(t *testing.T) {
+	isCDN, isNotCDN := true, false
+
 	tests := []struct {
 		name   string
 		config *config.AccessControl
@@ -197,6 +205,56 @@ func TestEngineAuthorize(t *testing.T) {
 			},
 			want: true,
 		},
+		{
+			name: "deny plaintext by protocol",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Domains:   []string{"admin.example.com"},
+						Protocols: []string{"http"},
+						Policy:    config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				RequestedDomain:   "admin.example.com",
+				RequestedProtocol: "http",
+			},
+			want: false,
+		},
+		{
+			name: "allow by protocol",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Protocols: []string{"https"},
+						Policy:    config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				RequestedProtocol: "HTTPS",
+			},
+			want: true,
+		},
+		{
+			name: "deny unknown protocol",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Protocols: []string{"https"},
+						Policy:    config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				RequestedMethod: "GET",
+			},
+			want: false,
+		},
 		{
 			name: "allow by network",
 			config: &config.AccessControl{
@@ -304,8 +362,11 @@ func TestEngineAuthorize(t *testing.T) {
 			config: &config.AccessControl{
 				Rules: []config.AccessControlRule{
 					{
-						AutonomousSystems: []uint32{1111, 2222},
-						Policy:            config.PolicyAllow,
+						AutonomousSystems: []config.ASNRange{
+							{First: 1111, Last: 1111},
+							{First: 2222, Last: 2222},
+						},
+						Policy: config.PolicyAllow,
 					},
 				},
 				DefaultPolicy: config.PolicyDeny,
@@ -320,8 +381,11 @@ func TestEngineAuthorize(t *testing.T) {
 			config: &config.AccessControl{
 				Rules: []config.AccessControlRule{
 					{
-						AutonomousSystems: []uint32{1111, 2222},
-						Policy:            config.PolicyDeny,
+						AutonomousSystems: []config.ASNRange{
+							{First: 1111, Last: 1111},
+							{First: 2222, Last: 2222},
+						},
+						Policy: config.PolicyDeny,
 					},
 				},
 				DefaultPolicy: config.PolicyAllow,
@@ -336,8 +400,11 @@ func TestEngineAuthorize(t *testing.T) {
 			config: &config.AccessControl{
 				Rules: []config.AccessControlRule{
 					{
-						AutonomousSystems: []uint32{1111, 2222},
-						Policy:            config.PolicyAllow,
+						AutonomousSystems: []config.ASNRange{
+							{First: 1111, Last: 1111},
+							{First: 2222, Last: 2222},
+						},
+						Policy: config.PolicyAllow,
 					},
 				},
 				DefaultPolicy: config.PolicyDeny,
@@ -347,6 +414,42 @@ func TestEngineAuthorize(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "allow by ASN range",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						AutonomousSystems: []config.ASNRange{
+							{First: 64512, Last: 65534},
+						},
+						Policy: config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceASN: 65000,
+			},
+			want: true,
+		},
+		{
+			name: "deny ASN outside of range",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						AutonomousSystems: []config.ASNRange{
+							{First: 64512, Last: 65534},
+						},
+						Policy: config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceASN: 65535,
+			},
+			want: false,
+		},
 		{
 			name: "allow by domain, network, country, and ASN",
 			config: &config.AccessControl{
@@ -356,9 +459,11 @@ func TestEngineAuthorize(t *testing.T) {
 						Networks: []config.CIDR{
 							{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
 						},
-						Countries:         []string{"FR"},
-						AutonomousSystems: []uint32{1111},
-						Policy:            config.PolicyAllow,
+						Countries: []string{"FR"},
+						AutonomousSystems: []config.ASNRange{
+							{First: 1111, Last: 1111},
+						},
+						Policy: config.PolicyAllow,
 					},
 				},
 				DefaultPolicy: config.PolicyDeny,
@@ -372,13 +477,193 @@ func TestEngineAuthorize(t *testing.T) {
 			want: true,
 		},
 		{
-			name: "deny by default when query doesn't fully match rule",
+			name: "deny CDN sources",
 			config: &config.AccessControl{
 				Rules: []config.AccessControlRule{
 					{
-						Domains: []string{"example.com"},
-						Networks: []config.CIDR{
-							{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+						IsCDN:  &isCDN,
+						Policy: config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceIsCDN: true,
+			},
+			want: false,
+		},
+		{
+			name: "allow non-CDN sources",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						IsCDN:  &isCDN,
+						Policy: config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceIsCDN: false,
+			},
+			want: true,
+		},
+		{
+			name: "allow only non-CDN sources",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						IsCDN:  &isNotCDN,
+						Policy: config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceIsCDN: true,
+			},
+			want: false,
+		},
+		{
+			name: "deny by minimum forwarded hops",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						MinForwardedHops: 3,
+						Policy:           config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				ForwardedHops: 4,
+			},
+			want: false,
+		},
+		{
+			name: "allow below minimum forwarded hops",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						MinForwardedHops: 3,
+						Policy:           config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				ForwardedHops: 2,
+			},
+			want: true,
+		},
+		{
+			name: "allow by maximum forwarded hops",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						MaxForwardedHops: 2,
+						Policy:           config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				ForwardedHops: 2,
+			},
+			want: true,
+		},
+		{
+			name: "deny above maximum forwarded hops",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						MaxForwardedHops: 2,
+						Policy:           config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				ForwardedHops: 3,
+			},
+			want: false,
+		},
+		{
+			name: "allow by region",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Countries: []string{"US"},
+						Regions:   []string{"California"},
+						Policy:    config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceCountry: "US",
+				SourceRegion:  "california",
+			},
+			want: true,
+		},
+		{
+			name: "deny by region",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Regions: []string{"California"},
+						Policy:  config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceCountry: "US",
+				SourceRegion:  "Nevada",
+			},
+			want: false,
+		},
+		{
+			name: "deny by city",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Cities: []string{"Munich", "Berlin"},
+						Policy: config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceCountry: "DE",
+				SourceCity:    "Munich",
+			},
+			want: false,
+		},
+		{
+			name: "allow without city",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Cities: []string{"Munich"},
+						Policy: config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceCountry: "DE",
+			},
+			want: true,
+		},
+		{
+			name: "allow by context",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Context: map[string][]string{
+							"Tenant": {"acme", "example-*"},
+							"tier":   {"paid"},
 						},
 						Policy: config.PolicyAllow,
 					},
@@ -386,27 +671,203 @@ func TestEngineAuthorize(t *testing.T) {
 				DefaultPolicy: config.PolicyDeny,
 			},
 			query: &rules.Query{
-				RequestedDomain: "example.com",
-				SourceIP:        netip.MustParseAddr("192.168.1.1"),
+				Context: map[string]string{
+					"tenant": "example-eu",
+					"tier":   "paid",
+				},
+			},
+			want: true,
+		},
+		{
+			name: "deny by context value",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Context: map[string][]string{"tier": {"paid"}},
+						Policy:  config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				Context: map[string]string{"tier": "Paid"},
 			},
 			want: false,
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			e := rules.NewEngine(tt.config)
-			if got := e.Authorize(tt.query); got != tt.want {
-				t.Errorf("Engine.Authorize() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestEngineUpdateConfig(t *testing.T) {
-	e := rules.NewEngine(&config.AccessControl{
-		DefaultPolicy: config.PolicyAllow,
-	})
+		{
+			name: "deny without context key",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Context: map[string][]string{"tier": {"*"}},
+						Policy:  config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				Context: map[string]string{"tenant": "acme"},
+			},
+			want: false,
+		},
+		{
+			name: "allow by monitor",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Domains:  []string{"example.com"},
+						Monitors: []string{"uptimerobot"},
+						Policy:   config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				RequestedDomain: "example.com",
+				SourceMonitor:   "uptimerobot",
+			},
+			want: true,
+		},
+		{
+			name: "deny by other monitor",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Monitors: []string{"uptimerobot"},
+						Policy:   config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				SourceMonitor: "pingdom",
+			},
+			want: false,
+		},
+		{
+			name: "deny by anonymizer",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Anonymizers: []string{"tor", "vpn"},
+						Policy:      config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceAnonymizers: []string{"vpn", "proxy"},
+			},
+			want: false,
+		},
+		{
+			name: "allow by other anonymizer",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Anonymizers: []string{"tor"},
+						Policy:      config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyAllow,
+			},
+			query: &rules.Query{
+				SourceAnonymizers: []string{"proxy"},
+			},
+			want: true,
+		},
+		{
+			name: "allow preflight before rules",
+			config: &config.AccessControl{
+				Preflight: config.Preflight{Allow: true},
+				Rules: []config.AccessControlRule{
+					{
+						Domains: []string{"example.com"},
+						Policy:  config.PolicyDeny,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				RequestedDomain: "example.com",
+				PreflightOrigin: "https://app.example.com",
+			},
+			want: true,
+		},
+		{
+			name: "allow preflight from matching origin",
+			config: &config.AccessControl{
+				Preflight: config.Preflight{
+					Allow:   true,
+					Origins: []string{"https://*.example.com"},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				PreflightOrigin: "https://APP.example.com",
+			},
+			want: true,
+		},
+		{
+			name: "deny preflight from other origin",
+			config: &config.AccessControl{
+				Preflight: config.Preflight{
+					Allow:   true,
+					Origins: []string{"https://*.example.com"},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				PreflightOrigin: "https://example.org",
+			},
+			want: false,
+		},
+		{
+			name: "deny preflight when fast path is disabled",
+			config: &config.AccessControl{
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				PreflightOrigin: "https://app.example.com",
+			},
+			want: false,
+		},
+		{
+			name: "deny by default when query doesn't fully match rule",
+			config: &config.AccessControl{
+				Rules: []config.AccessControlRule{
+					{
+						Domains: []string{"example.com"},
+						Networks: []config.CIDR{
+							{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+						},
+						Policy: config.PolicyAllow,
+					},
+				},
+				DefaultPolicy: config.PolicyDeny,
+			},
+			query: &rules.Query{
+				RequestedDomain: "example.com",
+				SourceIP:        netip.MustParseAddr("192.168.1.1"),
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := rules.NewEngine(tt.config)
+			if got := e.Authorize(tt.query); got != tt.want {
+				t.Errorf("Engine.Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineUpdateConfig(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
 
 	if got := e.Authorize(&rules.Query{}); got != true {
 		t.Errorf("Engine.Authorize() = %v, want %v", got, true)
@@ -420,3 +881,783 @@ func TestEngineUpdateConfig(t *testing.T) {
 		t.Errorf("Engine.Authorize() = %v, want %v", got, false)
 	}
 }
+
+func TestEngineDecideDenyResponse(t *testing.T) {
+	var (
+		defaultResponse = &config.DenyResponse{Status: 451}
+		ruleResponse    = &config.DenyResponse{Status: 404}
+	)
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		DenyResponse:  defaultResponse,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:      []string{"hidden.example.com"},
+				Policy:       config.PolicyDeny,
+				DenyResponse: ruleResponse,
+			},
+			{
+				Domains: []string{"blocked.example.com"},
+				Policy:  config.PolicyDeny,
+			},
+		},
+	})
+
+	tests := []struct {
+		domain string
+		want   *config.DenyResponse
+	}{
+		{"hidden.example.com", ruleResponse},
+		{"blocked.example.com", defaultResponse},
+		{"other.example.com", defaultResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			got := e.Decide(&rules.Query{RequestedDomain: tt.domain})
+			if got.Allowed {
+				t.Error("Engine.Decide() allowed the query")
+			}
+			if got.DenyResponse != tt.want {
+				t.Errorf("Engine.Decide() = %+v, want %+v",
+					got.DenyResponse, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineDecideWebhook(t *testing.T) {
+	hook := &config.Webhook{URL: "https://hooks.example.com/admin"}
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"admin.example.com"},
+				Policy:  config.PolicyDeny,
+				Webhook: hook,
+			},
+			{
+				Domains: []string{"www.example.com"},
+				Policy:  config.PolicyAllow,
+			},
+		},
+	})
+
+	tests := []struct {
+		domain string
+		want   *config.Webhook
+	}{
+		{"admin.example.com", hook},
+		{"www.example.com", nil},
+		{"other.example.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			got := e.Decide(&rules.Query{RequestedDomain: tt.domain})
+			if got.Webhook != tt.want {
+				t.Errorf("Engine.Decide() = %+v, want %+v",
+					got.Webhook, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineCountryGroups(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		CountryGroups: map[string][]string{
+			"NORDICS": {"DK", "FI", "IS", "NO", "SE"},
+			"HOME":    {"FIVE_EYES", "FR"},
+		},
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"eu.example.com"},
+				Countries: []string{"EU", "!HU"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Domains:   []string{"nordics.example.com"},
+				Countries: []string{"NORDICS"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Domains:   []string{"home.example.com"},
+				Countries: []string{"HOME", "!US"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Domains:   []string{"open.example.com"},
+				Countries: []string{"!RU"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+
+	tests := []struct {
+		domain  string
+		country string
+		want    bool
+	}{
+		{"eu.example.com", "FR", true},
+		{"eu.example.com", "fr", true},
+		{"eu.example.com", "HU", false},
+		{"eu.example.com", "NO", false},
+		{"nordics.example.com", "NO", true},
+		{"nordics.example.com", "FR", false},
+		{"home.example.com", "GB", true},
+		{"home.example.com", "FR", true},
+		{"home.example.com", "US", false},
+		{"open.example.com", "US", true},
+		{"open.example.com", "", true},
+		{"open.example.com", "RU", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.country, func(t *testing.T) {
+			got := e.Authorize(&rules.Query{
+				RequestedDomain: tt.domain,
+				SourceCountry:   tt.country,
+			})
+			if got != tt.want {
+				t.Errorf("Engine.Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineNegatedConditions(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:    []string{"*.example.com"},
+				NotDomains: []string{"admin.example.com"},
+				Policy:     config.PolicyAllow,
+			},
+			{
+				Domains: []string{"example.org"},
+				Networks: []config.CIDR{
+					{Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+				},
+				NotNetworks: []config.CIDR{
+					{Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+				},
+				Policy: config.PolicyAllow,
+			},
+			{
+				Domains:      []string{"example.net"},
+				NotCountries: []string{"RU", "FIVE_EYES"},
+				Policy:       config.PolicyAllow,
+			},
+			{
+				Domains:   []string{"eu.example.net"},
+				Countries: []string{"EU"},
+				NotAutonomousSystems: []config.ASNRange{
+					{First: 64512, Last: 65534},
+				},
+				Policy: config.PolicyAllow,
+			},
+		},
+	})
+
+	tests := []struct {
+		name  string
+		query rules.Query
+		want  bool
+	}{
+		{
+			"domain",
+			rules.Query{RequestedDomain: "www.example.com"},
+			true,
+		},
+		{
+			"excluded domain",
+			rules.Query{RequestedDomain: "admin.example.com"},
+			false,
+		},
+		{
+			"network",
+			rules.Query{
+				RequestedDomain: "example.org",
+				SourceIP:        netip.MustParseAddr("10.2.0.1"),
+			},
+			true,
+		},
+		{
+			"excluded network",
+			rules.Query{
+				RequestedDomain: "example.org",
+				SourceIP:        netip.MustParseAddr("10.1.0.1"),
+			},
+			false,
+		},
+		{
+			"country",
+			rules.Query{RequestedDomain: "example.net", SourceCountry: "FR"},
+			true,
+		},
+		{
+			"unknown country",
+			rules.Query{RequestedDomain: "example.net"},
+			true,
+		},
+		{
+			"excluded country",
+			rules.Query{RequestedDomain: "example.net", SourceCountry: "ru"},
+			false,
+		},
+		{
+			"excluded country group",
+			rules.Query{RequestedDomain: "example.net", SourceCountry: "US"},
+			false,
+		},
+		{
+			"ASN",
+			rules.Query{
+				RequestedDomain: "eu.example.net",
+				SourceCountry:   "FR",
+				SourceASN:       1234,
+			},
+			true,
+		},
+		{
+			"excluded ASN",
+			rules.Query{
+				RequestedDomain: "eu.example.net",
+				SourceCountry:   "FR",
+				SourceASN:       65000,
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Authorize(&tt.query); got != tt.want {
+				t.Errorf("Engine.Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineOrganizations(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Organizations: []string{"Example, Inc.", "Amazon*"},
+				Policy:        config.PolicyDeny,
+			},
+		},
+	})
+
+	tests := []struct {
+		org  string
+		want bool
+	}{
+		{"example", false},
+		{"amazon", false},
+		{"amazoncom", false},
+		{"example networks", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.org, func(t *testing.T) {
+			got := e.Authorize(&rules.Query{SourceOrg: tt.org})
+			if got != tt.want {
+				t.Errorf("Engine.Authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnginePeeringDBOrganizations(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				PeeringDBOrganizations: []string{"GitHub"},
+				Policy:                 config.PolicyAllow,
+			},
+			{
+				PeeringDBOrganizations: []string{"Example", "GitHub"},
+				Policy:                 config.PolicyDeny,
+			},
+		},
+	})
+	e.StageConfig(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				PeeringDBOrganizations: []string{"Staged"},
+				Policy:                 config.PolicyAllow,
+			},
+		},
+	})
+
+	names := e.PeeringDBOrganizations()
+	want := []string{"GitHub", "Example", "Staged"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got organizations %v, want %v", names, want)
+	}
+
+	query := &rules.Query{SourceASN: 36459}
+	if e.Authorize(query) {
+		t.Error("got true before the organization was resolved")
+	}
+
+	e.SetOrganizationASNs(map[string][]uint32{"github": {36459}})
+	if !e.Authorize(query) {
+		t.Error("got false after the organization was resolved")
+	}
+
+	// The ASNs of the organizations that aren't given are kept.
+	e.SetOrganizationASNs(map[string][]uint32{"Example": {64512}})
+	if !e.Authorize(query) {
+		t.Error("got false after another organization was resolved")
+	}
+	if e.Authorize(&rules.Query{SourceASN: 64512}) {
+		t.Error("got true for an ASN of the denied organization")
+	}
+}
+
+func TestEngineBans(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	if _, err := e.Bans().Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: time.Now().Add(time.Hour),
+	}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := e.Decide(&rules.Query{SourceIP: ip})
+	if got.Allowed || !got.Banned {
+		t.Errorf("Engine.Decide() = %+v, want banned", got)
+	}
+
+	// Bans survive configuration reloads.
+	e.UpdateConfig(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	if e.Authorize(&rules.Query{SourceIP: ip}) {
+		t.Error("Engine.Authorize() = true after reloading the config")
+	}
+	other := netip.MustParseAddr("11.0.0.1")
+	if !e.Authorize(&rules.Query{SourceIP: other}) {
+		t.Error("Engine.Authorize() = false for an IP that isn't banned")
+	}
+}
+
+func TestEnginePromoteConfig(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+
+	if e.PromoteConfig() {
+		t.Error("Engine.PromoteConfig() = true without a staged config")
+	}
+
+	e.StageConfig(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+	})
+	if !e.Authorize(&rules.Query{}) {
+		t.Error("Engine.Authorize() = false before the promotion")
+	}
+
+	if !e.PromoteConfig() {
+		t.Error("Engine.PromoteConfig() = false with a staged config")
+	}
+	if e.Authorize(&rules.Query{}) {
+		t.Error("Engine.Authorize() = true after the promotion")
+	}
+
+	// The staged configuration is only promoted once.
+	if e.PromoteConfig() {
+		t.Error("Engine.PromoteConfig() = true after the promotion")
+	}
+}
+
+func TestEngineDecideRule(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"a.example.com"},
+				Policy:  config.PolicyAllow,
+			},
+			{
+				Domains: []string{"b.example.com"},
+				Policy:  config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 1,
+					Window:   time.Minute,
+				},
+			},
+		},
+	})
+	ip := netip.MustParseAddr("10.0.0.1")
+
+	tests := []struct {
+		domain string
+		rule   int
+	}{
+		{"a.example.com", 0},
+		{"b.example.com", 1},
+		{"c.example.com", rules.NoRule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			query := &rules.Query{RequestedDomain: tt.domain, SourceIP: ip}
+			if got := e.Decide(query).Rule; got != tt.rule {
+				t.Errorf("Engine.Decide().Rule = %d, want %d", got, tt.rule)
+			}
+		})
+	}
+
+	// The rate limit of rule 1 was consumed by Decide, but not by Evaluate.
+	query := &rules.Query{RequestedDomain: "b.example.com", SourceIP: ip}
+	for range 3 {
+		if !e.Evaluate(query).Allowed {
+			t.Error("Engine.Evaluate() applied the rate limit")
+		}
+	}
+	if e.Decide(query).Allowed {
+		t.Error("Engine.Decide() didn't apply the rate limit")
+	}
+}
+
+func TestEngineFallbackPolicy(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	var (
+		unknown  = &rules.Query{SourceIP: netip.MustParseAddr("10.0.0.1")}
+		resolved = &rules.Query{
+			SourceIP:      netip.MustParseAddr("10.0.0.2"),
+			SourceCountry: "US",
+		}
+	)
+
+	tests := []struct {
+		policy   string
+		unknown  bool
+		resolved bool
+	}{
+		{"", false, false},
+		{config.PolicyAllow, true, false},
+		{config.PolicyDeny, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			e.SetFallbackPolicy(tt.policy)
+			if got := e.Authorize(unknown); got != tt.unknown {
+				t.Errorf("unknown country: got %v, want %v",
+					got, tt.unknown)
+			}
+			if got := e.Authorize(resolved); got != tt.resolved {
+				t.Errorf("resolved country: got %v, want %v",
+					got, tt.resolved)
+			}
+		})
+	}
+}
+
+func TestEngineTraceConditions(t *testing.T) {
+	hook := test.NewGlobal()
+	level := log.GetLevel()
+	log.SetLevel(log.TraceLevel)
+	defer func() {
+		log.SetLevel(level)
+		hook.Reset()
+	}()
+
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"example.com"},
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Name:      "united-states",
+				Countries: []string{"US"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	e.Decide(&rules.Query{
+		RequestedDomain: "example.com",
+		SourceIP:        netip.MustParseAddr("10.0.0.1"),
+		SourceCountry:   "US",
+	})
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d log entries, want 2", len(entries))
+	}
+	tests := []struct {
+		field string
+		want  [2]any
+	}{
+		{"rule", [2]any{0, 1}},
+		{"rule_name", [2]any{"", "united-states"}},
+		{"rule_applies", [2]any{false, true}},
+		{"match_domain", [2]any{true, true}},
+		{"match_country", [2]any{false, true}},
+		{"match_network", [2]any{true, true}},
+	}
+	for _, tt := range tests {
+		for i, entry := range entries {
+			if got := entry.Data[tt.field]; got != tt.want[i] {
+				t.Errorf("entry %d: got %s = %v, want %v", i, tt.field,
+					got, tt.want[i])
+			}
+		}
+	}
+
+	// Nothing is logged above the trace level.
+	hook.Reset()
+	log.SetLevel(log.DebugLevel)
+	e.Decide(&rules.Query{SourceCountry: "US"})
+	if len(hook.AllEntries()) != 0 {
+		t.Error("got log entries at the debug level, want none")
+	}
+}
+
+func TestEngineMaintenancePolicy(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	var (
+		allowed = &rules.Query{
+			SourceIP:      netip.MustParseAddr("10.0.0.1"),
+			SourceCountry: "FR",
+		}
+		denied = &rules.Query{
+			SourceIP:      netip.MustParseAddr("10.0.0.2"),
+			SourceCountry: "US",
+		}
+	)
+
+	tests := []struct {
+		policy  string
+		allowed bool
+		denied  bool
+	}{
+		{config.PolicyAllow, true, true},
+		{config.PolicyDeny, false, false},
+		{"", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			e.SetMaintenancePolicy(tt.policy)
+			if got := e.MaintenancePolicy(); got != tt.policy {
+				t.Errorf("got policy %q, want %q", got, tt.policy)
+			}
+			if got := e.Authorize(allowed); got != tt.allowed {
+				t.Errorf("allowed query: got %v, want %v", got, tt.allowed)
+			}
+			if got := e.Authorize(denied); got != tt.denied {
+				t.Errorf("denied query: got %v, want %v", got, tt.denied)
+			}
+		})
+	}
+}
+
+func TestEngineConfig(t *testing.T) {
+	current := &config.AccessControl{DefaultPolicy: config.PolicyAllow}
+	e := rules.NewEngine(current)
+	if got, staged := e.Config(); got != current || staged != nil {
+		t.Errorf("got %v and %v, want %v and nil", got, staged, current)
+	}
+
+	next := &config.AccessControl{DefaultPolicy: config.PolicyDeny}
+	e.StageConfig(next)
+	if got, staged := e.Config(); got != current || staged != next {
+		t.Errorf("got %v and %v, want %v and %v", got, staged, current, next)
+	}
+}
+
+func TestEngineSandbox(t *testing.T) {
+	current := &config.AccessControl{DefaultPolicy: config.PolicyAllow}
+	e := rules.NewEngine(current)
+	e.SetOrganizationASNs(map[string][]uint32{"github": {36459}})
+	if _, err := e.Bans().Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: time.Now().Add(time.Hour),
+	}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := e.Sandbox(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				PeeringDBOrganizations: []string{"GitHub"},
+				Policy:                 config.PolicyAllow,
+			},
+		},
+	})
+
+	github := &rules.Query{
+		SourceIP:  netip.MustParseAddr("192.0.2.1"),
+		SourceASN: 36459,
+	}
+	if !sandbox.Evaluate(github).Allowed {
+		t.Error("got denied, want allowed by the organization of the engine")
+	}
+	if sandbox.Evaluate(&rules.Query{SourceIP: github.SourceIP}).Allowed {
+		t.Error("got allowed, want denied by the candidate default policy")
+	}
+	banned := &rules.Query{
+		SourceIP:  netip.MustParseAddr("10.0.0.1"),
+		SourceASN: 36459,
+	}
+	if got := sandbox.Evaluate(banned); !got.Banned {
+		t.Errorf("got %+v, want banned by the bans of the engine", got)
+	}
+
+	// The engine keeps its configuration.
+	if got, staged := e.Config(); got != current || staged != nil {
+		t.Errorf("got %v and %v, want %v and nil", got, staged, current)
+	}
+	if !e.Evaluate(&rules.Query{SourceIP: github.SourceIP}).Allowed {
+		t.Error("got denied by the engine, want allowed")
+	}
+}
+
+func TestEngineGeneration(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	query := &rules.Query{SourceIP: netip.MustParseAddr("1.2.3.4")}
+
+	steps := []struct {
+		name   string
+		change func()
+		want   uint64
+	}{
+		{"initial", func() {}, 1},
+		{"update", func() {
+			e.UpdateConfig(&config.AccessControl{
+				DefaultPolicy: config.PolicyDeny,
+			})
+		}, 2},
+		{"stage", func() {
+			e.StageConfig(&config.AccessControl{
+				DefaultPolicy: config.PolicyAllow,
+			})
+		}, 2},
+		{"promote", func() { e.PromoteConfig() }, 3},
+		{"promote without staged", func() { e.PromoteConfig() }, 3},
+	}
+
+	for _, step := range steps {
+		step.change()
+		if got := e.Generation(); got != step.want {
+			t.Errorf("%s: got generation %d, want %d",
+				step.name, got, step.want)
+		}
+		if got := e.Decide(query).Generation; got != step.want {
+			t.Errorf("%s: got decision generation %d, want %d",
+				step.name, got, step.want)
+		}
+	}
+}
+
+func TestDecisionEvaluated(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{Domains: []string{"a.example.com"}, Policy: config.PolicyDeny},
+			{Domains: []string{"b.example.com"}, Policy: config.PolicyAllow},
+		},
+	})
+
+	tests := []struct {
+		domain string
+		want   int
+	}{
+		{"a.example.com", 1},
+		{"b.example.com", 2},
+		{"c.example.com", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			decision := e.Decide(&rules.Query{
+				RequestedDomain: tt.domain,
+				SourceIP:        netip.MustParseAddr("1.2.3.4"),
+			})
+			if decision.Evaluated != tt.want {
+				t.Errorf("got %d evaluated rules, want %d",
+					decision.Evaluated, tt.want)
+			}
+		})
+	}
+
+	e.SetMaintenancePolicy(config.PolicyDeny)
+	decision := e.Decide(&rules.Query{RequestedDomain: "a.example.com"})
+	if decision.Evaluated != 0 {
+		t.Errorf("got %d evaluated rules in maintenance mode, want 0",
+			decision.Evaluated)
+	}
+}
+
+func TestDecisionRuleName(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Name:        "admin-france",
+				Description: "Only allow the admins from France",
+				Domains:     []string{"admin.example.com"},
+				Countries:   []string{"!FR"},
+				Policy:      config.PolicyDeny,
+			},
+			{Domains: []string{"b.example.com"}, Policy: config.PolicyDeny},
+		},
+	})
+
+	tests := []struct {
+		domain string
+		rule   int
+		name   string
+	}{
+		{"admin.example.com", 0, "admin-france"},
+		{"b.example.com", 1, ""},
+		{"c.example.com", rules.NoRule, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			decision := e.Decide(&rules.Query{
+				RequestedDomain: tt.domain,
+				SourceCountry:   "US",
+			})
+			if decision.Rule != tt.rule || decision.RuleName != tt.name {
+				t.Errorf("got rule %d %q, want %d %q", decision.Rule,
+					decision.RuleName, tt.rule, tt.name)
+			}
+		})
+	}
+}
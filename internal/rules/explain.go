@@ -0,0 +1,89 @@
+package rules
+
+import "github.com/danroc/geoblock/internal/config"
+
+// Reasons of the decisions, see Explanation.
+const (
+	ReasonMaintenance = "maintenance" // The maintenance policy applied
+	ReasonBanned      = "banned"      // The source IP is banned
+	ReasonFallback    = "fallback"    // The fallback policy applied
+	ReasonPreflight   = "preflight"   // The CORS preflight was allowed
+	ReasonRule        = "rule"        // A rule matched
+	ReasonDefault     = "default"     // No rule matched
+)
+
+// RuleExplanation is the evaluation of a rule for a query.
+type RuleExplanation struct {
+	Rule    int    // Index of the rule
+	Name    string // Name of the rule, if any
+	Policy  string // Policy of the rule
+	Applies bool   // Whether all the conditions of the rule match
+
+	// Conditions are the results of the conditions of the rule, by name,
+	// e.g., "country". The conditions that the rule doesn't set always
+	// match.
+	Conditions map[string]bool
+}
+
+// Explanation explains the decision of the engine for a query.
+type Explanation struct {
+	Decision Decision
+
+	// Policy is the effective policy of the decision, PolicyAllow or
+	// PolicyDeny.
+	Policy string
+
+	// Reason is why the decision was made, e.g., ReasonRule if a rule matched
+	// or ReasonDefault if the default policy applied.
+	Reason string
+
+	// Rules are the evaluated rules, in order, up to the matching one. It's
+	// empty if the decision was made before evaluating the rules, e.g., for a
+	// banned source IP.
+	Rules []RuleExplanation
+}
+
+// setReason sets the reason of the explanation. It does nothing if the
+// explanation is nil.
+func (x *Explanation) setReason(reason string) {
+	if x != nil {
+		x.Reason = reason
+	}
+}
+
+// addRule adds the evaluation of the given rule, of the given index, to the
+// explanation. It does nothing if the explanation is nil.
+func (x *Explanation) addRule(
+	i int,
+	rule *config.AccessControlRule,
+	result *ruleMatch,
+) {
+	if x == nil {
+		return
+	}
+	x.Rules = append(x.Rules, RuleExplanation{
+		Rule:       i,
+		Name:       rule.Name,
+		Policy:     rule.Policy,
+		Applies:    result.applies(),
+		Conditions: result.conditions(),
+	})
+}
+
+// Explain evaluates the given query like Evaluate, without consuming the rate
+// limits and quotas, and explains its decision: why it was made and the
+// result of each condition of the evaluated rules.
+func (e *Engine) Explain(query *Query) Explanation {
+	var (
+		cfg         = e.config.Load()
+		explanation Explanation
+	)
+	explanation.Decision = e.decideWith(cfg, query, false, &explanation)
+	explanation.Decision.Generation = cfg.generation
+
+	explanation.Policy = config.PolicyDeny
+	if explanation.Decision.Allowed {
+		explanation.Policy = config.PolicyAllow
+	}
+	return explanation
+}
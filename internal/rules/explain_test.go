@@ -0,0 +1,86 @@
+package rules_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/bans"
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+func TestEngineExplain(t *testing.T) {
+	e := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"example.com"},
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Name:      "united-states",
+				Countries: []string{"US"},
+				Policy:    config.PolicyAllow,
+				RateLimit: &config.RateLimit{Requests: 1, Window: time.Hour},
+			},
+			{
+				Countries: []string{"DE"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	query := &rules.Query{
+		RequestedDomain: "example.com",
+		SourceIP:        netip.MustParseAddr("10.0.0.1"),
+		SourceCountry:   "US",
+	}
+
+	// The rate limits aren't consumed.
+	for range 2 {
+		got := e.Explain(query)
+		if !got.Decision.Allowed || got.Decision.Rule != 1 ||
+			got.Policy != config.PolicyAllow ||
+			got.Reason != rules.ReasonRule {
+			t.Fatalf("got %+v, want allowed by rule 1", got)
+		}
+		if len(got.Rules) != 2 {
+			t.Fatalf("got %d evaluated rules, want 2", len(got.Rules))
+		}
+
+		first, second := got.Rules[0], got.Rules[1]
+		if first.Applies || !first.Conditions["domain"] ||
+			first.Conditions["country"] {
+			t.Errorf("got first rule %+v, want only its country unmatched",
+				first)
+		}
+		if !second.Applies || second.Name != "united-states" ||
+			second.Policy != config.PolicyAllow {
+			t.Errorf("got second rule %+v, want it to apply", second)
+		}
+		for name, matched := range second.Conditions {
+			if !matched {
+				t.Errorf("got condition %s unmatched, want matched", name)
+			}
+		}
+	}
+
+	got := e.Explain(&rules.Query{SourceCountry: "CN"})
+	if got.Decision.Allowed || got.Policy != config.PolicyDeny ||
+		got.Reason != rules.ReasonDefault || len(got.Rules) != 3 {
+		t.Errorf("got %+v, want denied by default after 3 rules", got)
+	}
+
+	if _, err := e.Bans().Add(bans.Ban{
+		Network: netip.MustParsePrefix("10.0.0.0/8"),
+		Expires: time.Now().Add(time.Hour),
+	}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got = e.Explain(query)
+	if got.Decision.Allowed || got.Reason != rules.ReasonBanned ||
+		len(got.Rules) != 0 {
+		t.Errorf("got %+v, want banned without evaluated rules", got)
+	}
+}
@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+// quotaKey identifies the quota of a source country for a rule.
+type quotaKey struct {
+	rule    int
+	country string
+}
+
+// quotaWindow counts the requests made since the start of a time window.
+type quotaWindow struct {
+	start    time.Time
+	requests int
+}
+
+// quotaCounter limits the number of requests per rule and source country.
+// Unlike the rate limits, the windows are fixed: the quota of a country is
+// reset once its window, started by its first request, is over.
+type quotaCounter struct {
+	mu      sync.Mutex
+	windows map[quotaKey]*quotaWindow
+}
+
+// newQuotaCounter creates a new quota counter without any window.
+func newQuotaCounter() *quotaCounter {
+	return &quotaCounter{windows: make(map[quotaKey]*quotaWindow)}
+}
+
+// allow counts a request in the window of the given rule and source country.
+// It returns false if the quota of the window is already used. The number of
+// windows is bounded by the number of countries, so they're never removed.
+func (c *quotaCounter) allow(
+	rule int,
+	country string,
+	quota *config.Quota,
+	now time.Time,
+) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := quotaKey{rule: rule, country: country}
+	w, ok := c.windows[key]
+	if !ok || now.Sub(w.start) >= quota.Window {
+		w = &quotaWindow{start: now}
+		c.windows[key] = w
+	}
+
+	if w.requests >= quota.Requests {
+		return false
+	}
+	w.requests++
+	return true
+}
+
+// carryOver returns a new quota counter with the windows of the counter for
+// the rules of prev whose quota is unchanged in next, so that reloading the
+// configuration doesn't reset them. Rules are matched by name if they have
+// one, and by position otherwise.
+func (c *quotaCounter) carryOver(
+	prev []config.AccessControlRule,
+	next []config.AccessControlRule,
+) *quotaCounter {
+	moved := make(map[int]int)
+	for i, rule := range next {
+		j := findRule(prev, &rule, i)
+		if j >= 0 && rule.Quota != nil && prev[j].Quota != nil &&
+			*rule.Quota == *prev[j].Quota {
+			moved[j] = i
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter := newQuotaCounter()
+	for key, w := range c.windows {
+		if i, ok := moved[key.rule]; ok {
+			key.rule = i
+			counter.windows[key] = w
+		}
+	}
+	return counter
+}
+
+// findRule returns the index of the rule of rules matching the given rule at
+// the given index of its configuration, or -1 if none. Named rules match the
+// rule with the same name, and the other rules the one at the same index.
+func findRule(
+	rules []config.AccessControlRule,
+	rule *config.AccessControlRule,
+	i int,
+) int {
+	if rule.Name != "" {
+		for j := range rules {
+			if rules[j].Name == rule.Name {
+				return j
+			}
+		}
+		return -1
+	}
+	if i < len(rules) && rules[i].Name == "" {
+		return i
+	}
+	return -1
+}
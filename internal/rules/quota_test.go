@@ -0,0 +1,171 @@
+package rules_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+func TestEngineQuota(t *testing.T) {
+	cfg := &config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Policy: config.PolicyAllow,
+				Quota: &config.Quota{
+					Requests: 2,
+					Window:   time.Hour,
+				},
+			},
+		},
+	}
+	engine := rules.NewEngine(cfg)
+
+	query := func(ip string, country string) *rules.Query {
+		return &rules.Query{
+			SourceIP:      netip.MustParseAddr(ip),
+			SourceCountry: country,
+		}
+	}
+
+	steps := []struct {
+		name  string
+		query *rules.Query
+		want  bool
+	}{
+		{"first request", query("1.1.1.1", "US"), true},
+		{"other source IP", query("2.2.2.2", "US"), true},
+		{"over the quota", query("3.3.3.3", "US"), false},
+		{"other country", query("1.1.1.1", "DE"), true},
+		{"allowlisted country", query("4.4.4.4", "FR"), true},
+	}
+	for _, step := range steps {
+		if got := engine.Authorize(step.query); got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// Evaluated queries don't use the quota.
+	if !engine.Evaluate(query("1.1.1.1", "US")).Allowed {
+		t.Error("got false for an evaluated query, want true")
+	}
+
+	// Reloading the configuration keeps the quotas.
+	engine.UpdateConfig(cfg)
+	if engine.Authorize(query("1.1.1.1", "US")) {
+		t.Error("got true after reload, want false")
+	}
+}
+
+func TestEngineQuotaReload(t *testing.T) {
+	quota := func(name string, requests int) config.AccessControlRule {
+		return config.AccessControlRule{
+			Name:   name,
+			Policy: config.PolicyAllow,
+			Quota:  &config.Quota{Requests: requests, Window: time.Hour},
+		}
+	}
+	denyFR := config.AccessControlRule{
+		Countries: []string{"FR"},
+		Policy:    config.PolicyDeny,
+	}
+
+	tests := []struct {
+		name   string
+		before config.AccessControlRule
+		after  []config.AccessControlRule
+		want   bool
+	}{
+		{
+			"same configuration",
+			quota("", 1),
+			[]config.AccessControlRule{quota("", 1)},
+			false,
+		},
+		{
+			"named rule moved",
+			quota("daily", 1),
+			[]config.AccessControlRule{denyFR, quota("daily", 1)},
+			false,
+		},
+		{
+			"unnamed rule moved",
+			quota("", 1),
+			[]config.AccessControlRule{denyFR, quota("", 1)},
+			true,
+		},
+		{
+			"quota changed",
+			quota("", 1),
+			[]config.AccessControlRule{quota("", 2)},
+			true,
+		},
+		{
+			"rule renamed",
+			quota("daily", 1),
+			[]config.AccessControlRule{quota("other", 1)},
+			true,
+		},
+	}
+
+	query := &rules.Query{
+		SourceIP:      netip.MustParseAddr("1.1.1.1"),
+		SourceCountry: "US",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := rules.NewEngine(&config.AccessControl{
+				DefaultPolicy: config.PolicyDeny,
+				Rules:         []config.AccessControlRule{tt.before},
+			})
+			if !engine.Authorize(query) {
+				t.Fatal("got false before reload, want true")
+			}
+
+			engine.UpdateConfig(&config.AccessControl{
+				DefaultPolicy: config.PolicyDeny,
+				Rules:         tt.after,
+			})
+			if got := engine.Authorize(query); got != tt.want {
+				t.Errorf("got %v after reload, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineQuotaWindow(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Policy: config.PolicyAllow,
+				Quota: &config.Quota{
+					Requests: 1,
+					Window:   50 * time.Millisecond,
+				},
+			},
+		},
+	})
+	query := &rules.Query{
+		SourceIP:      netip.MustParseAddr("1.1.1.1"),
+		SourceCountry: "US",
+	}
+
+	if !engine.Authorize(query) {
+		t.Fatal("got false, want true")
+	}
+	if engine.Authorize(query) {
+		t.Fatal("got true, want false")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !engine.Authorize(query) {
+		t.Error("got false in a new window, want true")
+	}
+}
@@ -0,0 +1,132 @@
+package rules
+
+import (
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+// sweepInterval is the interval at which idle buckets are removed.
+const sweepInterval = time.Minute
+
+// maxBuckets is the maximum number of buckets of a rate limiter, so that the
+// memory usage is bounded even if the rules are hit from many source IPs.
+const maxBuckets = 100_000
+
+// ipv6PrefixBits is the prefix length of the IPv6 networks sharing a bucket.
+// A /64 is usually assigned to a single subscriber, who could otherwise get a
+// new bucket for each of its addresses.
+const ipv6PrefixBits = 64
+
+// bucketKey identifies the token bucket of a source network for a rule.
+type bucketKey struct {
+	rule    int
+	network netip.Prefix
+}
+
+// sourceNetwork returns the network of the bucket of the given source IP:
+// the IP itself for IPv4, and its /64 for IPv6.
+func sourceNetwork(ip netip.Addr) netip.Prefix {
+	ip = ip.Unmap()
+	bits := ip.BitLen()
+	if ip.Is6() {
+		bits = ipv6PrefixBits
+	}
+	network, _ := ip.Prefix(bits)
+	return network
+}
+
+// bucket is a token bucket. It holds up to capacity tokens and is refilled at
+// rate tokens per second.
+type bucket struct {
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = min(b.capacity, b.tokens+elapsed*b.rate)
+	b.last = now
+}
+
+// rateLimiter limits the number of requests per rule and source IP, IPv6
+// addresses being limited per /64.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a new rate limiter without any bucket.
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[bucketKey]*bucket)}
+}
+
+// allow consumes a token from the bucket of the given rule and source IP. If
+// the bucket is empty, it returns false and the time until a token is
+// available. If the limiter already has maxBuckets buckets, an arbitrary one
+// is removed to make room for a new one.
+func (l *rateLimiter) allow(
+	rule int,
+	ip netip.Addr,
+	limit *config.RateLimit,
+	now time.Time,
+) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	key := bucketKey{rule: rule, network: sourceNetwork(ip)}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.evict()
+		}
+		capacity := float64(limit.Requests)
+		b = &bucket{
+			tokens:   capacity,
+			capacity: capacity,
+			rate:     capacity / limit.Window.Seconds(),
+			last:     now,
+		}
+		l.buckets[key] = b
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / b.rate * float64(time.Second)
+		return false, time.Duration(math.Ceil(wait))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets that are full again, since they behave like new
+// buckets. It keeps the memory usage proportional to the number of recently
+// active source IPs.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// evict removes an arbitrary bucket.
+func (l *rateLimiter) evict() {
+	for key := range l.buckets {
+		delete(l.buckets, key)
+		return
+	}
+}
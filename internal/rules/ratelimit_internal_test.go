@@ -0,0 +1,54 @@
+package rules
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+func TestRateLimiterIPv6Network(t *testing.T) {
+	var (
+		limiter = newRateLimiter()
+		limit   = &config.RateLimit{Requests: 1, Window: time.Hour}
+		now     = time.Now()
+	)
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"2001:db8:0:1::1", true},
+		{"2001:db8:0:1::2", false},
+		{"2001:db8:0:1:ffff::1", false},
+		{"2001:db8:0:2::1", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", true},
+		{"::ffff:192.0.2.1", false},
+	}
+
+	for _, tt := range tests {
+		ip := netip.MustParseAddr(tt.ip)
+		if got, _ := limiter.allow(0, ip, limit, now); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	var (
+		limiter = newRateLimiter()
+		limit   = &config.RateLimit{Requests: 1, Window: time.Hour}
+		now     = time.Now()
+		ip      = netip.MustParseAddr("10.0.0.0")
+	)
+
+	for range maxBuckets + 10 {
+		limiter.allow(0, ip, limit, now)
+		ip = ip.Next()
+	}
+	if got := len(limiter.buckets); got != maxBuckets {
+		t.Errorf("got %d buckets, want %d", got, maxBuckets)
+	}
+}
@@ -0,0 +1,113 @@
+package rules_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+func TestEngineRateLimit(t *testing.T) {
+	cfg := &config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"api.example.com"},
+				Policy:  config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 2,
+					Window:   time.Hour,
+				},
+			},
+		},
+	}
+	engine := rules.NewEngine(cfg)
+
+	var (
+		ip1   = netip.MustParseAddr("1.1.1.1")
+		ip2   = netip.MustParseAddr("2.2.2.2")
+		query = func(domain string, ip netip.Addr) *rules.Query {
+			return &rules.Query{RequestedDomain: domain, SourceIP: ip}
+		}
+	)
+
+	steps := []struct {
+		name  string
+		query *rules.Query
+		want  bool
+	}{
+		{"first request", query("api.example.com", ip1), true},
+		{"second request", query("api.example.com", ip1), true},
+		{"over the limit", query("api.example.com", ip1), false},
+		{"other source IP", query("api.example.com", ip2), true},
+		{"other rule", query("www.example.com", ip1), true},
+	}
+	for _, step := range steps {
+		if got := engine.Authorize(step.query); got != step.want {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	// Reloading the configuration resets the limits.
+	engine.UpdateConfig(cfg)
+	if !engine.Authorize(query("api.example.com", ip1)) {
+		t.Error("got false after reload, want true")
+	}
+}
+
+func TestEngineRateLimitRefill(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Policy: config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 1,
+					Window:   50 * time.Millisecond,
+				},
+			},
+		},
+	})
+	query := &rules.Query{SourceIP: netip.MustParseAddr("1.1.1.1")}
+
+	if !engine.Authorize(query) {
+		t.Fatal("got false, want true")
+	}
+	if engine.Authorize(query) {
+		t.Fatal("got true, want false")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !engine.Authorize(query) {
+		t.Error("got false after refill, want true")
+	}
+}
+
+func TestEngineRateLimitRetryAfter(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Policy: config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 2,
+					Window:   time.Hour,
+				},
+			},
+		},
+	})
+	query := &rules.Query{SourceIP: netip.MustParseAddr("1.1.1.1")}
+
+	for range 2 {
+		if got := engine.Decide(query); got.RetryAfter != 0 {
+			t.Fatalf("got RetryAfter %v for an allowed query", got.RetryAfter)
+		}
+	}
+
+	// A token is refilled every 30 minutes.
+	got := engine.Decide(query).RetryAfter
+	if got <= 29*time.Minute || got > 30*time.Minute {
+		t.Errorf("got RetryAfter %v, want about 30m", got)
+	}
+}
@@ -0,0 +1,411 @@
+package server
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/history"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// HTTP headers used to authenticate the clients of the admin API.
+const (
+	HeaderAuthorization   = "Authorization"
+	HeaderWWWAuthenticate = "WWW-Authenticate"
+)
+
+// Redacted replaces the secrets of the configuration shown by the admin API.
+const Redacted = "REDACTED"
+
+// AdminOptions contains the options of the admin server.
+type AdminOptions struct {
+	// Token is the bearer token the clients must send in the Authorization
+	// header. If empty, the clients are only authenticated by their TLS
+	// certificate, which must then be required by TLSConfig.
+	Token string
+
+	// TLSConfig is the TLS configuration of the server, including the
+	// verification of the client certificates. If nil, the API is served over
+	// plain HTTP.
+	TLSConfig *tls.Config
+
+	// Config is the configuration shown by the admin API. Its access control
+	// configuration is replaced by the current one of the engine, and its
+	// secrets are redacted.
+	Config *config.Configuration
+
+	// Refresh updates the databases. If nil, the databases can't be
+	// refreshed.
+	Refresh func() error
+
+	// Resolver resolves the IPs of the debug, bulk authorization and
+	// configuration sandbox endpoints, and reports the state of the
+	// databases. If nil, these endpoints are disabled.
+	Resolver *ipres.Resolver
+
+	// History is the number of requests per source country and ASN. If nil,
+	// the history endpoint is disabled.
+	History *history.Store
+
+	// PromoteAPI enables the endpoint to promote the staged configuration.
+	PromoteAPI bool
+
+	// ReadOnly disables the endpoints that change the bans, the maintenance
+	// mode and the log level, the refresh of the databases and the promotion
+	// of the staged configuration.
+	ReadOnly bool
+}
+
+// adminConfigResponse is the response of the admin configuration endpoint.
+type adminConfigResponse struct {
+	Current config.Configuration  `yaml:"current"`
+	Staged  *config.AccessControl `yaml:"staged,omitempty"`
+}
+
+// maintenanceRequest is the body of a maintenance mode request, and the
+// response of the maintenance mode endpoint.
+type maintenanceRequest struct {
+	Policy string `json:"policy"`
+}
+
+// logLevelRequest is the body of a log level request, and the response of the
+// log level endpoint.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// authenticate wraps the given handler so that it's only called for the
+// requests with the given bearer token. An empty token authenticates all the
+// requests.
+func authenticate(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			got := []byte(request.Header.Get(HeaderAuthorization))
+			if subtle.ConstantTimeCompare(got, want) != 1 {
+				log.WithField(
+					FieldSourceIP, request.RemoteAddr,
+				).Warn("Unauthorized admin request")
+				writer.Header().Set(HeaderWWWAuthenticate, "Bearer")
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(writer, request)
+		},
+	)
+}
+
+// getAdminConfig returns the configuration in YAML, with the current and
+// staged access control configurations of the engine.
+func getAdminConfig(
+	writer http.ResponseWriter,
+	engine *rules.Engine,
+	cfg *config.Configuration,
+) {
+	current, staged := engine.Config()
+	response := adminConfigResponse{Current: *cfg}
+	response.Current.AccessControl = *current
+	redactWebhooks(&response.Current.AccessControl)
+	if staged != nil {
+		copied := *staged
+		redactWebhooks(&copied)
+		response.Staged = &copied
+	}
+	if response.Current.Signature.Secret != "" {
+		response.Current.Signature.Secret = Redacted
+	}
+	if response.Current.Admin.Token != "" {
+		response.Current.Admin.Token = Redacted
+	}
+	fetcher := &response.Current.Databases.Fetcher
+	fetcher.Proxy = redactUserinfo(fetcher.Proxy)
+	// The response headers are copied, since they're shared with cfg.
+	response.Current.ResponseHeaders = slices.Clone(
+		response.Current.ResponseHeaders,
+	)
+	for i := range response.Current.ResponseHeaders {
+		if response.Current.ResponseHeaders[i].HashKey != "" {
+			response.Current.ResponseHeaders[i].HashKey = Redacted
+		}
+	}
+
+	data, err := yaml.Marshal(&response)
+	if err != nil {
+		log.WithError(err).Error("Cannot encode configuration")
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set(HeaderContentType, "application/yaml")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data) // #nosec G104
+}
+
+// redactWebhooks redacts the URLs of the webhooks of the rules of the given
+// access control configuration, since they often contain a secret token. The
+// rules and their webhooks are copied, since they're shared with the engine.
+func redactWebhooks(accessControl *config.AccessControl) {
+	accessControl.Rules = slices.Clone(accessControl.Rules)
+	for i := range accessControl.Rules {
+		rule := &accessControl.Rules[i]
+		if rule.Webhook != nil {
+			copied := *rule.Webhook
+			copied.URL = Redacted
+			rule.Webhook = &copied
+		}
+	}
+}
+
+// redactUserinfo returns the given URL with its user information, which may
+// contain a password, replaced by Redacted. Invalid URLs are redacted.
+func redactUserinfo(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return Redacted
+	}
+	if parsed.User == nil {
+		return rawURL
+	}
+	parsed.User = url.User(Redacted)
+	return parsed.String()
+}
+
+// postRefresh updates the databases. It returns a 502 status code if the
+// update fails, in which case the previous databases keep being used.
+func postRefresh(writer http.ResponseWriter, refresh func() error) {
+	if err := refresh(); err != nil {
+		log.WithError(err).Error("Cannot refresh databases")
+		writer.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// postPromoteConfig replaces the configuration with the staged one. It returns
+// a 409 status code if no configuration is staged.
+func postPromoteConfig(writer http.ResponseWriter, engine *rules.Engine) {
+	if !engine.PromoteConfig() {
+		writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	log.Info("Staged configuration promoted")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// getMaintenance returns the policy of the maintenance mode, which is empty
+// if the maintenance mode is disabled.
+func getMaintenance(writer http.ResponseWriter, engine *rules.Engine) {
+	response := maintenanceRequest{Policy: engine.MaintenancePolicy()}
+	writeJSON(writer, http.StatusOK, response)
+}
+
+// putMaintenance enables the maintenance mode with the policy given in the
+// request body.
+func putMaintenance(
+	writer http.ResponseWriter,
+	request *http.Request,
+	engine *rules.Engine,
+) {
+	var body maintenanceRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil ||
+		(body.Policy != config.PolicyAllow &&
+			body.Policy != config.PolicyDeny) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	engine.SetMaintenancePolicy(body.Policy)
+	log.WithField("policy", body.Policy).Warn("Maintenance mode enabled")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// deleteMaintenance disables the maintenance mode.
+func deleteMaintenance(writer http.ResponseWriter, engine *rules.Engine) {
+	engine.SetMaintenancePolicy("")
+	log.Info("Maintenance mode disabled")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// getLogLevel returns the current log level.
+func getLogLevel(writer http.ResponseWriter) {
+	response := logLevelRequest{Level: log.GetLevel().String()}
+	writeJSON(writer, http.StatusOK, response)
+}
+
+// putLogLevel changes the log level to the one given in the request body. The
+// level applies to all the log messages, until it's changed again or the
+// process is restarted.
+func putLogLevel(writer http.ResponseWriter, request *http.Request) {
+	var body logLevelRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	level, err := log.ParseLevel(body.Level)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.SetLevel(level)
+	log.WithField("level", level).Info("Log level changed")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterAdminAPI registers the handlers of the admin API on the given mux,
+// under the given path prefix: status, configuration, domains, request
+// history, bans, database status and refresh, debug resolution, bulk
+// authorization, configuration sandbox and promotion, maintenance mode and log
+// level. The handlers aren't authenticated, see NewAdminServer.
+func RegisterAdminAPI(
+	mux *http.ServeMux,
+	prefix string,
+	engine *rules.Engine,
+	options AdminOptions,
+) {
+	if options.ReadOnly {
+		RegisterBanList(mux, prefix, engine)
+	} else {
+		RegisterBans(mux, prefix, engine)
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.HandleFunc(
+		"GET "+prefix+"/v1/status",
+		func(writer http.ResponseWriter, _ *http.Request) {
+			getStatus(writer, engine)
+		},
+	)
+	mux.HandleFunc(
+		"GET "+prefix+"/v1/domains",
+		func(writer http.ResponseWriter, request *http.Request) {
+			getDomains(writer, request, engine)
+		},
+	)
+	if options.History != nil {
+		mux.HandleFunc(
+			"GET "+prefix+"/v1/stats/history",
+			func(writer http.ResponseWriter, request *http.Request) {
+				getHistory(writer, request, options.History)
+			},
+		)
+	}
+	if options.Config != nil {
+		mux.HandleFunc(
+			"GET "+prefix+"/v1/config",
+			func(writer http.ResponseWriter, _ *http.Request) {
+				getAdminConfig(writer, engine, options.Config)
+			},
+		)
+	}
+	if options.Resolver != nil {
+		resolver := options.Resolver
+		mux.HandleFunc(
+			"GET "+prefix+"/v1/db/status",
+			func(writer http.ResponseWriter, _ *http.Request) {
+				getDatabaseStatus(writer, resolver)
+			},
+		)
+		mux.HandleFunc(
+			"GET "+prefix+"/v1/debug/resolve",
+			func(writer http.ResponseWriter, request *http.Request) {
+				getDebugResolve(writer, request, resolver)
+			},
+		)
+		mux.HandleFunc(
+			"POST "+prefix+"/v1/authorize",
+			func(writer http.ResponseWriter, request *http.Request) {
+				postAuthorize(writer, request, engine, resolver)
+			},
+		)
+		mux.HandleFunc(
+			"POST "+prefix+"/v1/sandbox",
+			func(writer http.ResponseWriter, request *http.Request) {
+				postSandbox(writer, request, engine, resolver)
+			},
+		)
+	}
+	mux.HandleFunc(
+		"GET "+prefix+"/v1/maintenance",
+		func(writer http.ResponseWriter, _ *http.Request) {
+			getMaintenance(writer, engine)
+		},
+	)
+	mux.HandleFunc(
+		"GET "+prefix+"/v1/log-level",
+		func(writer http.ResponseWriter, _ *http.Request) {
+			getLogLevel(writer)
+		},
+	)
+	if !options.ReadOnly {
+		if options.Refresh != nil {
+			mux.HandleFunc(
+				"POST "+prefix+"/v1/databases/refresh",
+				func(writer http.ResponseWriter, _ *http.Request) {
+					postRefresh(writer, options.Refresh)
+				},
+			)
+		}
+		mux.HandleFunc(
+			"PUT "+prefix+"/v1/log-level",
+			func(writer http.ResponseWriter, request *http.Request) {
+				putLogLevel(writer, request)
+			},
+		)
+		mux.HandleFunc(
+			"PUT "+prefix+"/v1/maintenance",
+			func(writer http.ResponseWriter, request *http.Request) {
+				putMaintenance(writer, request, engine)
+			},
+		)
+		mux.HandleFunc(
+			"DELETE "+prefix+"/v1/maintenance",
+			func(writer http.ResponseWriter, _ *http.Request) {
+				deleteMaintenance(writer, engine)
+			},
+		)
+		if options.PromoteAPI {
+			mux.HandleFunc(
+				"POST "+prefix+"/v1/config/promote",
+				func(writer http.ResponseWriter, _ *http.Request) {
+					postPromoteConfig(writer, engine)
+				},
+			)
+		}
+	}
+}
+
+// NewAdminServer creates a new HTTP server for the admin API that listens on
+// the given address. All the requests must be authenticated with the bearer
+// token of the options, if any, and the TLS client certificates required by
+// its TLS configuration.
+func NewAdminServer(
+	address string,
+	engine *rules.Engine,
+	options AdminOptions,
+) *http.Server {
+	mux := http.NewServeMux()
+	RegisterAdminAPI(mux, "", engine, options)
+
+	return &http.Server{
+		Addr:         address,
+		Handler:      authenticate(options.Token, mux),
+		TLSConfig:    options.TLSConfig,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 5 * time.Minute, // Database refreshes can be slow
+		IdleTimeout:  30 * time.Second,
+	}
+}
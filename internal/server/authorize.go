@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"strconv"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// Limits of the bulk authorization requests.
+const (
+	maxAuthorizeQueries = 1000
+	maxAuthorizeBody    = 1 << 20
+)
+
+// authorizeQuery is a query of a bulk authorization request.
+type authorizeQuery struct {
+	IP       string            `json:"ip"`
+	Domain   string            `json:"domain"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Context  map[string]string `json:"context,omitempty"`
+}
+
+// authorizeDecision is the decision of a query of a bulk authorization
+// request.
+type authorizeDecision struct {
+	authorizeQuery
+	Allowed  bool   `json:"allowed"`
+	Rule     *int   `json:"rule,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+	Banned   bool   `json:"banned,omitempty"`
+	Country  string `json:"country,omitempty"`
+	ASN      uint32 `json:"asn,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	// The explanation of the decision, if requested.
+	Reason string            `json:"reason,omitempty"`
+	Policy string            `json:"policy,omitempty"`
+	Rules  []ruleExplanation `json:"rules,omitempty"`
+}
+
+// ruleExplanation is the evaluation of a rule for a query of a bulk
+// authorization request.
+type ruleExplanation struct {
+	Rule       int             `json:"rule"`
+	RuleName   string          `json:"rule_name,omitempty"`
+	Policy     string          `json:"policy"`
+	Applies    bool            `json:"applies"`
+	Conditions map[string]bool `json:"conditions"`
+}
+
+// authorizeResponse is the response of the bulk authorization endpoint.
+type authorizeResponse struct {
+	Decisions []authorizeDecision `json:"decisions"`
+}
+
+// authorize evaluates the given query without affecting the rate limits of
+// the actual requests. If explain is true, the decision is explained.
+func authorize(
+	query authorizeQuery,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	explain bool,
+) authorizeDecision {
+	result := authorizeDecision{authorizeQuery: query}
+
+	ip, err := netip.ParseAddr(query.IP)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	ip = ip.Unmap()
+
+	resolved := resolver.Resolve(ip)
+	evaluated := &rules.Query{
+		RequestedDomain:   query.Domain,
+		RequestedMethod:   query.Method,
+		RequestedPath:     RequestPath(query.Path),
+		RequestedProtocol: query.Protocol,
+		SourceIP:          ip,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+		Context:           normalizeContext(query.Context),
+	}
+
+	// Only the explained decisions record the result of each condition.
+	var explanation rules.Explanation
+	if explain {
+		explanation = engine.Explain(evaluated)
+	} else {
+		explanation.Decision = engine.Evaluate(evaluated)
+	}
+
+	decision := explanation.Decision
+	result.Allowed = decision.Allowed
+	result.Banned = decision.Banned
+	result.RuleName = decision.RuleName
+	result.Country = resolved.CountryCode
+	result.ASN = resolved.ASN
+	if decision.Rule != rules.NoRule {
+		result.Rule = &decision.Rule
+	}
+	if !explain {
+		return result
+	}
+
+	result.Reason = explanation.Reason
+	result.Policy = explanation.Policy
+	for _, rule := range explanation.Rules {
+		result.Rules = append(result.Rules, ruleExplanation{
+			Rule:       rule.Rule,
+			RuleName:   rule.Name,
+			Policy:     rule.Policy,
+			Applies:    rule.Applies,
+			Conditions: rule.Conditions,
+		})
+	}
+	return result
+}
+
+// postAuthorize evaluates the queries given in the request body and returns
+// their decisions, in the same order. It's meant to test the rules against a
+// batch of queries, so the requests aren't logged nor counted. The decisions
+// are explained if the "explain" query parameter is true.
+func postAuthorize(
+	writer http.ResponseWriter,
+	request *http.Request,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+) {
+	explain := false
+	if value := request.URL.Query().Get("explain"); value != "" {
+		var err error
+		if explain, err = strconv.ParseBool(value); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var queries []authorizeQuery
+	body := http.MaxBytesReader(writer, request.Body, maxAuthorizeBody)
+	if err := json.NewDecoder(body).Decode(&queries); err != nil ||
+		len(queries) > maxAuthorizeQueries {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	response := authorizeResponse{
+		Decisions: make([]authorizeDecision, 0, len(queries)),
+	}
+	for _, query := range queries {
+		response.Decisions = append(
+			response.Decisions, authorize(query, engine, resolver, explain),
+		)
+	}
+	writeJSON(writer, http.StatusOK, response)
+}
@@ -0,0 +1,173 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestAuthorize(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Name:      "france",
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	handler := newAdminHandler(engine, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	body := `[
+		{"ip": "1.0.0.1", "domain": "example.com", "method": "GET"},
+		{"ip": "2.0.0.1", "domain": "example.com", "method": "GET"},
+		{"ip": "invalid", "domain": "example.com", "method": "GET"}
+	]`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodPost, "/v1/authorize", strings.NewReader(body),
+	))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Decisions []struct {
+			IP       string `json:"ip"`
+			Allowed  bool   `json:"allowed"`
+			Rule     *int   `json:"rule"`
+			RuleName string `json:"rule_name"`
+			Country  string `json:"country"`
+			Error    string `json:"error"`
+		} `json:"decisions"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Decisions) != 3 {
+		t.Fatalf("got %d decisions, want 3", len(response.Decisions))
+	}
+
+	allowed := response.Decisions[0]
+	if !allowed.Allowed || allowed.Rule == nil || *allowed.Rule != 0 ||
+		allowed.RuleName != "france" || allowed.Country != "FR" {
+		t.Errorf("got %+v, want allowed by rule 0 (france)", allowed)
+	}
+	denied := response.Decisions[1]
+	if denied.Allowed || denied.Rule != nil || denied.Country != "US" {
+		t.Errorf("got %+v, want denied by the default policy", denied)
+	}
+	if invalid := response.Decisions[2]; invalid.Error == "" {
+		t.Errorf("got %+v, want an error", invalid)
+	}
+}
+
+func TestAuthorizeInvalid(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	handler := newAdminHandler(engine, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not an array", `{"ip": "1.0.0.1"}`},
+		{"malformed", `[`},
+		{
+			"too many queries",
+			"[" + strings.Repeat(`{"ip": "1.0.0.1"},`, 1000) + "{}]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(
+				http.MethodPost, "/v1/authorize", strings.NewReader(tt.body),
+			))
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d",
+					recorder.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestAuthorizeExplain(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Name:      "france",
+				Domains:   []string{"example.com"},
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	handler := newAdminHandler(engine, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	body := `[{"ip": "2.0.0.1", "domain": "example.com", "method": "GET"}]`
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodPost, "/v1/authorize?explain=true",
+		strings.NewReader(body),
+	))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Decisions []struct {
+			Allowed bool   `json:"allowed"`
+			Reason  string `json:"reason"`
+			Policy  string `json:"policy"`
+			Rules   []struct {
+				Rule       int             `json:"rule"`
+				RuleName   string          `json:"rule_name"`
+				Applies    bool            `json:"applies"`
+				Conditions map[string]bool `json:"conditions"`
+			} `json:"rules"`
+		} `json:"decisions"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Decisions) != 1 {
+		t.Fatalf("got %d decisions, want 1", len(response.Decisions))
+	}
+	decision := response.Decisions[0]
+	if decision.Allowed || decision.Reason != rules.ReasonDefault ||
+		decision.Policy != config.PolicyDeny || len(decision.Rules) != 1 {
+		t.Fatalf("got %+v, want denied by default after 1 rule", decision)
+	}
+	rule := decision.Rules[0]
+	if rule.RuleName != "france" || rule.Applies ||
+		!rule.Conditions["domain"] || rule.Conditions["country"] {
+		t.Errorf("got %+v, want only the country unmatched", rule)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodPost, "/v1/authorize?explain=maybe",
+		strings.NewReader(body),
+	))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", recorder.Code,
+			http.StatusBadRequest)
+	}
+}
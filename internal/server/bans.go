@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/bans"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// Fields used in the log messages of the ban list API.
+const (
+	FieldBanned     = "banned"
+	FieldBanNetwork = "ban_network"
+	FieldBanExpires = "ban_expires"
+	FieldBanReason  = "ban_reason"
+)
+
+// banRequest is the body of a ban request.
+type banRequest struct {
+	Network string `json:"network"`
+	TTL     string `json:"ttl"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// bansResponse is the response of the ban list endpoint.
+type bansResponse struct {
+	Bans []bans.Ban `json:"bans"`
+}
+
+// parseNetwork parses an IP address or a network in CIDR notation. An IP
+// address is a network containing only this address.
+func parseNetwork(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// writeJSON writes the given value as a JSON response with the given status.
+func writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set(HeaderContentType, "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		log.WithError(err).Error("Cannot write response")
+	}
+}
+
+// getBans returns the bans that haven't expired.
+func getBans(writer http.ResponseWriter, _ *http.Request, list *bans.List) {
+	response := bansResponse{Bans: list.List(time.Now())}
+	writeJSON(writer, http.StatusOK, response)
+}
+
+// postBan bans the network given in the request body for the given TTL.
+func postBan(
+	writer http.ResponseWriter,
+	request *http.Request,
+	list *bans.List,
+) {
+	var body banRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	network, err := parseNetwork(body.Network)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	ban, err := list.Add(bans.Ban{
+		Network: network,
+		Expires: now.Add(ttl),
+		Reason:  body.Reason,
+	}, now)
+	if errors.Is(err, bans.ErrInvalidTTL) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	logFields := log.Fields{
+		FieldBanNetwork: ban.Network,
+		FieldBanExpires: ban.Expires,
+		FieldBanReason:  ban.Reason,
+	}
+	if err != nil {
+		log.WithFields(logFields).WithError(err).Error("Cannot save bans")
+	}
+	log.WithFields(logFields).Info("Network banned")
+	writeJSON(writer, http.StatusCreated, ban)
+}
+
+// deleteBan removes the ban of the network given in the request path.
+func deleteBan(
+	writer http.ResponseWriter,
+	request *http.Request,
+	list *bans.List,
+) {
+	network, err := parseNetwork(request.PathValue("network"))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	removed, err := list.Remove(network)
+	if !removed {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	logFields := log.Fields{FieldBanNetwork: network.Masked()}
+	if err != nil {
+		log.WithFields(logFields).WithError(err).Error("Cannot save bans")
+	}
+	log.WithFields(logFields).Info("Ban removed")
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterBanList registers the handler listing the bans on the given mux,
+// under the given path prefix.
+func RegisterBanList(mux *http.ServeMux, prefix string, engine *rules.Engine) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	list := engine.Bans()
+	mux.HandleFunc(
+		"GET "+prefix+"/v1/bans",
+		func(writer http.ResponseWriter, request *http.Request) {
+			getBans(writer, request, list)
+		},
+	)
+}
+
+// RegisterBans registers the ban list handlers on the given mux, under the
+// given path prefix. These handlers change the engine's decisions, so they
+// should only be reachable by trusted clients.
+func RegisterBans(mux *http.ServeMux, prefix string, engine *rules.Engine) {
+	RegisterBanList(mux, prefix, engine)
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	list := engine.Bans()
+	mux.HandleFunc(
+		"POST "+prefix+"/v1/bans",
+		func(writer http.ResponseWriter, request *http.Request) {
+			postBan(writer, request, list)
+		},
+	)
+	mux.HandleFunc(
+		"DELETE "+prefix+"/v1/bans/{network...}",
+		func(writer http.ResponseWriter, request *http.Request) {
+			deleteBan(writer, request, list)
+		},
+	)
+}
@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestBansAPI(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	mux := http.NewServeMux()
+	server.RegisterForwardAuth(
+		mux, "", engine, newTestResolver(t), server.Options{},
+	)
+	server.RegisterBans(mux, "", engine)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(
+			method, path, strings.NewReader(body),
+		)
+		request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+		request.Header.Set(server.HeaderXForwardedHost, "example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{
+			"invalid network", http.MethodPost, "/v1/bans",
+			`{"network": "1.0.0", "ttl": "1h"}`, http.StatusBadRequest,
+		},
+		{
+			"invalid ttl", http.MethodPost, "/v1/bans",
+			`{"network": "1.0.0.1", "ttl": "-1h"}`, http.StatusBadRequest,
+		},
+		{
+			"allowed before ban", http.MethodGet, "/v1/forward-auth",
+			"", http.StatusNoContent,
+		},
+		{
+			"ban network", http.MethodPost, "/v1/bans",
+			`{"network": "1.0.0.0/24", "ttl": "1h", "reason": "scanner"}`,
+			http.StatusCreated,
+		},
+		{
+			"denied after ban", http.MethodGet, "/v1/forward-auth",
+			"", http.StatusForbidden,
+		},
+		{
+			"remove unknown ban", http.MethodDelete, "/v1/bans/1.0.0.1",
+			"", http.StatusNotFound,
+		},
+		{
+			"remove ban", http.MethodDelete, "/v1/bans/1.0.0.0/24",
+			"", http.StatusNoContent,
+		},
+		{
+			"allowed after removal", http.MethodGet, "/v1/forward-auth",
+			"", http.StatusNoContent,
+		},
+	}
+
+	for _, step := range steps {
+		recorder := serve(step.method, step.path, step.body)
+		if recorder.Code != step.status {
+			t.Fatalf("%s: got status %d, want %d",
+				step.name, recorder.Code, step.status)
+		}
+	}
+}
+
+func TestBansAPIList(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	mux := http.NewServeMux()
+	server.RegisterBans(mux, "", engine)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodPost,
+		"/v1/bans",
+		strings.NewReader(`{"network": "2.0.0.1", "ttl": "10m"}`),
+	))
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want %d",
+			recorder.Code, http.StatusCreated)
+	}
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(
+		recorder, httptest.NewRequest(http.MethodGet, "/v1/bans", nil),
+	)
+
+	var response struct {
+		Bans []struct {
+			Network string `json:"network"`
+		} `json:"bans"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Bans) != 1 || response.Bans[0].Network != "2.0.0.1/32" {
+		t.Errorf("got bans %+v, want 2.0.0.1/32", response.Bans)
+	}
+}
+
+func TestBansAPIDisabled(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	mux := http.NewServeMux()
+	server.Register(mux, "", engine, newTestResolver(t), server.Options{})
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(
+		recorder, httptest.NewRequest(http.MethodGet, "/v1/bans", nil),
+	)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d",
+			recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/metrics"
+)
+
+// Log fields of the circuit breaker events.
+const (
+	FieldDomainPattern  = "domain_pattern"
+	FieldBreakerExpires = "breaker_expires"
+)
+
+// Default options of a circuit breaker.
+const (
+	DefaultBreakerWindow   = 10 * time.Second
+	DefaultBreakerDuration = time.Minute
+)
+
+// BreakerOptions contains the options of a circuit breaker. Zero durations
+// select the defaults.
+type BreakerOptions struct {
+	// MaxDenied is the number of denied requests of a domain pattern during
+	// a window above which its circuit opens.
+	MaxDenied int
+
+	// Window is the period during which the denied requests are counted.
+	Window time.Duration
+
+	// Duration is the time during which an open circuit denies all the
+	// requests of its domain pattern.
+	Duration time.Duration
+}
+
+// circuit counts the denied requests of a domain pattern during the current
+// window.
+type circuit struct {
+	start     time.Time // Start of the current window
+	denied    int
+	openUntil time.Time // Zero if the circuit is closed
+}
+
+// CircuitBreaker protects geoblock during floods: once a domain pattern
+// receives more denied requests than the limit during a window, its circuit
+// opens and all its requests are denied, without resolving their source IP
+// nor evaluating the rules, until the circuit closes again. It's safe for
+// concurrent use.
+type CircuitBreaker struct {
+	options  BreakerOptions
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker creates a circuit breaker with the given options, whose
+// circuits are all closed.
+func NewCircuitBreaker(options BreakerOptions) *CircuitBreaker {
+	if options.Window <= 0 {
+		options.Window = DefaultBreakerWindow
+	}
+	if options.Duration <= 0 {
+		options.Duration = DefaultBreakerDuration
+	}
+	return &CircuitBreaker{
+		options:  options,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Open reports whether the circuit of the given domain pattern is open at the
+// given time. An expired circuit is closed, and its denied requests are
+// counted again from zero.
+func (b *CircuitBreaker) Open(pattern string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[pattern]
+	if !ok || c.openUntil.IsZero() {
+		return false
+	}
+	if now.Before(c.openUntil) {
+		return true
+	}
+
+	delete(b.circuits, pattern)
+	log.WithField(FieldDomainPattern, pattern).Info("Circuit breaker closed")
+	return false
+}
+
+// Deny counts a denied request of the given domain pattern at the given time.
+// It returns true if the request opens the circuit of the pattern.
+func (b *CircuitBreaker) Deny(pattern string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[pattern]
+	if !ok {
+		c = &circuit{start: now}
+		b.circuits[pattern] = c
+	}
+	if !c.openUntil.IsZero() {
+		return false
+	}
+	if now.Sub(c.start) >= b.options.Window {
+		c.start = now
+		c.denied = 0
+	}
+
+	c.denied++
+	if c.denied <= b.options.MaxDenied {
+		return false
+	}
+	c.openUntil = now.Add(b.options.Duration)
+	return true
+}
+
+// breakerOpen reports whether the circuit of the given domain pattern, if
+// matched, is open in the given breaker, if any. The requests of an open
+// circuit are counted as denied, with the given labels.
+func breakerOpen(
+	breaker *CircuitBreaker,
+	pattern string,
+	matched bool,
+	labels metrics.RequestLabels,
+) bool {
+	if breaker == nil || !matched {
+		return false
+	}
+	now := time.Now()
+	if !breaker.Open(pattern, now) {
+		return false
+	}
+
+	domainCounters.Add(pattern, false, now)
+	counters.Denied.Add(1)
+	metrics.BreakerDenied.WithLabelValues(pattern).Inc()
+	metrics.CountRequest(metrics.ResultDenied, labels)
+	return true
+}
+
+// countBreaker counts the given decision of the given domain pattern in the
+// given breaker, if any, and reports the opening of its circuit.
+func countBreaker(breaker *CircuitBreaker, pattern string, allowed bool) {
+	if breaker == nil || allowed {
+		return
+	}
+
+	now := time.Now()
+	if breaker.Deny(pattern, now) {
+		metrics.BreakerTrips.WithLabelValues(pattern).Inc()
+		log.WithFields(log.Fields{
+			FieldDomainPattern:  pattern,
+			FieldBreakerExpires: now.Add(breaker.options.Duration),
+		}).Warn("Circuit breaker opened")
+	}
+}
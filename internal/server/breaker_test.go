@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := server.NewCircuitBreaker(server.BreakerOptions{
+		MaxDenied: 2,
+		Window:    time.Minute,
+		Duration:  time.Hour,
+	})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The denied requests of an expired window aren't counted.
+	breaker.Deny("example.com", now)
+	breaker.Deny("example.com", now.Add(time.Second))
+	if breaker.Deny("example.com", now.Add(time.Minute)) {
+		t.Fatal("circuit opened after an expired window")
+	}
+	if breaker.Deny("example.com", now.Add(time.Minute+time.Second)) {
+		t.Fatal("circuit opened before the limit")
+	}
+	if breaker.Open("example.com", now.Add(time.Minute+time.Second)) {
+		t.Fatal("circuit open before the limit")
+	}
+
+	now = now.Add(time.Minute + 2*time.Second)
+	if !breaker.Deny("example.com", now) {
+		t.Fatal("circuit not opened after the limit")
+	}
+	if breaker.Deny("example.com", now) {
+		t.Error("open circuit opened again")
+	}
+	if !breaker.Open("example.com", now.Add(time.Hour-time.Second)) {
+		t.Error("circuit closed before its duration")
+	}
+	if breaker.Open("other.example.com", now) {
+		t.Error("circuit of another domain open")
+	}
+
+	// The circuit closes after its duration, with no denied requests.
+	if breaker.Open("example.com", now.Add(time.Hour)) {
+		t.Error("circuit open after its duration")
+	}
+	breaker.Deny("example.com", now.Add(time.Hour))
+	breaker.Deny("example.com", now.Add(time.Hour))
+	if breaker.Open("example.com", now.Add(time.Hour)) {
+		t.Error("closed circuit open before the limit")
+	}
+}
+
+func TestForwardAuthBreaker(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"example.com"},
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	handler := server.NewServer("", engine, newTestResolver(t), server.Options{
+		Breaker: server.NewCircuitBreaker(server.BreakerOptions{
+			MaxDenied: 2,
+		}),
+	}).Handler
+
+	authorize := func(domain, ip string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+		request.Header.Set(server.HeaderXForwardedFor, ip)
+		request.Header.Set(server.HeaderXForwardedHost, domain)
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// Requests to domains without pattern never open a circuit.
+	for range 5 {
+		authorize("other.example.com", "2.0.0.1")
+	}
+	if got := authorize("other.example.com", "1.0.0.1").Code; got !=
+		http.StatusForbidden {
+		t.Errorf("got status %d, want %d", got, http.StatusForbidden)
+	}
+	if got := authorize("example.com", "1.0.0.1").Code; got !=
+		http.StatusNoContent {
+		t.Errorf("got status %d, want %d", got, http.StatusNoContent)
+	}
+
+	for range 3 {
+		authorize("example.com", "2.0.0.1")
+	}
+
+	// Once the circuit is open, even the allowed clients are denied, without
+	// rule evaluation.
+	recorder := authorize("example.com", "1.0.0.1")
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", recorder.Code,
+			http.StatusForbidden)
+	}
+	if got := recorder.Header().Get(server.HeaderConfigGeneration); got != "" {
+		t.Errorf("got configuration generation %q, want none", got)
+	}
+}
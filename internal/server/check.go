@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// FieldService is the log field of the service name of TCP checks.
+const FieldService = "service"
+
+// Responses of the TCP check server.
+const (
+	CheckAllow = "allow"
+	CheckDeny  = "deny"
+	CheckError = "error"
+)
+
+// checkIdleTimeout is the time after which idle check connections are closed.
+const checkIdleTimeout = 30 * time.Second
+
+// errInvalidCheck is returned when a check request is malformed.
+var errInvalidCheck = errors.New("expected: <ip> [service]")
+
+// CheckServer is a TCP server that tells whether an IP address is allowed to
+// access a service. It lets non-HTTP services, such as mail or game servers,
+// reuse the same rules.
+//
+// Each request is a line containing an IP address, optionally followed by a
+// service name. Each response is a line containing `allow`, `deny` or `error`
+// followed by a description of the error. Several requests can be sent over
+// the same connection.
+//
+// The decisions are recorded like the ones of the HTTP requests, see
+// recordDecision.
+type CheckServer struct {
+	Addr     string
+	engine   *rules.Engine
+	resolver *ipres.Resolver
+	options  Options
+}
+
+// NewCheckServer creates a new TCP check server that listens on the given
+// address. Of the options, only the audit log, the webhooks and the history
+// are used.
+func NewCheckServer(
+	address string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) *CheckServer {
+	return &CheckServer{
+		Addr:     address,
+		engine:   engine,
+		resolver: resolver,
+		options:  options,
+	}
+}
+
+// ListenAndServe listens on the server's address and handles the incoming
+// connections. It only returns on error.
+func (s *CheckServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve handles the requests of the given connection until it's closed or
+// idle for too long.
+func (s *CheckServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for {
+		deadline := time.Now().Add(checkIdleTimeout)
+		if err := conn.SetDeadline(deadline); err != nil {
+			return
+		}
+		if !scanner.Scan() {
+			return
+		}
+		if _, err := fmt.Fprintln(conn, s.Check(scanner.Text())); err != nil {
+			return
+		}
+	}
+}
+
+// Check evaluates the given request line and returns the response line.
+func (s *CheckServer) Check(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return CheckError + " " + errInvalidCheck.Error()
+	}
+
+	ip, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return CheckError + " " + err.Error()
+	}
+
+	var service string
+	if len(fields) == 2 {
+		service = fields[1]
+	}
+
+	resolved := s.resolver.Resolve(ip)
+	query := &rules.Query{
+		Service:           service,
+		SourceIP:          ip,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+	}
+
+	logFields := log.Fields{
+		FieldService:       service,
+		FieldSourceIP:      ip,
+		FieldSourceCountry: resolved.CountryCode,
+		FieldSourceASN:     resolved.ASN,
+		FieldSourceOrg:     resolved.Organization,
+	}
+
+	decision := s.engine.Decide(query)
+	recordDecision(&s.options, query, &decision, "", false, logFields)
+	if decision.Allowed {
+		log.WithFields(logFields).Info("Check authorized")
+		return CheckAllow
+	}
+	log.WithFields(logFields).Warn("Check denied")
+	return CheckDeny
+}
@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/audit"
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+type mockFetcher map[string]string
+
+func (m mockFetcher) Fetch(url string) (*ipres.Resource, error) {
+	return &ipres.Resource{Data: []byte(m[url])}, nil
+}
+
+func newTestResolver(t *testing.T) *ipres.Resolver {
+	t.Helper()
+	resolver := ipres.NewResolver(mockFetcher{
+		ipres.CountryIPv4URL: "1.0.0.0,1.0.0.255,FR\n2.0.0.0,2.0.0.255,US\n",
+	}, ipres.Options{DisableASN: true})
+	if err := resolver.Update(); err != nil {
+		t.Fatal(err)
+	}
+	return resolver
+}
+
+func TestCheckServerCheck(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Services:  []string{"smtp"},
+				Countries: []string{"US"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	check := server.NewCheckServer(
+		"", engine, newTestResolver(t), server.Options{},
+	)
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"1.0.0.1", server.CheckAllow},
+		{"1.0.0.1 ssh", server.CheckAllow},
+		{"2.0.0.1 SMTP", server.CheckAllow},
+		{"2.0.0.1 ssh", server.CheckDeny},
+		{"2.0.0.1", server.CheckDeny},
+		{"3.0.0.1 smtp", server.CheckDeny},
+		{"", server.CheckError},
+		{"invalid smtp", server.CheckError},
+		{"1.0.0.1 smtp extra", server.CheckError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got := check.Check(tt.line)
+			if got != tt.want && !strings.HasPrefix(got, tt.want+" ") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckServerAudit(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.Open(path, audit.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	check := server.NewCheckServer(
+		"", engine, newTestResolver(t), server.Options{Audit: logger},
+	)
+	for _, line := range []string{"1.0.0.1 ssh", "2.0.0.1 ssh", "invalid"} {
+		check.Check(line)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []struct {
+		ip      string
+		outcome string
+	}{
+		{"1.0.0.1", audit.OutcomeAllow},
+		{"2.0.0.1", audit.OutcomeDeny},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d records, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var record audit.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.IP != want[i].ip || record.Service != "ssh" ||
+			record.Outcome != want[i].outcome {
+			t.Errorf("got %+v, want %+v", record, want[i])
+		}
+	}
+}
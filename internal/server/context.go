@@ -0,0 +1,46 @@
+package server
+
+import "strings"
+
+// maxContextPairs is the maximum number of key-value pairs parsed from the
+// context header. The following pairs are ignored.
+const maxContextPairs = 32
+
+// ParseContext parses the key-value pairs of a context header, e.g.,
+// "tenant=acme,tier=paid", possibly split over multiple header values. Keys
+// are lowercased, keys and values are trimmed, keys without `=` have an empty
+// value, and entries without key are ignored. If a key is repeated, its last
+// value is used. It returns nil if there are no pairs.
+func ParseContext(values []string) map[string]string {
+	var context map[string]string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			key, value, _ := strings.Cut(entry, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key == "" {
+				continue
+			}
+			if context == nil {
+				context = make(map[string]string)
+			}
+			if _, ok := context[key]; !ok && len(context) == maxContextPairs {
+				continue
+			}
+			context[key] = strings.TrimSpace(value)
+		}
+	}
+	return context
+}
+
+// normalizeContext returns the given context with lowercase keys, as parsed
+// by ParseContext.
+func normalizeContext(context map[string]string) map[string]string {
+	if len(context) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(context))
+	for key, value := range context {
+		normalized[strings.ToLower(key)] = value
+	}
+	return normalized
+}
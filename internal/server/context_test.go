@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestParseContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   map[string]string
+	}{
+		{"empty", nil, nil},
+		{"blank", []string{" , "}, nil},
+		{
+			"pairs",
+			[]string{"tenant=acme, Tier = paid"},
+			map[string]string{"tenant": "acme", "tier": "paid"},
+		},
+		{
+			"split values",
+			[]string{"tenant=acme", "tier=paid,tenant=example"},
+			map[string]string{"tenant": "example", "tier": "paid"},
+		},
+		{
+			"without value",
+			[]string{"beta,=ignored,url=https://example.com/?a=b"},
+			map[string]string{"beta": "", "url": "https://example.com/?a=b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := server.ParseContext(tt.values)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardAuthContext(t *testing.T) {
+	const header = "X-Geoblock-Context"
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Context: map[string][]string{"tier": {"paid"}},
+				Policy:  config.PolicyAllow,
+			},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		options server.Options
+		context string
+		status  int
+	}{
+		{
+			"matching",
+			server.Options{ContextHeader: header},
+			"tenant=acme,tier=paid",
+			http.StatusNoContent,
+		},
+		{
+			"not matching",
+			server.Options{ContextHeader: header},
+			"tenant=acme,tier=free",
+			http.StatusForbidden,
+		},
+		{
+			"not configured",
+			server.Options{},
+			"tier=paid",
+			http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := server.NewServer(
+				"", engine, newTestResolver(t), tt.options,
+			).Handler
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "2.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, "example.com")
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+			request.Header.Set(header, tt.context)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// databaseSourceResponse is the state of a database source in the database
+// status response.
+type databaseSourceResponse struct {
+	Name       string     `json:"name"`
+	URL        string     `json:"url,omitempty"`
+	Records    int        `json:"records"`
+	LastUpdate *time.Time `json:"last_update,omitempty"`
+	Cached     bool       `json:"cached"`
+	Stale      bool       `json:"stale"`
+}
+
+// databaseStatusResponse is the response of the database status endpoint.
+type databaseStatusResponse struct {
+	Sources []databaseSourceResponse `json:"sources"`
+}
+
+// getDatabaseStatus returns the state of each database source: the URL and
+// the number of records of the loaded content, the time of its last update,
+// and whether it's a cached copy or stale.
+func getDatabaseStatus(writer http.ResponseWriter, resolver *ipres.Resolver) {
+	statuses := resolver.SourceStatuses()
+	response := databaseStatusResponse{
+		Sources: make([]databaseSourceResponse, 0, len(statuses)),
+	}
+	for _, status := range statuses {
+		source := databaseSourceResponse{
+			Name:    status.Name,
+			URL:     status.URL,
+			Records: status.Records,
+			Cached:  status.Cached,
+			Stale:   status.Stale,
+		}
+		if !status.LastUpdate.IsZero() {
+			source.LastUpdate = &status.LastUpdate
+		}
+		response.Sources = append(response.Sources, source)
+	}
+	writeJSON(writer, http.StatusOK, response)
+}
@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestDatabaseStatus(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	handler := newAdminHandler(engine, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodGet, "/v1/db/status", nil,
+	))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Sources []struct {
+			Name       string     `json:"name"`
+			URL        string     `json:"url"`
+			Records    int        `json:"records"`
+			LastUpdate *time.Time `json:"last_update"`
+			Cached     bool       `json:"cached"`
+			Stale      bool       `json:"stale"`
+		} `json:"sources"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Sources) != 2 {
+		t.Fatalf("got %d sources, want 2", len(response.Sources))
+	}
+	source := response.Sources[0]
+	if source.Name != ipres.SourceCountryIPv4 ||
+		source.URL != ipres.CountryIPv4URL || source.Records != 2 {
+		t.Errorf("got %+v, want 2 records from %s",
+			source, ipres.CountryIPv4URL)
+	}
+	if source.LastUpdate == nil || source.Cached || source.Stale {
+		t.Errorf("got %+v, want an up-to-date source", source)
+	}
+}
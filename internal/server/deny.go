@@ -0,0 +1,216 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"maps"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// HTTP headers of denied responses.
+const (
+	HeaderContentLanguage = "Content-Language"
+	HeaderContentType     = "Content-Type"
+	HeaderLocation        = "Location"
+	HeaderRetryAfter      = "Retry-After"
+	HeaderVary            = "Vary"
+	HeaderXRequestID      = "X-Request-Id"
+)
+
+// FieldRequestID is the log field of the request ID shown on block pages.
+const FieldRequestID = "request_id"
+
+// HeaderAcceptLanguage is the header of the languages preferred by the
+// client, used to select the translation of the block page.
+const HeaderAcceptLanguage = "Accept-Language"
+
+// DenyPage contains the values available to the block page templates.
+type DenyPage struct {
+	IP        string
+	Country   string
+	Domain    string
+	RequestID string
+	Language  string // Language tag of the selected translation, if any
+
+	acceptLanguage string // Accept-Language header of the request
+}
+
+// denyTemplates caches the parsed block page templates by their text. The
+// templates only come from the configuration, so the cache stays small.
+var denyTemplates sync.Map
+
+// denyTemplate returns the parsed template of the given block page.
+func denyTemplate(text string) (*template.Template, error) {
+	if cached, ok := denyTemplates.Load(text); ok {
+		return cached.(*template.Template), nil
+	}
+
+	parsed, err := template.New("deny").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	denyTemplates.Store(text, parsed)
+	return parsed, nil
+}
+
+// hasBody returns true if the given response has a block page, in any
+// language.
+func hasBody(response *config.DenyResponse) bool {
+	return response.Body != "" || len(response.Bodies) > 0
+}
+
+// denyBody returns the body template of the given response in the language
+// preferred by the client, given by its Accept-Language header, and the tag
+// of this language. The default body and an empty tag are returned if none
+// of the translations matches.
+func denyBody(
+	response *config.DenyResponse,
+	acceptLanguage string,
+) (string, string) {
+	if len(response.Bodies) == 0 || acceptLanguage == "" {
+		return response.Body, ""
+	}
+	preferred, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(preferred) == 0 {
+		return response.Body, ""
+	}
+
+	// The first tag of a matcher is its default, returned when nothing
+	// matches.
+	keys := slices.Sorted(maps.Keys(response.Bodies))
+	tags := []language.Tag{language.Und}
+	for _, key := range keys {
+		tags = append(tags, language.Make(key))
+	}
+	_, index, confidence := language.NewMatcher(tags).Match(preferred...)
+	if index == 0 || confidence == language.No {
+		return response.Body, ""
+	}
+	key := keys[index-1]
+	return response.Bodies[key], key
+}
+
+// requestID returns the ID of the given request, as set by the reverse proxy.
+// A random ID is generated if there's none.
+func requestID(request *http.Request) string {
+	if id := request.Header.Get(HeaderXRequestID); id != "" {
+		return id
+	}
+
+	var id [8]byte
+	rand.Read(id[:]) // #nosec G104
+	return hex.EncodeToString(id[:])
+}
+
+// delayDenied waits for a random duration of up to the delay of the given
+// response, so that scanners can't use the response time to map the domains
+// behind the proxy. It returns early if the context is canceled.
+func delayDenied(ctx context.Context, response *config.DenyResponse) {
+	if response == nil || response.Delay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(mathrand.N(response.Delay)) // #nosec G404
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// retryAfter formats the given duration as the value of a Retry-After header:
+// a number of seconds, rounded up so that clients don't retry too early.
+func retryAfter(duration time.Duration) string {
+	seconds := (duration + time.Second - 1) / time.Second
+	return strconv.FormatInt(int64(seconds), 10)
+}
+
+// renderDenied returns the status, headers and body of the response of a
+// denied request. Without a configured response, it's 403 Forbidden.
+//
+// Requests denied by a rate limit get an empty 429 Too Many Requests response
+// instead, with a Retry-After header telling when the limit lets the client
+// make a new request.
+func renderDenied(
+	decision *rules.Decision,
+	page *DenyPage,
+) (int, http.Header, []byte) {
+	header := make(http.Header)
+	if decision.RetryAfter > 0 {
+		header.Set(HeaderRetryAfter, retryAfter(decision.RetryAfter))
+		return http.StatusTooManyRequests, header, nil
+	}
+
+	response := decision.DenyResponse
+	if response == nil {
+		return http.StatusForbidden, header, nil
+	}
+
+	if response.Redirect != "" {
+		header.Set(HeaderLocation, response.Redirect)
+		return http.StatusFound, header, nil
+	}
+
+	status := response.Status
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	if len(response.Bodies) > 0 {
+		header.Set(HeaderVary, HeaderAcceptLanguage)
+	}
+	text, lang := denyBody(response, page.acceptLanguage)
+	if text == "" {
+		return status, header, nil
+	}
+
+	// Render the page before setting the headers, so that a template error
+	// still results in a valid response.
+	var body bytes.Buffer
+	page.Language = lang
+	tmpl, err := denyTemplate(text)
+	if err == nil {
+		err = tmpl.Execute(&body, page)
+	}
+	if err != nil {
+		log.WithError(err).Error("Cannot render block page")
+		return status, header, nil
+	}
+
+	header.Set(HeaderContentType, "text/html; charset=utf-8")
+	if lang != "" {
+		header.Set(HeaderContentLanguage, lang)
+	}
+	header.Set(HeaderXRequestID, page.RequestID)
+	return status, header, body.Bytes()
+}
+
+// writeDenied writes the response of a denied request.
+func writeDenied(
+	writer http.ResponseWriter,
+	decision *rules.Decision,
+	page *DenyPage,
+) {
+	status, header, body := renderDenied(decision, page)
+	for name, values := range header {
+		writer.Header()[name] = values
+	}
+	writer.WriteHeader(status)
+	if body != nil {
+		writer.Write(body) // #nosec G104
+	}
+}
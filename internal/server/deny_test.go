@@ -0,0 +1,252 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestForwardAuthDenyResponse(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		DenyResponse: &config.DenyResponse{
+			Status: http.StatusUnavailableForLegalReasons,
+			Body: "<p>{{.IP}} ({{.Country}}) on {{.Domain}}: " +
+				"{{.RequestID}}</p>",
+		},
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"redirect.example.com"},
+				Policy:  config.PolicyDeny,
+				DenyResponse: &config.DenyResponse{
+					Redirect: "https://example.com/blocked",
+				},
+			},
+			{
+				Domains: []string{"hidden.example.com"},
+				Policy:  config.PolicyDeny,
+				DenyResponse: &config.DenyResponse{
+					Status: http.StatusNotFound,
+				},
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	tests := []struct {
+		domain   string
+		status   int
+		location string
+		body     string
+	}{
+		{
+			"redirect.example.com",
+			http.StatusFound,
+			"https://example.com/blocked",
+			"",
+		},
+		{
+			"hidden.example.com",
+			http.StatusNotFound,
+			"",
+			"",
+		},
+		{
+			"www.example.com",
+			http.StatusUnavailableForLegalReasons,
+			"",
+			"<p>1.0.0.1 (FR) on www.example.com: abc&lt;1&gt;</p>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, tt.domain)
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+			request.Header.Set(server.HeaderXRequestID, "abc<1>")
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+			location := recorder.Header().Get(server.HeaderLocation)
+			if location != tt.location {
+				t.Errorf("got location %q, want %q", location, tt.location)
+			}
+			if body := recorder.Body.String(); body != tt.body {
+				t.Errorf("got body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestForwardAuthDenyLanguage(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		DenyResponse: &config.DenyResponse{
+			Body: "<p>Blocked</p>",
+			Bodies: map[string]string{
+				"fr":    `<p lang="{{.Language}}">Bloqué</p>`,
+				"pt-BR": `<p lang="{{.Language}}">Bloqueado</p>`,
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	tests := []struct {
+		acceptLanguage string
+		language       string
+		body           string
+	}{
+		{"", "", "<p>Blocked</p>"},
+		{"de-DE, de;q=0.9", "", "<p>Blocked</p>"},
+		{"fr-CA, en;q=0.8", "fr", `<p lang="fr">Bloqué</p>`},
+		{"en;q=0.5, pt-BR", "pt-BR", `<p lang="pt-BR">Bloqueado</p>`},
+		{"invalid;q=x", "", "<p>Blocked</p>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, "example.com")
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+			request.Header.Set(server.HeaderAcceptLanguage, tt.acceptLanguage)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if body := recorder.Body.String(); body != tt.body {
+				t.Errorf("got body %q, want %q", body, tt.body)
+			}
+			header := recorder.Header()
+			if got := header.Get(server.HeaderContentLanguage); got !=
+				tt.language {
+				t.Errorf("got language %q, want %q", got, tt.language)
+			}
+			if got := header.Get(server.HeaderVary); got !=
+				server.HeaderAcceptLanguage {
+				t.Errorf("got vary %q, want %q", got,
+					server.HeaderAcceptLanguage)
+			}
+		})
+	}
+}
+
+func TestForwardAuthDenyDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"slow.example.com"},
+				Policy:  config.PolicyDeny,
+				DenyResponse: &config.DenyResponse{
+					Delay: delay,
+				},
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+	request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+	request.Header.Set(server.HeaderXForwardedHost, "slow.example.com")
+	request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+
+	// A canceled request isn't delayed.
+	ctx, cancel := context.WithCancel(request.Context())
+	cancel()
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request.WithContext(ctx))
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("got a delay of %v for a canceled request", elapsed)
+	}
+
+	// The delay never exceeds the configured one.
+	for range 5 {
+		start := time.Now()
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		if elapsed := time.Since(start); elapsed > delay+time.Second {
+			t.Errorf("got a delay of %v, want at most %v", elapsed, delay)
+		}
+	}
+}
+
+func TestForwardAuthRateLimited(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		DenyResponse: &config.DenyResponse{
+			Redirect: "https://example.com/blocked",
+		},
+		Rules: []config.AccessControlRule{
+			{
+				Policy: config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 1,
+					Window:   time.Minute,
+				},
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	forwardAuth := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(
+			http.MethodGet, "/v1/forward-auth", nil,
+		)
+		request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+		request.Header.Set(server.HeaderXForwardedHost, "example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if got := forwardAuth().Code; got != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", got, http.StatusNoContent)
+	}
+
+	// The rate limit takes precedence over the configured deny response.
+	recorder := forwardAuth()
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d",
+			recorder.Code, http.StatusTooManyRequests)
+	}
+	if got := recorder.Header().Get(server.HeaderRetryAfter); got != "60" {
+		t.Errorf("got Retry-After %q, want %q", got, "60")
+	}
+	if got := recorder.Header().Get(server.HeaderLocation); got != "" {
+		t.Errorf("got Location %q, want none", got)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// DNSBLService is the service name of the queries made by the DNSBL server.
+// It can be matched with the `services` rule condition.
+const DNSBLService = "dnsbl"
+
+// DNSBLListed is the address returned for denied IP addresses.
+var DNSBLListed = netip.AddrFrom4([4]byte{127, 0, 0, 2})
+
+// DNSBLText is the TXT record returned for denied IP addresses.
+const DNSBLText = "Denied by geoblock"
+
+// Test entries that every DNSBL must support, as per RFC 5782.
+var (
+	dnsblTestListed    = netip.AddrFrom4([4]byte{127, 0, 0, 2})
+	dnsblTestNotListed = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+)
+
+const (
+	// dnsblTTL is the TTL, in seconds, of the DNSBL answers. It's kept short
+	// so that configuration changes are quickly visible to the clients.
+	dnsblTTL = 60
+
+	// dnsblMaxPacket is the maximum size of a DNS query over UDP.
+	dnsblMaxPacket = 512
+)
+
+var (
+	errDNSBLZone = errors.New("name outside of the DNSBL zone")
+	errDNSBLName = errors.New("name isn't a reversed IP address")
+
+	errDNSResponse = errors.New("unexpected DNS response")
+	errDNSResource = errors.New("unsupported DNS resource")
+)
+
+// DNSBLServer is a DNS server that answers DNSBL queries, as described in RFC
+// 5782, based on the rules. It lets software that only supports DNSBL
+// lookups, such as mail filters, reuse the same rules.
+//
+// An IP address is looked up by querying its reversed form under the zone,
+// e.g., `4.3.2.1.dnsbl.example.com` for `1.2.3.4`. Denied addresses are
+// listed with the address DNSBLListed and the text DNSBLText, while allowed
+// addresses don't exist.
+type DNSBLServer struct {
+	Addr     string
+	zone     string
+	engine   *rules.Engine
+	resolver *ipres.Resolver
+}
+
+// NewDNSBLServer creates a new DNSBL server for the given zone that listens
+// on the given address.
+func NewDNSBLServer(
+	address string,
+	zone string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+) *DNSBLServer {
+	return &DNSBLServer{
+		Addr:     address,
+		zone:     normalizeDNSName(zone),
+		engine:   engine,
+		resolver: resolver,
+	}
+}
+
+// ListenAndServe listens on the server's UDP address and answers the
+// incoming queries. It only returns on error.
+func (s *DNSBLServer) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(conn)
+}
+
+// Serve answers the queries received on the given connection. It closes the
+// connection and only returns on error.
+func (s *DNSBLServer) Serve(conn net.PacketConn) error {
+	defer conn.Close()
+
+	buf := make([]byte, dnsblMaxPacket)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		response, err := s.respond(buf[:n])
+		if err != nil {
+			log.WithError(err).Debug("Invalid DNSBL query")
+			continue
+		}
+		if _, err := conn.WriteTo(response, addr); err != nil {
+			log.WithError(err).Debug("Cannot send DNSBL response")
+		}
+	}
+}
+
+// respond returns the response to the given DNS query. It returns an error if
+// the query can't be answered at all.
+func (s *DNSBLServer) respond(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errDNSResponse
+	}
+
+	responseHeader := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+	}
+
+	question, err := parser.Question()
+	if err != nil {
+		responseHeader.RCode = dnsmessage.RCodeFormatError
+		builder := dnsmessage.NewBuilder(nil, responseHeader)
+		return builder.Finish()
+	}
+
+	var answers []dnsmessage.Resource
+	responseHeader.RCode, answers = s.answer(header.OpCode, question)
+
+	builder := dnsmessage.NewBuilder(nil, responseHeader)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, answer := range answers {
+		if err := addDNSResource(&builder, answer); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// answer returns the response code and the answers to the given question.
+func (s *DNSBLServer) answer(
+	opCode dnsmessage.OpCode,
+	question dnsmessage.Question,
+) (dnsmessage.RCode, []dnsmessage.Resource) {
+	if opCode != 0 || question.Class != dnsmessage.ClassINET {
+		return dnsmessage.RCodeNotImplemented, nil
+	}
+
+	ip, err := s.parseName(question.Name.String())
+	if errors.Is(err, errDNSBLZone) {
+		return dnsmessage.RCodeRefused, nil
+	}
+	if err != nil || !s.listed(ip) {
+		return dnsmessage.RCodeNameError, nil
+	}
+
+	header := dnsmessage.ResourceHeader{
+		Name:  question.Name,
+		Class: dnsmessage.ClassINET,
+		TTL:   dnsblTTL,
+	}
+
+	var (
+		answers []dnsmessage.Resource
+		qtype   = question.Type
+	)
+	if qtype == dnsmessage.TypeA || qtype == dnsmessage.TypeALL {
+		header.Type = dnsmessage.TypeA
+		answers = append(answers, dnsmessage.Resource{
+			Header: header,
+			Body:   &dnsmessage.AResource{A: DNSBLListed.As4()},
+		})
+	}
+	if qtype == dnsmessage.TypeTXT || qtype == dnsmessage.TypeALL {
+		header.Type = dnsmessage.TypeTXT
+		answers = append(answers, dnsmessage.Resource{
+			Header: header,
+			Body:   &dnsmessage.TXTResource{TXT: []string{DNSBLText}},
+		})
+	}
+	return dnsmessage.RCodeSuccess, answers
+}
+
+// listed checks if the given IP address is listed, i.e., denied by the rules.
+// The lookups don't consume the rate limits and quotas of the listed IP.
+func (s *DNSBLServer) listed(ip netip.Addr) bool {
+	switch ip {
+	case dnsblTestListed:
+		return true
+	case dnsblTestNotListed:
+		return false
+	}
+
+	resolved := s.resolver.Resolve(ip)
+	query := &rules.Query{
+		Service:           DNSBLService,
+		SourceIP:          ip,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+	}
+
+	logFields := log.Fields{
+		FieldService:       DNSBLService,
+		FieldSourceIP:      ip,
+		FieldSourceCountry: resolved.CountryCode,
+		FieldSourceASN:     resolved.ASN,
+		FieldSourceOrg:     resolved.Organization,
+	}
+
+	decision := s.engine.Evaluate(query)
+	addRuleFields(logFields, &decision)
+	if decision.Allowed {
+		log.WithFields(logFields).Info("DNSBL query authorized")
+		return false
+	}
+	log.WithFields(logFields).Warn("DNSBL query denied")
+	return true
+}
+
+// parseName returns the IP address queried by the given name. IPv4 addresses
+// are written as 4 reversed decimal labels and IPv6 addresses as 32 reversed
+// hexadecimal nibbles.
+func (s *DNSBLServer) parseName(name string) (netip.Addr, error) {
+	name = normalizeDNSName(name)
+	if name == s.zone {
+		return netip.Addr{}, errDNSBLName
+	}
+	prefix, ok := strings.CutSuffix(name, "."+s.zone)
+	if !ok {
+		return netip.Addr{}, errDNSBLZone
+	}
+
+	labels := strings.Split(prefix, ".")
+	slices.Reverse(labels)
+
+	switch len(labels) {
+	case 4:
+		ip, err := netip.ParseAddr(strings.Join(labels, "."))
+		if err != nil || !ip.Is4() {
+			return netip.Addr{}, errDNSBLName
+		}
+		return ip, nil
+	case 32:
+		var bytes [16]byte
+		for i, label := range labels {
+			nibble, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Addr{}, errDNSBLName
+			}
+			bytes[i/2] |= byte(nibble) << (4 * (1 - i%2))
+		}
+		return netip.AddrFrom16(bytes).Unmap(), nil
+	default:
+		return netip.Addr{}, errDNSBLName
+	}
+}
+
+// normalizeDNSName returns the given DNS name in lower case and without the
+// trailing dot.
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// addDNSResource adds the given A or TXT resource to the builder.
+func addDNSResource(
+	builder *dnsmessage.Builder,
+	resource dnsmessage.Resource,
+) error {
+	switch body := resource.Body.(type) {
+	case *dnsmessage.AResource:
+		return builder.AResource(resource.Header, *body)
+	case *dnsmessage.TXTResource:
+		return builder.TXTResource(resource.Header, *body)
+	default:
+		return errDNSResource
+	}
+}
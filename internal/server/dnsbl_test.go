@@ -0,0 +1,229 @@
+package server_test
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+// dnsblQuery sends a DNS query to the given server and returns its response.
+func dnsblQuery(
+	t *testing.T,
+	addr net.Addr,
+	name string,
+	qtype dnsmessage.Type,
+) *dnsmessage.Message {
+	t.Helper()
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if response.ID != query.ID || !response.Response {
+		t.Fatalf("got ID %d, want response to %d", response.ID, query.ID)
+	}
+	return &response
+}
+
+func TestDNSBLServer(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Services: []string{"dnsbl"},
+				Networks: []config.CIDR{
+					{Prefix: netip.MustParsePrefix("2001:db8::/32")},
+				},
+				Policy: config.PolicyAllow,
+			},
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	dnsbl := server.NewDNSBLServer(
+		"", "DNSBL.example.com.", engine, newTestResolver(t),
+	)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go dnsbl.Serve(conn) // #nosec G104
+	t.Cleanup(func() { conn.Close() })
+
+	// Reversed nibbles of 2001:db8::1.
+	const ipv6 = "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0." +
+		"0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"
+
+	tests := []struct {
+		name   string
+		qtype  dnsmessage.Type
+		rcode  dnsmessage.RCode
+		answer dnsmessage.ResourceBody
+	}{
+		{
+			"1.0.0.1.dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeNameError,
+			nil,
+		},
+		{
+			"1.0.0.2.dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeSuccess,
+			&dnsmessage.AResource{A: server.DNSBLListed.As4()},
+		},
+		{
+			"1.0.0.2.DNSBL.example.com.",
+			dnsmessage.TypeTXT,
+			dnsmessage.RCodeSuccess,
+			&dnsmessage.TXTResource{TXT: []string{server.DNSBLText}},
+		},
+		{
+			"1.0.0.2.dnsbl.example.com.",
+			dnsmessage.TypeAAAA,
+			dnsmessage.RCodeSuccess,
+			nil,
+		},
+		{
+			ipv6 + ".dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeNameError,
+			nil,
+		},
+		{
+			"2.0.0.127.dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeSuccess,
+			&dnsmessage.AResource{A: server.DNSBLListed.As4()},
+		},
+		{
+			"1.0.0.127.dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeNameError,
+			nil,
+		},
+		{
+			"0.0.1.dnsbl.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeNameError,
+			nil,
+		},
+		{
+			"1.0.0.2.example.com.",
+			dnsmessage.TypeA,
+			dnsmessage.RCodeRefused,
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" "+tt.qtype.String(), func(t *testing.T) {
+			response := dnsblQuery(t, conn.LocalAddr(), tt.name, tt.qtype)
+			if response.RCode != tt.rcode {
+				t.Errorf("got %v, want %v", response.RCode, tt.rcode)
+			}
+
+			var want []dnsmessage.ResourceBody
+			if tt.answer != nil {
+				want = append(want, tt.answer)
+			}
+			if len(response.Answers) != len(want) {
+				t.Fatalf("got %d answers, want %d",
+					len(response.Answers), len(want))
+			}
+			for i, answer := range response.Answers {
+				if answer.Body.GoString() != want[i].GoString() {
+					t.Errorf("got %s, want %s",
+						answer.Body.GoString(), want[i].GoString())
+				}
+			}
+		})
+	}
+}
+
+func TestDNSBLServerRateLimit(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+				RateLimit: &config.RateLimit{
+					Requests: 1,
+					Window:   time.Minute,
+				},
+			},
+		},
+	})
+	dnsbl := server.NewDNSBLServer(
+		"", "dnsbl.example.com.", engine, newTestResolver(t),
+	)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go dnsbl.Serve(conn) // #nosec G104
+	t.Cleanup(func() { conn.Close() })
+
+	for range 3 {
+		response := dnsblQuery(
+			t, conn.LocalAddr(), "1.0.0.1.dnsbl.example.com.",
+			dnsmessage.TypeA,
+		)
+		if response.RCode != dnsmessage.RCodeNameError {
+			t.Errorf("got %v, want %v", response.RCode,
+				dnsmessage.RCodeNameError)
+		}
+	}
+
+	decision := engine.Decide(&rules.Query{
+		SourceIP:      netip.MustParseAddr("1.0.0.1"),
+		SourceCountry: "FR",
+	})
+	if !decision.Allowed {
+		t.Error("got request denied, want rate limit not consumed")
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// Recent requests are counted in one-minute buckets over the last hour.
+const (
+	domainBucketSize  = time.Minute
+	domainBucketCount = 60
+)
+
+// domainBucket counts the requests of a domain pattern during one minute.
+type domainBucket struct {
+	start   int64 // Start of the bucket, in minutes since the Unix epoch
+	allowed uint64
+	denied  uint64
+}
+
+// DomainCounters counts the recent allowed and denied requests per domain
+// pattern.
+type DomainCounters struct {
+	mu      sync.Mutex
+	buckets map[string]*[domainBucketCount]domainBucket
+}
+
+// NewDomainCounters creates empty domain counters.
+func NewDomainCounters() *DomainCounters {
+	return &DomainCounters{
+		buckets: make(map[string]*[domainBucketCount]domainBucket),
+	}
+}
+
+// Add counts a request for the given domain pattern at the given time.
+func (c *DomainCounters) Add(pattern string, allowed bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buckets, ok := c.buckets[pattern]
+	if !ok {
+		buckets = &[domainBucketCount]domainBucket{}
+		c.buckets[pattern] = buckets
+	}
+
+	start := now.Unix() / int64(domainBucketSize.Seconds())
+	bucket := &buckets[start%domainBucketCount]
+	if bucket.start != start {
+		*bucket = domainBucket{start: start}
+	}
+	if allowed {
+		bucket.allowed++
+	} else {
+		bucket.denied++
+	}
+}
+
+// Counts returns the number of allowed and denied requests for the given
+// domain pattern during the last hour.
+func (c *DomainCounters) Counts(
+	pattern string,
+	now time.Time,
+) (allowed, denied uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buckets, ok := c.buckets[pattern]
+	if !ok {
+		return 0, 0
+	}
+
+	current := now.Unix() / int64(domainBucketSize.Seconds())
+	for _, bucket := range buckets {
+		if current-bucket.start < domainBucketCount {
+			allowed += bucket.allowed
+			denied += bucket.denied
+		}
+	}
+	return allowed, denied
+}
+
+var domainCounters = NewDomainCounters()
+
+// domainResponse is the summary of a domain pattern in the domains response.
+type domainResponse struct {
+	Pattern string `json:"pattern"`
+	Policy  string `json:"policy"`
+	Rules   []int  `json:"rules"`
+	Allowed uint64 `json:"allowed"`
+	Denied  uint64 `json:"denied"`
+}
+
+// domainsResponse is the response of the domains endpoint.
+type domainsResponse struct {
+	Domains []domainResponse `json:"domains"`
+}
+
+// getDomains returns, for each domain pattern of the rules, the policy, the
+// rules that may apply and the number of allowed and denied requests during
+// the last hour.
+func getDomains(
+	writer http.ResponseWriter,
+	_ *http.Request,
+	engine *rules.Engine,
+) {
+	var (
+		now      = time.Now()
+		response = domainsResponse{Domains: []domainResponse{}}
+	)
+	for _, summary := range engine.Domains() {
+		allowed, denied := domainCounters.Counts(summary.Pattern, now)
+		response.Domains = append(response.Domains, domainResponse{
+			Pattern: summary.Pattern,
+			Policy:  summary.Policy,
+			Rules:   summary.Rules,
+			Allowed: allowed,
+			Denied:  denied,
+		})
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		log.WithError(err).Error("Cannot write domains response")
+	}
+}
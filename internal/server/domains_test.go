@@ -0,0 +1,43 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestDomainCounters(t *testing.T) {
+	var (
+		counters = server.NewDomainCounters()
+		now      = time.Unix(1700000000, 0)
+	)
+
+	counters.Add("a", true, now.Add(-2*time.Hour))
+	counters.Add("a", true, now.Add(-30*time.Minute))
+	counters.Add("a", false, now.Add(-time.Minute))
+	counters.Add("a", true, now)
+	counters.Add("b", false, now)
+
+	tests := []struct {
+		pattern string
+		allowed uint64
+		denied  uint64
+	}{
+		{"a", 2, 1},
+		{"b", 0, 1},
+		{"c", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			allowed, denied := counters.Counts(tt.pattern, now)
+			if allowed != tt.allowed || denied != tt.denied {
+				t.Errorf(
+					"got (%d, %d), want (%d, %d)",
+					allowed, denied, tt.allowed, tt.denied,
+				)
+			}
+		})
+	}
+}
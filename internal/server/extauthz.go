@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// ExtAuthzServer is a gRPC server implementing Envoy's external authorization
+// API. It lets Envoy and Istio check requests against the rules without
+// translating them into forward-auth headers.
+//
+// The client's IP is the source address sent by Envoy, which already takes
+// the trusted hops of the X-Forwarded-For header into account.
+type ExtAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+
+	Addr     string
+	engine   *rules.Engine
+	resolver *ipres.Resolver
+	options  Options
+}
+
+// NewExtAuthzServer creates a new ext_authz server that listens on the given
+// address. Of the options, only the signer is used.
+func NewExtAuthzServer(
+	address string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) *ExtAuthzServer {
+	return &ExtAuthzServer{
+		Addr:     address,
+		engine:   engine,
+		resolver: resolver,
+		options:  options,
+	}
+}
+
+// ListenAndServe listens on the server's address and handles the incoming
+// connections. It only returns on error.
+func (s *ExtAuthzServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve handles the incoming connections of the given listener. It only
+// returns on error.
+func (s *ExtAuthzServer) Serve(listener net.Listener) error {
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, s)
+	return server.Serve(listener)
+}
+
+// headerOption returns an Envoy header that replaces any header of the same
+// name.
+func headerOption(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{Key: name, Value: value},
+		AppendAction: corev3.
+			HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// envoyHeader returns the value of the given header of a check request. Envoy
+// sends the header names in lowercase.
+func envoyHeader(headers map[string]string, name string) string {
+	return headers[strings.ToLower(name)]
+}
+
+// invalidCheck returns the response of a malformed check request.
+func invalidCheck() *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.InvalidArgument)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status: &typev3.HttpStatus{Code: typev3.StatusCode_BadRequest},
+			},
+		},
+	}
+}
+
+// Check evaluates the given request. Denied requests are answered with the
+// configured deny response.
+func (s *ExtAuthzServer) Check(
+	ctx context.Context,
+	request *authv3.CheckRequest,
+) (*authv3.CheckResponse, error) {
+	var (
+		attributes  = request.GetAttributes()
+		httpRequest = attributes.GetRequest().GetHttp()
+		headers     = httpRequest.GetHeaders()
+		domain      = httpRequest.GetHost()
+		method      = httpRequest.GetMethod()
+		source      = attributes.GetSource().GetAddress().GetSocketAddress()
+		origin      = source.GetAddress()
+	)
+
+	// Envoy includes the port in the host when it's not the default one.
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+
+	sourceIP, err := netip.ParseAddr(origin)
+	if err != nil || domain == "" || method == "" {
+		log.WithFields(log.Fields{
+			FieldRequestDomain: domain,
+			FieldRequestMethod: method,
+			FieldSourceIP:      origin,
+		}).Error("Invalid ext_authz request")
+		counters.Invalid.Add(1)
+		auditInvalid(s.options.Audit, origin, domain, method)
+		metrics.CountRequest(metrics.ResultInvalid, metrics.RequestLabels{})
+		return invalidCheck(), nil
+	}
+	sourceIP = sourceIP.Unmap()
+
+	// During a flood, the requests of the domain are denied before any
+	// further work.
+	pattern, matched := s.engine.DomainPattern(domain)
+	if breakerOpen(
+		s.options.Breaker, pattern, matched,
+		metrics.RequestLabels{Domain: pattern, Method: method},
+	) {
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(codes.PermissionDenied)},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{
+				DeniedResponse: &authv3.DeniedHttpResponse{
+					Status: &typev3.HttpStatus{
+						Code: typev3.StatusCode_Forbidden,
+					},
+				},
+			},
+		}, nil
+	}
+
+	resolved := s.resolver.Resolve(sourceIP)
+
+	var preflightOrigin string
+	if method == http.MethodOptions &&
+		envoyHeader(headers, HeaderAccessControlRequestMethod) != "" {
+		preflightOrigin = envoyHeader(headers, HeaderOrigin)
+	}
+	chain := forwardedFor([]string{envoyHeader(headers, HeaderXForwardedFor)})
+
+	query := &rules.Query{
+		RequestedDomain:   domain,
+		RequestedMethod:   method,
+		RequestedPath:     RequestPath(httpRequest.GetPath()),
+		RequestedProtocol: httpRequest.GetScheme(),
+		SourceIP:          sourceIP,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+		ForwardedHops:     len(chain),
+		PreflightOrigin:   preflightOrigin,
+	}
+	if s.options.ContextHeader != "" {
+		query.Context = ParseContext([]string{
+			envoyHeader(headers, s.options.ContextHeader),
+		})
+	}
+
+	logFields := log.Fields{
+		FieldRequestDomain: domain,
+		FieldRequestMethod: method,
+		FieldSourceIP:      sourceIP,
+		FieldSourceCountry: resolved.CountryCode,
+		FieldSourceASN:     resolved.ASN,
+		FieldSourceOrg:     resolved.Organization,
+	}
+	if query.RequestedPath != "" {
+		logFields[FieldRequestPath] = query.RequestedPath
+	}
+	if query.Context != nil {
+		logFields[FieldContext] = query.Context
+	}
+	if resolved.Region != "" {
+		logFields[FieldSourceRegion] = resolved.Region
+	}
+	if resolved.City != "" {
+		logFields[FieldSourceCity] = resolved.City
+	}
+	if resolved.IsCDN() {
+		logFields[FieldSourceCDN] = resolved.CDN
+	}
+	if resolved.Monitor != "" {
+		logFields[FieldSourceMonitor] = resolved.Monitor
+	}
+	if resolved.Anonymizers != 0 {
+		logFields[FieldSourceAnonymizers] = resolved.Anonymizers.String()
+	}
+
+	decision := s.engine.Decide(query)
+	recordDecision(
+		&s.options, query, &decision, pattern, matched, logFields,
+	)
+
+	generation := headerOption(
+		HeaderConfigGeneration, strconv.FormatUint(decision.Generation, 10),
+	)
+
+	if decision.Allowed {
+		log.WithFields(logFields).Info("Request authorized")
+		trackCountry(
+			s.options.FirstSeen, domain, resolved.CountryCode, logFields,
+		)
+
+		// The resolution headers and the signature are added to the upstream
+		// request, replacing any header of the same name sent by the client.
+		// The resolution headers of unknown values are removed.
+		ok := &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{generation},
+		}
+		headers := resolutionHeaders(
+			&resolved, decision.Rule, sourceIP,
+			findHeaderPolicy(s.options.HeaderPolicies, domain),
+		)
+		for _, name := range resolutionHeaderNames {
+			if value := headers.Get(name); value != "" {
+				ok.Headers = append(ok.Headers, headerOption(name, value))
+			} else {
+				ok.HeadersToRemove = append(ok.HeadersToRemove, name)
+			}
+		}
+		if signer := s.options.Signer; signer != nil {
+			ok.Headers = append(ok.Headers, headerOption(
+				signer.Header(),
+				signer.Sign(sourceIP, DecisionAllow, time.Now()),
+			))
+		}
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+		}, nil
+	}
+
+	page := &DenyPage{
+		IP:      sourceIP.String(),
+		Country: resolved.CountryCode,
+		Domain:  domain,
+
+		acceptLanguage: envoyHeader(headers, HeaderAcceptLanguage),
+	}
+	if response := decision.DenyResponse; response != nil &&
+		response.Redirect == "" && hasBody(response) {
+		page.RequestID = envoyHeader(headers, HeaderXRequestID)
+		if page.RequestID == "" {
+			page.RequestID = httpRequest.GetId()
+		}
+		logFields[FieldRequestID] = page.RequestID
+	}
+
+	log.WithFields(logFields).Warn("Request denied")
+	delayDenied(ctx, decision.DenyResponse)
+
+	code, header, body := renderDenied(&decision, page)
+	denied := &authv3.DeniedHttpResponse{
+		Status:  &typev3.HttpStatus{Code: typev3.StatusCode(code)},
+		Headers: []*corev3.HeaderValueOption{generation},
+		Body:    string(body),
+	}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		denied.Headers = append(
+			denied.Headers, headerOption(name, header.Get(name)),
+		)
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.PermissionDenied)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: denied,
+		},
+	}, nil
+}
@@ -0,0 +1,217 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+// newCheckRequest returns an ext_authz request as sent by Envoy.
+func newCheckRequest(
+	ip, host, method string,
+	headers map[string]string,
+) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Address: ip,
+						},
+					},
+				},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Host:    host,
+					Method:  method,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func TestExtAuthzServerCheck(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"hidden.example.com"},
+				Policy:  config.PolicyDeny,
+				DenyResponse: &config.DenyResponse{
+					Status: http.StatusNotFound,
+					Body:   "{{.IP}} {{.RequestID}}",
+				},
+			},
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	signer := server.NewSigner([]byte("secret"), "")
+	extAuthz := server.NewExtAuthzServer(
+		"", engine, newTestResolver(t), server.Options{Signer: signer},
+	)
+
+	tests := []struct {
+		name    string
+		request *authv3.CheckRequest
+		code    codes.Code
+		status  int
+		body    string
+		signed  bool
+	}{
+		{
+			"allowed country",
+			newCheckRequest("1.0.0.1", "example.com:8443", "GET", nil),
+			codes.OK,
+			0,
+			"",
+			true,
+		},
+		{
+			"denied country",
+			newCheckRequest("2.0.0.1", "example.com", "GET", nil),
+			codes.PermissionDenied,
+			http.StatusForbidden,
+			"",
+			false,
+		},
+		{
+			"deny response",
+			newCheckRequest(
+				"1.0.0.1", "hidden.example.com", "GET",
+				map[string]string{"x-request-id": "abc"},
+			),
+			codes.PermissionDenied,
+			http.StatusNotFound,
+			"1.0.0.1 abc",
+			false,
+		},
+		{
+			"invalid source",
+			newCheckRequest("", "example.com", "GET", nil),
+			codes.InvalidArgument,
+			http.StatusBadRequest,
+			"",
+			false,
+		},
+		{
+			"missing host",
+			newCheckRequest("1.0.0.1", "", "GET", nil),
+			codes.InvalidArgument,
+			http.StatusBadRequest,
+			"",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := extAuthz.Check(context.Background(), tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := codes.Code(response.GetStatus().GetCode())
+			if code != tt.code {
+				t.Errorf("got code %v, want %v", code, tt.code)
+			}
+
+			if tt.code == codes.OK {
+				ok := response.GetOkResponse()
+				headers := make(map[string]string)
+				for _, option := range ok.GetHeaders() {
+					header := option.GetHeader()
+					headers[header.GetKey()] = header.GetValue()
+				}
+				_, signed := headers[signer.Header()]
+				if signed != tt.signed {
+					t.Errorf("got headers %v, want signed %v",
+						headers, tt.signed)
+				}
+				if got := headers[server.HeaderCountry]; got != "FR" {
+					t.Errorf("got country header %q, want FR", got)
+				}
+				if got := headers[server.HeaderRule]; got != "1" {
+					t.Errorf("got rule header %q, want 1", got)
+				}
+
+				// The ASN database isn't loaded and the IP header isn't
+				// selected, so the client can't set these headers.
+				removed := ok.GetHeadersToRemove()
+				want := []string{
+					server.HeaderASN, server.HeaderOrg, server.HeaderIP,
+				}
+				if !slices.Equal(removed, want) {
+					t.Errorf("got removed headers %v, want %v", removed,
+						want)
+				}
+				return
+			}
+
+			denied := response.GetDeniedResponse()
+			if got := int(denied.GetStatus().GetCode()); got != tt.status {
+				t.Errorf("got status %d, want %d", got, tt.status)
+			}
+			if denied.GetBody() != tt.body {
+				t.Errorf("got body %q, want %q", denied.GetBody(), tt.body)
+			}
+		})
+	}
+}
+
+func TestExtAuthzServerServe(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"US"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	extAuthz := server.NewExtAuthzServer(
+		"", engine, newTestResolver(t), server.Options{},
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go extAuthz.Serve(listener) // #nosec G104
+
+	conn, err := grpc.NewClient(
+		listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	response, err := authv3.NewAuthorizationClient(conn).Check(
+		context.Background(),
+		newCheckRequest("2.0.0.1", "example.com", "GET", nil),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := codes.Code(response.GetStatus().GetCode()); got != codes.OK {
+		t.Errorf("got code %v, want %v", got, codes.OK)
+	}
+}
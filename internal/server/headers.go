@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/utils/glob"
+)
+
+// Names of the resolution headers, as selected by a header policy.
+const (
+	HeaderNameCountry = "country"
+	HeaderNameASN     = "asn"
+	HeaderNameOrg     = "org"
+	HeaderNameRule    = "rule"
+	HeaderNameIP      = "ip"
+)
+
+// Forms of the client IP sent in the IP header.
+const (
+	IPFull     = "full"     // The IP as is
+	IPTruncate = "truncate" // The network of the IP, see TruncateIP
+	IPHash     = "hash"     // The HMAC of the IP, see HashIP
+)
+
+// Prefix lengths of the networks the truncated IPs are sent as. They're large
+// enough to identify the provider and location of a client, but not the
+// client itself.
+const (
+	TruncateIPv4Bits = 24
+	TruncateIPv6Bits = 48
+)
+
+// defaultHeaderNames are the names of the resolution headers sent to the
+// upstreams of the domains without header policy. The IP header is only sent
+// if a policy selects it.
+var defaultHeaderNames = []string{
+	HeaderNameCountry,
+	HeaderNameASN,
+	HeaderNameOrg,
+	HeaderNameRule,
+}
+
+// HeaderPolicy selects the resolution headers sent to the upstreams of the
+// domains matching one of its patterns, e.g., to keep the location of the
+// clients private from some applications.
+type HeaderPolicy struct {
+	// Domains are the patterns of the domains the policy applies to. They may
+	// contain `*` wildcards.
+	Domains []string
+
+	// Headers are the names of the headers sent, e.g., HeaderNameCountry. If
+	// empty, no resolution header is sent.
+	Headers []string
+
+	// IP is the form of the client IP sent in the IP header: IPFull,
+	// IPTruncate or IPHash. If empty, IPFull is used.
+	IP string
+
+	// HashKey is the key of the HMAC of the hashed IPs.
+	HashKey []byte
+}
+
+// matches returns true if the policy applies to the given domain.
+func (p *HeaderPolicy) matches(domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, pattern := range p.Domains {
+		if glob.Star(strings.ToLower(pattern), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// findHeaderPolicy returns the first of the given policies that applies to
+// the given domain, or nil if none does.
+func findHeaderPolicy(policies []HeaderPolicy, domain string) *HeaderPolicy {
+	for i := range policies {
+		if policies[i].matches(domain) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// TruncateIP returns the network of the given IP, with TruncateIPv4Bits or
+// TruncateIPv6Bits, in CIDR notation.
+func TruncateIP(ip netip.Addr) string {
+	ip = ip.Unmap()
+	bits := TruncateIPv6Bits
+	if ip.Is4() {
+		bits = TruncateIPv4Bits
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// HashIP returns the hex-encoded HMAC-SHA256 of the given IP using the given
+// key. Unlike a plain hash, it can't be reversed by hashing all the IPv4
+// addresses without the key.
+func HashIP(key []byte, ip netip.Addr) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(ip.Unmap().String())) // #nosec G104
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// resolutionHeaders returns the resolution headers of an authorized request
+// from the given IP, with the given resolution and matching rule. The headers
+// are selected by the given policy, or are the default ones if it's nil. The
+// headers of unknown values are omitted.
+func resolutionHeaders(
+	resolved *ipres.Resolution,
+	rule int,
+	ip netip.Addr,
+	policy *HeaderPolicy,
+) http.Header {
+	names := defaultHeaderNames
+	if policy != nil {
+		names = policy.Headers
+	}
+
+	headers := make(http.Header)
+	if resolved.CountryCode != "" &&
+		slices.Contains(names, HeaderNameCountry) {
+		headers.Set(HeaderCountry, resolved.CountryCode)
+	}
+	if resolved.ASN != ipres.AS0 && slices.Contains(names, HeaderNameASN) {
+		headers.Set(HeaderASN, strconv.FormatUint(uint64(resolved.ASN), 10))
+	}
+	if resolved.Organization != "" && slices.Contains(names, HeaderNameOrg) {
+		headers.Set(HeaderOrg, resolved.Organization)
+	}
+	if label := ruleLabel(rule); label != "" &&
+		slices.Contains(names, HeaderNameRule) {
+		headers.Set(HeaderRule, label)
+	}
+	if policy != nil && slices.Contains(names, HeaderNameIP) {
+		switch policy.IP {
+		case IPTruncate:
+			headers.Set(HeaderIP, TruncateIP(ip))
+		case IPHash:
+			headers.Set(HeaderIP, HashIP(policy.HashKey, ip))
+		default:
+			headers.Set(HeaderIP, ip.Unmap().String())
+		}
+	}
+	return headers
+}
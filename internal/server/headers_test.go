@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+const hashKey = "0123456789abcdef0123456789abcdef"
+
+func TestForwardAuthHeaderPolicies(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{
+			HeaderPolicies: []server.HeaderPolicy{
+				{
+					Domains: []string{"private.example.com"},
+				},
+				{
+					Domains: []string{"*.truncate.example.com"},
+					Headers: []string{server.HeaderNameIP},
+					IP:      server.IPTruncate,
+				},
+				{
+					Domains: []string{"hash.example.com"},
+					Headers: []string{
+						server.HeaderNameCountry, server.HeaderNameIP,
+					},
+					IP:      server.IPHash,
+					HashKey: []byte(hashKey),
+				},
+				{
+					Domains: []string{"full.example.com"},
+					Headers: []string{server.HeaderNameIP},
+				},
+			},
+		},
+	).Handler
+
+	tests := []struct {
+		domain  string
+		country string
+		ip      string
+	}{
+		{"example.com", "FR", ""},
+		{"private.example.com", "", ""},
+		{"app.truncate.example.com", "", "1.0.0.0/24"},
+		{
+			"hash.example.com", "FR",
+			server.HashIP([]byte(hashKey), netip.MustParseAddr("1.0.0.1")),
+		},
+		{"FULL.example.com", "", "1.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, tt.domain)
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusNoContent {
+				t.Fatalf("got status %d, want %d", recorder.Code,
+					http.StatusNoContent)
+			}
+
+			headers := recorder.Header()
+			if got := headers.Get(server.HeaderCountry); got != tt.country {
+				t.Errorf("got country %q, want %q", got, tt.country)
+			}
+			if got := headers.Get(server.HeaderIP); got != tt.ip {
+				t.Errorf("got IP %q, want %q", got, tt.ip)
+			}
+		})
+	}
+}
+
+func TestTruncateIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.10", "203.0.113.0/24"},
+		{"::ffff:203.0.113.10", "203.0.113.0/24"},
+		{"2001:db8:1:2::10", "2001:db8:1::/48"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got := server.TruncateIP(netip.MustParseAddr(tt.ip))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	var (
+		ip     = netip.MustParseAddr("203.0.113.10")
+		mapped = netip.MustParseAddr("::ffff:203.0.113.10")
+		other  = netip.MustParseAddr("203.0.113.11")
+	)
+
+	hash := server.HashIP([]byte(hashKey), ip)
+	if len(hash) != 64 {
+		t.Errorf("got hash %q, want 64 hex digits", hash)
+	}
+	if got := server.HashIP([]byte(hashKey), mapped); got != hash {
+		t.Errorf("got hash %q for mapped IP, want %q", got, hash)
+	}
+	if got := server.HashIP([]byte(hashKey), other); got == hash {
+		t.Errorf("got the same hash %q for another IP", got)
+	}
+	if got := server.HashIP([]byte("other-key"), ip); got == hash {
+		t.Errorf("got the same hash %q with another key", got)
+	}
+}
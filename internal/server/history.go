@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/history"
+)
+
+// historyResponse is the response of the history endpoint.
+type historyResponse struct {
+	By     string          `json:"by"`
+	Step   string          `json:"step"`
+	Points []history.Point `json:"points"`
+}
+
+// parseHistoryDuration parses the given query parameter as a positive
+// duration, or returns the given default value if it's empty.
+func parseHistoryDuration(
+	value string,
+	defaultValue time.Duration,
+) (time.Duration, bool) {
+	if value == "" {
+		return defaultValue, true
+	}
+	duration, err := config.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, false
+	}
+	return duration, true
+}
+
+// getHistory returns the number of allowed and denied requests per source
+// country or ASN, selected by the "by" query parameter, for each step of the
+// given period. The steps are whole numbers of hours, so that the hourly
+// counts are summed into coarser points.
+func getHistory(
+	writer http.ResponseWriter,
+	request *http.Request,
+	store *history.Store,
+) {
+	params := request.URL.Query()
+	by := params.Get("by")
+	if by == "" {
+		by = history.DimensionCountry
+	}
+	step, stepOK := parseHistoryDuration(params.Get("step"), time.Hour)
+	period, periodOK := parseHistoryDuration(
+		params.Get("period"), store.Retention(),
+	)
+	if !stepOK || !periodOK || step < history.BucketSize ||
+		(by != history.DimensionCountry && by != history.DimensionASN) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	step = step.Truncate(history.BucketSize)
+	writeJSON(writer, http.StatusOK, historyResponse{
+		By:     by,
+		Step:   step.String(),
+		Points: store.Query(by, step, period, time.Now()),
+	})
+}
@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/history"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestHistory(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{Countries: []string{"FR"}, Policy: config.PolicyAllow},
+		},
+	})
+	store := history.NewStore(history.Options{})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{History: store},
+	).Handler
+
+	for _, ip := range []string{"1.0.0.1", "1.0.0.2", "2.0.0.1"} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+		request.Header.Set(server.HeaderXForwardedFor, ip)
+		request.Header.Set(server.HeaderXForwardedHost, "example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	recorder := httptest.NewRecorder()
+	newAdminHandler(engine, server.AdminOptions{History: store}).ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/v1/stats/history?step=1d", nil),
+	)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var response struct {
+		By     string          `json:"by"`
+		Step   string          `json:"step"`
+		Points []history.Point `json:"points"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.By != history.DimensionCountry || response.Step != "24h0m0s" {
+		t.Errorf("got by %q and step %q, want country and 24h0m0s",
+			response.By, response.Step)
+	}
+	if len(response.Points) != 1 {
+		t.Fatalf("got %d points, want 1", len(response.Points))
+	}
+	counts := response.Points[0].Counts
+	if counts["FR"] != (history.Counts{Allowed: 2}) ||
+		counts["US"] != (history.Counts{Denied: 1}) {
+		t.Errorf("got counts %v, want 2 allowed FR and 1 denied US", counts)
+	}
+}
+
+func TestHistoryInvalid(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	handler := newAdminHandler(engine, server.AdminOptions{
+		History: history.NewStore(history.Options{}),
+	})
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"?by=asn&step=6h&period=2d", http.StatusOK},
+		{"?by=domain", http.StatusBadRequest},
+		{"?step=30m", http.StatusBadRequest},
+		{"?step=invalid", http.StatusBadRequest},
+		{"?period=-1h", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(
+				http.MethodGet, "/v1/stats/history"+tt.query, nil,
+			))
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+
+	handler = newAdminHandler(engine, server.AdminOptions{})
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(
+		http.MethodGet, "/v1/stats/history", nil,
+	))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d without history, want %d",
+			recorder.Code, http.StatusNotFound)
+	}
+}
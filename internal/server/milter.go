@@ -0,0 +1,301 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// MilterService is the service name of the queries made by the milter
+// server. It can be matched with the `services` rule condition.
+const MilterService = "smtp"
+
+// Milter commands sent by the MTA.
+const (
+	milterAbort   = 'A'
+	milterConnect = 'C'
+	milterMacro   = 'D'
+	milterHelo    = 'H'
+	milterOptNeg  = 'O'
+	milterQuit    = 'Q'
+	milterQuitNC  = 'K'
+)
+
+// Milter responses sent to the MTA.
+const (
+	milterAccept   = 'a'
+	milterContinue = 'c'
+	milterReject   = 'r'
+)
+
+// Milter protocol flags telling the MTA which steps can be skipped. Only the
+// connect and HELO steps are needed to evaluate the rules.
+const (
+	milterNoMail    = 0x004
+	milterNoRcpt    = 0x008
+	milterNoBody    = 0x010
+	milterNoHeaders = 0x020
+	milterNoEOH     = 0x040
+	milterNoUnknown = 0x100
+	milterNoData    = 0x200
+
+	milterSkipped = milterNoMail | milterNoRcpt | milterNoBody |
+		milterNoHeaders | milterNoEOH | milterNoUnknown | milterNoData
+)
+
+const (
+	// milterVersion is the version of the milter protocol used by the server.
+	milterVersion = 6
+
+	// milterMaxPacket is the maximum size of a milter packet.
+	milterMaxPacket = 1 << 20
+
+	// milterIdleTimeout is the time after which idle milter connections are
+	// closed. It's longer than checkIdleTimeout since the MTA keeps the
+	// connection open during the whole SMTP session.
+	milterIdleTimeout = 10 * time.Minute
+)
+
+var (
+	errMilterPacketSize = errors.New("invalid milter packet size")
+	errMilterOptNeg     = errors.New("invalid milter option negotiation")
+)
+
+// MilterServer is a milter (Sendmail and Postfix mail filter) server that
+// evaluates the rules for the clients connecting to the MTA. The HELO domain
+// is used as the requested domain and the service is MilterService.
+//
+// Allowed clients are accepted without further checks and denied clients are
+// rejected. Clients connected through a UNIX socket have no IP address and
+// are always accepted.
+//
+// The decisions are recorded like the ones of the HTTP requests, see
+// recordDecision, and the clients are rejected while the circuit breaker of
+// their HELO domain is open.
+type MilterServer struct {
+	Addr     string
+	engine   *rules.Engine
+	resolver *ipres.Resolver
+	options  Options
+}
+
+// NewMilterServer creates a new milter server that listens on the given
+// address. Of the options, only the audit log, the webhooks, the history and
+// the circuit breaker are used.
+func NewMilterServer(
+	address string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) *MilterServer {
+	return &MilterServer{
+		Addr:     address,
+		engine:   engine,
+		resolver: resolver,
+		options:  options,
+	}
+}
+
+// ListenAndServe listens on the server's address and handles the incoming
+// connections. It only returns on error.
+func (s *MilterServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve handles the incoming connections of the given listener. It closes the
+// listener and only returns on error.
+func (s *MilterServer) Serve(listener net.Listener) error {
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// milterSession is the state of a milter connection.
+type milterSession struct {
+	conn net.Conn
+	ip   netip.Addr
+}
+
+// serve handles the commands of the given connection until it's closed, idle
+// for too long or an error occurs.
+func (s *MilterServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		reader  = bufio.NewReader(conn)
+		session = &milterSession{conn: conn}
+	)
+	for {
+		deadline := time.Now().Add(milterIdleTimeout)
+		if err := conn.SetDeadline(deadline); err != nil {
+			return
+		}
+
+		command, data, err := readMilterPacket(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.WithError(err).Debug("Milter connection closed")
+			}
+			return
+		}
+		if command == milterQuit {
+			return
+		}
+		if err := s.handle(session, command, data); err != nil {
+			log.WithError(err).Debug("Milter connection closed")
+			return
+		}
+	}
+}
+
+// handle processes a milter command and sends its response, if any.
+func (s *MilterServer) handle(
+	session *milterSession,
+	command byte,
+	data []byte,
+) error {
+	switch command {
+	case milterOptNeg:
+		if len(data) < 12 {
+			return errMilterOptNeg
+		}
+		protocol := binary.BigEndian.Uint32(data[8:12])
+		response := binary.BigEndian.AppendUint32(nil, milterVersion)
+		response = binary.BigEndian.AppendUint32(response, 0)
+		response = binary.BigEndian.AppendUint32(
+			response, protocol&milterSkipped,
+		)
+		return writeMilterPacket(session.conn, milterOptNeg, response)
+	case milterMacro, milterAbort:
+		return nil
+	case milterQuitNC:
+		session.ip = netip.Addr{}
+		return nil
+	case milterConnect:
+		session.ip = parseMilterConnect(data)
+		return writeMilterPacket(session.conn, milterContinue, nil)
+	case milterHelo:
+		helo, _, _ := bytes.Cut(data, []byte{0})
+		return writeMilterPacket(
+			session.conn, s.check(session.ip, string(helo)), nil,
+		)
+	default:
+		return writeMilterPacket(session.conn, milterContinue, nil)
+	}
+}
+
+// check evaluates the rules for a client with the given IP address and HELO
+// domain, and returns the milter response.
+func (s *MilterServer) check(ip netip.Addr, helo string) byte {
+	if !ip.IsValid() {
+		return milterAccept
+	}
+
+	pattern, matched := s.engine.DomainPattern(helo)
+	if breakerOpen(
+		s.options.Breaker, pattern, matched,
+		metrics.RequestLabels{Domain: pattern},
+	) {
+		return milterReject
+	}
+
+	resolved := s.resolver.Resolve(ip)
+	query := &rules.Query{
+		Service:           MilterService,
+		RequestedDomain:   helo,
+		SourceIP:          ip,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+	}
+
+	logFields := log.Fields{
+		FieldService:       MilterService,
+		FieldRequestDomain: helo,
+		FieldSourceIP:      ip,
+		FieldSourceCountry: resolved.CountryCode,
+		FieldSourceASN:     resolved.ASN,
+		FieldSourceOrg:     resolved.Organization,
+	}
+
+	decision := s.engine.Decide(query)
+	recordDecision(&s.options, query, &decision, pattern, matched, logFields)
+	if decision.Allowed {
+		log.WithFields(logFields).Info("Mail client authorized")
+		return milterAccept
+	}
+	log.WithFields(logFields).Warn("Mail client denied")
+	return milterReject
+}
+
+// parseMilterConnect returns the IP address of the connect command data. It
+// returns an invalid address if the client isn't connected over IPv4 or IPv6.
+func parseMilterConnect(data []byte) netip.Addr {
+	// The data is: hostname, NUL, family, port (2 bytes), address, NUL.
+	_, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok || len(rest) < 3 || (rest[0] != '4' && rest[0] != '6') {
+		return netip.Addr{}
+	}
+
+	address, _, _ := bytes.Cut(rest[3:], []byte{0})
+	ip, err := netip.ParseAddr(strings.TrimPrefix(string(address), "IPv6:"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// readMilterPacket reads a milter packet and returns its command and data.
+func readMilterPacket(reader io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > milterMaxPacket {
+		return 0, nil, errMilterPacketSize
+	}
+
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(reader, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// writeMilterPacket writes a milter packet with the given command and data.
+func writeMilterPacket(writer io.Writer, command byte, data []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(data)+1))
+	packet = append(packet, command)
+	packet = append(packet, data...)
+	_, err := writer.Write(packet)
+	return err
+}
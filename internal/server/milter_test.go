@@ -0,0 +1,154 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+// milterClient sends milter commands the way an MTA does.
+type milterClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (c *milterClient) send(command byte, data ...byte) {
+	c.t.Helper()
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(data)+1))
+	packet = append(packet, command)
+	packet = append(packet, data...)
+	if _, err := c.conn.Write(packet); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *milterClient) receive() (byte, []byte) {
+	c.t.Helper()
+	var header [4]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		c.t.Fatal(err)
+	}
+	return packet[0], packet[1:]
+}
+
+// connect sends a connect command for the given family and address.
+func (c *milterClient) connect(family byte, address string) {
+	c.t.Helper()
+	data := append([]byte("mail.example.com\x00"), family, 0, 25)
+	c.send('C', append(append(data, address...), 0)...)
+	if command, _ := c.receive(); command != 'c' {
+		c.t.Fatalf("connect: got %q, want 'c'", command)
+	}
+}
+
+// helo sends a HELO command and returns the response.
+func (c *milterClient) helo(domain string) byte {
+	c.t.Helper()
+	c.send('H', append([]byte(domain), 0)...)
+	command, _ := c.receive()
+	return command
+}
+
+func newMilterClient(t *testing.T) *milterClient {
+	t.Helper()
+
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Services:  []string{"smtp"},
+				Domains:   []string{"*.example.org"},
+				Countries: []string{"US"},
+				Policy:    config.PolicyAllow,
+			},
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	milter := server.NewMilterServer(
+		"", engine, newTestResolver(t), server.Options{},
+	)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go milter.Serve(listener) // #nosec G104
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := &milterClient{t: t, conn: conn}
+	client.send('O',
+		0, 0, 0, 6, // Version
+		0, 0, 0x01, 0xff, // Actions
+		0, 0, 0x03, 0xff, // Protocol
+	)
+	command, data := client.receive()
+	if command != 'O' || len(data) != 12 {
+		t.Fatalf("got %q with %d bytes, want 'O' with 12", command, len(data))
+	}
+	if protocol := binary.BigEndian.Uint32(data[8:]); protocol != 0x37c {
+		t.Fatalf("got protocol %#x, want %#x", protocol, 0x37c)
+	}
+	return client
+}
+
+func TestMilterServer(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  byte
+		address string
+		helo    string
+		want    byte
+	}{
+		{"allowed country", '4', "1.0.0.1", "mail.example.com", 'a'},
+		{"allowed HELO domain", '4', "2.0.0.1", "MX.example.org", 'a'},
+		{"denied HELO domain", '4', "2.0.0.1", "mail.example.com", 'r'},
+		{"denied country", '4', "3.0.0.1", "mx.example.org", 'r'},
+		{"mapped IPv6", '6', "IPv6:::ffff:1.0.0.1", "mail.example.com", 'a'},
+		{"UNIX socket", 'L', "/run/smtp.sock", "localhost", 'a'},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMilterClient(t)
+			client.send('D', 'C', 'j', 0, 'm', 'x', 0)
+			client.connect(tt.family, tt.address)
+			if got := client.helo(tt.helo); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMilterServerReuse(t *testing.T) {
+	client := newMilterClient(t)
+
+	client.connect('4', "3.0.0.1")
+	if got := client.helo("mx.example.org"); got != 'r' {
+		t.Errorf("got %q, want 'r'", got)
+	}
+
+	// The MTA can reuse the connection for the next SMTP session.
+	client.send('K')
+	client.connect('4', "1.0.0.1")
+	if got := client.helo("mx.example.org"); got != 'a' {
+		t.Errorf("got %q, want 'a'", got)
+	}
+}
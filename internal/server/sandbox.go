@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/rules"
+)
+
+// sandboxRequest is the body of a sandbox request: a candidate configuration
+// file, in YAML, and the queries to evaluate against it.
+type sandboxRequest struct {
+	Config  string           `json:"config"`
+	Queries []authorizeQuery `json:"queries"`
+}
+
+// liveDecision is the decision of a query with the current configuration.
+type liveDecision struct {
+	Allowed  bool   `json:"allowed"`
+	Rule     *int   `json:"rule,omitempty"`
+	RuleName string `json:"rule_name,omitempty"`
+}
+
+// sandboxDecision is the decision of a query with the candidate
+// configuration, compared with its decision with the current configuration.
+type sandboxDecision struct {
+	authorizeDecision
+	Changed bool          `json:"changed"`
+	Live    *liveDecision `json:"live,omitempty"`
+}
+
+// sandboxResponse is the response of the sandbox endpoint.
+type sandboxResponse struct {
+	Decisions []sandboxDecision `json:"decisions"`
+}
+
+// sandboxError is an error of an invalid candidate configuration.
+type sandboxError struct {
+	Field   string `json:"field,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// sandboxErrorsResponse is the response of the sandbox endpoint for an
+// invalid candidate configuration.
+type sandboxErrorsResponse struct {
+	Errors []sandboxError `json:"errors"`
+}
+
+// newSandboxErrors converts the given error reading a configuration to the
+// errors of a sandbox response.
+func newSandboxErrors(err error) []sandboxError {
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		return []sandboxError{{Message: err.Error()}}
+	}
+	result := make([]sandboxError, 0, len(errs))
+	for _, err := range errs {
+		result = append(result, sandboxError{
+			Field:   err.Field,
+			Line:    err.Line,
+			Message: err.Message,
+		})
+	}
+	return result
+}
+
+// postSandbox evaluates the queries given in the request body against the
+// candidate configuration of the body, without replacing the current one,
+// and returns their decisions, in the same order. Like the bulk
+// authorization, the queries don't consume the rate limits and aren't logged
+// nor counted.
+func postSandbox(
+	writer http.ResponseWriter,
+	request *http.Request,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+) {
+	var body sandboxRequest
+	reader := http.MaxBytesReader(writer, request.Body, maxAuthorizeBody)
+	if err := json.NewDecoder(reader).Decode(&body); err != nil ||
+		len(body.Queries) > maxAuthorizeQueries {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := config.ReadConfig(strings.NewReader(body.Config))
+	if err != nil {
+		response := sandboxErrorsResponse{Errors: newSandboxErrors(err)}
+		writeJSON(writer, http.StatusUnprocessableEntity, response)
+		return
+	}
+	sandbox := engine.Sandbox(&cfg.AccessControl)
+
+	response := sandboxResponse{
+		Decisions: make([]sandboxDecision, 0, len(body.Queries)),
+	}
+	for _, query := range body.Queries {
+		decision := sandboxDecision{
+			authorizeDecision: authorize(query, sandbox, resolver, false),
+		}
+		if decision.Error == "" {
+			live := authorize(query, engine, resolver, false)
+			decision.Changed = live.Allowed != decision.Allowed
+			decision.Live = &liveDecision{
+				Allowed:  live.Allowed,
+				Rule:     live.Rule,
+				RuleName: live.RuleName,
+			}
+		}
+		response.Decisions = append(response.Decisions, decision)
+	}
+	writeJSON(writer, http.StatusOK, response)
+}
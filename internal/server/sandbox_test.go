@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestSandbox(t *testing.T) {
+	engine, handler := newTestAdmin(t, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	body := `{
+		"config": "access_control:\n  default_policy: deny\n  rules:\n` +
+		`    - name: france\n      countries: [FR]\n      policy: allow\n",
+		"queries": [
+			{"ip": "1.0.0.1", "domain": "example.com", "method": "GET"},
+			{"ip": "2.0.0.1", "domain": "example.com", "method": "GET"},
+			{"ip": "invalid", "domain": "example.com", "method": "GET"}
+		]
+	}`
+	recorder := serveAdmin(handler, http.MethodPost, "/v1/sandbox", body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Decisions []struct {
+			IP       string `json:"ip"`
+			Allowed  bool   `json:"allowed"`
+			Rule     *int   `json:"rule"`
+			RuleName string `json:"rule_name"`
+			Changed  bool   `json:"changed"`
+			Live     *struct {
+				Allowed bool `json:"allowed"`
+				Rule    *int `json:"rule"`
+			} `json:"live"`
+			Error string `json:"error"`
+		} `json:"decisions"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Decisions) != 3 {
+		t.Fatalf("got %d decisions, want 3", len(response.Decisions))
+	}
+
+	allowed := response.Decisions[0]
+	if !allowed.Allowed || allowed.Rule == nil || *allowed.Rule != 0 ||
+		allowed.RuleName != "france" || allowed.Changed ||
+		allowed.Live == nil || !allowed.Live.Allowed {
+		t.Errorf("got %+v, want allowed by rule 0, unchanged", allowed)
+	}
+	denied := response.Decisions[1]
+	if denied.Allowed || denied.Rule != nil || !denied.Changed ||
+		denied.Live == nil || !denied.Live.Allowed {
+		t.Errorf("got %+v, want denied by the default policy, changed", denied)
+	}
+	if invalid := response.Decisions[2]; invalid.Error == "" ||
+		invalid.Live != nil {
+		t.Errorf("got %+v, want an error", invalid)
+	}
+
+	// The current configuration isn't replaced.
+	if current, staged := engine.Config(); current.DefaultPolicy !=
+		config.PolicyAllow || staged != nil {
+		t.Errorf("got %v and %v, want the initial configuration", current,
+			staged)
+	}
+}
+
+func TestSandboxInvalid(t *testing.T) {
+	_, handler := newTestAdmin(t, server.AdminOptions{
+		Resolver: newTestResolver(t),
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"invalid body", "invalid", http.StatusBadRequest, ""},
+		{
+			"invalid config",
+			`{"config": "access_control:\n  default_policy: maybe\n"}`,
+			http.StatusUnprocessableEntity,
+			"access_control.default_policy",
+		},
+		{
+			"invalid YAML",
+			`{"config": "access_control: ["}`,
+			http.StatusUnprocessableEntity,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveAdmin(
+				handler, http.MethodPost, "/v1/sandbox", tt.body,
+			)
+			if recorder.Code != tt.status {
+				t.Fatalf("got status %d, want %d", recorder.Code, tt.status)
+			}
+			if tt.status != http.StatusUnprocessableEntity {
+				return
+			}
+
+			var response struct {
+				Errors []struct {
+					Field   string `json:"field"`
+					Line    int    `json:"line"`
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			err := json.NewDecoder(recorder.Body).Decode(&response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Errors) != 1 {
+				t.Fatalf("got errors %+v, want 1", response.Errors)
+			}
+			got := response.Errors[0]
+			if got.Field != tt.field || got.Message == "" {
+				t.Errorf("got error %+v, want field %q", got, tt.field)
+			}
+			if tt.field != "" && got.Line != 2 {
+				t.Errorf("got line %d, want 2", got.Line)
+			}
+		})
+	}
+
+	_, handler = newTestAdmin(t, server.AdminOptions{})
+	recorder := serveAdmin(handler, http.MethodPost, "/v1/sandbox", "{}")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("got status %d without resolver, want %d",
+			recorder.Code, http.StatusNotFound)
+	}
+}
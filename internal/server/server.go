@@ -2,16 +2,30 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/danroc/geoblock/internal/audit"
+	"github.com/danroc/geoblock/internal/firstseen"
+	"github.com/danroc/geoblock/internal/health"
+	"github.com/danroc/geoblock/internal/history"
 	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/notify"
 	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/utils/urlpath"
+	"github.com/danroc/geoblock/internal/webhook"
 )
 
 // HTTP headers used by reverse proxies to identify the original request.
@@ -23,14 +37,60 @@ const (
 	HeaderXForwardedFor    = "X-Forwarded-For"
 )
 
+// HTTP headers telling proxies for how long they may cache a decision.
+const (
+	HeaderCacheControl = "Cache-Control"
+	HeaderCacheTTL     = "X-Geoblock-Cache-TTL"
+)
+
+// HTTP headers carrying the resolution of the source IP of the authorized
+// requests and the index of the matching rule, so that proxies can forward
+// them to the upstream applications.
+const (
+	HeaderCountry = "X-Geoblock-Country"
+	HeaderASN     = "X-Geoblock-ASN"
+	HeaderOrg     = "X-Geoblock-Org"
+	HeaderRule    = "X-Geoblock-Rule"
+	HeaderIP      = "X-Geoblock-IP"
+)
+
+// HeaderConfigGeneration is the HTTP header of the responses to the decided
+// requests with the generation of the configuration that decided them.
+const HeaderConfigGeneration = "X-Geoblock-Config-Generation"
+
+// resolutionHeaderNames are the names of the resolution headers.
+var resolutionHeaderNames = []string{
+	HeaderCountry,
+	HeaderASN,
+	HeaderOrg,
+	HeaderRule,
+	HeaderIP,
+}
+
+// HTTP headers of CORS preflight requests, forwarded by the reverse proxies
+// along with the original request headers.
+const (
+	HeaderOrigin                     = "Origin"
+	HeaderAccessControlRequestMethod = "Access-Control-Request-Method"
+)
+
 // Fields used in the log messages.
 const (
-	FieldRequestDomain = "request_domain"
-	FieldRequestMethod = "request_method"
-	FieldSourceIP      = "source_ip"
-	FieldSourceCountry = "source_country"
-	FieldSourceASN     = "source_asn"
-	FieldSourceOrg     = "source_org"
+	FieldRequestDomain     = "request_domain"
+	FieldRequestMethod     = "request_method"
+	FieldRequestPath       = "request_path"
+	FieldSourceIP          = rules.FieldSourceIP
+	FieldSourceCountry     = "source_country"
+	FieldSourceRegion      = "source_region"
+	FieldSourceCity        = "source_city"
+	FieldSourceASN         = "source_asn"
+	FieldSourceOrg         = "source_org"
+	FieldSourceCDN         = "source_cdn"
+	FieldSourceMonitor     = "source_monitor"
+	FieldSourceAnonymizers = "source_anonymizers"
+	FieldContext           = "context"
+	FieldRule              = "rule"
+	FieldRuleName          = "rule_name"
 )
 
 // Metrics contains the metric values of the server.
@@ -45,7 +105,154 @@ func (m *Metrics) Total() uint64 {
 	return m.Denied.Load() + m.Allowed.Load() + m.Invalid.Load()
 }
 
-var metrics = Metrics{}
+var counters = Metrics{}
+
+// forwardedFor returns the addresses of the X-Forwarded-For chain, from the
+// client to the nearest proxy. The chain may be split over multiple header
+// values. Empty entries are ignored.
+func forwardedFor(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	return chain
+}
+
+// RequestPath returns the cleaned path of the given request URI, without its
+// query, so that paths such as `/public/../admin` can't bypass the paths
+// conditions of the rules. The trailing slash is kept. It returns an empty
+// string if the URI is empty.
+func RequestPath(uri string) string {
+	if uri == "" {
+		return ""
+	}
+
+	// Invalid URIs are still matched, so that they can't bypass the rules.
+	raw, _, _ := strings.Cut(uri, "?")
+	if parsed, err := url.ParseRequestURI(uri); err == nil {
+		raw = parsed.Path
+	}
+
+	return urlpath.Clean("/" + raw)
+}
+
+// RequestProtocol returns the protocol of the request, in lowercase, from the
+// given X-Forwarded-Proto header values. If the request went through several
+// proxies, or if the client sent the header, the header may list several
+// protocols, and the last one, set by the proxy in front of Geoblock, is
+// returned, since the others can be spoofed by the client. It returns an
+// empty string if the header is missing.
+func RequestProtocol(values []string) string {
+	for i := len(values) - 1; i >= 0; i-- {
+		protocols := strings.Split(values[i], ",")
+		for j := len(protocols) - 1; j >= 0; j-- {
+			if protocol := strings.TrimSpace(protocols[j]); protocol != "" {
+				return strings.ToLower(protocol)
+			}
+		}
+	}
+	return ""
+}
+
+// ruleLabel returns the index of the given rule as a string, or an empty
+// string if no rule matched.
+func ruleLabel(rule int) string {
+	if rule == rules.NoRule {
+		return ""
+	}
+	return strconv.Itoa(rule)
+}
+
+// requestLabels returns the metric labels of the given query, decided by the
+// given decision. The domain label is the configured domain pattern matching
+// the requested domain, or OtherLabel if none does, so that clients can't add
+// series by sending arbitrary hosts. The rule label is the name of the
+// matching rule, or its index if it has no name.
+func requestLabels(
+	query *rules.Query,
+	decision *rules.Decision,
+	pattern string,
+	matched bool,
+) metrics.RequestLabels {
+	rule := decision.RuleName
+	if rule == "" {
+		rule = ruleLabel(decision.Rule)
+	}
+	domain := pattern
+	if !matched && query.RequestedDomain != "" {
+		domain = metrics.OtherLabel
+	}
+	return metrics.RequestLabels{
+		Country: query.SourceCountry,
+		Domain:  domain,
+		Method:  query.RequestedMethod,
+		Rule:    rule,
+	}
+}
+
+// addRuleFields adds the index and name of the matching rule of the given
+// decision to the given log fields, if a rule matched.
+func addRuleFields(logFields log.Fields, decision *rules.Decision) {
+	if decision.Rule == rules.NoRule {
+		return
+	}
+	logFields[FieldRule] = decision.Rule
+	if decision.RuleName != "" {
+		logFields[FieldRuleName] = decision.RuleName
+	}
+}
+
+// setDecisionTTL sets the headers telling proxies for how long they may cache
+// the decision. A zero TTL forbids caching.
+func setDecisionTTL(writer http.ResponseWriter, ttl time.Duration) {
+	seconds := int64(ttl.Seconds())
+	if seconds <= 0 {
+		writer.Header().Set(HeaderCacheControl, "no-store")
+		writer.Header().Set(HeaderCacheTTL, "0")
+		return
+	}
+	writer.Header().Set(
+		HeaderCacheControl,
+		"private, max-age="+strconv.FormatInt(seconds, 10),
+	)
+	writer.Header().Set(HeaderCacheTTL, strconv.FormatInt(seconds, 10))
+}
+
+// ErrInvalidClientIP is returned when the client's IP can't be read from the
+// X-Forwarded-For chain.
+var ErrInvalidClientIP = errors.New("invalid client IP")
+
+// ClientIP returns the client's IP address from the given X-Forwarded-For
+// chain. The chain is walked from right to left, skipping the addresses of
+// trusted proxies. If all the addresses are trusted, the leftmost address is
+// returned. IPv4-mapped IPv6 addresses are returned as IPv4 addresses.
+//
+// ErrInvalidClientIP is returned if the chain is empty or if one of the
+// walked addresses is invalid.
+func ClientIP(chain []string, trusted []netip.Prefix) (netip.Addr, error) {
+	if len(chain) == 0 {
+		return netip.Addr{}, ErrInvalidClientIP
+	}
+
+	var ip netip.Addr
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		if ip, err = netip.ParseAddr(chain[i]); err != nil {
+			return netip.Addr{}, fmt.Errorf("%w: %w", ErrInvalidClientIP, err)
+		}
+		ip = ip.Unmap()
+		if !slices.ContainsFunc(trusted, func(p netip.Prefix) bool {
+			return p.Contains(ip)
+		}) {
+			break
+		}
+	}
+	return ip, nil
+}
 
 // getForwardAuth checks if the request is authorized to access the requested
 // resource. It uses the reverse proxy headers to determine the source IP and
@@ -55,11 +262,13 @@ func getForwardAuth(
 	request *http.Request,
 	resolver *ipres.Resolver,
 	engine *rules.Engine,
+	options *Options,
 ) {
 	var (
 		origin = request.Header.Get(HeaderXForwardedFor)
 		domain = request.Header.Get(HeaderXForwardedHost)
 		method = request.Header.Get(HeaderXForwardedMethod)
+		uri    = request.Header.Get(HeaderXForwardedURI)
 	)
 
 	// Block the request if one or more of the required headers are missing. It
@@ -71,13 +280,35 @@ func getForwardAuth(
 			FieldSourceIP:      origin,
 		}).Error("Missing required headers")
 		writer.WriteHeader(http.StatusBadRequest)
-		metrics.Invalid.Add(1)
+		counters.Invalid.Add(1)
+		auditInvalid(options.Audit, origin, domain, method)
+		metrics.CountRequest(metrics.ResultInvalid, metrics.RequestLabels{})
 		return
 	}
 
+	// During a flood, the requests of the domain are denied before any
+	// further work.
+	pattern, matched := engine.DomainPattern(domain)
+	if breakerOpen(
+		options.Breaker, pattern, matched,
+		metrics.RequestLabels{Domain: pattern, Method: method},
+	) {
+		setDecisionTTL(writer, 0)
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// The source IP is the first address of the X-Forwarded-For chain, read
+	// from the right, that doesn't belong to a trusted proxy. Addresses on its
+	// left are set by the client and can't be trusted.
+	//
 	// For sanity, we check if the source IP is a valid IP address. If the IP
 	// is invalid, we deny the request regardless of the default policy.
-	sourceIP, err := netip.ParseAddr(origin)
+	chain := forwardedFor(request.Header.Values(HeaderXForwardedFor))
+	if len(chain) == 0 {
+		chain = []string{origin}
+	}
+	sourceIP, err := ClientIP(chain, options.TrustedProxies)
 	if err != nil {
 		log.WithFields(log.Fields{
 			FieldRequestDomain: domain,
@@ -85,18 +316,44 @@ func getForwardAuth(
 			FieldSourceIP:      origin,
 		}).Error("Invalid source IP")
 		writer.WriteHeader(http.StatusBadRequest)
-		metrics.Invalid.Add(1)
+		counters.Invalid.Add(1)
+		auditInvalid(options.Audit, origin, domain, method)
+		metrics.CountRequest(metrics.ResultInvalid, metrics.RequestLabels{})
 		return
 	}
 
 	resolved := resolver.Resolve(sourceIP)
 
+	// A CORS preflight is an OPTIONS request with both an origin and the
+	// method of the actual request.
+	var preflightOrigin string
+	if method == http.MethodOptions &&
+		request.Header.Get(HeaderAccessControlRequestMethod) != "" {
+		preflightOrigin = request.Header.Get(HeaderOrigin)
+	}
+
+	protocol := RequestProtocol(request.Header.Values(HeaderXForwardedProto))
 	query := &rules.Query{
-		RequestedDomain: domain,
-		RequestedMethod: method,
-		SourceIP:        sourceIP,
-		SourceCountry:   resolved.CountryCode,
-		SourceASN:       resolved.ASN,
+		RequestedDomain:   domain,
+		RequestedMethod:   method,
+		RequestedPath:     RequestPath(uri),
+		RequestedProtocol: protocol,
+		SourceIP:          sourceIP,
+		SourceCountry:     resolved.CountryCode,
+		SourceRegion:      resolved.Region,
+		SourceCity:        resolved.City,
+		SourceASN:         resolved.ASN,
+		SourceOrg:         resolved.OrganizationKey,
+		SourceIsCDN:       resolved.IsCDN(),
+		SourceMonitor:     resolved.Monitor,
+		SourceAnonymizers: resolved.Anonymizers.Names(),
+		ForwardedHops:     len(chain),
+		PreflightOrigin:   preflightOrigin,
+	}
+	if options.ContextHeader != "" {
+		query.Context = ParseContext(
+			request.Header.Values(options.ContextHeader),
+		)
 	}
 
 	logFields := log.Fields{
@@ -107,15 +364,73 @@ func getForwardAuth(
 		FieldSourceASN:     resolved.ASN,
 		FieldSourceOrg:     resolved.Organization,
 	}
+	if query.RequestedPath != "" {
+		logFields[FieldRequestPath] = query.RequestedPath
+	}
+	if query.Context != nil {
+		logFields[FieldContext] = query.Context
+	}
+	if resolved.Region != "" {
+		logFields[FieldSourceRegion] = resolved.Region
+	}
+	if resolved.City != "" {
+		logFields[FieldSourceCity] = resolved.City
+	}
+	if resolved.IsCDN() {
+		logFields[FieldSourceCDN] = resolved.CDN
+	}
+	if resolved.Monitor != "" {
+		logFields[FieldSourceMonitor] = resolved.Monitor
+	}
+	if resolved.Anonymizers != 0 {
+		logFields[FieldSourceAnonymizers] = resolved.Anonymizers.String()
+	}
+
+	decision := engine.Decide(query)
+	recordDecision(options, query, &decision, pattern, matched, logFields)
+	writer.Header().Set(
+		HeaderConfigGeneration, strconv.FormatUint(decision.Generation, 10),
+	)
 
-	if engine.Authorize(query) {
+	if decision.Allowed {
 		log.WithFields(logFields).Info("Request authorized")
+		trackCountry(
+			options.FirstSeen, domain, resolved.CountryCode, logFields,
+		)
+		for name, values := range resolutionHeaders(
+			&resolved, decision.Rule, sourceIP,
+			findHeaderPolicy(options.HeaderPolicies, domain),
+		) {
+			writer.Header()[name] = values
+		}
+		if options.Signer != nil {
+			writer.Header().Set(
+				options.Signer.Header(),
+				options.Signer.Sign(sourceIP, DecisionAllow, time.Now()),
+			)
+		}
+		setDecisionTTL(writer, options.DecisionTTL)
 		writer.WriteHeader(http.StatusNoContent)
-		metrics.Allowed.Add(1)
 	} else {
+		// The request ID is only needed to correlate block pages with the
+		// logs.
+		page := &DenyPage{
+			IP:      sourceIP.String(),
+			Country: resolved.CountryCode,
+			Domain:  domain,
+
+			acceptLanguage: request.Header.Get(HeaderAcceptLanguage),
+		}
+		if response := decision.DenyResponse; response != nil &&
+			response.Redirect == "" && hasBody(response) {
+			page.RequestID = requestID(request)
+			logFields[FieldRequestID] = page.RequestID
+		}
+
 		log.WithFields(logFields).Warn("Request denied")
-		writer.WriteHeader(http.StatusForbidden)
-		metrics.Denied.Add(1)
+		setDecisionTTL(writer, 0)
+		delayDenied(request.Context(), decision.DenyResponse)
+		writeDenied(writer, &decision, page)
 	}
 }
 
@@ -124,6 +439,46 @@ func getHealth(writer http.ResponseWriter, _ *http.Request) {
 	writer.WriteHeader(http.StatusNoContent)
 }
 
+// getReady returns a 204 status code if the resolver has country data, or a
+// 503 status code otherwise. Unlike the health check, it lets orchestrators
+// and load balancers avoid instances that can't geolocate requests.
+func getReady(writer http.ResponseWriter, resolver *ipres.Resolver) {
+	if resolver.Ready() != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// statusResponse is the response of the status endpoint.
+type statusResponse struct {
+	Status           string                      `json:"status"`
+	ConfigGeneration uint64                      `json:"config_generation"`
+	ConfigStaged     bool                        `json:"config_staged"`
+	Components       map[string]health.Component `json:"components"`
+}
+
+// getStatus returns the overall health of the server, the state of its
+// components, the generation of the configuration and whether a
+// configuration is staged, so that orchestrators and fleet tooling need a
+// single probe. The status code is 503 if a component has failed.
+func getStatus(writer http.ResponseWriter, engine *rules.Engine) {
+	_, staged := engine.Config()
+	components := health.Components()
+	response := statusResponse{
+		Status:           health.Overall(components),
+		ConfigGeneration: engine.Generation(),
+		ConfigStaged:     staged != nil,
+		Components:       components,
+	}
+
+	status := http.StatusOK
+	if response.Status == health.StateFailed {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(writer, status, response)
+}
+
 // getMetrics returns the metrics in JSON format.
 func getMetrics(writer http.ResponseWriter, _ *http.Request) {
 	writer.Header().Set("Content-Type", "application/json")
@@ -132,10 +487,10 @@ func getMetrics(writer http.ResponseWriter, _ *http.Request) {
 		[]byte(
 			fmt.Sprintf(
 				`{"denied": %d, "allowed": %d, "invalid": %d, "total": %d}`,
-				metrics.Denied.Load(),
-				metrics.Allowed.Load(),
-				metrics.Invalid.Load(),
-				metrics.Total(),
+				counters.Denied.Load(),
+				counters.Allowed.Load(),
+				counters.Invalid.Load(),
+				counters.Total(),
 			),
 		),
 	); err != nil {
@@ -143,31 +498,354 @@ func getMetrics(writer http.ResponseWriter, _ *http.Request) {
 	}
 }
 
-// NewServer creates a new HTTP server that listens on the given address.
-func NewServer(
-	address string,
-	engine *rules.Engine,
+// crossCheckResponse is the cross-check section of the resolve debug response.
+type crossCheckResponse struct {
+	ASNCountry   string         `json:"asn_country"`
+	ASNCountries map[string]int `json:"asn_countries"`
+	Mismatch     bool           `json:"mismatch"`
+}
+
+// resolveResponse is the response of the resolve debug endpoint.
+type resolveResponse struct {
+	IP           string              `json:"ip"`
+	Country      string              `json:"country"`
+	Region       string              `json:"region,omitempty"`
+	City         string              `json:"city,omitempty"`
+	ASN          uint32              `json:"asn"`
+	Organization string              `json:"organization"`
+	OrgKey       string              `json:"organization_key,omitempty"`
+	CDN          string              `json:"cdn,omitempty"`
+	Monitor      string              `json:"monitor,omitempty"`
+	Registry     string              `json:"registry,omitempty"`
+	Anonymizers  []string            `json:"anonymizers,omitempty"`
+	CrossCheck   *crossCheckResponse `json:"cross_check,omitempty"`
+}
+
+// getDebugResolve returns the resolution of the IP given in the `ip` query
+// parameter. If cross-checking is enabled, the response also tells whether
+// the country and ASN databases disagree for this IP.
+func getDebugResolve(
+	writer http.ResponseWriter,
+	request *http.Request,
 	resolver *ipres.Resolver,
-) *http.Server {
-	mux := http.NewServeMux()
-	mux.HandleFunc(
-		"GET /v1/forward-auth",
-		func(writer http.ResponseWriter, request *http.Request) {
-			getForwardAuth(writer, request, resolver, engine)
-		},
+) {
+	ip, err := netip.ParseAddr(request.URL.Query().Get("ip"))
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resolved := resolver.Resolve(ip)
+	response := resolveResponse{
+		IP:           ip.String(),
+		Country:      resolved.CountryCode,
+		Region:       resolved.Region,
+		City:         resolved.City,
+		ASN:          resolved.ASN,
+		Organization: resolved.Organization,
+		OrgKey:       resolved.OrganizationKey,
+		CDN:          resolved.CDN,
+		Monitor:      resolved.Monitor,
+		Registry:     resolved.Registry,
+		Anonymizers:  resolved.Anonymizers.Names(),
+	}
+	if check, ok := resolver.CrossCheck(ip); ok {
+		response.CrossCheck = &crossCheckResponse{
+			ASNCountry:   check.ASNCountry,
+			ASNCountries: check.ASNCountries,
+			Mismatch:     check.Mismatch,
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		log.WithError(err).Error("Cannot write resolve response")
+	}
+}
+
+// Options contains the options of the server.
+type Options struct {
+	// Signer signs the decisions of allowed requests. If nil, decisions aren't
+	// signed.
+	Signer *Signer
+
+	// TrustedProxies are the networks of the proxies whose addresses are
+	// skipped when looking for the client's IP in the X-Forwarded-For chain.
+	TrustedProxies []netip.Prefix
+
+	// ContextHeader is the HTTP header whose key-value pairs, set by the
+	// proxies, are the context of the requests, see ParseContext. If empty,
+	// the requests have no context.
+	ContextHeader string
+
+	// DecisionTTL is the time during which proxies may cache the decisions
+	// of allowed requests. If zero, decisions must not be cached.
+	DecisionTTL time.Duration
+
+	// HeaderPolicies select the resolution headers of the allowed requests,
+	// per domain. The first policy matching the domain applies, and the
+	// domains without policy get the default headers.
+	HeaderPolicies []HeaderPolicy
+
+	// Audit writes the decisions to a dedicated audit log. If nil, decisions
+	// aren't audited.
+	Audit *audit.Logger
+
+	// FirstSeen tracks the countries of the allowed requests to sensitive
+	// domains, to report the first request from each country. If nil,
+	// countries aren't tracked.
+	FirstSeen *firstseen.Tracker
+
+	// Webhooks notifies the webhooks of the matching rules of their
+	// decisions. If nil, webhooks aren't notified.
+	Webhooks *webhook.Notifier
+
+	// History keeps the number of requests per source country and ASN, which
+	// the admin API returns. If nil, requests aren't kept.
+	History *history.Store
+
+	// Breaker denies the requests of the domains receiving a flood of denied
+	// requests, see CircuitBreaker. If nil, requests are always decided.
+	Breaker *CircuitBreaker
+}
+
+// addHistory counts the given decision of the given query in the given
+// history, if any.
+func addHistory(
+	store *history.Store,
+	query *rules.Query,
+	decision *rules.Decision,
+) {
+	if store == nil {
+		return
+	}
+	store.Add(
+		query.SourceCountry, query.SourceASN, decision.Allowed, time.Now(),
+	)
+}
+
+// trackCountry reports the first allowed request from a country to one of the
+// sensitive domains of the given tracker, with the given log fields.
+func trackCountry(
+	tracker *firstseen.Tracker,
+	domain string,
+	country string,
+	logFields log.Fields,
+) {
+	if tracker == nil {
+		return
+	}
+
+	pattern, isNew, err := tracker.Observe(domain, country)
+	if err != nil {
+		log.WithError(err).Error("Cannot save seen countries")
+	}
+	if isNew {
+		metrics.NewCountries.WithLabelValues(pattern).Inc()
+		log.WithFields(logFields).Warn("First request from a new country")
+	}
+}
+
+// recordDecision records the given decision of the given query, whatever the
+// frontend that made it: it's written to the audit log, notified to the
+// webhook of its rule, added to the history, and counted by the request
+// counters and metrics. If the requested domain matched the given domain
+// pattern, it's also counted by the domain counters and the circuit breaker.
+// The rule and the ban of the decision are added to the given log fields.
+func recordDecision(
+	options *Options,
+	query *rules.Query,
+	decision *rules.Decision,
+	pattern string,
+	matched bool,
+	logFields log.Fields,
+) {
+	auditDecision(options.Audit, query, decision)
+	notifyWebhook(options.Webhooks, query, decision)
+	addHistory(options.History, query, decision)
+	addRuleFields(logFields, decision)
+	if decision.Banned {
+		logFields[FieldBanned] = true
+	}
+	if matched {
+		domainCounters.Add(pattern, decision.Allowed, time.Now())
+		countBreaker(options.Breaker, pattern, decision.Allowed)
+	}
+
+	result := metrics.ResultDenied
+	if decision.Allowed {
+		result = metrics.ResultAllowed
+		counters.Allowed.Add(1)
+	} else {
+		counters.Denied.Add(1)
+	}
+	metrics.CountRequest(
+		result, requestLabels(query, decision, pattern, matched),
 	)
+}
+
+// auditDecision writes the given decision of the given query to the audit
+// log, if any.
+func auditDecision(
+	logger *audit.Logger,
+	query *rules.Query,
+	decision *rules.Decision,
+) {
+	if logger == nil {
+		return
+	}
+
+	record := &audit.Record{
+		Time:     time.Now(),
+		IP:       query.SourceIP.String(),
+		Country:  query.SourceCountry,
+		ASN:      query.SourceASN,
+		Service:  query.Service,
+		Domain:   query.RequestedDomain,
+		Method:   query.RequestedMethod,
+		Path:     query.RequestedPath,
+		RuleName: decision.RuleName,
+		Banned:   decision.Banned,
+		Outcome:  audit.OutcomeDeny,
+	}
+	if decision.Rule != rules.NoRule {
+		record.Rule = &decision.Rule
+	}
+	if decision.Allowed {
+		record.Outcome = audit.OutcomeAllow
+	}
+	writeAudit(logger, record)
+}
+
+// notifyWebhook notifies the webhook of the rule of the given decision of the
+// given query, if any.
+func notifyWebhook(
+	notifier *webhook.Notifier,
+	query *rules.Query,
+	decision *rules.Decision,
+) {
+	if notifier == nil || decision.Webhook == nil {
+		return
+	}
+
+	event := &notify.Event{
+		Time:     time.Now(),
+		Outcome:  webhook.OutcomeDeny,
+		Rule:     decision.Rule,
+		RuleName: decision.RuleName,
+		IP:       query.SourceIP.String(),
+		Country:  query.SourceCountry,
+		ASN:      query.SourceASN,
+		Domain:   query.RequestedDomain,
+		Method:   query.RequestedMethod,
+		Path:     query.RequestedPath,
+	}
+	if decision.Allowed {
+		event.Outcome = webhook.OutcomeAllow
+	}
+	notifier.Notify(decision.Webhook, event)
+}
+
+// writeAudit writes the given record to the given audit log and reports the
+// health of the audit log.
+func writeAudit(logger *audit.Logger, record *audit.Record) {
+	if err := logger.Log(record); err != nil {
+		log.WithError(err).Error("Cannot write audit record")
+		health.Report(health.ComponentAudit, health.StateDegraded, err)
+		return
+	}
+	health.Report(health.ComponentAudit, health.StateOK, nil)
+}
+
+// auditInvalid writes an invalid request, from the given source IP for the
+// given domain and method, to the audit log, if any. The values are written
+// as received, even if they're missing or malformed.
+func auditInvalid(logger *audit.Logger, sourceIP, domain, method string) {
+	if logger == nil {
+		return
+	}
+
+	record := &audit.Record{
+		Time:    time.Now(),
+		IP:      sourceIP,
+		Domain:  domain,
+		Method:  method,
+		Outcome: audit.OutcomeInvalid,
+	}
+	writeAudit(logger, record)
+}
+
+// RegisterForwardAuth registers the forward-auth handler on the given mux,
+// under the given path prefix (e.g., "/geoblock"). An empty prefix mounts it
+// at the root.
+func RegisterForwardAuth(
+	mux *http.ServeMux,
+	prefix string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) {
+	prefix = strings.TrimSuffix(prefix, "/")
 	mux.HandleFunc(
-		"GET /v1/health",
+		"GET "+prefix+"/v1/forward-auth",
 		func(writer http.ResponseWriter, request *http.Request) {
-			getHealth(writer, request)
+			getForwardAuth(writer, request, resolver, engine, &options)
 		},
 	)
+}
+
+// RegisterMetrics registers the metrics handlers, both in JSON and in the
+// Prometheus format, on the given mux, under the given path prefix.
+func RegisterMetrics(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.HandleFunc("GET "+prefix+"/v1/metrics", getMetrics)
+	mux.Handle("GET "+prefix+"/metrics", metrics.Handler())
+}
+
+// RegisterHealth registers the health and readiness handlers on the given
+// mux, under the given path prefix.
+func RegisterHealth(
+	mux *http.ServeMux,
+	prefix string,
+	resolver *ipres.Resolver,
+) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.HandleFunc("GET "+prefix+"/v1/health", getHealth)
 	mux.HandleFunc(
-		"GET /v1/metrics",
-		func(writer http.ResponseWriter, request *http.Request) {
-			getMetrics(writer, request)
+		"GET "+prefix+"/v1/ready",
+		func(writer http.ResponseWriter, _ *http.Request) {
+			getReady(writer, resolver)
 		},
 	)
+}
+
+// Register registers the handlers of the server on the given mux, under the
+// given path prefix: forward-auth, metrics, health and readiness. It lets
+// applications embedding geoblock mount it on their own server. The handlers
+// revealing the rules or changing the state of the server are only served by
+// the admin API, see RegisterAdminAPI.
+func Register(
+	mux *http.ServeMux,
+	prefix string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) {
+	RegisterForwardAuth(mux, prefix, engine, resolver, options)
+	RegisterMetrics(mux, prefix)
+	RegisterHealth(mux, prefix, resolver)
+}
+
+// NewServer creates a new HTTP server that listens on the given address.
+func NewServer(
+	address string,
+	engine *rules.Engine,
+	resolver *ipres.Resolver,
+	options Options,
+) *http.Server {
+	mux := http.NewServeMux()
+	Register(mux, "", engine, resolver, options)
 
 	return &http.Server{
 		Addr:         address,
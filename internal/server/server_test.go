@@ -0,0 +1,751 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/audit"
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/firstseen"
+	"github.com/danroc/geoblock/internal/health"
+	"github.com/danroc/geoblock/internal/history"
+	"github.com/danroc/geoblock/internal/ipres"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		name    string
+		chain   []string
+		trusted []netip.Prefix
+		want    string
+		wantErr bool
+	}{
+		{"single address", []string{"1.1.1.1"}, trusted, "1.1.1.1", false},
+		{
+			"no trusted proxies",
+			[]string{"1.1.1.1", "10.0.0.1"},
+			nil,
+			"10.0.0.1",
+			false,
+		},
+		{
+			"skip trusted proxies",
+			[]string{"1.1.1.1", "2.2.2.2", "2001:db8::1", "10.0.0.1"},
+			trusted,
+			"2.2.2.2",
+			false,
+		},
+		{
+			"all trusted",
+			[]string{"10.0.0.2", "10.0.0.1"},
+			trusted,
+			"10.0.0.2",
+			false,
+		},
+		{
+			"spoofed entry is ignored",
+			[]string{"invalid", "2.2.2.2", "10.0.0.1"},
+			trusted,
+			"2.2.2.2",
+			false,
+		},
+		{
+			"invalid trusted hop",
+			[]string{"1.1.1.1", "invalid"},
+			trusted,
+			"",
+			true,
+		},
+		{
+			"IPv4-mapped address",
+			[]string{"::ffff:1.1.1.1"},
+			trusted,
+			"1.1.1.1",
+			false,
+		},
+		{
+			"IPv4-mapped trusted proxy",
+			[]string{"2.2.2.2", "::ffff:10.0.0.1"},
+			trusted,
+			"2.2.2.2",
+			false,
+		},
+		{"empty chain", nil, trusted, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.ClientIP(tt.chain, tt.trusted)
+			if errors.Is(err, server.ErrInvalidClientIP) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestPath(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"/admin", "/admin"},
+		{"/admin/", "/admin/"},
+		{"/admin?user=1", "/admin"},
+		{"/public/../admin/users", "/admin/users"},
+		{"//admin//users", "/admin/users"},
+		{"/%61dmin", "/admin"},
+		{"/admin/%zz?x", "/admin/%zz"},
+		{"https://example.com/admin", "/admin"},
+		{"https://example.com", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := server.RequestPath(tt.uri); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"missing", nil, ""},
+		{"empty", []string{""}, ""},
+		{"single", []string{"https"}, "https"},
+		{"uppercase", []string{"HTTP"}, "http"},
+		{"list", []string{"https, http"}, "http"},
+		{"trailing comma", []string{"http, "}, "http"},
+		{"multiple values", []string{"http", "https", " "}, "https"},
+		{"spoofed", []string{"https", "http"}, "http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := server.RequestProtocol(tt.values); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardAuthProtocols(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Domains:   []string{"admin.example.com"},
+				Protocols: []string{"http"},
+				Policy:    config.PolicyDeny,
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	tests := []struct {
+		domain   string
+		protocol string
+		status   int
+	}{
+		{"admin.example.com", "http", http.StatusForbidden},
+		{"admin.example.com", "https", http.StatusNoContent},
+		{"admin.example.com", "", http.StatusNoContent},
+		{"admin.example.com", "https, http", http.StatusForbidden},
+		{"example.com", "http", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.protocol, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "2.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, tt.domain)
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+			request.Header.Set(server.HeaderXForwardedProto, tt.protocol)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestForwardAuthPaths(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Paths:     []string{"/admin/**"},
+				Countries: []string{"US"},
+				Policy:    config.PolicyDeny,
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	tests := []struct {
+		uri    string
+		status int
+	}{
+		{"/admin/users", http.StatusForbidden},
+		{"/public/../admin/users?page=2", http.StatusForbidden},
+		{"/public/index.html", http.StatusNoContent},
+		{"", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, "2.0.0.1")
+			request.Header.Set(server.HeaderXForwardedHost, "example.com")
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+			request.Header.Set(server.HeaderXForwardedURI, tt.uri)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestForwardAuthResolutionHeaders(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    config.PolicyAllow,
+			},
+		},
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	tests := []struct {
+		ip      string
+		country string
+		rule    string
+	}{
+		{"1.0.0.1", "FR", "0"},
+		{"2.0.0.1", "US", ""},
+		{"3.0.0.1", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet, "/v1/forward-auth", nil,
+			)
+			request.Header.Set(server.HeaderXForwardedFor, tt.ip)
+			request.Header.Set(server.HeaderXForwardedHost, "example.com")
+			request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusNoContent {
+				t.Fatalf("got status %d, want %d", recorder.Code,
+					http.StatusNoContent)
+			}
+
+			headers := recorder.Header()
+			if got := headers.Get(server.HeaderCountry); got != tt.country {
+				t.Errorf("got country %q, want %q", got, tt.country)
+			}
+			if got := headers.Get(server.HeaderRule); got != tt.rule {
+				t.Errorf("got rule %q, want %q", got, tt.rule)
+			}
+			if got := headers.Values(server.HeaderASN); len(got) != 0 {
+				t.Errorf("got ASN %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestConfigGeneration(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	engine.StageConfig(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{},
+	).Handler
+
+	status := func() string {
+		var response struct {
+			ConfigGeneration uint64 `json:"config_generation"`
+			ConfigStaged     bool   `json:"config_staged"`
+		}
+		recorder := getStatus(engine)
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%d %t",
+			response.ConfigGeneration, response.ConfigStaged)
+	}
+	forwardAuth := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(
+			http.MethodGet, "/v1/forward-auth", nil,
+		)
+		request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+		request.Header.Set(server.HeaderXForwardedHost, "example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	want := "1 true"
+	if got := status(); got != want {
+		t.Errorf("got status %s, want %s", got, want)
+	}
+	header := forwardAuth().Header()
+	if got := header.Get(server.HeaderConfigGeneration); got != "1" {
+		t.Errorf("got generation %q on allowed response, want 1", got)
+	}
+
+	engine.PromoteConfig()
+	want = "2 false"
+	if got := status(); got != want {
+		t.Errorf("got status %s, want %s", got, want)
+	}
+	recorder := forwardAuth()
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", recorder.Code,
+			http.StatusForbidden)
+	}
+	header = recorder.Header()
+	if got := header.Get(server.HeaderConfigGeneration); got != "2" {
+		t.Errorf("got generation %q on denied response, want 2", got)
+	}
+}
+
+// getStatus returns the response of the status endpoint of the admin API
+// with the given engine.
+func getStatus(engine *rules.Engine) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	newAdminHandler(engine, server.AdminOptions{}).ServeHTTP(
+		recorder, httptest.NewRequest(http.MethodGet, "/v1/status", nil),
+	)
+	return recorder
+}
+
+func TestStatusHealth(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	newTestResolver(t) // Reports the databases as up to date
+	t.Cleanup(func() {
+		health.Report(health.ComponentWebhooks, health.StateOK, nil)
+		health.Report(health.ComponentConfig, health.StateOK, nil)
+	})
+
+	steps := []struct {
+		name      string
+		component string
+		state     string
+		err       error
+		want      string
+		code      int
+	}{
+		{
+			"degraded webhooks",
+			health.ComponentWebhooks,
+			health.StateDegraded,
+			errors.New("cannot post to webhook"),
+			health.StateDegraded,
+			http.StatusOK,
+		},
+		{
+			"failed config",
+			health.ComponentConfig,
+			health.StateFailed,
+			errors.New("invalid"),
+			health.StateFailed,
+			http.StatusServiceUnavailable,
+		},
+		{
+			"recovered config",
+			health.ComponentConfig,
+			health.StateOK,
+			nil,
+			health.StateDegraded,
+			http.StatusOK,
+		},
+	}
+
+	for _, step := range steps {
+		health.Report(step.component, step.state, step.err)
+
+		recorder := getStatus(engine)
+		if recorder.Code != step.code {
+			t.Errorf("%s: got code %d, want %d",
+				step.name, recorder.Code, step.code)
+		}
+		var response struct {
+			Status     string                      `json:"status"`
+			Components map[string]health.Component `json:"components"`
+		}
+		err := json.NewDecoder(recorder.Body).Decode(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != step.want {
+			t.Errorf("%s: got status %q, want %q",
+				step.name, response.Status, step.want)
+		}
+		got := response.Components[step.component]
+		if got.State != step.state {
+			t.Errorf("%s: got state %q, want %q",
+				step.name, got.State, step.state)
+		}
+		if step.err != nil && got.LastError != step.err.Error() {
+			t.Errorf("%s: got last error %q, want %q",
+				step.name, got.LastError, step.err)
+		}
+	}
+	if got := health.Components()[health.ComponentDatabases]; got.State !=
+		health.StateOK {
+		t.Errorf("got databases %+v, want ok after the update", got)
+	}
+}
+
+func TestRegisterPrefix(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	mux := http.NewServeMux()
+	server.Register(
+		mux, "/geoblock/", engine, newTestResolver(t), server.Options{},
+	)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/geoblock/v1/health", http.StatusNoContent},
+		{"/geoblock/v1/metrics", http.StatusOK},
+		{"/geoblock/metrics", http.StatusOK},
+		{"/geoblock/v1/ready", http.StatusNoContent},
+		{"/geoblock/v1/domains", http.StatusNotFound},
+		{"/geoblock/v1/forward-auth", http.StatusBadRequest},
+		{"/v1/health", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, tt.path, nil),
+			)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestServerWithoutAdminAPI(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+	engine.StageConfig(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+	})
+	handler := server.NewServer(
+		"", engine, newTestResolver(t), server.Options{
+			History: history.NewStore(history.Options{}),
+		},
+	).Handler
+
+	// The endpoints revealing the rules or changing the state of the server
+	// are only served by the authenticated admin API.
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/v1/status", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/domains", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/stats/history", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/db/status", "", http.StatusNotFound},
+		{http.MethodGet, "/v1/debug/resolve", "", http.StatusNotFound},
+		{
+			http.MethodPost,
+			"/v1/authorize",
+			`[{"ip": "1.0.0.1", "domain": "example.com"}]`,
+			http.StatusNotFound,
+		},
+		{http.MethodGet, "/v1/bans", "", http.StatusNotFound},
+		{
+			http.MethodPost,
+			"/v1/bans",
+			`{"network": "0.0.0.0/0", "ttl": "1h"}`,
+			http.StatusNotFound,
+		},
+		{http.MethodDelete, "/v1/bans/10.0.0.0/8", "", http.StatusNotFound},
+		{http.MethodPost, "/v1/config/promote", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(
+				tt.method, tt.path, strings.NewReader(tt.body),
+			))
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+
+	if !engine.Authorize(&rules.Query{
+		SourceIP: netip.MustParseAddr("1.0.0.1"),
+	}) {
+		t.Error("staged configuration was promoted or source IP banned")
+	}
+}
+
+func TestReady(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+	})
+
+	tests := []struct {
+		name     string
+		resolver *ipres.Resolver
+		status   int
+	}{
+		{"with country data", newTestResolver(t), http.StatusNoContent},
+		{
+			"without country data",
+			ipres.NewResolver(mockFetcher{}, ipres.Options{}),
+			http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := server.NewServer(
+				"", engine, tt.resolver, server.Options{},
+			).Handler
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/v1/ready", nil),
+			)
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestForwardAuthFirstSeen(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"US"},
+				Policy:    config.PolicyDeny,
+			},
+		},
+	})
+	tracker := firstseen.NewTracker([]string{"nas.example.com"})
+	handler := server.NewServer(
+		"",
+		engine,
+		newTestResolver(t),
+		server.Options{FirstSeen: tracker},
+	).Handler
+
+	for _, ip := range []string{"1.0.0.1", "2.0.0.1"} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+		request.Header.Set(server.HeaderXForwardedFor, ip)
+		request.Header.Set(server.HeaderXForwardedHost, "nas.example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	// Only the countries of the allowed requests are tracked.
+	if _, isNew, _ := tracker.Observe("nas.example.com", "FR"); isNew {
+		t.Error("FR wasn't tracked by the allowed request")
+	}
+	if _, isNew, _ := tracker.Observe("nas.example.com", "US"); !isNew {
+		t.Error("US was tracked by the denied request")
+	}
+}
+
+func TestForwardAuthAudit(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyAllow,
+		Rules: []config.AccessControlRule{
+			{
+				Countries: []string{"US"},
+				Policy:    config.PolicyDeny,
+			},
+		},
+	})
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := audit.Open(path, audit.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	handler := server.NewServer(
+		"",
+		engine,
+		newTestResolver(t),
+		server.Options{Audit: logger},
+	).Handler
+
+	for _, ip := range []string{"1.0.0.1", "2.0.0.1", "invalid"} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+		request.Header.Set(server.HeaderXForwardedFor, ip)
+		request.Header.Set(server.HeaderXForwardedHost, "example.com")
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []struct {
+		ip      string
+		country string
+		rule    int
+		outcome string
+	}{
+		{"1.0.0.1", "FR", rules.NoRule, audit.OutcomeAllow},
+		{"2.0.0.1", "US", 0, audit.OutcomeDeny},
+		{"invalid", "", rules.NoRule, audit.OutcomeInvalid},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d records, want %d", len(lines), len(want))
+	}
+	for i, line := range lines {
+		var record audit.Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		rule := rules.NoRule
+		if record.Rule != nil {
+			rule = *record.Rule
+		}
+		if record.IP != want[i].ip || record.Country != want[i].country ||
+			record.Domain != "example.com" ||
+			record.Method != http.MethodGet ||
+			rule != want[i].rule ||
+			record.Outcome != want[i].outcome {
+			t.Errorf("got %+v, want %+v", record, want[i])
+		}
+	}
+}
+
+// requestCount returns the value of the requests metric with the given labels,
+// or 0 if it wasn't counted yet.
+func requestCount(t *testing.T, labels string) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(
+		recorder,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	)
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "geoblock_requests_total{") ||
+			!strings.Contains(line, labels) {
+			continue
+		}
+		var count int
+		value := line[strings.LastIndex(line, " ")+1:]
+		if _, err := fmt.Sscanf(value, "%d", &count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	return 0
+}
+
+func TestForwardAuthDomainLabel(t *testing.T) {
+	engine := rules.NewEngine(&config.AccessControl{
+		DefaultPolicy: config.PolicyDeny,
+		Rules: []config.AccessControlRule{
+			{
+				Domains: []string{"*.label.example.com"},
+				Policy:  config.PolicyAllow,
+			},
+		},
+	})
+	handler := server.NewServer(
+		"",
+		engine,
+		newTestResolver(t),
+		server.Options{},
+	).Handler
+
+	matched := `country="FR",domain="*.label.example.com",result="allowed"`
+	other := `country="FR",domain="other",result="denied"`
+	matchedBefore := requestCount(t, matched)
+	otherBefore := requestCount(t, other)
+
+	for _, host := range []string{
+		"a.label.example.com", "B.label.example.com", "attacker.test",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/forward-auth", nil)
+		request.Header.Set(server.HeaderXForwardedFor, "1.0.0.1")
+		request.Header.Set(server.HeaderXForwardedHost, host)
+		request.Header.Set(server.HeaderXForwardedMethod, http.MethodGet)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	if got := requestCount(t, matched) - matchedBefore; got != 2 {
+		t.Errorf("got %d requests of the pattern, want 2", got)
+	}
+	if got := requestCount(t, other) - otherBefore; got != 1 {
+		t.Errorf("got %d requests of other domains, want 1", got)
+	}
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/server"
+)
+
+func TestSignerVerify(t *testing.T) {
+	var (
+		secret = []byte("secret")
+		ip     = netip.MustParseAddr("2001:db8::1")
+		now    = time.Unix(1700000000, 0)
+		signer = server.NewSigner(secret, "")
+		value  = signer.Sign(ip, server.DecisionAllow, now)
+	)
+
+	if header := signer.Header(); header != server.DefaultSignatureHeader {
+		t.Errorf("got %q, want %q", header, server.DefaultSignatureHeader)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		value  string
+		now    time.Time
+		err    error
+	}{
+		{"valid", secret, value, now, nil},
+		{"valid within age", secret, value, now.Add(time.Minute), nil},
+		{
+			"expired",
+			secret,
+			value,
+			now.Add(time.Hour),
+			server.ErrExpiredSignature,
+		},
+		{
+			"wrong secret",
+			[]byte("other"),
+			value,
+			now,
+			server.ErrInvalidSignature,
+		},
+		{
+			"tampered IP",
+			secret,
+			strings.Replace(value, "ip=2001:db8::1", "ip=2001:db8::2", 1),
+			now,
+			server.ErrInvalidSignature,
+		},
+		{
+			"tampered decision",
+			secret,
+			strings.Replace(value, "d=allow", "d=deny", 1),
+			now,
+			server.ErrInvalidSignature,
+		},
+		{"malformed", secret, "garbage", now, server.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotIP, decision, err := server.Verify(
+				tt.secret,
+				tt.value,
+				5*time.Minute,
+				tt.now,
+			)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if gotIP != ip {
+				t.Errorf("got %v, want %v", gotIP, ip)
+			}
+			if decision != server.DecisionAllow {
+				t.Errorf("got %q, want %q", decision, server.DecisionAllow)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 // Package glob provides simple globbing functions.
 package glob
 
+import "strings"
+
 // Star matches a string against a pattern that may contain `*` as a
 // wildcard. The `*` character matches zero or more characters.
 func Star(pattern, s string) bool {
@@ -14,3 +16,21 @@ func Star(pattern, s string) bool {
 
 	return s != "" && s[0] == pattern[0] && Star(pattern[1:], s[1:])
 }
+
+// Path matches a URL path against a pattern that may contain `*` and `**` as
+// wildcards. The `*` wildcard matches zero or more characters other than `/`,
+// i.e., within a path segment, and `**` matches zero or more characters,
+// including `/`.
+func Path(pattern, path string) bool {
+	if pattern == "" {
+		return path == ""
+	}
+	if rest, ok := strings.CutPrefix(pattern, "**"); ok {
+		return Path(rest, path) || (path != "" && Path(pattern, path[1:]))
+	}
+	if pattern[0] == '*' {
+		return Path(pattern[1:], path) ||
+			(path != "" && path[0] != '/' && Path(pattern, path[1:]))
+	}
+	return path != "" && path[0] == pattern[0] && Path(pattern[1:], path[1:])
+}
@@ -45,3 +45,41 @@ func TestStar(t *testing.T) {
 		})
 	}
 }
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/admin", "/admin", true},
+		{"/admin", "/admin/", false},
+		{"/admin/*", "/admin/users", true},
+		{"/admin/*", "/admin/", true},
+		{"/admin/*", "/admin/users/1", false},
+		{"/admin/*", "/admin", false},
+		{"/api/**", "/api/v1/users", true},
+		{"/api/**", "/api/", true},
+		{"/api/**", "/api", false},
+		{"/api/**/edit", "/api/users/1/edit", true},
+		{"/api/**/edit", "/api/edit", false},
+		{"/*/edit", "/users/edit", true},
+		{"/*/edit", "/users/1/edit", false},
+		{"*.php", "/index.php", false},
+		{"**.php", "/wp/index.php", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"_"+tt.path, func(t *testing.T) {
+			if got := glob.Path(tt.pattern, tt.path); got != tt.want {
+				t.Errorf(
+					"Path(%q, %q) = %v, want %v",
+					tt.pattern,
+					tt.path,
+					got,
+					tt.want,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+// Package orgname normalizes organization names.
+package orgname
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// legalSuffixes are the legal entity suffixes removed from organization
+// names, after punctuation is removed.
+var legalSuffixes = []string{
+	"ab", "ag", "as", "bv", "co", "corp", "corporation", "gmbh", "inc",
+	"incorporated", "kg", "kk", "limited", "llc", "ltd", "nv", "oy", "plc",
+	"pty", "sa", "sarl", "sas", "spa", "srl", "sro",
+}
+
+// Normalize returns the normalized form of the given organization name, so
+// that the names of the same organization compare equal despite naming
+// inconsistencies: it's lower case, without punctuation or repeated spaces,
+// and without legal entity suffixes such as "LLC" or "GmbH".
+//
+// For example, "Example, Inc." and "EXAMPLE Inc" are both normalized to
+// "example". The `*` wildcard is kept so that patterns can be normalized too.
+func Normalize(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '.' || r == '\'':
+			return -1
+		case r == '*' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		default:
+			return ' '
+		}
+	}, name)
+
+	words := strings.Fields(name)
+	for len(words) > 1 && slices.Contains(legalSuffixes, words[len(words)-1]) {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
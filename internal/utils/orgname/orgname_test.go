@@ -0,0 +1,34 @@
+package orgname_test
+
+import (
+	"testing"
+
+	"github.com/danroc/geoblock/internal/utils/orgname"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"Example", "example"},
+		{"Example, Inc.", "example"},
+		{"EXAMPLE Inc", "example"},
+		{"Example Networks GmbH", "example networks"},
+		{"Example-Net  S.A.", "example net"},
+		{"Example Co., Ltd.", "example"},
+		{"O'Example LLC", "oexample"},
+		{"Inc", "inc"},
+		{"Amazon*", "amazon*"},
+		{"Société Générale SA", "société générale"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orgname.Normalize(tt.name); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
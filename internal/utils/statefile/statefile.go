@@ -0,0 +1,124 @@
+// Package statefile reads and writes the files of the dynamic state, e.g.,
+// the temporary bans, so that it survives restarts. The files are versioned
+// and checksummed, and replaced atomically, so that a corrupted file is
+// refused on load instead of being silently replaced by an empty state.
+package statefile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Version is the version of the format of the state files written by Write.
+// Files without version contain the bare state, as written before the files
+// were versioned.
+const Version = 1
+
+// Errors returned by Read.
+var (
+	ErrCorrupted = errors.New("corrupted state file")
+	ErrVersion   = errors.New("unsupported state file version")
+	ErrKind      = errors.New("unexpected state file kind")
+)
+
+// file is the content of a state file. Checksum is the SHA-256 checksum of
+// the state, in hex.
+type file struct {
+	Version  int             `json:"version"`
+	Kind     string          `json:"kind"`
+	Checksum string          `json:"checksum"`
+	State    json.RawMessage `json:"state"`
+}
+
+// checksum returns the checksum of the given state.
+func checksum(state []byte) string {
+	sum := sha256.Sum256(state)
+	return hex.EncodeToString(sum[:])
+}
+
+// Read decodes the state of the given kind, e.g., "bans", saved in the given
+// file into the given value. It returns false if the file doesn't exist.
+//
+// It returns an error wrapping ErrCorrupted if the file can't be decoded or
+// if its checksum doesn't match, ErrVersion if it's written in a newer format,
+// and ErrKind if it contains another kind of state.
+func Read(path, kind string, value any) (bool, error) {
+	data, err := os.ReadFile(path) // #nosec G304
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var saved file
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Version == 0 {
+		// Files without version contain the bare state.
+		if err := json.Unmarshal(data, value); err != nil {
+			return false, fmt.Errorf("%w: %s: %w", ErrCorrupted, path, err)
+		}
+		return true, nil
+	}
+
+	switch {
+	case saved.Version > Version:
+		return false, fmt.Errorf(
+			"%w: %s: version %d", ErrVersion, path, saved.Version,
+		)
+	case saved.Kind != kind:
+		return false, fmt.Errorf(
+			"%w: %s: got %q, want %q", ErrKind, path, saved.Kind, kind,
+		)
+	case saved.Checksum != checksum(saved.State):
+		return false, fmt.Errorf(
+			"%w: %s: checksum mismatch", ErrCorrupted, path,
+		)
+	}
+	if err := json.Unmarshal(saved.State, value); err != nil {
+		return false, fmt.Errorf("%w: %s: %w", ErrCorrupted, path, err)
+	}
+	return true, nil
+}
+
+// Write encodes the given state of the given kind in the given file. The file
+// is replaced atomically, once its content is synced to disk, so that a crash
+// never leaves a partial file.
+func Write(path, kind string, value any) error {
+	state, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(file{
+		Version:  Version,
+		Kind:     kind,
+		Checksum: checksum(state),
+		State:    state,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // #nosec G104
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() // #nosec G104
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() // #nosec G104
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
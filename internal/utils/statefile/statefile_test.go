@@ -0,0 +1,118 @@
+package statefile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/internal/utils/statefile"
+)
+
+func TestReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	var missing []string
+	ok, err := statefile.Read(path, "test", &missing)
+	if ok || err != nil {
+		t.Fatalf("got %v and %v for a missing file, want false and nil",
+			ok, err)
+	}
+
+	want := []string{"a", "b"}
+	if err := statefile.Write(path, "test", want); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	ok, err = statefile.Read(path, "test", &got)
+	if !ok || err != nil {
+		t.Fatalf("got %v and %v, want true and nil", ok, err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want only the state file", len(files))
+	}
+}
+
+func TestReadUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`["a","b"]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	if _, err := statefile.Read(path, "test", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got %v, want [a b]", got)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(data string) string
+		want error
+	}{
+		{
+			"changed state",
+			func(data string) string {
+				return strings.Replace(data, `"a"`, `"c"`, 1)
+			},
+			statefile.ErrCorrupted,
+		},
+		{
+			"truncated",
+			func(data string) string { return data[:len(data)/2] },
+			statefile.ErrCorrupted,
+		},
+		{
+			"newer version",
+			func(data string) string {
+				return strings.Replace(data, `"version":1`, `"version":2`, 1)
+			},
+			statefile.ErrVersion,
+		},
+		{
+			"other kind",
+			func(data string) string {
+				return strings.Replace(data, `"test"`, `"other"`, 1)
+			},
+			statefile.ErrKind,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			err := statefile.Write(path, "test", []string{"a"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			edited := tt.edit(string(data))
+			if err := os.WriteFile(path, []byte(edited), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			_, err = statefile.Read(path, "test", &got)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
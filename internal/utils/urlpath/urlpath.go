@@ -0,0 +1,22 @@
+// Package urlpath cleans URL paths before they're matched by the rules.
+package urlpath
+
+import (
+	"path"
+	"strings"
+)
+
+// Clean returns the cleaned form of the given URL path, so that paths such as
+// `/public/../admin` can't bypass the paths conditions of the rules. The
+// trailing slash is kept. It returns an empty string if the path is empty.
+func Clean(p string) string {
+	if p == "" {
+		return ""
+	}
+
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
@@ -0,0 +1,31 @@
+package urlpath_test
+
+import (
+	"testing"
+
+	"github.com/danroc/geoblock/internal/utils/urlpath"
+)
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"/admin", "/admin"},
+		{"/admin/", "/admin/"},
+		{"admin", "/admin"},
+		{"/public/../admin", "/admin"},
+		{"/public/./admin//", "/public/admin/"},
+		{"/../..", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := urlpath.Clean(tt.path); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
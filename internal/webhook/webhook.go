@@ -0,0 +1,235 @@
+// Package webhook notifies external services of the decisions of the rules
+// that have a webhook. Notifications are sent in the background, so that
+// they never delay the decisions, and are rate-limited per webhook. They're
+// sent by the targets of the notify package.
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/health"
+	"github.com/danroc/geoblock/internal/metrics"
+	"github.com/danroc/geoblock/internal/notify"
+)
+
+// Outcomes of the notified decisions.
+const (
+	OutcomeAllow = config.PolicyAllow
+	OutcomeDeny  = config.PolicyDeny
+)
+
+// Results of the notifications, see metrics.WebhookNotifications.
+const (
+	ResultSent    = "sent"
+	ResultFailed  = "failed"
+	ResultLimited = "limited"
+	ResultDropped = "dropped"
+)
+
+// Default options of a Notifier.
+const (
+	DefaultQueueSize = 100
+	DefaultTimeout   = 5 * time.Second
+)
+
+// DefaultRateLimit is the rate limit of the webhooks without one.
+var DefaultRateLimit = config.RateLimit{Requests: 10, Window: time.Minute}
+
+// Options contains the options of a Notifier.
+type Options struct {
+	// QueueSize is the number of pending notifications above which the new
+	// ones are dropped. If zero, DefaultQueueSize is used.
+	QueueSize int
+
+	// Timeout is the timeout of each webhook call. If zero, DefaultTimeout
+	// is used.
+	Timeout time.Duration
+
+	// Anonymize returns the form of the IPs sent to the webhooks. If nil,
+	// the IPs are sent as is.
+	Anonymize func(ip string) string
+
+	// NewTarget creates the target of the notifications of the given
+	// webhook, e.g., to send them by email. If nil, the payloads rendered by
+	// the template of the webhook are posted to its URL.
+	NewTarget func(webhook *config.Webhook) (notify.Notifier, error)
+}
+
+// delivery is a pending notification.
+type delivery struct {
+	target notify.Notifier
+	event  *notify.Event
+}
+
+// window counts the notifications of a webhook during a rate limit window.
+type window struct {
+	start time.Time
+	count int
+}
+
+// Notifier sends the notifications of the rule webhooks, one at a time, from
+// a background goroutine. It's safe for concurrent use.
+type Notifier struct {
+	options Options
+	client  *http.Client
+	queue   chan delivery
+	mu      sync.Mutex
+	windows map[string]*window         // Rate limit windows by webhook URL
+	targets map[string]notify.Notifier // Targets by webhook, see targetKey
+	now     func() time.Time
+}
+
+// NewNotifier creates a new notifier and starts sending its notifications.
+func NewNotifier(options Options) *Notifier {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+
+	n := &Notifier{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		queue:   make(chan delivery, options.QueueSize),
+		windows: make(map[string]*window),
+		targets: make(map[string]notify.Notifier),
+		now:     time.Now,
+	}
+	if n.options.NewTarget == nil {
+		n.options.NewTarget = n.newWebhook
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues the notification of the given event to the given webhook,
+// unless the webhook isn't notified of the outcome of the event, its rate
+// limit is exceeded or too many notifications are pending. It returns true
+// if the notification is queued.
+func (n *Notifier) Notify(webhook *config.Webhook, event *notify.Event) bool {
+	if len(webhook.Outcomes) > 0 &&
+		!slices.Contains(webhook.Outcomes, event.Outcome) {
+		return false
+	}
+
+	limit := webhook.RateLimit
+	if limit == nil {
+		limit = &DefaultRateLimit
+	}
+	if !n.allow(webhook.URL, limit) {
+		metrics.WebhookNotifications.WithLabelValues(ResultLimited).Inc()
+		return false
+	}
+
+	target, err := n.target(webhook)
+	if err != nil {
+		log.WithError(err).WithField(
+			"rule", event.Rule,
+		).Warn("Cannot create webhook target")
+		metrics.WebhookNotifications.WithLabelValues(ResultFailed).Inc()
+		return false
+	}
+
+	copied := *event
+	if n.options.Anonymize != nil {
+		copied.IP = n.options.Anonymize(copied.IP)
+	}
+	copied.Text = notify.Summary(&copied)
+
+	select {
+	case n.queue <- delivery{target: target, event: &copied}:
+		return true
+	default:
+		metrics.WebhookNotifications.WithLabelValues(ResultDropped).Inc()
+		return false
+	}
+}
+
+// allow counts a notification of the webhook with the given URL and returns
+// true if it's within the given rate limit.
+func (n *Notifier) allow(url string, limit *config.RateLimit) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	w, ok := n.windows[url]
+	if !ok || now.Sub(w.start) >= limit.Window {
+		w = &window{start: now}
+		n.windows[url] = w
+	}
+	if w.count >= limit.Requests {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// targetKey returns the key of the target of the given webhook, which is
+// created again only if the webhook changes.
+func targetKey(webhook *config.Webhook) string {
+	return webhook.URL + "\n" + webhook.ContentType + "\n" + webhook.Template
+}
+
+// target returns the target of the notifications of the given webhook,
+// creating it on first use.
+func (n *Notifier) target(webhook *config.Webhook) (notify.Notifier, error) {
+	key := targetKey(webhook)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if target, ok := n.targets[key]; ok {
+		return target, nil
+	}
+	target, err := n.options.NewTarget(webhook)
+	if err != nil {
+		return nil, err
+	}
+	n.targets[key] = target
+	return target, nil
+}
+
+// newWebhook creates the target posting the payloads rendered by the
+// template of the given webhook to its URL.
+func (n *Notifier) newWebhook(
+	webhook *config.Webhook,
+) (notify.Notifier, error) {
+	template, err := notify.ParseTemplate(webhook.Template)
+	if err != nil {
+		return nil, err
+	}
+	return notify.NewWebhook(
+		n.client, webhook.URL, template, webhook.ContentType,
+	), nil
+}
+
+// run sends the queued notifications.
+func (n *Notifier) run() {
+	for d := range n.queue {
+		result := ResultSent
+		ctx, cancel := context.WithTimeout(
+			context.Background(), n.options.Timeout,
+		)
+		err := d.target.Notify(ctx, d.event)
+		cancel()
+		if err != nil {
+			result = ResultFailed
+			// The URL isn't logged, since it often contains a secret token.
+			log.WithError(err).WithField(
+				"rule", d.event.Rule,
+			).Warn("Cannot call webhook")
+			health.Report(health.ComponentWebhooks, health.StateDegraded, err)
+		} else {
+			health.Report(health.ComponentWebhooks, health.StateOK, nil)
+		}
+		metrics.WebhookNotifications.WithLabelValues(result).Inc()
+	}
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danroc/geoblock/internal/config"
+	"github.com/danroc/geoblock/internal/notify"
+)
+
+// newTestServer starts a webhook server sending the received events to the
+// returned channel.
+func newTestServer(t *testing.T) (*httptest.Server, chan notify.Event) {
+	t.Helper()
+	events := make(chan notify.Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			var event notify.Event
+			err := json.NewDecoder(request.Body).Decode(&event)
+			if err != nil {
+				t.Errorf("cannot decode event: %v", err)
+			}
+			events <- event
+			writer.WriteHeader(http.StatusNoContent)
+		},
+	))
+	t.Cleanup(server.Close)
+	return server, events
+}
+
+// receive returns the next event received by a test server.
+func receive(t *testing.T, events chan notify.Event) notify.Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return notify.Event{}
+	}
+}
+
+func TestNotify(t *testing.T) {
+	server, events := newTestServer(t)
+	notifier := NewNotifier(Options{
+		Anonymize: func(string) string { return "203.0.113.0" },
+	})
+	hook := &config.Webhook{
+		URL:      server.URL,
+		Outcomes: []string{OutcomeDeny},
+	}
+
+	event := notify.Event{
+		Rule:     1,
+		RuleName: "admin",
+		IP:       "203.0.113.10",
+		Country:  "FR",
+		ASN:      64500,
+		Domain:   "admin.example.com",
+		Method:   http.MethodGet,
+		Path:     "/login",
+	}
+
+	allowed := event
+	allowed.Outcome = OutcomeAllow
+	if notifier.Notify(hook, &allowed) {
+		t.Error("got allow notified, want only deny")
+	}
+
+	event.Outcome = OutcomeDeny
+	if !notifier.Notify(hook, &event) {
+		t.Fatal("got deny not notified")
+	}
+	got := receive(t, events)
+	if got.IP != "203.0.113.0" {
+		t.Errorf("got IP %q, want anonymized IP", got.IP)
+	}
+	if event.IP != "203.0.113.10" {
+		t.Errorf("got event IP %q, want the event unchanged", event.IP)
+	}
+	want := "deny of GET admin.example.com/login from 203.0.113.0 " +
+		"(FR, AS64500) by rule admin"
+	if got.Text != want {
+		t.Errorf("got text %q, want %q", got.Text, want)
+	}
+	if got.RuleName != "admin" || got.Domain != event.Domain {
+		t.Errorf("got event %+v, want %+v", got, event)
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	server, events := newTestServer(t)
+	now := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	notifier := NewNotifier(Options{})
+	notifier.now = func() time.Time { return now }
+
+	hook := &config.Webhook{
+		URL:       server.URL,
+		RateLimit: &config.RateLimit{Requests: 2, Window: time.Minute},
+	}
+	event := &notify.Event{Outcome: OutcomeDeny, IP: "203.0.113.10"}
+
+	steps := []struct {
+		elapsed time.Duration
+		want    bool
+	}{
+		{0, true},
+		{10 * time.Second, true},
+		{20 * time.Second, false},
+		{time.Minute, true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.elapsed)
+		if got := notifier.Notify(hook, event); got != step.want {
+			t.Errorf("after %v: got %v, want %v", step.elapsed, got, step.want)
+		}
+		if step.want {
+			receive(t, events)
+		}
+	}
+}
+
+// targetFunc is a notification target calling a function.
+type targetFunc func(event *notify.Event) error
+
+func (f targetFunc) Notify(_ context.Context, event *notify.Event) error {
+	return f(event)
+}
+
+func TestNotifyTarget(t *testing.T) {
+	var (
+		events  = make(chan notify.Event, 10)
+		created int
+	)
+	notifier := NewNotifier(Options{
+		NewTarget: func(*config.Webhook) (notify.Notifier, error) {
+			created++
+			return targetFunc(func(event *notify.Event) error {
+				events <- *event
+				return nil
+			}), nil
+		},
+	})
+	hook := &config.Webhook{URL: "https://hooks.example.com"}
+	event := &notify.Event{Outcome: OutcomeDeny, IP: "203.0.113.10"}
+
+	for range 2 {
+		if !notifier.Notify(hook, event) {
+			t.Fatal("got event not notified")
+		}
+		if got := receive(t, events); got.IP != event.IP {
+			t.Errorf("got IP %q, want %q", got.IP, event.IP)
+		}
+	}
+	if created != 1 {
+		t.Errorf("got %d targets created, want 1", created)
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	bodies := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, request *http.Request) {
+			body, _ := io.ReadAll(request.Body)
+			bodies <- request.Header.Get("Content-Type") + " " + string(body)
+			writer.WriteHeader(http.StatusNoContent)
+		},
+	))
+	t.Cleanup(server.Close)
+
+	notifier := NewNotifier(Options{})
+	hook := &config.Webhook{
+		URL:         server.URL,
+		Template:    "{{.Outcome}} {{.IP}}",
+		ContentType: "text/plain",
+	}
+	event := &notify.Event{Outcome: OutcomeDeny, IP: "203.0.113.10"}
+	if !notifier.Notify(hook, event) {
+		t.Fatal("got event not notified")
+	}
+
+	select {
+	case got := <-bodies:
+		if want := "text/plain deny 203.0.113.10"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no payload received")
+	}
+}
@@ -0,0 +1,69 @@
+package geoblock
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/danroc/geoblock/internal/config"
+)
+
+// Policies of the rules and of the default policy.
+const (
+	PolicyAllow = config.PolicyAllow
+	PolicyDeny  = config.PolicyDeny
+)
+
+// Configuration types. They're the types of the YAML configuration file, see
+// the README, so they follow its compatibility: fields can be added, but
+// aren't removed or changed.
+type (
+	// Configuration is a whole configuration file.
+	Configuration = config.Configuration
+
+	// AccessControl is the `access_control` section of a configuration: the
+	// rules evaluated by an Engine and its default policy.
+	AccessControl = config.AccessControl
+
+	// AccessControlRule is a rule of an AccessControl.
+	AccessControlRule = config.AccessControlRule
+
+	// Preflight is the handling of the CORS preflight requests of an
+	// AccessControl.
+	Preflight = config.Preflight
+
+	// CIDR is a network of the `networks` conditions of a rule.
+	CIDR = config.CIDR
+
+	// ASNRange is an ASN or a range of ASNs of the `autonomous_systems`
+	// conditions of a rule.
+	ASNRange = config.ASNRange
+
+	// RateLimit is the maximum number of requests per source IP of a rule.
+	RateLimit = config.RateLimit
+
+	// Quota is the maximum number of requests per source country of a rule.
+	Quota = config.Quota
+
+	// DenyResponse is the response sent for the requests denied by a rule or
+	// by the default policy.
+	DenyResponse = config.DenyResponse
+
+	// Webhook is the webhook notified of the decisions of a rule.
+	Webhook = config.Webhook
+)
+
+// ReadConfig reads and validates a YAML configuration from the given reader.
+func ReadConfig(reader io.Reader) (*Configuration, error) {
+	return config.ReadConfig(reader)
+}
+
+// ReadConfigFile reads and validates the YAML configuration file at the given
+// path.
+func ReadConfigFile(path string) (*Configuration, error) {
+	file, err := os.ReadFile(path) // #nosec G304
+	if err != nil {
+		return nil, err
+	}
+	return config.ReadConfig(bytes.NewReader(file))
+}
@@ -0,0 +1,160 @@
+package geoblock
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/danroc/geoblock/internal/rules"
+	"github.com/danroc/geoblock/internal/utils/orgname"
+	"github.com/danroc/geoblock/internal/utils/urlpath"
+)
+
+// NoRule is the rule index of the decisions that aren't made by a rule: the
+// default policy, or a banned source IP.
+const NoRule = rules.NoRule
+
+// Reasons of the decisions, see Explanation.
+const (
+	ReasonMaintenance = rules.ReasonMaintenance // Maintenance policy applied
+	ReasonBanned      = rules.ReasonBanned      // Source IP is banned
+	ReasonFallback    = rules.ReasonFallback    // Fallback policy applied
+	ReasonPreflight   = rules.ReasonPreflight   // CORS preflight was allowed
+	ReasonRule        = rules.ReasonRule        // A rule matched
+	ReasonDefault     = rules.ReasonDefault     // No rule matched
+)
+
+// Request is a request to authorize.
+type Request struct {
+	Domain   string     // Requested domain
+	Method   string     // Requested HTTP method
+	Path     string     // Requested URL path, without query, if known
+	Protocol string     // Requested protocol, "http" or "https", if known
+	SourceIP netip.Addr // IP address of the client
+
+	// Context contains the key-value pairs of the request matched by the
+	// `context` rule condition, e.g., its tenant, by lowercase key.
+	Context map[string]string
+}
+
+// Decision is the decision of an Engine for a request.
+type Decision struct {
+	Allowed bool
+	Banned  bool // Whether the source IP is temporarily banned
+	Rule    int  // Index of the matching rule, or NoRule if none matched
+
+	// RuleName is the name of the matching rule. It's empty if no rule
+	// matched or if the rule has no name.
+	RuleName string
+
+	// RetryAfter is the time until the rate limit of the matching rule lets
+	// the source IP make a new request. It's zero unless the request is
+	// denied by a rate limit.
+	RetryAfter time.Duration
+}
+
+// RuleExplanation is the evaluation of a rule for a request.
+type RuleExplanation struct {
+	Rule    int    // Index of the rule
+	Name    string // Name of the rule, if any
+	Policy  string // Policy of the rule
+	Applies bool   // Whether all the conditions of the rule match
+
+	// Conditions are the results of the conditions of the rule, by name,
+	// e.g., "country". The conditions that the rule doesn't set always
+	// match.
+	Conditions map[string]bool
+}
+
+// Explanation explains the decision of an Engine for a request.
+type Explanation struct {
+	Decision Decision
+	Policy   string // Effective policy, PolicyAllow or PolicyDeny
+	Reason   string // Why the decision was made, e.g., ReasonRule
+
+	// Rules are the evaluated rules, in order, up to the matching one. It's
+	// empty if the decision was made before evaluating the rules, e.g., for a
+	// banned source IP.
+	Rules []RuleExplanation
+}
+
+// Engine evaluates requests against the rules of an access control
+// configuration. It's safe for concurrent use.
+type Engine struct {
+	engine *rules.Engine
+}
+
+// NewEngine creates an engine evaluating the given access control
+// configuration, which must have been validated, e.g., by ReadConfig.
+func NewEngine(accessControl *AccessControl) *Engine {
+	return &Engine{engine: rules.NewEngine(accessControl)}
+}
+
+// UpdateConfig replaces the access control configuration of the engine. The
+// rate limits are reset since the rules may have changed, and the quotas are
+// kept for the rules whose quota didn't change.
+func (e *Engine) UpdateConfig(accessControl *AccessControl) {
+	e.engine.UpdateConfig(accessControl)
+}
+
+// Decide returns the decision for the given request from the given source.
+// The requests it allows count against the rate limits and quotas of the
+// rules.
+func (e *Engine) Decide(request *Request, source *Resolution) Decision {
+	return newDecision(e.engine.Decide(newQuery(request, source)))
+}
+
+// Evaluate returns the decision for the given request from the given source
+// like Decide, but without consuming the rate limits and quotas, e.g., to
+// test the rules.
+func (e *Engine) Evaluate(request *Request, source *Resolution) Decision {
+	return newDecision(e.engine.Evaluate(newQuery(request, source)))
+}
+
+// Explain returns the decision for the given request from the given source
+// like Evaluate, with its explanation: why it was made and the result of each
+// condition of the evaluated rules.
+func (e *Engine) Explain(request *Request, source *Resolution) Explanation {
+	explanation := e.engine.Explain(newQuery(request, source))
+	evaluated := make([]RuleExplanation, 0, len(explanation.Rules))
+	for _, rule := range explanation.Rules {
+		evaluated = append(evaluated, RuleExplanation(rule))
+	}
+	return Explanation{
+		Decision: newDecision(explanation.Decision),
+		Policy:   explanation.Policy,
+		Reason:   explanation.Reason,
+		Rules:    evaluated,
+	}
+}
+
+// newQuery returns the query of the engine for the given request from the
+// given source.
+func newQuery(request *Request, source *Resolution) *rules.Query {
+	return &rules.Query{
+		RequestedDomain:   request.Domain,
+		RequestedMethod:   request.Method,
+		RequestedPath:     urlpath.Clean(request.Path),
+		RequestedProtocol: request.Protocol,
+		SourceIP:          request.SourceIP,
+		SourceCountry:     source.Country,
+		SourceRegion:      source.Region,
+		SourceCity:        source.City,
+		SourceASN:         source.ASN,
+		SourceOrg:         orgname.Normalize(source.Organization),
+		SourceIsCDN:       source.CDN != "",
+		SourceMonitor:     source.Monitor,
+		SourceAnonymizers: source.Anonymizers,
+		Context:           request.Context,
+	}
+}
+
+// newDecision returns the public decision of the given engine decision.
+func newDecision(decision rules.Decision) Decision {
+	return Decision{
+		Allowed:    decision.Allowed,
+		Banned:     decision.Banned,
+		Rule:       decision.Rule,
+		RuleName:   decision.RuleName,
+		RetryAfter: decision.RetryAfter,
+	}
+}
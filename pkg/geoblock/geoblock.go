@@ -0,0 +1,57 @@
+// Package geoblock lets Go services authorize requests by the country, the
+// network and the other properties of their clients directly, with the rules
+// and databases of geoblock, instead of calling the HTTP API of an instance.
+//
+// An Engine evaluates the rules of an access control configuration, a
+// Resolver resolves the IP addresses of the clients with the IP databases,
+// and an Authorizer combines both:
+//
+//	cfg, err := geoblock.ReadConfigFile("config.yaml")
+//	...
+//	resolver := geoblock.NewResolver(geoblock.ResolverOptions{})
+//	if err := resolver.Update(); err != nil {
+//		...
+//	}
+//	authorizer := geoblock.NewAuthorizer(
+//		geoblock.NewEngine(&cfg.AccessControl), resolver,
+//	)
+//	decision := authorizer.Authorize(&geoblock.Request{...})
+//
+// The API of this package follows semantic versioning, unlike the internal
+// packages of the module, which it wraps. The logs are written with the
+// standard logger of logrus.
+package geoblock
+
+// Authorizer authorizes requests: it resolves their source IP, then evaluates
+// them with an engine. It's safe for concurrent use.
+type Authorizer struct {
+	engine   *Engine
+	resolver IPResolver
+}
+
+// NewAuthorizer creates an authorizer evaluating the requests with the given
+// engine, once their source IP is resolved with the given resolver.
+func NewAuthorizer(engine *Engine, resolver IPResolver) *Authorizer {
+	return &Authorizer{engine: engine, resolver: resolver}
+}
+
+// Authorize returns the decision for the given request. The requests it
+// allows count against the rate limits and quotas of the rules.
+func (a *Authorizer) Authorize(request *Request) Decision {
+	source := a.resolver.Resolve(request.SourceIP)
+	return a.engine.Decide(request, &source)
+}
+
+// Evaluate returns the decision for the given request like Authorize, but
+// without consuming the rate limits and quotas.
+func (a *Authorizer) Evaluate(request *Request) Decision {
+	source := a.resolver.Resolve(request.SourceIP)
+	return a.engine.Evaluate(request, &source)
+}
+
+// Explain returns the decision for the given request like Evaluate, with its
+// explanation, see Engine.Explain.
+func (a *Authorizer) Explain(request *Request) Explanation {
+	source := a.resolver.Resolve(request.SourceIP)
+	return a.engine.Explain(request, &source)
+}
@@ -0,0 +1,223 @@
+package geoblock_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/danroc/geoblock/pkg/geoblock"
+)
+
+// mapFetcher is a fetcher serving the databases of a map, by URL.
+type mapFetcher map[string]string
+
+func (f mapFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, ok := f[url]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return []byte(data), nil
+}
+
+const testConfig = `
+access_control:
+  default_policy: deny
+  rules:
+    - name: france
+      domains:
+        - example.com
+      countries:
+        - FR
+      policy: allow
+    - name: monitors
+      organizations:
+        - Example Monitoring
+      policy: allow
+`
+
+func newTestResolver(t *testing.T) *geoblock.Resolver {
+	t.Helper()
+	resolver := geoblock.NewResolver(geoblock.ResolverOptions{
+		Fetcher: mapFetcher{
+			"country-ipv4": "1.0.0.0,1.0.0.255,FR\n2.0.0.0,2.0.0.255,US\n",
+			"country-ipv6": "",
+			"asn-ipv4":     "2.0.0.0,2.0.0.127,64500,Example Monitoring Inc\n",
+			"asn-ipv6":     "",
+		},
+		URLs: map[string][]string{
+			"country-ipv4": {"country-ipv4"},
+			"country-ipv6": {"country-ipv6"},
+			"asn-ipv4":     {"asn-ipv4"},
+			"asn-ipv6":     {"asn-ipv6"},
+		},
+	})
+	if resolver.Ready() {
+		t.Error("resolver ready before its first update")
+	}
+	if err := resolver.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if !resolver.Ready() {
+		t.Error("resolver not ready after its update")
+	}
+	return resolver
+}
+
+func TestResolver(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	got := resolver.Resolve(netip.MustParseAddr("2.0.0.1"))
+	if got.Country != "US" || got.ASN != 64500 ||
+		got.Organization != "Example Monitoring Inc" {
+		t.Errorf("got %+v, want US and AS64500", got)
+	}
+	got = resolver.Resolve(netip.MustParseAddr("3.0.0.1"))
+	if got.Country != "" || got.ASN != 0 {
+		t.Errorf("got %+v, want an empty resolution", got)
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	cfg, err := geoblock.ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := geoblock.NewAuthorizer(
+		geoblock.NewEngine(&cfg.AccessControl), newTestResolver(t),
+	)
+
+	tests := []struct {
+		domain  string
+		ip      string
+		allowed bool
+		rule    int
+		name    string
+	}{
+		{"example.com", "1.0.0.1", true, 0, "france"},
+		{"other.example.com", "1.0.0.1", false, geoblock.NoRule, ""},
+		{"example.com", "2.0.0.200", false, geoblock.NoRule, ""},
+		{"other.example.com", "2.0.0.1", true, 1, "monitors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain+" "+tt.ip, func(t *testing.T) {
+			decision := authorizer.Authorize(&geoblock.Request{
+				Domain:   tt.domain,
+				Method:   "GET",
+				SourceIP: netip.MustParseAddr(tt.ip),
+			})
+			if decision.Allowed != tt.allowed || decision.Rule != tt.rule ||
+				decision.RuleName != tt.name {
+				t.Errorf("got %+v, want allowed %t by rule %d %q",
+					decision, tt.allowed, tt.rule, tt.name)
+			}
+		})
+	}
+}
+
+// staticResolver resolves every IP address to the same resolution.
+type staticResolver geoblock.Resolution
+
+func (r staticResolver) Resolve(netip.Addr) geoblock.Resolution {
+	return geoblock.Resolution(r)
+}
+
+func TestAuthorizerCustomResolver(t *testing.T) {
+	engine := geoblock.NewEngine(&geoblock.AccessControl{
+		DefaultPolicy: geoblock.PolicyDeny,
+		Rules: []geoblock.AccessControlRule{
+			{
+				Countries: []string{"FR"},
+				Policy:    geoblock.PolicyAllow,
+			},
+		},
+	})
+	request := &geoblock.Request{
+		Domain:   "example.com",
+		Method:   "GET",
+		SourceIP: netip.MustParseAddr("192.0.2.1"),
+	}
+
+	authorizer := geoblock.NewAuthorizer(engine, staticResolver{Country: "FR"})
+	if !authorizer.Evaluate(request).Allowed {
+		t.Error("request from FR denied")
+	}
+	authorizer = geoblock.NewAuthorizer(engine, staticResolver{Country: "US"})
+	if authorizer.Evaluate(request).Allowed {
+		t.Error("request from US allowed")
+	}
+}
+
+func TestAuthorizerExplain(t *testing.T) {
+	cfg, err := geoblock.ReadConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := geoblock.NewAuthorizer(
+		geoblock.NewEngine(&cfg.AccessControl), newTestResolver(t),
+	)
+
+	got := authorizer.Explain(&geoblock.Request{
+		Domain:   "example.com",
+		Method:   "GET",
+		SourceIP: netip.MustParseAddr("2.0.0.1"),
+	})
+	if !got.Decision.Allowed || got.Decision.Rule != 1 ||
+		got.Policy != geoblock.PolicyAllow ||
+		got.Reason != geoblock.ReasonRule || len(got.Rules) != 2 {
+		t.Fatalf("got %+v, want allowed by rule 1", got)
+	}
+	if first := got.Rules[0]; first.Name != "france" || first.Applies ||
+		!first.Conditions["domain"] || first.Conditions["country"] {
+		t.Errorf("got first rule %+v, want only its country unmatched",
+			first)
+	}
+	if second := got.Rules[1]; second.Name != "monitors" || !second.Applies {
+		t.Errorf("got second rule %+v, want it to apply", second)
+	}
+}
+
+func TestEnginePaths(t *testing.T) {
+	engine := geoblock.NewEngine(&geoblock.AccessControl{
+		DefaultPolicy: geoblock.PolicyAllow,
+		Rules: []geoblock.AccessControlRule{
+			{
+				Paths: []string{"/admin/**"},
+				Networks: []geoblock.CIDR{
+					{Prefix: netip.MustParsePrefix("192.0.2.0/24")},
+				},
+				Policy: geoblock.PolicyDeny,
+			},
+		},
+	})
+	source := &geoblock.Resolution{Country: "FR"}
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/public/index.html", true},
+		{"/admin/users", false},
+		{"/public/../admin/users", false},
+		{"/public/./../admin//users", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			decision := engine.Evaluate(&geoblock.Request{
+				Domain:   "example.com",
+				Path:     tt.path,
+				SourceIP: netip.MustParseAddr("192.0.2.1"),
+			}, source)
+			if decision.Allowed != tt.allowed {
+				t.Errorf("got allowed %t, want %t", decision.Allowed,
+					tt.allowed)
+			}
+		})
+	}
+}
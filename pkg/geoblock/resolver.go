@@ -0,0 +1,147 @@
+package geoblock
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/danroc/geoblock/internal/ipres"
+)
+
+// Resolution is what a resolver knows about an IP address.
+type Resolution struct {
+	Country      string // ISO 3166-1 alpha-2 country code
+	Region       string // Name of the region, e.g., a state, if known
+	City         string // Name of the city, if known
+	ASN          uint32 // Autonomous System Number
+	Organization string // Name of the organization of the ASN
+	CDN          string // Name of the CDN or anycast provider, if any
+	Monitor      string // Name of the uptime monitoring service, if any
+
+	// Anonymizers are the anonymization networks, e.g., "tor", whose
+	// published lists contain the IP.
+	Anonymizers []string
+}
+
+// IPResolver resolves the IP addresses of the clients. Resolver implements
+// it, and embedders can provide their own, e.g., backed by another database.
+// Its methods must be safe for concurrent use.
+type IPResolver interface {
+	// Resolve returns what is known about the given IP address. Unknown
+	// addresses resolve to an empty resolution.
+	Resolve(ip netip.Addr) Resolution
+}
+
+// Fetcher retrieves the raw content of the databases of a Resolver from their
+// URLs, e.g., to download them with a custom HTTP client. Its methods must be
+// safe for concurrent use.
+type Fetcher interface {
+	// Fetch returns the content at the given URL. The fetch must be aborted
+	// when the given context is done.
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+// ResolverOptions contains the options of a Resolver. The zero value loads the
+// country and ASN CSV databases from their default URLs.
+type ResolverOptions struct {
+	// Fetcher retrieves the databases. If nil, they're downloaded over
+	// HTTP(S), through the proxy of the environment, and the `file://` URLs
+	// are read from the filesystem.
+	Fetcher Fetcher
+
+	// URLs replace the URLs of the database sources, by source name, e.g.,
+	// "country-ipv4". The sources are listed in the README.
+	URLs map[string][]string
+
+	City        bool     // Load the city databases instead of the country ones
+	DisableASN  bool     // Don't load the ASN databases
+	CDN         bool     // Load the ranges of the CDN and anycast providers
+	Monitors    []string // Uptime monitoring services, e.g., "pingdom"
+	Anonymizers []string // Anonymization networks, e.g., "tor"
+	Registries  []string // Regional Internet Registries, e.g., "ripencc"
+}
+
+// Resolver resolves IP addresses with the IP databases, which it downloads.
+// It's safe for concurrent use.
+type Resolver struct {
+	resolver *ipres.Resolver
+}
+
+// NewResolver creates a resolver with the given options. It has no databases
+// until its first successful update, see Update.
+func NewResolver(options ResolverOptions) *Resolver {
+	var fetcher ipres.Fetcher = ipres.NewHTTPFetcher(ipres.HTTPOptions{})
+	if options.Fetcher != nil {
+		fetcher = &fetcherAdapter{fetcher: options.Fetcher}
+	}
+	return &Resolver{
+		resolver: ipres.NewResolver(fetcher, ipres.Options{
+			URLs:        options.URLs,
+			City:        options.City,
+			DisableASN:  options.DisableASN,
+			CDN:         options.CDN,
+			Monitors:    options.Monitors,
+			Anonymizers: options.Anonymizers,
+			Registries:  options.Registries,
+		}),
+	}
+}
+
+// Update downloads the databases and replaces the ones of the resolver. If a
+// source can't be updated, its previous content is kept, if any, and the
+// error is returned. It should be called periodically, e.g., daily.
+func (r *Resolver) Update() error {
+	return r.resolver.Update()
+}
+
+// Ready checks if the resolver has country data, which it doesn't until its
+// first successful update.
+func (r *Resolver) Ready() bool {
+	return !r.resolver.Empty()
+}
+
+// Resolve returns what the databases know about the given IP address.
+func (r *Resolver) Resolve(ip netip.Addr) Resolution {
+	resolved := r.resolver.Resolve(ip)
+	return Resolution{
+		Country:      resolved.CountryCode,
+		Region:       resolved.Region,
+		City:         resolved.City,
+		ASN:          resolved.ASN,
+		Organization: resolved.Organization,
+		CDN:          resolved.CDN,
+		Monitor:      resolved.Monitor,
+		Anonymizers:  resolved.Anonymizers.Names(),
+	}
+}
+
+// fetcherAdapter adapts a Fetcher to the fetchers of the resolver.
+type fetcherAdapter struct {
+	fetcher Fetcher
+}
+
+// Fetch fetches the given URL without deadline.
+func (f *fetcherAdapter) Fetch(url string) (*ipres.Resource, error) {
+	return f.FetchContext(context.Background(), url, ipres.Validators{})
+}
+
+// FetchIfModified fetches the given URL. The content is always fetched, since
+// a Fetcher returns no validators.
+func (f *fetcherAdapter) FetchIfModified(
+	url string,
+	validators ipres.Validators,
+) (*ipres.Resource, error) {
+	return f.FetchContext(context.Background(), url, validators)
+}
+
+// FetchContext fetches the given URL, aborted when the given context is done.
+func (f *fetcherAdapter) FetchContext(
+	ctx context.Context,
+	url string,
+	_ ipres.Validators,
+) (*ipres.Resource, error) {
+	data, err := f.fetcher.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return &ipres.Resource{Data: data}, nil
+}